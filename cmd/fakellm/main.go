@@ -0,0 +1,62 @@
+// cmd/fakellm/main.go
+//
+// Фейковый OpenAI-совместимый LLM API для локальной разработки. Пример:
+//
+//	go run ./cmd/fakellm -addr :8089 -chunk-delay 50ms
+//	REMOTE_LLM_API_URL=http://localhost:8089/v1/chat/completions go run ./cmd/server
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"shaman-ai.kz/internal/llm/fakellm"
+)
+
+func main() {
+	addr := flag.String("addr", ":8089", "адрес для прослушивания")
+	scriptPath := flag.String("script", "", "JSON-файл со списком ответов (fakellm.Response), отдаются по порядку")
+	reply := flag.String("reply", "", "фиксированный текст ответа; по умолчанию повторяется запрос пользователя")
+	delay := flag.Duration("delay", 0, "задержка перед ответом")
+	chunkDelay := flag.Duration("chunk-delay", 30*time.Millisecond, "задержка между чанками потокового ответа")
+	status := flag.Int("status", 0, "HTTP-статус ошибки, который возвращать на каждый запрос (например, 503)")
+	flag.Parse()
+
+	handler := fakellm.NewHandler()
+	handler.SetResponder(func(req fakellm.Request) fakellm.Response {
+		resp := fakellm.EchoResponder(req)
+		if *reply != "" {
+			resp.Content = *reply
+		}
+		resp.Delay = fakellm.Duration(*delay)
+		resp.ChunkDelay = fakellm.Duration(*chunkDelay)
+		resp.StatusCode = *status
+		return resp
+	})
+
+	if *scriptPath != "" {
+		data, err := os.ReadFile(*scriptPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Не удалось прочитать сценарий: %v\n", err)
+			os.Exit(1)
+		}
+		var script []fakellm.Response
+		if err := json.Unmarshal(data, &script); err != nil {
+			fmt.Fprintf(os.Stderr, "Некорректный JSON сценария: %v\n", err)
+			os.Exit(1)
+		}
+		handler.Enqueue(script...)
+		slog.Info("Сценарий загружен", "responses", len(script))
+	}
+
+	slog.Info("Фейковый LLM API запущен", "addr", *addr, "endpoint", "/v1/chat/completions")
+	if err := http.ListenAndServe(*addr, handler); err != nil {
+		slog.Error("Фейковый LLM API остановлен", "error", err)
+		os.Exit(1)
+	}
+}
@@ -2,18 +2,33 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
+	"shaman-ai.kz/internal/billing"
 	"shaman-ai.kz/internal/config"
 	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/export"
+	"shaman-ai.kz/internal/guardrails"
 	"shaman-ai.kz/internal/handlers"
+	"shaman-ai.kz/internal/history"
 	adminhandlers "shaman-ai.kz/internal/handlers/admin"
+	"shaman-ai.kz/internal/llm"
 	"shaman-ai.kz/internal/middleware"
 	"shaman-ai.kz/internal/models"
-	"shaman-ai.kz/internal/utils"
+	"shaman-ai.kz/internal/moderouter"
+	paymentgateway "shaman-ai.kz/internal/payment_gateway"
+	"shaman-ai.kz/internal/payment_gateway/bcc"
+	"shaman-ai.kz/internal/payment_gateway/sandbox"
+	"shaman-ai.kz/internal/personas"
+	"shaman-ai.kz/internal/prompts"
+	"shaman-ai.kz/internal/storage"
+	"shaman-ai.kz/internal/titlegen"
+	"shaman-ai.kz/internal/uploadcheck"
 	"time"
 
 	"github.com/alexedwards/scs/mysqlstore"
@@ -23,8 +38,6 @@ import (
 )
 
 var sessionManager *scs.SessionManager
-var shamanSystemPrompt string
-var generalSystemPrompt string
 
 func main() {
 	configPath := "configs/config.yaml"
@@ -37,26 +50,6 @@ func main() {
 	config.InitLogger(cfg.AppEnv)
 	slog.Info("Запуск сервера Shaman...", "app_env", cfg.AppEnv)
 
-	shamanSystemPrompt, err = utils.LoadSystemPrompt(cfg.RemoteLLM.ShamanSystemPromptPath)
-	if err != nil {
-		slog.Error("Критическая ошибка: не удалось загрузить системный промпт Shaman", "path", cfg.RemoteLLM.ShamanSystemPromptPath, "error", err)
-		os.Exit(1)
-	}
-	slog.Info("Системный промпт Shaman успешно загружен")
-
-	if cfg.RemoteLLM.GeneralSystemPromptPath != "" {
-		generalSystemPrompt, err = utils.LoadSystemPrompt(cfg.RemoteLLM.GeneralSystemPromptPath)
-		if err != nil {
-			slog.Error("Ошибка: не удалось загрузить общий системный промпт, используется дефолтный", "path", cfg.RemoteLLM.GeneralSystemPromptPath, "error", err)
-			generalSystemPrompt = "Ты — полезный AI-ассистент."
-		} else {
-			slog.Info("Общий системный промпт успешно загружен")
-		}
-	} else {
-		generalSystemPrompt = "Ты — полезный AI-ассистент."
-		slog.Info("Общий системный промпт не указан в конфиге, используется дефолтный.")
-	}
-
 	err = db.InitDB(cfg)
 	if err != nil {
 		slog.Error("Критическая ошибка: не удалось инициализировать базу данных", "error", err)
@@ -117,24 +110,101 @@ func main() {
 		os.Exit(1)
 	}
 	authHandlers := handlers.NewAuthHandlers(sessionManager, appHandlers.RenderPage, appHandlers.NewPageData, cfg)
-	billingHandlers := handlers.NewBillingHandlers(sessionManager, cfg, appHandlers)
+	// Платежные шлюзы: шлюз выбирается при создании платежа и сохраняется в payments.gateway_name
+	paymentGateways := paymentgateway.NewRegistry(cfg.Billing.Gateway)
+	paymentGateways.Register(bcc.NewGateway(bcc.NewClient(cfg.BCCGateway.BaseURL, cfg.BCCGateway.Login, cfg.BCCGateway.Password), cfg.Billing.WebhookSecret))
+	var sandboxGateway *sandbox.Gateway
+	if cfg.Billing.Sandbox.Enabled {
+		if cfg.Billing.Sandbox.Scenario != "" && !sandbox.ValidScenario(cfg.Billing.Sandbox.Scenario) {
+			slog.Error("Критическая ошибка: неизвестный сценарий песочницы оплаты", "scenario", cfg.Billing.Sandbox.Scenario)
+			os.Exit(1)
+		}
+		if rs := cfg.Billing.Sandbox.RenewalScenario; rs != "" && rs != sandbox.ScenarioSuccess && rs != sandbox.ScenarioDecline {
+			slog.Error("Критическая ошибка: сценарий автопродления песочницы - success или decline", "renewal_scenario", rs)
+			os.Exit(1)
+		}
+		sandboxGateway = sandbox.New(cfg.Billing.Sandbox, cfg.Billing.WebhookSecret, strings.TrimRight(cfg.BaseURL, "/")+"/api/billing/webhook/"+sandbox.Name)
+		paymentGateways.Register(sandboxGateway)
+		slog.Warn("Включена песочница оплаты: платежи проводятся без банка", "scenario", cfg.Billing.Sandbox.Scenario)
+	}
+	if _, ok := paymentGateways.Get(""); !ok {
+		slog.Error("Критическая ошибка: платежный шлюз по умолчанию не подключен", "gateway", cfg.Billing.Gateway, "available", paymentGateways.Names())
+		os.Exit(1)
+	}
+	billingService := billing.NewService(cfg, paymentGateways)
+	billingService.StartInbox()
+	billingService.StartRenewals()
+	billingHandlers := handlers.NewBillingHandlers(sessionManager, cfg, appHandlers, billingService)
 	userProfileHandlers := handlers.NewUserProfileHandlers(sessionManager)
 	userSettingsHandlers := handlers.NewUserSettingsHandlers(sessionManager)
 
+	llmClient, err := llm.NewRegistry(cfg.RemoteLLM)
+	if err != nil {
+		slog.Error("Критическая ошибка: не удалось инициализировать LLM-провайдеров", "error", err)
+		os.Exit(1)
+	}
+	modeRouter := moderouter.New(cfg.ModeRouter, llmClient, cfg.RemoteLLM)
+	guard, err := guardrails.New(cfg.Guardrails, llmClient, cfg.RemoteLLM)
+	if err != nil {
+		slog.Error("Критическая ошибка: не удалось загрузить правила проверок безопасности", "rules_path", cfg.Guardrails.RulesPath, "error", err)
+		os.Exit(1)
+	}
+	historyBuilder := history.NewBuilder(cfg.History, llmClient, cfg.RemoteLLM)
+	titleGenerator := titlegen.NewGenerator(cfg.Titles, llmClient, cfg.RemoteLLM)
+	titleGenerator.StartRetries()
+	// Промпты встроенных персон: из настроек в БД, затем из файлов, затем встроенные.
+	// Кеш сбрасывается при каждом изменении соответствующей настройки.
+	promptProvider := prompts.NewProvider(cfg.RemoteLLM)
+	db.OnSettingChanged(promptProvider.Invalidate)
+	for _, slug := range []string{personas.SlugShaman, personas.SlugGeneral} {
+		if _, source := promptProvider.Resolve(slug); source == prompts.SourceBuiltin {
+			slog.Warn("Системный промпт не найден ни в БД, ни в файле, используется встроенный", "slug", slug)
+		} else {
+			slog.Info("Системный промпт загружен", "slug", slug, "source", source)
+		}
+	}
+	personaRegistry := personas.NewRegistry(promptProvider)
+	pdfRenderer, err := export.NewPDFRenderer(cfg.Export)
+	if err != nil {
+		slog.Error("Критическая ошибка: не удалось загрузить шрифты для выгрузки в PDF", "error", err)
+		os.Exit(1)
+	}
+
+	storageCtx, storageCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	fileStore, err := storage.New(storageCtx, cfg.Storage, cfg.UploadPath)
+	storageCancel()
+	if err != nil {
+		slog.Error("Критическая ошибка: не удалось инициализировать хранилище загрузок", "backend", cfg.Storage.Backend, "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Хранилище загрузок инициализировано", "backend", cfg.Storage.Backend)
+	storage.StartJanitor(fileStore, time.Duration(cfg.Storage.JanitorIntervalMinutes)*time.Minute, time.Duration(cfg.Storage.OrphanGraceMinutes)*time.Minute)
+
 	mainMux := http.NewServeMux()
 	fs := http.FileServer(http.Dir("./static"))
 	mainMux.Handle("/static/", http.StripPrefix("/static/", fs))
 
 	// Middleware
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		slog.Error("Критическая ошибка: некорректный список trusted_proxies", "error", err)
+		os.Exit(1)
+	}
+	clientIPMiddleware := middleware.ClientIPMiddleware(trustedProxies)
 	injectUserMiddleware := middleware.InjectUserData(sessionManager)
 	requireAuthMiddleware := middleware.RequireAuthentication(sessionManager)
 	requireSubscriptionMiddleware := middleware.RequireActiveSubscription(sessionManager)
 	requireAdminRoleMiddleware := middleware.RequireRole(models.RoleAdmin)
+	checkTokenLimitMiddleware := middleware.CheckTokenLimit(cfg) // Лимит и возможности тарифа, после injectUserMiddleware
 
 	// Public Routes
 	mainMux.Handle("/", injectUserMiddleware(http.HandlerFunc(appHandlers.WelcomePageHandler)))
 	mainMux.Handle("/documentation", injectUserMiddleware(http.HandlerFunc(appHandlers.DocumentationPageHandler)))
 	mainMux.Handle("/public-offer", injectUserMiddleware(http.HandlerFunc(appHandlers.PublicOfferPageHandler)))
+	// Диалоги по публичным ссылкам: без входа, с ограничением частоты просмотров по IP
+	shareViewRPS := cfg.Shares.ViewsPerMinute / 60
+	mainMux.Handle("/share/{token}", middleware.RateLimitMiddleware(injectUserMiddleware(handlers.SharedChatPageHandler(appHandlers, personaRegistry)), shareViewRPS, cfg.Shares.ViewBurst))
+	mainMux.Handle("/share/{token}/attachments/{id}", middleware.RateLimitMiddleware(handlers.SharedChatAttachmentHandler(cfg, fileStore), shareViewRPS, cfg.Shares.ViewBurst))
 
 	// Auth Routes
 	mainMux.Handle("/register", injectUserMiddleware(http.HandlerFunc(authHandlers.RegisterPageHandler)))
@@ -159,9 +229,9 @@ func main() {
 	// Subscription Routes
 	mainMux.Handle("/subscribe", requireAuthMiddleware(injectUserMiddleware(http.HandlerFunc(appHandlers.SubscribePageHandler))))
 	mainMux.Handle("/billing/create-payment-link", requireAuthMiddleware(http.HandlerFunc(billingHandlers.CreatePaymentLinkHandler)))
+	mainMux.Handle("/billing/start-trial", requireAuthMiddleware(injectUserMiddleware(http.HandlerFunc(billingHandlers.StartTrialHandler))))
 	mainMux.HandleFunc("/billing/success", billingHandlers.PaymentSuccessPageHandler)
 	mainMux.HandleFunc("/billing/failure", billingHandlers.PaymentFailurePageHandler)
-	mainMux.HandleFunc("/api/billing/webhook", billingHandlers.PaymentWebhookHandler)
 	mainMux.Handle("/api/billing/cancel-subscription", requireAuthMiddleware(http.HandlerFunc(billingHandlers.CancelSubscriptionHandler)))
 
 	// Authenticated User Routes
@@ -172,15 +242,32 @@ func main() {
 	// Authenticated User API Routes
 	mainMux.Handle("/api/profile/update", requireAuthMiddleware(http.HandlerFunc(userProfileHandlers.UpdateProfileHandler)))
 	mainMux.Handle("/api/profile/change-password", requireAuthMiddleware(http.HandlerFunc(userProfileHandlers.ChangePasswordHandler)))
+	mainMux.Handle("/api/profile/export", requireAuthMiddleware(handlers.ExportUserDataHandler(cfg, fileStore, personaRegistry)))
 	mainMux.Handle("/api/settings/update", requireAuthMiddleware(http.HandlerFunc(userSettingsHandlers.UpdateUserSettingsHandler)))
 
 	// Dialogue API (защищенные)
-	dialogueWithFileHandler := handlers.DialogueWithFileHandler(cfg, shamanSystemPrompt, generalSystemPrompt)
-	mainMux.Handle("/api/dialogue_with_file", requireAuthMiddleware(requireSubscriptionMiddleware(dialogueWithFileHandler)))
+	dialogueWithFileHandler := handlers.DialogueWithFileHandler(cfg, llmClient, modeRouter, personaRegistry, historyBuilder, titleGenerator, guard, fileStore, uploadcheck.New(cfg.UploadSafety))
+	mainMux.Handle("/api/dialogue_with_file", requireAuthMiddleware(requireSubscriptionMiddleware(injectUserMiddleware(checkTokenLimitMiddleware(dialogueWithFileHandler)))))
+	mainMux.Handle("/api/dialogue_regenerate", requireAuthMiddleware(requireSubscriptionMiddleware(injectUserMiddleware(checkTokenLimitMiddleware(handlers.RegenerateDialogueHandler(cfg, llmClient, modeRouter, personaRegistry, historyBuilder, titleGenerator, guard, fileStore))))))
+	mainMux.Handle("/api/dialogue_edit", requireAuthMiddleware(requireSubscriptionMiddleware(injectUserMiddleware(checkTokenLimitMiddleware(handlers.EditDialogueHandler(cfg, llmClient, modeRouter, personaRegistry, historyBuilder, titleGenerator, guard, fileStore))))))
+	mainMux.Handle("/api/dialogue_branch", requireAuthMiddleware(requireSubscriptionMiddleware(handlers.SelectDialogueBranchHandler())))
+	mainMux.Handle("/api/dialogue_feedback", requireAuthMiddleware(handlers.MessageFeedbackHandler()))
 
 	mainMux.Handle("/api/chat_sessions", requireAuthMiddleware(requireSubscriptionMiddleware(handlers.ListChatSessionsHandler())))
 	mainMux.Handle("/api/chat_session_messages", requireAuthMiddleware(requireSubscriptionMiddleware(handlers.GetChatSessionMessagesHandler())))
-	mainMux.Handle("/api/chat_session_create", requireAuthMiddleware(requireSubscriptionMiddleware(handlers.CreateNewChatSessionHandler())))
+	mainMux.Handle("/api/attachment", requireAuthMiddleware(handlers.DownloadAttachmentHandler(cfg, fileStore)))
+	mainMux.Handle("/api/chat_session_create", requireAuthMiddleware(requireSubscriptionMiddleware(handlers.CreateNewChatSessionHandler(personaRegistry))))
+	mainMux.Handle("/api/chat_session_rename", requireAuthMiddleware(handlers.RenameChatSessionHandler()))
+	mainMux.Handle("/api/chat_session_pin", requireAuthMiddleware(handlers.PinChatSessionHandler()))
+	mainMux.Handle("/api/chat_session_archive", requireAuthMiddleware(handlers.ArchiveChatSessionHandler()))
+	mainMux.Handle("/api/chat_session_delete", requireAuthMiddleware(handlers.DeleteChatSessionHandler()))
+	mainMux.Handle("/api/chat_session_restore", requireAuthMiddleware(handlers.RestoreChatSessionHandler()))
+	mainMux.Handle("/api/chat_sessions/{uuid}/export", requireAuthMiddleware(handlers.ExportChatSessionHandler(personaRegistry, pdfRenderer)))
+	mainMux.Handle("/api/chat_share_create", requireAuthMiddleware(handlers.CreateChatShareHandler(cfg)))
+	mainMux.Handle("/api/chat_shares", requireAuthMiddleware(handlers.ListChatSharesHandler()))
+	mainMux.Handle("/api/chat_share_revoke", requireAuthMiddleware(handlers.RevokeChatShareHandler()))
+	mainMux.Handle("/api/chat_search", requireAuthMiddleware(requireSubscriptionMiddleware(handlers.SearchDialoguesHandler())))
+	mainMux.Handle("/api/personas", requireAuthMiddleware(handlers.ListPersonasHandler(personaRegistry)))
 
 	// Legal Docs API (публичные)
 	mainMux.Handle("/api/legal/terms", handlers.GetLegalDocHandler("terms"))
@@ -198,14 +285,46 @@ func main() {
 	adminReportsHandlerFunc := adminhandlers.AdminReportsPageHandler(appHandlers)
 	adminSettingsHandlerFunc := adminhandlers.AdminSettingsPageHandler(appHandlers)
 	adminUpdateSettingsHandlerFunc := adminhandlers.AdminUpdateSettingsHandler(appHandlers)
+	adminPersonasListHandlerFunc := adminhandlers.AdminPersonasListPageHandler(appHandlers)
+	adminEditPersonaHandlerFunc := adminhandlers.AdminEditPersonaPageHandler(appHandlers)
+	adminSavePersonaHandlerFunc := adminhandlers.AdminSavePersonaHandler(appHandlers, personaRegistry)
+	adminTogglePersonaHandlerFunc := adminhandlers.AdminTogglePersonaHandler(appHandlers, personaRegistry)
+	adminPromptHistoryHandlerFunc := adminhandlers.AdminPromptHistoryPageHandler(appHandlers)
+	adminPromptDiffHandlerFunc := adminhandlers.AdminPromptDiffPageHandler(appHandlers)
+	adminPromptRollbackHandlerFunc := adminhandlers.AdminPromptRollbackHandler(appHandlers, personaRegistry)
+	adminFeedbackHandlerFunc := adminhandlers.AdminFeedbackPageHandler(appHandlers)
+	adminFeedbackReviewHandlerFunc := adminhandlers.AdminFeedbackReviewHandler(appHandlers)
+	adminFeedbackExportHandlerFunc := adminhandlers.AdminFeedbackExportHandler(appHandlers)
+	adminGuardrailEventsHandlerFunc := adminhandlers.AdminGuardrailEventsPageHandler(appHandlers)
+	adminPaymentEventsHandlerFunc := adminhandlers.AdminPaymentEventsPageHandler(appHandlers)
+	adminRetryPaymentEventHandlerFunc := adminhandlers.AdminRetryPaymentEventHandler(appHandlers, billingService)
+	adminPlansListHandlerFunc := adminhandlers.AdminPlansListPageHandler(appHandlers)
+	adminEditPlanHandlerFunc := adminhandlers.AdminEditPlanPageHandler(appHandlers)
+	adminSavePlanHandlerFunc := adminhandlers.AdminSavePlanHandler(appHandlers)
 
 	adminRouter.HandleFunc("/dashboard", adminDashboardHandlerFunc)
 	adminRouter.HandleFunc("/users", adminUsersListHandlerFunc)
 	adminRouter.HandleFunc("/users/edit", adminEditUserPageHandlerFunc)
 	adminRouter.HandleFunc("/users/update", adminUpdateUserHandlerFunc)
 	adminRouter.HandleFunc("/reports", adminReportsHandlerFunc)
+	adminRouter.HandleFunc("/feedback", adminFeedbackHandlerFunc)
+	adminRouter.HandleFunc("/feedback/review", adminFeedbackReviewHandlerFunc)
+	adminRouter.HandleFunc("/feedback/export", adminFeedbackExportHandlerFunc)
+	adminRouter.HandleFunc("/guardrails", adminGuardrailEventsHandlerFunc)
+	adminRouter.HandleFunc("/payments/events", adminPaymentEventsHandlerFunc)
+	adminRouter.HandleFunc("/payments/events/retry", adminRetryPaymentEventHandlerFunc)
+	adminRouter.HandleFunc("/plans", adminPlansListHandlerFunc)
+	adminRouter.HandleFunc("/plans/edit", adminEditPlanHandlerFunc)
+	adminRouter.HandleFunc("/plans/save", adminSavePlanHandlerFunc)
 	adminRouter.HandleFunc("/settings", adminSettingsHandlerFunc)
 	adminRouter.HandleFunc("/settings/update", adminUpdateSettingsHandlerFunc)
+	adminRouter.HandleFunc("/personas", adminPersonasListHandlerFunc)
+	adminRouter.HandleFunc("/personas/edit", adminEditPersonaHandlerFunc)
+	adminRouter.HandleFunc("/personas/save", adminSavePersonaHandlerFunc)
+	adminRouter.HandleFunc("/personas/toggle", adminTogglePersonaHandlerFunc)
+	adminRouter.HandleFunc("/prompts", adminPromptHistoryHandlerFunc)
+	adminRouter.HandleFunc("/prompts/diff", adminPromptDiffHandlerFunc)
+	adminRouter.HandleFunc("/prompts/rollback", adminPromptRollbackHandlerFunc)
 
 	adminProtectedHandler := injectUserMiddleware(
 		requireAuthMiddleware(
@@ -218,12 +337,19 @@ func main() {
 
 	// Top Level Mux
 	topLevelMux := http.NewServeMux()
-	topLevelMux.HandleFunc("/api/trial-dialogue", handlers.TrialDialogueHandler(cfg, generalSystemPrompt))
+	topLevelMux.HandleFunc("/api/trial-dialogue", handlers.TrialDialogueHandler(cfg, llmClient, personaRegistry))
+	// Уведомления банка приходят без CSRF-токена
+	topLevelMux.HandleFunc("/api/billing/webhook", billingHandlers.PaymentWebhookHandler)
+	topLevelMux.HandleFunc("/api/billing/webhook/{gateway}", billingHandlers.PaymentWebhookHandler)
+	if sandboxGateway != nil {
+		// Страница оплаты песочницы имитирует страницу банка и отправляет форму без CSRF-токена
+		topLevelMux.Handle(sandbox.PayPath, sandboxGateway.PayPageHandler())
+	}
 	topLevelMux.Handle("/admin/", http.StripPrefix("/admin", adminProtectedHandler))
 	topLevelMux.Handle("/", csrfProtectedRoutes)
 
-	// Обертываем topLevelMux в менеджер сессий
-	finalHandler := sessionManager.LoadAndSave(topLevelMux)
+	// Обертываем topLevelMux в менеджер сессий; адрес клиента определяется до всех обработчиков
+	finalHandler := clientIPMiddleware(sessionManager.LoadAndSave(topLevelMux))
 
 	addr := fmt.Sprintf(":%d", cfg.Port)
 	slog.Info("Сервер Shaman запущен и слушает", "address", fmt.Sprintf("http://localhost%s", addr))
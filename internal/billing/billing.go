@@ -0,0 +1,103 @@
+// internal/billing/billing.go
+//
+// Package billing проводит оплаты подписки: сверяет заказ в платежном шлюзе с платежом, продлевает
+// подписку и обрабатывает очередь уведомлений шлюзов (payment_events). Уведомление сохраняется
+// сразу при получении, а обрабатывается в фоне с повторами; исчерпавшее попытки уходит в dead-letter.
+// Автопродление списывает оплату с сохраненной карты до конца периода; при неудаче подписка
+// переходит в past_due, пользователь получает напоминания, а после льготного периода подписка отменяется.
+// Подписка без карты, с которой шлюз может списать продление, заканчивается вместе с оплаченным периодом.
+package billing
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/models"
+	paymentgateway "shaman-ai.kz/internal/payment_gateway"
+)
+
+type Service struct {
+	cfg      *config.Config
+	gateways *paymentgateway.Registry
+
+	mu      sync.Mutex
+	running map[int64]bool // Уведомления, которые обрабатываются в этом процессе
+}
+
+func NewService(cfg *config.Config, gateways *paymentgateway.Registry) *Service {
+	return &Service{cfg: cfg, gateways: gateways, running: make(map[int64]bool)}
+}
+
+// Gateways - подключенные платежные шлюзы
+func (s *Service) Gateways() *paymentgateway.Registry {
+	return s.gateways
+}
+
+// verifyOrder сверяет заказ в шлюзе с платежом: номер заказа, сумму и валюту
+func verifyOrder(order *paymentgateway.OrderStatus, payment *models.Payment) error {
+	if order.MerchantOrderID != "" && order.MerchantOrderID != payment.ID {
+		return fmt.Errorf("заказ выписан на другой платеж: %s", order.MerchantOrderID)
+	}
+	if order.Currency != "" && !strings.EqualFold(order.Currency, payment.Currency) {
+		return fmt.Errorf("валюта заказа %s, ожидалась %s", order.Currency, payment.Currency)
+	}
+	if order.Amount != payment.Amount {
+		return fmt.Errorf("сумма заказа %d тиын, ожидалось %d", order.Amount, payment.Amount)
+	}
+	return nil
+}
+
+// SyncPayment запрашивает статус заказа в шлюзе платежа и применяет его к платежу. Статусу из уведомления
+// и параметрам возврата не доверяем: подписку продлевает только заказ, подтвержденный шлюзом.
+func (s *Service) SyncPayment(ctx context.Context, payment *models.Payment) (models.PaymentStatus, error) {
+	switch payment.Status {
+	case models.PaymentStatusSuccess, models.PaymentStatusFailed, models.PaymentStatusRefunded:
+		return payment.Status, nil
+	}
+	if payment.GatewayOrderID == "" {
+		return payment.Status, nil // Заказ в шлюзе не создан
+	}
+	gateway, ok := s.gateways.Get(payment.GatewayName)
+	if !ok || payment.GatewayName == "" {
+		return payment.Status, fmt.Errorf("платежный шлюз %q не подключен", payment.GatewayName)
+	}
+
+	order, err := gateway.OrderStatus(ctx, payment.GatewayOrderID)
+	if err != nil {
+		return payment.Status, fmt.Errorf("не удалось получить статус заказа: %w", err)
+	}
+	return s.applyOrder(payment, order)
+}
+
+// applyOrder проводит платеж по состоянию заказа, полученному от шлюза
+func (s *Service) applyOrder(payment *models.Payment, order *paymentgateway.OrderStatus) (models.PaymentStatus, error) {
+	switch order.Status {
+	case models.PaymentStatusSuccess:
+		if err := verifyOrder(order, payment); err != nil {
+			slog.Error("КРИТИЧНО: оплаченный заказ не совпадает с платежом, подписка не продлена", "gateway", payment.GatewayName,
+				"paymentID", payment.ID, "gatewayOrderID", payment.GatewayOrderID, "error", err)
+			_ = db.UpdatePaymentStatus(payment.ID, models.PaymentStatusFailed, order.TransactionID)
+			return models.PaymentStatusFailed, nil
+		}
+		applied, err := db.CompletePayment(payment.ID, order.TransactionID, order.RecurringToken)
+		if err != nil {
+			slog.Error("КРИТИЧНО: оплата получена, но подписка не продлена", "paymentID", payment.ID, "userID", payment.UserID, "error", err)
+			return models.PaymentStatusProcessing, err
+		}
+		if applied {
+			slog.Info("Оплата подписки подтверждена шлюзом", "gateway", payment.GatewayName, "paymentID", payment.ID, "userID", payment.UserID,
+				"plan", payment.PlanID, "months", payment.PeriodMonths, "orderStatus", order.RawStatus, "renewal", payment.Renewal, "cardSaved", order.RecurringToken != "")
+		}
+	case models.PaymentStatusFailed, models.PaymentStatusRefunded:
+		slog.Info("Оплата подписки не прошла", "gateway", payment.GatewayName, "paymentID", payment.ID, "userID", payment.UserID, "orderStatus", order.RawStatus)
+		if err := db.UpdatePaymentStatus(payment.ID, order.Status, ""); err != nil {
+			return order.Status, err
+		}
+	}
+	return order.Status, nil
+}
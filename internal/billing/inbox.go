@@ -0,0 +1,150 @@
+// internal/billing/inbox.go
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/models"
+	paymentgateway "shaman-ai.kz/internal/payment_gateway"
+)
+
+// Сколько уведомлений берется за один проход очереди
+const inboxBatchSize = 50
+
+// Как часто просматривается очередь уведомлений
+const inboxPollInterval = 30 * time.Second
+
+// Обработка дольше этого считается прерванной (например, перезапуском) и начинается заново
+const inboxStaleAfter = 10 * time.Minute
+
+// Сколько ждать ответа шлюза при обработке одного уведомления
+const inboxProcessTimeout = 30 * time.Second
+
+// errPaymentPending - шлюз еще не завершил оплату; уведомление повторяется, пока статус не станет окончательным
+var errPaymentPending = errors.New("оплата еще не завершена в шлюзе")
+
+// ReceiveWebhook сохраняет проверенное уведомление в очередь и запускает его обработку в фоне.
+// false - уведомление с таким ID события уже получено, повтор ничего не делает.
+func (s *Service) ReceiveWebhook(gatewayName string, event *paymentgateway.WebhookEvent, payload []byte) (bool, error) {
+	e := &db.PaymentEvent{
+		GatewayName:    gatewayName,
+		EventID:        event.EventID,
+		GatewayOrderID: event.GatewayOrderID,
+		EventStatus:    event.Status,
+		Payload:        string(payload),
+	}
+	created, err := db.CreatePaymentEvent(e)
+	if err != nil || !created {
+		return false, err
+	}
+	s.Enqueue(e.ID)
+	return true, nil
+}
+
+// Enqueue запускает обработку уведомления в фоне; повторный вызов, пока обработка идет, ничего не делает
+func (s *Service) Enqueue(eventID int64) {
+	s.mu.Lock()
+	if s.running[eventID] {
+		s.mu.Unlock()
+		return
+	}
+	s.running[eventID] = true
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, eventID)
+			s.mu.Unlock()
+		}()
+		s.processEvent(eventID)
+	}()
+}
+
+// StartInbox периодически обрабатывает уведомления, ждущие повтора или прерванные перезапуском
+func (s *Service) StartInbox() {
+	go func() {
+		ticker := time.NewTicker(inboxPollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ids, err := db.GetDuePaymentEventIDs(inboxStaleAfter, inboxBatchSize)
+			if err != nil {
+				slog.Error("Не удалось получить уведомления платежных шлюзов для обработки", "error", err)
+				continue
+			}
+			for _, id := range ids {
+				s.Enqueue(id)
+			}
+		}
+	}()
+}
+
+// retryDelay - пауза перед следующей попыткой: интервал, умноженный на номер попытки
+func (s *Service) retryDelay(attempts int) time.Duration {
+	return time.Duration(s.cfg.Billing.Webhooks.RetryIntervalSeconds*attempts) * time.Second
+}
+
+func (s *Service) processEvent(eventID int64) {
+	claimed, err := db.ClaimPaymentEvent(eventID, inboxStaleAfter)
+	if err != nil {
+		slog.Error("Не удалось взять уведомление платежного шлюза в обработку", "eventID", eventID, "error", err)
+		return
+	}
+	if !claimed {
+		return // Уже обработано или обрабатывается другим процессом
+	}
+	event, err := db.GetPaymentEventByID(eventID)
+	if err != nil || event == nil {
+		slog.Error("Не удалось получить уведомление платежного шлюза", "eventID", eventID, "error", err)
+		return // Останется в обработке и будет взято повторно как зависшее
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), inboxProcessTimeout)
+	defer cancel()
+	processErr := s.applyEvent(ctx, event)
+	if processErr == nil {
+		if err := db.MarkPaymentEventProcessed(event.ID); err != nil {
+			slog.Error("Не удалось отметить уведомление обработанным", "eventID", event.ID, "error", err)
+		}
+		return
+	}
+
+	dead := event.Attempts >= s.cfg.Billing.Webhooks.MaxAttempts
+	if err := db.MarkPaymentEventFailed(event.ID, processErr, time.Now().Add(s.retryDelay(event.Attempts)), dead); err != nil {
+		slog.Error("Не удалось сохранить ошибку обработки уведомления", "eventID", event.ID, "error", err)
+	}
+	if dead {
+		slog.Error("Уведомление платежного шлюза не обработано, попытки исчерпаны", "eventID", event.ID, "gateway", event.GatewayName,
+			"gatewayOrderID", event.GatewayOrderID, "attempts", event.Attempts, "error", processErr)
+		return
+	}
+	slog.Warn("Ошибка обработки уведомления платежного шлюза, будет повтор", "eventID", event.ID, "gateway", event.GatewayName,
+		"gatewayOrderID", event.GatewayOrderID, "attempt", event.Attempts, "error", processErr)
+}
+
+// applyEvent находит платеж по заказу из уведомления и сверяет его со шлюзом
+func (s *Service) applyEvent(ctx context.Context, event *db.PaymentEvent) error {
+	payment, err := db.GetPaymentByGatewayOrderID(event.GatewayName, event.GatewayOrderID)
+	if err != nil {
+		return err
+	}
+	if payment == nil {
+		// Заказ мог быть сохранен позже, чем пришло уведомление; после повторов - разбор вручную
+		return fmt.Errorf("платеж для заказа %s не найден", event.GatewayOrderID)
+	}
+	status, err := s.SyncPayment(ctx, payment)
+	if err != nil {
+		return err
+	}
+	if status == models.PaymentStatusProcessing || status == models.PaymentStatusPending {
+		return errPaymentPending
+	}
+	slog.Info("Уведомление платежного шлюза обработано", "eventID", event.ID, "gateway", event.GatewayName, "paymentID", payment.ID,
+		"notifiedStatus", event.EventStatus, "status", status)
+	return nil
+}
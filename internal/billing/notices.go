@@ -0,0 +1,60 @@
+// internal/billing/notices.go
+package billing
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/email"
+	"shaman-ai.kz/internal/sms"
+	"shaman-ai.kz/internal/timeutil"
+)
+
+// notice - сообщение пользователю о подписке: письмо и короткий текст для SMS
+type notice struct {
+	Subject string
+	Body    string
+	SMS     string
+}
+
+// dunningNotice - напоминание о неоплаченном продлении; доступ сохраняется до graceEnd
+func dunningNotice(baseURL string, graceEnd time.Time) notice {
+	payURL := strings.TrimRight(baseURL, "/") + "/subscribe"
+	until := graceEnd.In(timeutil.Location).Format("02.01.2006")
+	return notice{
+		Subject: "Подписка Sham'an AI не оплачена",
+		Body: fmt.Sprintf("Здравствуйте!\n\nНе удалось списать оплату продления подписки Sham'an AI с сохраненной карты. Мы повторим попытку позже.\n\n"+
+			"Доступ сохранится до %s. Чтобы не потерять его, оплатите подписку: %s\n\n"+
+			"Если вы уже оплатили, не обращайте внимания на это письмо.", until, payURL),
+		SMS: fmt.Sprintf("Sham'an AI: подписка не оплачена, доступ сохранится до %s. Оплатить: %s", until, payURL),
+	}
+}
+
+// canceledNotice - подписка отменена после льготного периода без оплаты
+func canceledNotice(baseURL string) notice {
+	payURL := strings.TrimRight(baseURL, "/") + "/subscribe"
+	return notice{
+		Subject: "Подписка Sham'an AI отменена",
+		Body: fmt.Sprintf("Здравствуйте!\n\nМы не получили оплату продления, поэтому подписка Sham'an AI отменена. "+
+			"Ваши диалоги сохранены - оформите подписку снова, чтобы продолжить: %s", payURL),
+		SMS: fmt.Sprintf("Sham'an AI: подписка отменена из-за неоплаты. Возобновить: %s", payURL),
+	}
+}
+
+// notifyUser отправляет сообщение на email и, если номер подтвержден, по SMS. Ошибки отправки
+// только записываются в журнал: повторять напоминание из-за них не нужно.
+func (s *Service) notifyUser(sub db.BillingSubscription, n notice) {
+	if sub.Email != "" {
+		if err := email.SendEmail(s.cfg, sub.Email, n.Subject, n.Body, false, "", nil); err != nil {
+			slog.Error("Не удалось отправить письмо о подписке", "userID", sub.UserID, "subject", n.Subject, "error", err)
+		}
+	}
+	if sub.Phone != "" {
+		if err := sms.SendSMS(s.cfg, sub.Phone, n.SMS); err != nil {
+			slog.Error("Не удалось отправить SMS о подписке", "userID", sub.UserID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+// internal/billing/renewals.go
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/models"
+	paymentgateway "shaman-ai.kz/internal/payment_gateway"
+)
+
+// Сколько подписок обрабатывается за один проход
+const renewalBatchSize = 100
+
+// Сколько ждать ответа шлюза при списании с сохраненной карты
+const renewalChargeTimeout = time.Minute
+
+// Сколько месяцев расчетного периода токенов нагоняется за один проход после простоя
+const maxTokenPeriodRolls = 12
+
+// StartRenewals периодически продлевает подписки, период которых подходит к концу, и ведет
+// неоплаченные подписки через льготный период. Месячные периоды токенов годовых подписок
+// начинаются здесь же, даже если автопродление отключено.
+func (s *Service) StartRenewals() {
+	interval := time.Duration(s.cfg.Billing.Renewals.CheckIntervalMinutes) * time.Minute
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			now := time.Now()
+			rollTokenPeriods(now)
+			if !s.cfg.Billing.Renewals.Disabled {
+				s.runRenewals(now)
+			}
+		}
+	}()
+}
+
+// rollTokenPeriods сбрасывает счетчики токенов пользователей, у которых начался новый месяц
+// расчетного периода без оплаты
+func rollTokenPeriods(now time.Time) {
+	for i := 0; i < maxTokenPeriodRolls; i++ {
+		rolled, err := db.RollTokenPeriods(now)
+		if err != nil || rolled == 0 {
+			return
+		}
+		slog.Info("Начался новый месяц расчетного периода, счетчики токенов сброшены", "users", rolled)
+	}
+}
+
+func (s *Service) runRenewals(now time.Time) {
+	subs, err := db.GetSubscriptionsForBilling(s.billingDue(now), renewalBatchSize)
+	if err != nil {
+		slog.Error("Не удалось получить подписки для продления", "error", err)
+		return
+	}
+	for _, sub := range subs {
+		s.processSubscription(sub, now)
+	}
+}
+
+// billingDue - условия отбора подписок, с которыми на момент now пора что-то делать
+func (s *Service) billingDue(now time.Time) db.BillingDue {
+	due := db.BillingDue{
+		Now:             now,
+		RenewBefore:     now.Add(time.Duration(s.cfg.Billing.Renewals.RenewBeforeHours) * time.Hour),
+		GracePeriodDays: s.cfg.Billing.Renewals.GracePeriodDays,
+		DunningDays:     s.cfg.Billing.Renewals.DunningDays,
+	}
+	for _, name := range s.gateways.Names() {
+		if gateway, ok := s.gateways.Get(name); ok && !gateway.SupportsRecurring() {
+			due.ManualGateways = append(due.ManualGateways, name)
+		}
+	}
+	return due
+}
+
+// processSubscription - один шаг жизненного цикла подписки: продление, переход в past_due,
+// напоминание или отмена после льготного периода
+func (s *Service) processSubscription(sub db.BillingSubscription, now time.Time) {
+	periodEnded := !sub.CurrentPeriodEnd.After(now)
+	charged := false // Списание уже пробовали в этом проходе, повтор запланирован
+	if sub.Status == models.SubscriptionStatusActive {
+		if sub.CancelAtPeriodEnd {
+			// Автопродление отключено пользователем: подписка заканчивается вместе с периодом
+			if periodEnded {
+				if _, err := db.EndSubscription(sub.ID, sub.UserID); err != nil {
+					slog.Error("Не удалось завершить подписку в конце периода", "subscriptionID", sub.ID, "userID", sub.UserID, "error", err)
+				}
+			}
+			return
+		}
+		if !s.canRenew(sub) {
+			// Списать продление не с чего: подписка заканчивается вместе с периодом, как при отключенном
+			// автопродлении. past_due и напоминания - только для неудавшегося списания.
+			if periodEnded {
+				if _, err := db.EndSubscription(sub.ID, sub.UserID); err != nil {
+					slog.Error("Не удалось завершить подписку без автопродления в конце периода", "subscriptionID", sub.ID, "userID", sub.UserID, "error", err)
+				}
+			}
+			return
+		}
+		if s.renewalDue(sub, now) {
+			if s.renew(sub, now) {
+				return
+			}
+			charged = true
+		}
+		if !periodEnded {
+			return
+		}
+		marked, err := db.MarkSubscriptionPastDue(sub.ID, sub.UserID)
+		if err != nil || !marked {
+			if err != nil {
+				slog.Error("Не удалось перевести подписку в past_due", "subscriptionID", sub.ID, "userID", sub.UserID, "error", err)
+			}
+			return
+		}
+		slog.Warn("Подписка не продлена, начат льготный период", "subscriptionID", sub.ID, "userID", sub.UserID,
+			"graceDays", s.cfg.Billing.Renewals.GracePeriodDays)
+		sub.Status, sub.PastDueSince, sub.DunningStage = models.SubscriptionStatusPastDue, &now, 0
+	}
+
+	if sub.Status != models.SubscriptionStatusPastDue || sub.PastDueSince == nil {
+		return
+	}
+	if !charged && s.renewalDue(sub, now) && s.renew(sub, now) {
+		return
+	}
+	if !now.Before(s.graceEnd(sub)) {
+		ended, err := db.EndSubscription(sub.ID, sub.UserID)
+		if err != nil {
+			slog.Error("Не удалось отменить подписку после льготного периода", "subscriptionID", sub.ID, "userID", sub.UserID, "error", err)
+			return
+		}
+		if ended {
+			slog.Warn("Подписка отменена: льготный период закончился без оплаты", "subscriptionID", sub.ID, "userID", sub.UserID)
+			s.notifyUser(sub, canceledNotice(s.cfg.BaseURL))
+		}
+		return
+	}
+	if s.canRenew(sub) {
+		s.sendDunning(sub, now)
+	}
+}
+
+// graceEnd - конец льготного периода неоплаченной подписки
+func (s *Service) graceEnd(sub db.BillingSubscription) time.Time {
+	return sub.PastDueSince.AddDate(0, 0, s.cfg.Billing.Renewals.GracePeriodDays)
+}
+
+// canRenew - у подписки есть сохраненная карта, и ее шлюз умеет списывать с нее продление.
+// Неподключенный шлюз считается временной ошибкой: списание повторяется, как при отказе банка.
+func (s *Service) canRenew(sub db.BillingSubscription) bool {
+	if sub.RecurringToken == "" {
+		return false
+	}
+	gateway, ok := s.gateways.Get(sub.RecurringGateway)
+	return !ok || sub.RecurringGateway == "" || gateway.SupportsRecurring()
+}
+
+// renewalDue - есть сохраненная карта и пора списывать: первая попытка или время повтора наступило
+func (s *Service) renewalDue(sub db.BillingSubscription, now time.Time) bool {
+	if sub.RecurringToken == "" {
+		return false
+	}
+	return sub.NextRenewalAttemptAt == nil || !sub.NextRenewalAttemptAt.After(now)
+}
+
+// renew списывает оплату следующего периода с сохраненной карты. true - подписка продлена.
+func (s *Service) renew(sub db.BillingSubscription, now time.Time) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), renewalChargeTimeout)
+	defer cancel()
+
+	// Прошлое списание еще не завершено в шлюзе: повторно не списываем, итог придет уведомлением
+	open, err := db.GetOpenRenewalPayment(sub.ID)
+	if err != nil {
+		slog.Error("Не удалось проверить незавершенное списание автопродления", "subscriptionID", sub.ID, "error", err)
+		return false
+	}
+	if open != nil && open.GatewayOrderID == "" {
+		// Списание прервалось до обращения к шлюзу (например, перезапуском)
+		_ = db.UpdatePaymentStatus(open.ID, models.PaymentStatusFailed, "")
+		open = nil
+	}
+	if open != nil {
+		status, err := s.SyncPayment(ctx, open)
+		if err != nil {
+			slog.Warn("Не удалось проверить списание автопродления", "subscriptionID", sub.ID, "paymentID", open.ID, "error", err)
+		}
+		if status == models.PaymentStatusSuccess {
+			return true
+		}
+		if status != models.PaymentStatusFailed {
+			return false
+		}
+	}
+
+	retryAt := now.Add(time.Duration(s.cfg.Billing.Renewals.RetryIntervalHours) * time.Hour)
+	gateway, ok := s.gateways.Get(sub.RecurringGateway)
+	if !ok || sub.RecurringGateway == "" {
+		slog.Error("Шлюз сохраненной карты не подключен, автопродление невозможно", "subscriptionID", sub.ID, "gateway", sub.RecurringGateway)
+		_ = db.ScheduleRenewalRetry(sub.ID, retryAt)
+		return false
+	}
+
+	// Продление по текущей цене тарифа за прежний период оплаты; если годовая оплата тарифа
+	// больше недоступна, подписка продлевается помесячно
+	plan, err := db.GetPlanOrDefault(sub.PlanID)
+	if err != nil {
+		slog.Error("Не удалось получить тариф подписки для автопродления", "subscriptionID", sub.ID, "plan", sub.PlanID, "error", err)
+		_ = db.ScheduleRenewalRetry(sub.ID, retryAt)
+		return false
+	}
+	months := sub.PeriodMonths
+	amount := plan.Price(months)
+	if amount <= 0 {
+		months, amount = 1, plan.MonthlyPrice
+	}
+
+	payment := &models.Payment{
+		UserID:         sub.UserID,
+		SubscriptionID: sub.ID,
+		Amount:         amount,
+		Currency:       s.cfg.Billing.Currency,
+		Status:         models.PaymentStatusPending,
+		GatewayName:    gateway.Name(),
+		Renewal:        true,
+		PlanID:         plan.Slug,
+		PeriodMonths:   months,
+	}
+	if err := db.CreatePayment(payment); err != nil {
+		return false
+	}
+	order, err := gateway.ChargeRecurring(ctx, sub.RecurringToken, paymentgateway.OrderRequest{
+		PaymentID:   payment.ID,
+		Amount:      payment.Amount,
+		Currency:    payment.Currency,
+		Description: fmt.Sprintf("Продление подписки Sham'an AI «%s» на %d мес.", plan.Name, months),
+		Customer:    paymentgateway.Customer{Email: sub.Email, Name: sub.FirstName, Phone: sub.Phone},
+	})
+	if err != nil {
+		if errors.Is(err, paymentgateway.ErrNotSupported) {
+			slog.Warn("Шлюз не поддерживает списание с сохраненной карты", "subscriptionID", sub.ID, "gateway", gateway.Name())
+		} else {
+			slog.Error("Ошибка списания автопродления", "subscriptionID", sub.ID, "paymentID", payment.ID, "gateway", gateway.Name(), "error", err)
+		}
+		_ = db.UpdatePaymentStatus(payment.ID, models.PaymentStatusFailed, "")
+		_ = db.ScheduleRenewalRetry(sub.ID, retryAt)
+		return false
+	}
+	if err := db.SetPaymentGatewayOrder(payment.ID, order.GatewayOrderID, models.PaymentStatusProcessing); err != nil {
+		// Деньги могли списаться, поэтому результат заказа все равно применяется к платежу
+		slog.Error("КРИТИЧНО: не удалось сохранить ID заказа списания автопродления", "paymentID", payment.ID, "gatewayOrderID", order.GatewayOrderID, "error", err)
+	}
+	payment.GatewayOrderID, payment.Status = order.GatewayOrderID, models.PaymentStatusProcessing
+
+	status, err := s.applyOrder(payment, order)
+	if err != nil {
+		slog.Error("Не удалось провести списание автопродления", "subscriptionID", sub.ID, "paymentID", payment.ID, "error", err)
+		return false
+	}
+	switch status {
+	case models.PaymentStatusSuccess:
+		return true
+	case models.PaymentStatusFailed:
+		slog.Warn("Банк отклонил списание автопродления", "subscriptionID", sub.ID, "paymentID", payment.ID, "attempt", sub.RenewalAttempts+1,
+			"nextAttempt", retryAt)
+		_ = db.ScheduleRenewalRetry(sub.ID, retryAt)
+	}
+	return false
+}
+
+// CancelAutoRenewal отключает автопродление: подписка действует до конца оплаченного периода,
+// карта отвязывается в шлюзе. Неоплаченная подписка (past_due) отменяется сразу.
+func (s *Service) CancelAutoRenewal(ctx context.Context, sub *models.Subscription) error {
+	if sub.RecurringToken != "" {
+		if gateway, ok := s.gateways.Get(sub.RecurringGateway); ok && sub.RecurringGateway != "" {
+			if err := gateway.CancelRecurring(ctx, sub.RecurringToken); err != nil && !errors.Is(err, paymentgateway.ErrNotSupported) {
+				// Карта у нас забывается в любом случае, списаний по ней больше не будет
+				slog.Warn("Не удалось отвязать карту в платежном шлюзе", "subscriptionID", sub.ID, "gateway", sub.RecurringGateway, "error", err)
+			}
+		}
+	}
+	if sub.Status == models.SubscriptionStatusPastDue {
+		_, err := db.EndSubscription(sub.ID, sub.UserID)
+		return err
+	}
+	return db.SetSubscriptionCancelAtPeriodEnd(sub.ID)
+}
+
+// sendDunning отправляет очередное напоминание о неоплате, если его день наступил. Напоминания,
+// пропущенные из-за простоя, не досылаются: отправляется только последнее наступившее.
+func (s *Service) sendDunning(sub db.BillingSubscription, now time.Time) {
+	stage := 0
+	for i, days := range s.cfg.Billing.Renewals.DunningDays {
+		if !now.Before(sub.PastDueSince.AddDate(0, 0, days)) {
+			stage = i + 1
+		}
+	}
+	if stage <= sub.DunningStage {
+		return
+	}
+	// Этап сохраняется до отправки: ошибка почты или SMS не должна повторять напоминание каждый проход
+	if err := db.SetSubscriptionDunningStage(sub.ID, stage); err != nil {
+		slog.Error("Не удалось сохранить этап напоминаний о неоплате", "subscriptionID", sub.ID, "error", err)
+		return
+	}
+	slog.Info("Напоминание о неоплате подписки", "subscriptionID", sub.ID, "userID", sub.UserID, "stage", stage)
+	s.notifyUser(sub, dunningNotice(s.cfg.BaseURL, s.graceEnd(sub)))
+}
@@ -0,0 +1,261 @@
+// internal/billing/renewals_test.go
+package billing
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/models"
+	paymentgateway "shaman-ai.kz/internal/payment_gateway"
+)
+
+// fakeGateway declines every recurring charge and counts the attempts
+type fakeGateway struct {
+	name      string
+	recurring bool
+
+	mu      sync.Mutex
+	charges int
+}
+
+func (g *fakeGateway) Name() string            { return g.name }
+func (g *fakeGateway) SupportsRecurring() bool { return g.recurring }
+
+func (g *fakeGateway) CreateOrder(ctx context.Context, req paymentgateway.OrderRequest) (*paymentgateway.Order, error) {
+	return nil, paymentgateway.ErrNotSupported
+}
+
+func (g *fakeGateway) OrderStatus(ctx context.Context, gatewayOrderID string) (*paymentgateway.OrderStatus, error) {
+	return nil, paymentgateway.ErrNotSupported
+}
+
+func (g *fakeGateway) Refund(ctx context.Context, gatewayOrderID string, amount int64) error {
+	return paymentgateway.ErrNotSupported
+}
+
+func (g *fakeGateway) VerifyWebhook(r *http.Request, body []byte) (*paymentgateway.WebhookEvent, error) {
+	return nil, paymentgateway.ErrNotSupported
+}
+
+func (g *fakeGateway) ChargeRecurring(ctx context.Context, recurringToken string, req paymentgateway.OrderRequest) (*paymentgateway.OrderStatus, error) {
+	g.mu.Lock()
+	g.charges++
+	g.mu.Unlock()
+	return &paymentgateway.OrderStatus{GatewayOrderID: "ord-" + uuid.NewString(), Status: models.PaymentStatusFailed, RawStatus: "declined"}, nil
+}
+
+func (g *fakeGateway) CancelRecurring(ctx context.Context, recurringToken string) error {
+	return nil
+}
+
+func (g *fakeGateway) chargeCount() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.charges
+}
+
+type renewalTestEnv struct {
+	service *Service
+	cards   *fakeGateway // Saves cards and charges them
+	manual  *fakeGateway // Renewed by paying again only
+}
+
+func newRenewalTestEnv(t *testing.T) *renewalTestEnv {
+	t.Helper()
+	db.OpenTestDB(t)
+	cfg := &config.Config{}
+	cfg.Billing.Currency = "KZT"
+	cfg.Billing.Renewals = config.RenewalConfig{RenewBeforeHours: 24, RetryIntervalHours: 6, GracePeriodDays: 7, DunningDays: []int{1, 3}}
+
+	env := &renewalTestEnv{cards: &fakeGateway{name: "cards", recurring: true}, manual: &fakeGateway{name: "manual"}}
+	gateways := paymentgateway.NewRegistry(env.manual.name)
+	gateways.Register(env.cards)
+	gateways.Register(env.manual)
+	env.service = NewService(cfg, gateways)
+	return env
+}
+
+// paidSubscription pays a month through the gateway and moves the period end to periodEnd
+func paidSubscription(t *testing.T, gatewayName, recurringToken string, periodEnd time.Time) db.BillingSubscription {
+	t.Helper()
+	userID := db.CreateTestUser(t)
+	payment := &models.Payment{UserID: userID, Amount: 449900, Currency: "KZT", GatewayName: gatewayName, PlanID: models.DefaultPlanSlug, PeriodMonths: 1}
+	if err := db.CreatePayment(payment); err != nil {
+		t.Fatalf("CreatePayment failed: %v", err)
+	}
+	if _, err := db.CompletePayment(payment.ID, "tx-"+payment.ID, recurringToken); err != nil {
+		t.Fatalf("CompletePayment failed: %v", err)
+	}
+	completed, err := db.GetPaymentByID(payment.ID)
+	if err != nil || completed == nil {
+		t.Fatalf("Failed to load payment: %v", err)
+	}
+	if _, err := db.DB.Exec(`UPDATE subscriptions SET current_period_end = ? WHERE id = ?`, periodEnd, completed.SubscriptionID); err != nil {
+		t.Fatalf("Failed to move the period end: %v", err)
+	}
+	return billingSubscription(t, completed.SubscriptionID)
+}
+
+// billingSubscription loads a subscription as the scheduler sees it. A year ahead every
+// subscription in a test has something due.
+func billingSubscription(t *testing.T, subscriptionID string) db.BillingSubscription {
+	t.Helper()
+	yearAhead := time.Now().AddDate(1, 0, 0)
+	subs, err := db.GetSubscriptionsForBilling(db.BillingDue{Now: yearAhead, RenewBefore: yearAhead, GracePeriodDays: 7}, 10000)
+	if err != nil {
+		t.Fatalf("GetSubscriptionsForBilling failed: %v", err)
+	}
+	for _, sub := range subs {
+		if sub.ID == subscriptionID {
+			return sub
+		}
+	}
+	t.Fatalf("Subscription %s not selected for billing", subscriptionID)
+	return db.BillingSubscription{}
+}
+
+func subscriptionState(t *testing.T, subscriptionID string) (models.SubscriptionStatus, int) {
+	t.Helper()
+	var status models.SubscriptionStatus
+	var dunningStage int
+	if err := db.DB.QueryRow(`SELECT status, dunning_stage FROM subscriptions WHERE id = ?`, subscriptionID).Scan(&status, &dunningStage); err != nil {
+		t.Fatalf("Failed to load subscription: %v", err)
+	}
+	return status, dunningStage
+}
+
+func TestRenewalEndsSubscriptionWithoutRecurringGateway(t *testing.T) {
+	env := newRenewalTestEnv(t)
+	now := time.Now()
+
+	// Paid through a gateway that cannot save cards: no token, nothing to charge
+	sub := paidSubscription(t, env.manual.name, "", now.Add(-time.Hour))
+	env.service.processSubscription(sub, now)
+	if status, _ := subscriptionState(t, sub.ID); status != models.SubscriptionStatusCanceled {
+		t.Fatalf("Expected the subscription to end with its period, got %s", status)
+	}
+
+	// A token left from a gateway that cannot charge it is not charged or dunned either
+	sub = paidSubscription(t, env.manual.name, "legacy-token", now.Add(-time.Hour))
+	env.service.processSubscription(sub, now)
+	if status, _ := subscriptionState(t, sub.ID); status != models.SubscriptionStatusCanceled {
+		t.Fatalf("Expected the subscription to end with its period, got %s", status)
+	}
+	if env.manual.chargeCount() != 0 {
+		t.Fatal("A gateway without recurring support must not be charged")
+	}
+
+	// The period has not ended yet: the subscription stays active
+	sub = paidSubscription(t, env.manual.name, "", now.Add(time.Hour))
+	env.service.processSubscription(sub, now)
+	if status, _ := subscriptionState(t, sub.ID); status != models.SubscriptionStatusActive {
+		t.Fatalf("Expected the subscription to stay active until the period ends, got %s", status)
+	}
+}
+
+func TestRenewalDunsOnlyRenewableSubscriptions(t *testing.T) {
+	env := newRenewalTestEnv(t)
+	now := time.Now()
+
+	// A declined charge of a saved card starts the grace period
+	sub := paidSubscription(t, env.cards.name, "card-token", now.Add(-time.Hour))
+	env.service.processSubscription(sub, now)
+	if status, _ := subscriptionState(t, sub.ID); status != models.SubscriptionStatusPastDue {
+		t.Fatalf("Expected past_due after a declined charge, got %s", status)
+	}
+	if env.cards.chargeCount() != 1 {
+		t.Fatalf("Expected one charge attempt, got %d", env.cards.chargeCount())
+	}
+
+	// Subscriptions left past_due without a renewable card are not dunned
+	sub = paidSubscription(t, env.manual.name, "", now.Add(-48*time.Hour))
+	if _, err := db.MarkSubscriptionPastDue(sub.ID, sub.UserID); err != nil {
+		t.Fatalf("MarkSubscriptionPastDue failed: %v", err)
+	}
+	sub = billingSubscription(t, sub.ID)
+	env.service.processSubscription(sub, now.Add(48*time.Hour))
+	if status, stage := subscriptionState(t, sub.ID); status != models.SubscriptionStatusPastDue || stage != 0 {
+		t.Fatalf("Expected past_due without reminders, got %s, stage %d", status, stage)
+	}
+	env.service.processSubscription(sub, now.Add(8*24*time.Hour))
+	if status, _ := subscriptionState(t, sub.ID); status != models.SubscriptionStatusCanceled {
+		t.Fatalf("Expected cancellation after the grace period, got %s", status)
+	}
+}
+
+func TestBillingSelectsOnlyDueSubscriptions(t *testing.T) {
+	env := newRenewalTestEnv(t)
+	now := time.Now()
+
+	selected := func() map[string]bool {
+		subs, err := db.GetSubscriptionsForBilling(env.service.billingDue(now), 10000)
+		if err != nil {
+			t.Fatalf("GetSubscriptionsForBilling failed: %v", err)
+		}
+		ids := make(map[string]bool, len(subs))
+		for _, sub := range subs {
+			ids[sub.ID] = true
+		}
+		return ids
+	}
+	pastDue := func(sub db.BillingSubscription, since time.Time, stage int) {
+		t.Helper()
+		if _, err := db.DB.Exec(`UPDATE subscriptions SET status = ?, past_due_since = ?, dunning_stage = ? WHERE id = ?`,
+			models.SubscriptionStatusPastDue, since, stage, sub.ID); err != nil {
+			t.Fatalf("Failed to mark the subscription past_due: %v", err)
+		}
+	}
+
+	renewalDue := paidSubscription(t, env.cards.name, "card-token", now.Add(time.Hour))
+	retryLater := paidSubscription(t, env.cards.name, "card-token", now.Add(time.Hour))
+	if err := db.ScheduleRenewalRetry(retryLater.ID, now.Add(3*time.Hour)); err != nil {
+		t.Fatalf("ScheduleRenewalRetry failed: %v", err)
+	}
+	notYet := paidSubscription(t, env.cards.name, "card-token", now.Add(72*time.Hour))
+	manualRunning := paidSubscription(t, env.manual.name, "", now.Add(time.Hour))
+	manualEnded := paidSubscription(t, env.manual.name, "", now.Add(-time.Hour))
+
+	// Past due two days, retry scheduled: the day 1 reminder is due, then nothing until day 3
+	dunningDue := paidSubscription(t, env.cards.name, "card-token", now.Add(-48*time.Hour))
+	pastDue(dunningDue, now.Add(-48*time.Hour), 0)
+	dunningSent := paidSubscription(t, env.cards.name, "card-token", now.Add(-48*time.Hour))
+	pastDue(dunningSent, now.Add(-48*time.Hour), 1)
+	for _, sub := range []db.BillingSubscription{dunningDue, dunningSent} {
+		if err := db.ScheduleRenewalRetry(sub.ID, now.Add(3*time.Hour)); err != nil {
+			t.Fatalf("ScheduleRenewalRetry failed: %v", err)
+		}
+	}
+	// Without a renewable card only the end of the grace period is due
+	manualInGrace := paidSubscription(t, env.manual.name, "", now.Add(-48*time.Hour))
+	pastDue(manualInGrace, now.Add(-48*time.Hour), 0)
+	graceEnded := paidSubscription(t, env.manual.name, "", now.AddDate(0, 0, -8))
+	pastDue(graceEnded, now.AddDate(0, 0, -8), 0)
+
+	ids := selected()
+	for _, c := range []struct {
+		name string
+		sub  db.BillingSubscription
+		want bool
+	}{
+		{"renewal due", renewalDue, true},
+		{"retry scheduled later", retryLater, false},
+		{"period ends after the renewal window", notYet, false},
+		{"no card, period running", manualRunning, false},
+		{"no card, period ended", manualEnded, true},
+		{"reminder due", dunningDue, true},
+		{"reminder sent, retry later", dunningSent, false},
+		{"no card, in grace", manualInGrace, false},
+		{"grace ended", graceEnded, true},
+	} {
+		if ids[c.sub.ID] != c.want {
+			t.Errorf("%s: selected = %v, want %v", c.name, ids[c.sub.ID], c.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,19 +17,163 @@ import (
 type BCCGatewayConfig struct {
 	BaseURL   string `yaml:"base_url"`
 	Login     string `yaml:"login"`
-	Password  string `yaml:"password"`
-	ReturnURL string `yaml:"return_url"`
-	Currency  string `yaml:"currency"`
+	Password  string `yaml:"password"`   // Из BCC_GATEWAY_PASSWORD
+	ReturnURL string `yaml:"return_url"` // Пусто - <base_url приложения>/billing/success
 }
 type RemoteLLMConfig struct {
+	Provider                  string  `yaml:"provider"` // openai (по умолчанию), anthropic, ollama
 	APIKey                    string  `yaml:"api_key"`
 	APIUrl                    string  `yaml:"api_url"`
 	ModelName                 string  `yaml:"model_name"`
+	MaxTokens                 int     `yaml:"max_tokens"`
+	Temperature               float64 `yaml:"temperature"`
 	ShamanSystemPromptPath    string  `yaml:"shaman_system_prompt_path"`
 	GeneralSystemPromptPath   string  `yaml:"general_system_prompt_path"`
 	RequestTimeoutSeconds     int     `yaml:"request_timeout_seconds"`
 	TokenCostInputPerMillion  float64 `yaml:"token_cost_input_per_million"`
 	TokenCostOutputPerMillion float64 `yaml:"token_cost_output_per_million"`
+	// Дополнительные провайдеры, на которые можно переключиться настройкой default_llm_model
+	// в формате "<провайдер>:<модель>", например "anthropic:claude-sonnet-4-20250514"
+	Providers map[string]LLMProviderConfig `yaml:"providers"`
+	// Резервные endpoint'ы в порядке приоритета, используются при недоступности основного
+	Fallbacks      []LLMFallbackConfig     `yaml:"fallbacks"`
+	Retry          LLMRetryConfig          `yaml:"retry"`
+	CircuitBreaker LLMCircuitBreakerConfig `yaml:"circuit_breaker"`
+	// Модели, принимающие изображения: "<модель>" или "<провайдер>:<модель>"
+	VisionModels      []string `yaml:"vision_models"`
+	ImageMaxDimension int      `yaml:"image_max_dimension"` // Большая сторона изображения после уменьшения, px
+	ImageJPEGQuality  int      `yaml:"image_jpeg_quality"`
+	// Модель "<провайдер>:<модель>" для конкретного запроса (например, из настроек персоны),
+	// приоритетнее default_llm_model. В конфиге не задается.
+	ModelSpec string `yaml:"-"`
+	// Изображения (data URL) к текущему сообщению пользователя. Передаются только
+	// vision-моделям, остальным запрос уходит без них. В конфиге не задается.
+	Images []string `yaml:"-"`
+}
+
+type LLMProviderConfig struct {
+	APIKey    string `yaml:"api_key"`
+	APIUrl    string `yaml:"api_url"`
+	ModelName string `yaml:"model_name"`
+}
+
+// LLMFallbackConfig - резервный endpoint. Пустые api_key/api_url берутся из настроек
+// одноименного провайдера (основного или из providers).
+type LLMFallbackConfig struct {
+	Provider  string `yaml:"provider"`
+	APIKey    string `yaml:"api_key"`
+	APIUrl    string `yaml:"api_url"`
+	ModelName string `yaml:"model_name"`
+}
+
+type LLMRetryConfig struct {
+	MaxAttempts      int `yaml:"max_attempts"` // Попыток на один endpoint, включая первую
+	InitialBackoffMs int `yaml:"initial_backoff_ms"`
+	MaxBackoffMs     int `yaml:"max_backoff_ms"`
+}
+
+type LLMCircuitBreakerConfig struct {
+	FailureThreshold int `yaml:"failure_threshold"` // Подряд неудачных запросов до отключения endpoint'а
+	CooldownSeconds  int `yaml:"cooldown_seconds"`
+}
+
+// ModeRouterConfig - выбор режима диалога (общий/шаман) для каждого сообщения
+type ModeRouterConfig struct {
+	Strategy         string  `yaml:"strategy"`          // keyword (по умолчанию) или llm
+	Model            string  `yaml:"model"`             // Модель классификатора "<провайдер>:<модель>", пусто - модель по умолчанию
+	MinConfidence    float64 `yaml:"min_confidence"`    // Ниже этой уверенности LLM используется поиск по ключевым словам
+	SwitchConfidence float64 `yaml:"switch_confidence"` // Уверенность, нужная для смены закрепленного режима сессии
+	TimeoutSeconds   int     `yaml:"timeout_seconds"`
+}
+
+// GuardrailsConfig - проверки безопасности сообщений и ответов: неотложные состояния и опасные советы
+type GuardrailsConfig struct {
+	Disabled   bool                      `yaml:"disabled"`
+	Personas   []string                  `yaml:"personas"`   // Режимы, в которых выполняются проверки; пусто - shaman, "*" - все
+	RulesPath  string                    `yaml:"rules_path"` // YAML со своими правилами; правило с тем же name заменяет встроенное
+	Moderation GuardrailModerationConfig `yaml:"moderation"`
+}
+
+// GuardrailModerationConfig - дополнительная проверка запросом к LLM-модератору
+type GuardrailModerationConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Model          string `yaml:"model"` // Модель "<провайдер>:<модель>", пусто - модель по умолчанию
+	TimeoutSeconds int    `yaml:"timeout_seconds"`
+}
+
+// HistoryConfig - сборка истории диалога для запроса к LLM в пределах бюджета токенов
+type HistoryConfig struct {
+	DefaultTokenBudget    int            `yaml:"default_token_budget"`  // Бюджет входных токенов на запрос: системный промпт + резюме + история + сообщение
+	ModelTokenBudgets     map[string]int `yaml:"model_token_budgets"`   // Бюджеты для отдельных моделей ("<модель>" или "<провайдер>:<модель>")
+	MaxTurns              int            `yaml:"max_turns"`             // Сколько последних обменов читать из БД при сборке истории
+	DisableSummary        bool           `yaml:"disable_summary"`       // Не резюмировать старую часть диалога
+	SummaryModel          string         `yaml:"summary_model"`         // Модель для резюме "<провайдер>:<модель>", пусто - модель по умолчанию
+	SummaryMaxTokens      int            `yaml:"summary_max_tokens"`    // Ограничение длины резюме
+	SummaryTriggerTurns   int            `yaml:"summary_trigger_turns"` // Сколько выпавших из истории обменов делает резюме устаревшим
+	SummaryInputBudget    int            `yaml:"summary_input_budget"`  // Сколько токенов старых сообщений резюмируется за один проход
+	SummaryTimeoutSeconds int            `yaml:"summary_timeout_seconds"`
+}
+
+// TitleConfig - автоматические заголовки диалогов по первому обмену
+type TitleConfig struct {
+	Disabled             bool   `yaml:"disabled"`
+	Model                string `yaml:"model"`                  // Модель "<провайдер>:<модель>", пусто - модель по умолчанию
+	MaxTokens            int    `yaml:"max_tokens"`             // Ограничение длины ответа модели
+	MaxAttempts          int    `yaml:"max_attempts"`           // Сколько раз пробовать придумать заголовок для одного диалога
+	RetryIntervalMinutes int    `yaml:"retry_interval_minutes"` // Пауза перед повторной попыткой после ошибки
+	TimeoutSeconds       int    `yaml:"timeout_seconds"`
+}
+
+// ExportConfig - выгрузка диалогов в PDF. Встроенный шрифт Go не содержит казахских букв (ә, ғ, қ, ң, ө, ұ, ү, һ),
+// поэтому по умолчанию ищется DejaVu Sans из системных шрифтов.
+type ExportConfig struct {
+	PDFFontPath     string `yaml:"pdf_font_path"`      // TTF-шрифт для текста; пусто - DejaVu Sans, если установлен, иначе встроенный
+	PDFBoldFontPath string `yaml:"pdf_bold_font_path"` // Полужирный TTF для заголовков
+}
+
+// ShareConfig - публичные ссылки на диалоги только для чтения
+type ShareConfig struct {
+	ViewsPerMinute float64 `yaml:"views_per_minute"` // Просмотров публичных страниц в минуту с одного IP
+	ViewBurst      int     `yaml:"view_burst"`       // Сколько просмотров подряд допускается сверх среднего
+	MaxExpiryDays  int     `yaml:"max_expiry_days"`  // Наибольший срок действия ссылки; 0 - ссылки могут быть бессрочными
+}
+
+// StorageConfig - хранилище загруженных файлов. Объекты адресуются SHA-256 содержимого,
+// поэтому одинаковые файлы хранятся в одном экземпляре.
+type StorageConfig struct {
+	Backend                string          `yaml:"backend"`                  // local (по умолчанию, каталог upload_path) или s3
+	S3                     S3StorageConfig `yaml:"s3"`
+	UserQuotaMB            int64           `yaml:"user_quota_mb"`            // Объем вложений на пользователя; 0 - 500 МБ, меньше 0 - без ограничения
+	JanitorIntervalMinutes int             `yaml:"janitor_interval_minutes"` // Как часто удалять файлы, на которые не ссылается ни одно вложение
+	OrphanGraceMinutes     int             `yaml:"orphan_grace_minutes"`     // Сколько хранить такой файл после загрузки (запрос к LLM еще может идти)
+}
+
+// S3StorageConfig - S3-совместимое хранилище (AWS S3, MinIO и т.п.)
+type S3StorageConfig struct {
+	Endpoint  string `yaml:"endpoint"` // host[:port] без схемы
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"` // Префикс ключей внутри бакета
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"-"` // Только из S3_SECRET_KEY
+	UseSSL    bool   `yaml:"use_ssl"`
+}
+
+// UploadSafetyConfig - проверки загруженного файла до сохранения в хранилище
+type UploadSafetyConfig struct {
+	AllowedExtensions   []string    `yaml:"allowed_extensions"`    // Пусто - список по умолчанию; HTML, SVG и т.п. включить нельзя
+	KeepImageMetadata   bool        `yaml:"keep_image_metadata"`   // Не перекодировать изображения (EXIF и GPS останутся в файле)
+	MaxArchiveEntries   int         `yaml:"max_archive_entries"`   // DOCX/XLSX/ODT/ODS - это zip-архивы
+	MaxUncompressedMB   int64       `yaml:"max_uncompressed_mb"`   // Суммарный размер файлов архива после распаковки
+	MaxCompressionRatio int         `yaml:"max_compression_ratio"` // Во сколько раз файл архива может быть больше сжатого
+	Clamd               ClamdConfig `yaml:"clamd"`
+}
+
+// ClamdConfig - антивирусная проверка загрузок демоном ClamAV
+type ClamdConfig struct {
+	Address        string `yaml:"address"` // "host:3310" или путь к unix-сокету; пусто - проверка отключена
+	TimeoutSeconds int    `yaml:"timeout_seconds"`
+	FailOpen       bool   `yaml:"fail_open"` // Принимать файлы, если clamd недоступен (по умолчанию отклонять)
 }
 
 type DatabaseConfig struct {
@@ -42,13 +187,39 @@ type DatabaseConfig struct {
 }
 
 type BillingConfig struct {
-	PriceID                      string  `yaml:"price_id"`
-	PaymentGatewayPublishableKey string  `yaml:"payment_gateway_publishable_key"`
-	PaymentGatewaySecretKey      string  `yaml:"payment_gateway_secret_key"`
-	WebhookSecret                string  `yaml:"webhook_secret"`
-	Currency                     string  `yaml:"currency"`
-	MonthlyAmount                int64   `yaml:"monthly_amount"`
-	USDToKZTRate                 float64 `yaml:"usd_to_kzt_rate"` // Новое поле
+	PaymentGatewayPublishableKey string               `yaml:"payment_gateway_publishable_key"`
+	PaymentGatewaySecretKey      string               `yaml:"payment_gateway_secret_key"`
+	WebhookSecret                string               `yaml:"webhook_secret"`
+	Currency                     string               `yaml:"currency"`
+	USDToKZTRate                 float64              `yaml:"usd_to_kzt_rate"` // Новое поле
+	Gateway                      string               `yaml:"gateway"`         // Шлюз по умолчанию: bcc или sandbox
+	Sandbox                      PaymentSandboxConfig `yaml:"sandbox"`
+	Webhooks                     PaymentWebhookConfig `yaml:"webhooks"`
+	Renewals                     RenewalConfig        `yaml:"renewals"`
+}
+
+// RenewalConfig - автопродление подписки по сохраненной карте и напоминания о неоплате
+type RenewalConfig struct {
+	Disabled             bool  `yaml:"disabled"`
+	CheckIntervalMinutes int   `yaml:"check_interval_minutes"` // Как часто проверяются подписки
+	RenewBeforeHours     int   `yaml:"renew_before_hours"`     // За сколько часов до конца периода списывается оплата
+	RetryIntervalHours   int   `yaml:"retry_interval_hours"`   // Пауза между повторами неудачного списания
+	GracePeriodDays      int   `yaml:"grace_period_days"`      // Сколько дней доступ сохраняется после неудачного продления
+	DunningDays          []int `yaml:"dunning_days"`           // Через сколько дней после неудачного продления отправлять напоминания
+}
+
+// PaymentWebhookConfig - обработка уведомлений платежных шлюзов из очереди payment_events
+type PaymentWebhookConfig struct {
+	MaxAttempts          int `yaml:"max_attempts"`           // После стольких неудач уведомление уходит в dead-letter
+	RetryIntervalSeconds int `yaml:"retry_interval_seconds"` // Пауза перед повтором, растет с каждой попыткой
+}
+
+// PaymentSandboxConfig - локальный шлюз для разработки: оплата без банка с выбором сценария
+type PaymentSandboxConfig struct {
+	Enabled             bool   `yaml:"enabled"`  // В production не подключается
+	Scenario            string `yaml:"scenario"` // success, decline, 3ds, delayed_webhook; пусто - выбор на странице оплаты
+	WebhookDelaySeconds int    `yaml:"webhook_delay_seconds"`
+	RenewalScenario     string `yaml:"renewal_scenario"` // Автопродление: success или decline
 }
 
 type EmailConfig struct {
@@ -72,14 +243,24 @@ type Config struct {
 	BaseURL              string          `yaml:"base_url"`
 	Port                 int             `yaml:"port"`
 	AppEnv               string          `yaml:"app_env"`
+	// Обратные прокси (IP или CIDR), от которых принимается адрес клиента в X-Forwarded-For
+	// и X-Real-IP; пусто - заголовки игнорируются
+	TrustedProxies []string `yaml:"trusted_proxies"`
 	RemoteLLM            RemoteLLMConfig `yaml:"remote_llm"`
+	ModeRouter           ModeRouterConfig `yaml:"mode_router"`
+	Guardrails           GuardrailsConfig `yaml:"guardrails"`
+	History              HistoryConfig   `yaml:"history"`
+	Titles               TitleConfig     `yaml:"titles"`
+	Export               ExportConfig    `yaml:"export"`
+	Shares               ShareConfig     `yaml:"shares"`
 	Database             DatabaseConfig  `yaml:"database"`
 	Billing              BillingConfig   `yaml:"billing"`
 	CSRFAuthKey          string
 	UploadPath           string      `yaml:"upload_path"`
+	Storage              StorageConfig `yaml:"storage"`
+	UploadSafety         UploadSafetyConfig `yaml:"upload_safety"`
 	Email                EmailConfig `yaml:"email"`
 	SMS                  SMSConfig   `yaml:"sms"`
-	TokenMonthlyLimitKZT float64
 	BCCGateway           BCCGatewayConfig `yaml:"bcc_gateway"`
 }
 
@@ -131,6 +312,9 @@ func LoadConfig(filename string) (*Config, error) {
 
 	cfg.BaseURL = getStringEnvOrDefault("BASE_URL", cfg.BaseURL)
 	cfg.Port = getIntEnvOrDefault("PORT", cfg.Port)
+	if trustedProxies := os.Getenv("TRUSTED_PROXIES"); trustedProxies != "" {
+		cfg.TrustedProxies = strings.Split(trustedProxies, ",")
+	}
 
 	cfg.RemoteLLM.APIKey = getStringEnvOrDefault("REMOTE_LLM_API_KEY", cfg.RemoteLLM.APIKey)
 	if isProduction && cfg.RemoteLLM.APIKey == "" {
@@ -167,6 +351,12 @@ func LoadConfig(filename string) (*Config, error) {
 
 	cfg.RemoteLLM.APIUrl = getStringEnvOrDefault("REMOTE_LLM_API_URL", cfg.RemoteLLM.APIUrl)
 	cfg.RemoteLLM.ModelName = getStringEnvOrDefault("REMOTE_LLM_MODEL_NAME", cfg.RemoteLLM.ModelName)
+	cfg.RemoteLLM.Provider = getStringEnvOrDefault("REMOTE_LLM_PROVIDER", cfg.RemoteLLM.Provider)
+	// Ключи дополнительных провайдеров берутся из ENV вида ANTHROPIC_API_KEY
+	for name, provider := range cfg.RemoteLLM.Providers {
+		provider.APIKey = getStringEnvOrDefault(strings.ToUpper(name)+"_API_KEY", provider.APIKey)
+		cfg.RemoteLLM.Providers[name] = provider
+	}
 
 	if dsn := os.Getenv("DATABASE_DSN"); dsn != "" {
 		cfg.Database.Path = dsn
@@ -185,8 +375,10 @@ func LoadConfig(filename string) (*Config, error) {
 	}
 
 	cfg.Billing.PaymentGatewayPublishableKey = getStringEnvOrDefault("PAYMENT_GATEWAY_PUBLISHABLE_KEY", cfg.Billing.PaymentGatewayPublishableKey)
-	if priceIDFromEnv := os.Getenv("PRICE_ID"); priceIDFromEnv != "" {
-		cfg.Billing.PriceID = priceIDFromEnv
+	cfg.BCCGateway.Login = getStringEnvOrDefault("BCC_GATEWAY_LOGIN", cfg.BCCGateway.Login)
+	cfg.BCCGateway.Password = getStringEnvOrDefault("BCC_GATEWAY_PASSWORD", cfg.BCCGateway.Password)
+	if isProduction && (cfg.BCCGateway.BaseURL == "" || cfg.BCCGateway.Password == "") {
+		slog.Warn("Платежный шлюз BCC не настроен (bcc_gateway.base_url, BCC_GATEWAY_PASSWORD). Оплата подписки работать не будет.")
 	}
 
 	// Загрузка конфигурации Email
@@ -205,6 +397,39 @@ func LoadConfig(filename string) (*Config, error) {
 	if cfg.UploadPath == "" {
 		cfg.UploadPath = "./uploads"
 	}
+	cfg.Storage.Backend = strings.ToLower(getStringEnvOrDefault("STORAGE_BACKEND", cfg.Storage.Backend))
+	if cfg.Storage.Backend == "" {
+		cfg.Storage.Backend = "local"
+	}
+	cfg.Storage.S3.Endpoint = getStringEnvOrDefault("S3_ENDPOINT", cfg.Storage.S3.Endpoint)
+	cfg.Storage.S3.Bucket = getStringEnvOrDefault("S3_BUCKET", cfg.Storage.S3.Bucket)
+	cfg.Storage.S3.AccessKey = getStringEnvOrDefault("S3_ACCESS_KEY", cfg.Storage.S3.AccessKey)
+	cfg.Storage.S3.SecretKey = os.Getenv("S3_SECRET_KEY")
+	if cfg.Storage.Backend == "s3" && (cfg.Storage.S3.Endpoint == "" || cfg.Storage.S3.Bucket == "") {
+		return nil, fmt.Errorf("для storage.backend: s3 нужно задать storage.s3.endpoint и storage.s3.bucket")
+	}
+	if cfg.Storage.UserQuotaMB == 0 {
+		cfg.Storage.UserQuotaMB = 500
+	}
+	if cfg.Storage.JanitorIntervalMinutes <= 0 {
+		cfg.Storage.JanitorIntervalMinutes = 60
+	}
+	if cfg.Storage.OrphanGraceMinutes <= 0 {
+		cfg.Storage.OrphanGraceMinutes = 60
+	}
+	if cfg.UploadSafety.MaxArchiveEntries <= 0 {
+		cfg.UploadSafety.MaxArchiveEntries = 2000
+	}
+	if cfg.UploadSafety.MaxUncompressedMB <= 0 {
+		cfg.UploadSafety.MaxUncompressedMB = 100
+	}
+	if cfg.UploadSafety.MaxCompressionRatio <= 0 {
+		cfg.UploadSafety.MaxCompressionRatio = 100
+	}
+	cfg.UploadSafety.Clamd.Address = getStringEnvOrDefault("CLAMD_ADDRESS", cfg.UploadSafety.Clamd.Address)
+	if cfg.UploadSafety.Clamd.TimeoutSeconds <= 0 {
+		cfg.UploadSafety.Clamd.TimeoutSeconds = 30
+	}
 
 	if cfg.CurrentYear == 0 {
 		cfg.CurrentYear = time.Now().Year()
@@ -236,6 +461,96 @@ func LoadConfig(filename string) (*Config, error) {
 	if cfg.RemoteLLM.RequestTimeoutSeconds <= 0 {
 		cfg.RemoteLLM.RequestTimeoutSeconds = 90
 	}
+	if cfg.RemoteLLM.Provider == "" {
+		cfg.RemoteLLM.Provider = "openai"
+	}
+	if cfg.RemoteLLM.MaxTokens <= 0 {
+		cfg.RemoteLLM.MaxTokens = 2048
+	}
+	if cfg.RemoteLLM.Temperature <= 0 {
+		cfg.RemoteLLM.Temperature = 0.7
+	}
+	if cfg.RemoteLLM.Retry.MaxAttempts <= 0 {
+		cfg.RemoteLLM.Retry.MaxAttempts = 3
+	}
+	if cfg.RemoteLLM.Retry.InitialBackoffMs <= 0 {
+		cfg.RemoteLLM.Retry.InitialBackoffMs = 500
+	}
+	if cfg.RemoteLLM.Retry.MaxBackoffMs <= 0 {
+		cfg.RemoteLLM.Retry.MaxBackoffMs = 8000
+	}
+	if cfg.RemoteLLM.ImageMaxDimension <= 0 {
+		cfg.RemoteLLM.ImageMaxDimension = 1024
+	}
+	if cfg.RemoteLLM.ImageJPEGQuality <= 0 || cfg.RemoteLLM.ImageJPEGQuality > 100 {
+		cfg.RemoteLLM.ImageJPEGQuality = 85
+	}
+	if cfg.RemoteLLM.CircuitBreaker.FailureThreshold <= 0 {
+		cfg.RemoteLLM.CircuitBreaker.FailureThreshold = 5
+	}
+	if cfg.RemoteLLM.CircuitBreaker.CooldownSeconds <= 0 {
+		cfg.RemoteLLM.CircuitBreaker.CooldownSeconds = 60
+	}
+	cfg.ModeRouter.Strategy = getStringEnvOrDefault("MODE_ROUTER_STRATEGY", cfg.ModeRouter.Strategy)
+	if cfg.ModeRouter.Strategy == "" {
+		cfg.ModeRouter.Strategy = "keyword"
+	}
+	if cfg.ModeRouter.MinConfidence <= 0 {
+		cfg.ModeRouter.MinConfidence = 0.6
+	}
+	if cfg.ModeRouter.SwitchConfidence <= 0 {
+		cfg.ModeRouter.SwitchConfidence = 0.75
+	}
+	if cfg.ModeRouter.TimeoutSeconds <= 0 {
+		cfg.ModeRouter.TimeoutSeconds = 5
+	}
+	if len(cfg.Guardrails.Personas) == 0 {
+		cfg.Guardrails.Personas = []string{"shaman"}
+	}
+	if cfg.Guardrails.Moderation.TimeoutSeconds <= 0 {
+		cfg.Guardrails.Moderation.TimeoutSeconds = 5
+	}
+	if cfg.History.DefaultTokenBudget <= 0 {
+		cfg.History.DefaultTokenBudget = 6000
+	}
+	if cfg.History.MaxTurns <= 0 {
+		cfg.History.MaxTurns = 50
+	}
+	if cfg.History.SummaryMaxTokens <= 0 {
+		cfg.History.SummaryMaxTokens = 400
+	}
+	if cfg.History.SummaryTriggerTurns <= 0 {
+		cfg.History.SummaryTriggerTurns = 4
+	}
+	if cfg.History.SummaryInputBudget <= 0 {
+		cfg.History.SummaryInputBudget = 6000
+	}
+	if cfg.History.SummaryTimeoutSeconds <= 0 {
+		cfg.History.SummaryTimeoutSeconds = 60
+	}
+	if cfg.Titles.MaxTokens <= 0 {
+		cfg.Titles.MaxTokens = 30
+	}
+	if cfg.Titles.MaxAttempts <= 0 {
+		cfg.Titles.MaxAttempts = 3
+	}
+	if cfg.Titles.RetryIntervalMinutes <= 0 {
+		cfg.Titles.RetryIntervalMinutes = 5
+	}
+	if cfg.Titles.TimeoutSeconds <= 0 {
+		cfg.Titles.TimeoutSeconds = 30
+	}
+	if cfg.Shares.ViewsPerMinute <= 0 {
+		cfg.Shares.ViewsPerMinute = 30
+	}
+	if cfg.Shares.ViewBurst <= 0 {
+		cfg.Shares.ViewBurst = 10
+	}
+	for i, fallback := range cfg.RemoteLLM.Fallbacks {
+		if fallback.ModelName == "" {
+			return nil, fmt.Errorf("remote_llm.fallbacks[%d].model_name не задан", i)
+		}
+	}
 	if cfg.Database.Path == "" && cfg.Database.Host == "" {
 		return nil, fmt.Errorf("параметры подключения к БД (DATABASE_DSN или DB_HOST и др.) не заданы")
 	}
@@ -247,25 +562,57 @@ func LoadConfig(filename string) (*Config, error) {
 			return nil, fmt.Errorf("DB_NAME не задан для подключения к БД")
 		}
 	}
-	if cfg.Billing.PriceID == "" {
-		return nil, fmt.Errorf("billing.price_id (PRICE_ID) не задан")
-	}
 	if cfg.Billing.PaymentGatewayPublishableKey == "" && isProduction {
 		slog.Warn("PAYMENT_GATEWAY_PUBLISHABLE_KEY не установлен для production")
 	}
 	if cfg.Billing.Currency == "" {
 		cfg.Billing.Currency = "KZT"
 	}
-	if cfg.Billing.MonthlyAmount == 0 {
-		return nil, fmt.Errorf("billing.monthly_amount не задан или равен 0")
+	if cfg.Billing.Gateway == "" {
+		cfg.Billing.Gateway = "bcc"
+	}
+	if cfg.Billing.Sandbox.Enabled && isProduction {
+		slog.Warn("Платежная песочница отключена: в production она недоступна")
+		cfg.Billing.Sandbox.Enabled = false
+	}
+	if cfg.Billing.Sandbox.WebhookDelaySeconds <= 0 {
+		cfg.Billing.Sandbox.WebhookDelaySeconds = 10
+	}
+	if cfg.Billing.Webhooks.MaxAttempts <= 0 {
+		cfg.Billing.Webhooks.MaxAttempts = 8
+	}
+	if cfg.Billing.Webhooks.RetryIntervalSeconds <= 0 {
+		cfg.Billing.Webhooks.RetryIntervalSeconds = 60
+	}
+	if cfg.Billing.Renewals.CheckIntervalMinutes <= 0 {
+		cfg.Billing.Renewals.CheckIntervalMinutes = 5
+	}
+	if cfg.Billing.Renewals.RenewBeforeHours <= 0 {
+		cfg.Billing.Renewals.RenewBeforeHours = 24
+	}
+	if cfg.Billing.Renewals.RetryIntervalHours <= 0 {
+		cfg.Billing.Renewals.RetryIntervalHours = 24
+	}
+	if cfg.Billing.Renewals.GracePeriodDays <= 0 {
+		cfg.Billing.Renewals.GracePeriodDays = 7
+	}
+	if cfg.Billing.Renewals.DunningDays == nil {
+		cfg.Billing.Renewals.DunningDays = []int{0, 3, 6}
+	}
+	sort.Ints(cfg.Billing.Renewals.DunningDays)
+	for _, day := range cfg.Billing.Renewals.DunningDays {
+		if day < 0 || day >= cfg.Billing.Renewals.GracePeriodDays {
+			return nil, fmt.Errorf("billing.renewals.dunning_days: напоминание на %d день вне льготного периода (%d дн.)", day, cfg.Billing.Renewals.GracePeriodDays)
+		}
+	}
+	if cfg.BCCGateway.ReturnURL == "" {
+		cfg.BCCGateway.ReturnURL = strings.TrimRight(cfg.BaseURL, "/") + "/billing/success"
 	}
 	if cfg.Billing.USDToKZTRate <= 0 {
 		return nil, fmt.Errorf("billing.usd_to_kzt_rate не задан или равен 0")
 	}
 
-	cfg.TokenMonthlyLimitKZT = float64(cfg.Billing.MonthlyAmount) / 100.0
-
-	slog.Info("Конфигурация загружена", "app_env", cfg.AppEnv, "base_url", cfg.BaseURL, "port", cfg.Port, "token_limit_kzt", cfg.TokenMonthlyLimitKZT)
+	slog.Info("Конфигурация загружена", "app_env", cfg.AppEnv, "base_url", cfg.BaseURL, "port", cfg.Port)
 	return &cfg, nil
 }
 
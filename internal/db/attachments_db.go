@@ -0,0 +1,188 @@
+// internal/db/attachments_db.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// MessageAttachment - файл, прикрепленный пользователем к сообщению (обмену в dialogues).
+// JSON-поля name/type/url совпадают с тем, что ожидает chat.js.
+type MessageAttachment struct {
+	ID           int64     `json:"id"`
+	DialogueID   int64     `json:"dialogue_id"`
+	OriginalName string    `json:"name"`
+	ServerPath   string    `json:"-"` // Только у вложений, загруженных до появления хранилища
+	SHA256       string    `json:"-"`
+	StorageKey   string    `json:"-"` // Ключ объекта в хранилище (storage.ContentKey)
+	URL          string    `json:"url"`
+	MIMEType     string    `json:"type"`
+	Size         int64     `json:"size"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AttachmentURL - адрес скачивания вложения через защищенный обработчик
+func AttachmentURL(id int64) string {
+	return fmt.Sprintf("/api/attachment?id=%d", id)
+}
+
+func CreateMessageAttachment(a *MessageAttachment) (int64, error) {
+	if DB == nil {
+		return 0, errors.New("БД не инициализирована")
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	res, err := DB.Exec(`INSERT INTO message_attachments (dialogue_id, original_name, server_path, content_sha256, storage_key, mime_type, size, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.DialogueID, a.OriginalName, a.ServerPath, nullString(a.SHA256), nullString(a.StorageKey), a.MIMEType, a.Size, a.CreatedAt)
+	if err != nil {
+		slog.Error("Ошибка сохранения вложения", "dialogue_id", a.DialogueID, "error", err)
+		return 0, fmt.Errorf("не удалось сохранить вложение: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("не удалось получить ID вложения: %w", err)
+	}
+	a.ID = id
+	a.URL = AttachmentURL(id)
+	return id, nil
+}
+
+const attachmentColumns = `a.id, a.dialogue_id, a.original_name, a.server_path, a.content_sha256, a.storage_key, a.mime_type, a.size, a.created_at`
+
+func scanAttachment(row rowScanner, extra ...interface{}) (*MessageAttachment, error) {
+	a := &MessageAttachment{}
+	var sha, storageKey sql.NullString
+	dest := append([]interface{}{&a.ID, &a.DialogueID, &a.OriginalName, &a.ServerPath, &sha, &storageKey, &a.MIMEType, &a.Size, &a.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	a.SHA256, a.StorageKey = sha.String, storageKey.String
+	a.URL = AttachmentURL(a.ID)
+	return a, nil
+}
+
+// GetAttachmentsForDialogues возвращает вложения указанных обменов, сгруппированные по dialogue_id
+func GetAttachmentsForDialogues(dialogueIDs []int64) (map[int64][]MessageAttachment, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	result := make(map[int64][]MessageAttachment)
+	if len(dialogueIDs) == 0 {
+		return result, nil
+	}
+	args := make([]interface{}, len(dialogueIDs))
+	for i, id := range dialogueIDs {
+		args[i] = id
+	}
+	query := `SELECT ` + attachmentColumns + ` FROM message_attachments a WHERE a.dialogue_id IN (?` + strings.Repeat(",?", len(dialogueIDs)-1) + `) ORDER BY a.id ASC`
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения вложений: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			slog.Error("Ошибка сканирования вложения", "error", err)
+			continue
+		}
+		result[a.DialogueID] = append(result[a.DialogueID], *a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при получении вложений: %w", err)
+	}
+	return result, nil
+}
+
+// GetAttachmentWithOwner возвращает вложение и ID пользователя, которому принадлежит сообщение
+func GetAttachmentWithOwner(id int64) (*MessageAttachment, int64, error) {
+	if DB == nil {
+		return nil, 0, errors.New("БД не инициализирована")
+	}
+	var ownerID int64
+	row := DB.QueryRow(`SELECT `+attachmentColumns+`, d.user_id FROM message_attachments a JOIN dialogues d ON d.id = a.dialogue_id WHERE a.id = ?`, id)
+	a, err := scanAttachment(row, &ownerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, fmt.Errorf("вложение %d не найдено: %w", id, sql.ErrNoRows)
+		}
+		return nil, 0, fmt.Errorf("ошибка получения вложения: %w", err)
+	}
+	return a, ownerID, nil
+}
+
+// GetUserStorageUsage возвращает объем вложений пользователя в байтах. Один и тот же файл,
+// прикрепленный несколько раз, в хранилище лежит однократно и считается один раз.
+func GetUserStorageUsage(userID int64) (int64, error) {
+	if DB == nil {
+		return 0, errors.New("БД не инициализирована")
+	}
+	var used int64
+	err := DB.QueryRow(`
+		SELECT COALESCE(SUM(t.size), 0) FROM (
+			SELECT MAX(a.size) AS size
+			FROM message_attachments a JOIN dialogues d ON d.id = a.dialogue_id
+			WHERE d.user_id = ?
+			GROUP BY COALESCE(a.storage_key, CONCAT('id:', a.id))
+		) t`, userID).Scan(&used)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка подсчета объема вложений пользователя: %w", err)
+	}
+	return used, nil
+}
+
+// UserHasStorageKey сообщает, есть ли у пользователя вложение с этим объектом хранилища
+// (повторная загрузка того же файла квоту не расходует)
+func UserHasStorageKey(userID int64, storageKey string) (bool, error) {
+	if DB == nil {
+		return false, errors.New("БД не инициализирована")
+	}
+	var exists bool
+	err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM message_attachments a JOIN dialogues d ON d.id = a.dialogue_id WHERE d.user_id = ? AND a.storage_key = ?)`,
+		userID, storageKey).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("ошибка проверки вложений пользователя: %w", err)
+	}
+	return exists, nil
+}
+
+// IsStorageKeyReferenced сообщает, ссылается ли хоть одно вложение на объект хранилища
+func IsStorageKeyReferenced(storageKey string) (bool, error) {
+	if DB == nil {
+		return false, errors.New("БД не инициализирована")
+	}
+	var exists bool
+	if err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM message_attachments WHERE storage_key = ?)`, storageKey).Scan(&exists); err != nil {
+		return false, fmt.Errorf("ошибка проверки ссылок на объект хранилища: %w", err)
+	}
+	return exists, nil
+}
+
+// GetReferencedStorageKeys возвращает все ключи хранилища, на которые ссылаются вложения
+func GetReferencedStorageKeys() (map[string]bool, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	rows, err := DB.Query(`SELECT DISTINCT storage_key FROM message_attachments WHERE storage_key IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ключей хранилища: %w", err)
+	}
+	defer rows.Close()
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования ключа хранилища: %w", err)
+		}
+		keys[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации ключей хранилища: %w", err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,400 @@
+// internal/db/chat_sessions_db.go
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Источники заголовка диалога (chat_sessions.title_source)
+const (
+	TitleSourceDefault   = "default"   // Дата создания, заголовок еще можно сгенерировать
+	TitleSourceGenerated = "generated" // Придуман LLM по первому обмену
+	TitleSourceUser      = "user"      // Задан пользователем
+)
+
+const chatSessionColumns = `uuid, user_id, title, title_source, title_attempts, mode, persona_slug, pinned_at, archived_at, deleted_at, created_at, updated_at`
+
+func scanChatSession(row rowScanner) (*ChatSessionMeta, error) {
+	s := &ChatSessionMeta{}
+	var title, mode, personaSlug sql.NullString
+	var pinnedAt, archivedAt, deletedAt sql.NullTime
+	if err := row.Scan(&s.UUID, &s.UserID, &title, &s.TitleSource, &s.TitleAttempts, &mode, &personaSlug, &pinnedAt, &archivedAt, &deletedAt, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	s.Title = chatSessionTitle(title, s.CreatedAt)
+	s.Mode = mode.String
+	s.PersonaSlug = personaSlug.String
+	s.PinnedAt = nullTimePtr(pinnedAt)
+	s.ArchivedAt = nullTimePtr(archivedAt)
+	s.DeletedAt = nullTimePtr(deletedAt)
+	return s, nil
+}
+
+func chatSessionTitle(title sql.NullString, createdAt time.Time) string {
+	if title.Valid && strings.TrimSpace(title.String) != "" {
+		return title.String
+	}
+	return "Диалог от " + createdAt.Format("02.01.06 15:04")
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// ChatSessionFilter - какие диалоги показывать в списке
+type ChatSessionFilter string
+
+const (
+	ChatSessionsActive   ChatSessionFilter = ""         // Обычный список: без архивных и удаленных
+	ChatSessionsArchived ChatSessionFilter = "archived" // Архив (без удаленных)
+	ChatSessionsDeleted  ChatSessionFilter = "deleted"  // Корзина
+)
+
+// ChatSessionCursor - позиция в списке диалогов. Список отсортирован по ключу
+// (закреплен, updated_at, uuid) по убыванию, следующая страница начинается строго после курсора.
+type ChatSessionCursor struct {
+	Pinned    bool      `json:"p"`
+	UpdatedAt time.Time `json:"t"`
+	UUID      string    `json:"u"`
+}
+
+// String кодирует курсор в непрозрачную для клиента строку
+func (c ChatSessionCursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseChatSessionCursor разбирает курсор, полученный от клиента
+func ParseChatSessionCursor(value string) (*ChatSessionCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный курсор: %w", err)
+	}
+	var c ChatSessionCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.UUID == "" {
+		return nil, errors.New("некорректный курсор")
+	}
+	return &c, nil
+}
+
+// GetUserChatSessions возвращает страницу диалогов пользователя: сначала закрепленные, затем
+// по времени последнего сообщения. after - курсор предыдущей страницы (nil - первая страница).
+// Второе значение - курсор следующей страницы, nil если страница последняя.
+func GetUserChatSessions(userID int64, filter ChatSessionFilter, after *ChatSessionCursor, limit int) ([]ChatSessionMeta, *ChatSessionCursor, error) {
+	if DB == nil {
+		return nil, nil, errors.New("БД не инициализирована")
+	}
+	query := `SELECT ` + chatSessionColumns + ` FROM chat_sessions WHERE user_id = ?`
+	args := []interface{}{userID}
+	switch filter {
+	case ChatSessionsArchived:
+		query += ` AND deleted_at IS NULL AND archived_at IS NOT NULL`
+	case ChatSessionsDeleted:
+		query += ` AND deleted_at IS NOT NULL`
+	default:
+		query += ` AND deleted_at IS NULL AND archived_at IS NULL`
+	}
+	if after != nil {
+		query += ` AND ((pinned_at IS NOT NULL) < ? OR ((pinned_at IS NOT NULL) = ? AND (updated_at < ? OR (updated_at = ? AND uuid < ?))))`
+		args = append(args, after.Pinned, after.Pinned, after.UpdatedAt, after.UpdatedAt, after.UUID)
+	}
+	// Лишняя строка показывает, есть ли следующая страница
+	query += ` ORDER BY (pinned_at IS NOT NULL) DESC, updated_at DESC, uuid DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка получения сессий пользователя: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []ChatSessionMeta{}
+	for rows.Next() {
+		s, err := scanChatSession(rows)
+		if err != nil {
+			slog.Error("Ошибка сканирования сессии", "error", err)
+			continue
+		}
+		sessions = append(sessions, *s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("ошибка итерации при получении сессий: %w", err)
+	}
+
+	if len(sessions) <= limit {
+		return sessions, nil, nil
+	}
+	sessions = sessions[:limit]
+	last := sessions[limit-1]
+	return sessions, &ChatSessionCursor{Pinned: last.PinnedAt != nil, UpdatedAt: last.UpdatedAt, UUID: last.UUID}, nil
+}
+
+// RenameChatSession задает заголовок от пользователя; после этого заголовок не генерируется.
+// Время последнего сообщения не меняется.
+func RenameChatSession(sessionUUID, title string) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	_, err := DB.Exec(`UPDATE chat_sessions SET title = ?, title_source = ?, updated_at = updated_at WHERE uuid = ?`, title, TitleSourceUser, sessionUUID)
+	if err != nil {
+		slog.Error("Ошибка переименования сессии", "uuid", sessionUUID, "error", err)
+		return fmt.Errorf("не удалось переименовать сессию: %w", err)
+	}
+	return nil
+}
+
+// MarkChatSessionTitleAttempt отмечает попытку сгенерировать заголовок (до запроса к LLM,
+// чтобы упавший процесс тоже израсходовал попытку)
+func MarkChatSessionTitleAttempt(sessionUUID string) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	_, err := DB.Exec(`UPDATE chat_sessions SET title_attempts = title_attempts + 1, title_attempted_at = ?, updated_at = updated_at WHERE uuid = ?`, time.Now(), sessionUUID)
+	if err != nil {
+		return fmt.Errorf("не удалось отметить попытку генерации заголовка: %w", err)
+	}
+	return nil
+}
+
+// SetGeneratedChatSessionTitle сохраняет сгенерированный заголовок, только если заголовок еще не менялся.
+// false - пользователь успел задать свой заголовок, сгенерированный отброшен.
+func SetGeneratedChatSessionTitle(sessionUUID, title string) (bool, error) {
+	if DB == nil {
+		return false, errors.New("БД не инициализирована")
+	}
+	res, err := DB.Exec(`UPDATE chat_sessions SET title = ?, title_source = ?, updated_at = updated_at WHERE uuid = ? AND title_source = ?`,
+		title, TitleSourceGenerated, sessionUUID, TitleSourceDefault)
+	if err != nil {
+		slog.Error("Ошибка сохранения сгенерированного заголовка", "uuid", sessionUUID, "error", err)
+		return false, fmt.Errorf("не удалось сохранить заголовок: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("не удалось проверить сохранение заголовка: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// GetChatSessionsForTitleRetry возвращает диалоги, для которых генерация заголовка завершилась ошибкой
+// не позже retryBefore и попытки еще остались
+func GetChatSessionsForTitleRetry(maxAttempts int, retryBefore time.Time, limit int) ([]string, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	rows, err := DB.Query(`SELECT uuid FROM chat_sessions
+		WHERE title_source = ? AND deleted_at IS NULL AND title_attempts > 0 AND title_attempts < ? AND title_attempted_at < ?
+		ORDER BY title_attempted_at LIMIT ?`, TitleSourceDefault, maxAttempts, retryBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения диалогов без заголовка: %w", err)
+	}
+	defer rows.Close()
+
+	var uuids []string
+	for rows.Next() {
+		var sessionUUID string
+		if err := rows.Scan(&sessionUUID); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования диалога без заголовка: %w", err)
+		}
+		uuids = append(uuids, sessionUUID)
+	}
+	return uuids, rows.Err()
+}
+
+// SetChatSessionPinned закрепляет диалог вверху списка или открепляет его
+func SetChatSessionPinned(sessionUUID string, pinned bool) error {
+	return setChatSessionState(sessionUUID, "pinned_at", pinned)
+}
+
+// SetChatSessionArchived переносит диалог в архив или возвращает из него
+func SetChatSessionArchived(sessionUUID string, archived bool) error {
+	return setChatSessionState(sessionUUID, "archived_at", archived)
+}
+
+// SetChatSessionDeleted переносит диалог в корзину (сообщения не удаляются) или восстанавливает его
+func SetChatSessionDeleted(sessionUUID string, deleted bool) error {
+	return setChatSessionState(sessionUUID, "deleted_at", deleted)
+}
+
+// setChatSessionState ставит или снимает отметку времени column. updated_at сохраняется,
+// иначе ON UPDATE CURRENT_TIMESTAMP переставил бы диалог в начало списка.
+func setChatSessionState(sessionUUID, column string, set bool) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	var value interface{}
+	if set {
+		value = time.Now()
+	}
+	_, err := DB.Exec(`UPDATE chat_sessions SET `+column+` = ?, updated_at = updated_at WHERE uuid = ?`, value, sessionUUID)
+	if err != nil {
+		slog.Error("Ошибка обновления состояния сессии", "uuid", sessionUUID, "column", column, "set", set, "error", err)
+		return fmt.Errorf("не удалось обновить сессию: %w", err)
+	}
+	return nil
+}
+
+// DialogueSearchResult - обмен, найденный поиском по диалогам
+type DialogueSearchResult struct {
+	DialogueID   int64     `json:"dialogue_id"`
+	SessionUUID  string    `json:"session_uuid"`
+	SessionTitle string    `json:"session_title"`
+	UserPrompt   string    `json:"-"`
+	AIResponse   string    `json:"-"`
+	Snippet      string    `json:"snippet"` // Заполняет вызывающий код
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Слова короче innodb_ft_min_token_size (по умолчанию 3) не попадают в индекс, искать их бесполезно
+const searchMinTermLength = 3
+
+// SearchTerms разбивает поисковую строку на слова без операторов полнотекстового поиска
+func SearchTerms(query string) []string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := words[:0]
+	for _, w := range words {
+		if utf8.RuneCountInString(w) >= searchMinTermLength {
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// SearchUserDialogues ищет по тексту обменов пользователя (FULLTEXT-индекс dialogues).
+// Нужны все слова запроса, каждое ищется по началу, чтобы находились другие словоформы.
+// Диалоги из корзины и обмены из неактивных веток не ищутся, из архива - ищутся.
+func SearchUserDialogues(userID int64, query string, limit int) ([]DialogueSearchResult, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	terms := SearchTerms(query)
+	results := []DialogueSearchResult{}
+	if len(terms) == 0 {
+		return results, nil
+	}
+	against := "+" + strings.Join(terms, "* +") + "*"
+
+	rows, err := DB.Query(`SELECT d.id, d.chat_session_uuid, s.title, s.created_at, d.user_prompt, d.ai_response, d.created_at
+		FROM dialogues d JOIN chat_sessions s ON s.uuid = d.chat_session_uuid
+		WHERE d.user_id = ? AND s.deleted_at IS NULL AND d.active_branch = TRUE AND MATCH(d.user_prompt, d.ai_response) AGAINST (? IN BOOLEAN MODE)
+		ORDER BY MATCH(d.user_prompt, d.ai_response) AGAINST (? IN BOOLEAN MODE) DESC, d.id DESC
+		LIMIT ?`, userID, against, against, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска по диалогам: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var res DialogueSearchResult
+		var title, aiResponse sql.NullString
+		var sessionCreatedAt time.Time
+		if err := rows.Scan(&res.DialogueID, &res.SessionUUID, &title, &sessionCreatedAt, &res.UserPrompt, &aiResponse, &res.CreatedAt); err != nil {
+			slog.Error("Ошибка сканирования результата поиска", "error", err)
+			continue
+		}
+		res.SessionTitle = chatSessionTitle(title, sessionCreatedAt)
+		res.AIResponse = aiResponse.String
+		results = append(results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при поиске по диалогам: %w", err)
+	}
+	return results, nil
+}
+
+// TranscriptTurn - обмен со временем отправки, для выгрузки диалога целиком
+type TranscriptTurn struct {
+	ID         int64
+	UserPrompt string
+	AIResponse string
+	CreatedAt  time.Time
+}
+
+// GetChatSessionTranscript возвращает все обмены текущей ветки сессии в хронологическом порядке
+func GetChatSessionTranscript(chatSessionUUID string) ([]TranscriptTurn, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	return queryTranscript(`SELECT id, user_prompt, ai_response, created_at FROM dialogues WHERE chat_session_uuid = ? AND active_branch = TRUE ORDER BY id ASC`, chatSessionUUID)
+}
+
+// GetDialogueBranchTranscript возвращает обмены ветки, которая заканчивается обменом leafID
+// (0 - пустая ветка), независимо от того, какая ветка сейчас текущая
+func GetDialogueBranchTranscript(chatSessionUUID string, leafID int64) ([]TranscriptTurn, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	ids, err := dialoguePathIDs(chatSessionUUID, leafID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []TranscriptTurn{}, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return queryTranscript(`SELECT id, user_prompt, ai_response, created_at FROM dialogues WHERE id IN (?`+strings.Repeat(",?", len(ids)-1)+`) ORDER BY id ASC`, args...)
+}
+
+func queryTranscript(query string, args ...interface{}) ([]TranscriptTurn, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения обменов сессии: %w", err)
+	}
+	defer rows.Close()
+
+	turns := []TranscriptTurn{}
+	for rows.Next() {
+		var turn TranscriptTurn
+		var aiResponse sql.NullString
+		if err := rows.Scan(&turn.ID, &turn.UserPrompt, &aiResponse, &turn.CreatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования обмена сессии: %w", err)
+		}
+		turn.AIResponse = aiResponse.String
+		turns = append(turns, turn)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при получении обменов сессии: %w", err)
+	}
+	return turns, nil
+}
+
+// GetAllUserChatSessions возвращает все диалоги пользователя, включая архив и корзину, от старых к новым
+func GetAllUserChatSessions(userID int64) ([]ChatSessionMeta, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	rows, err := DB.Query(`SELECT `+chatSessionColumns+` FROM chat_sessions WHERE user_id = ? ORDER BY created_at ASC, uuid ASC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения сессий пользователя: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []ChatSessionMeta{}
+	for rows.Next() {
+		s, err := scanChatSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования сессии: %w", err)
+		}
+		sessions = append(sessions, *s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при получении сессий: %w", err)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,182 @@
+// internal/db/chat_shares_db.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ChatShare - публичная ссылка на диалог только для чтения. Сам токен не хранится,
+// поэтому адрес ссылки можно показать только при создании.
+type ChatShare struct {
+	ID                int64      `json:"id"`
+	ChatSessionUUID   string     `json:"session_uuid"`
+	SessionTitle      string     `json:"session_title"`
+	UserID            int64      `json:"-"`
+	LastDialogueID    int64      `json:"-"` // Последний обмен ветки, попавшей в ссылку
+	RedactAttachments bool       `json:"redact_attachments"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	ViewCount         int64      `json:"view_count"`
+	LastViewedAt      *time.Time `json:"last_viewed_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	Active            bool       `json:"active"` // Не отозвана и срок действия не истек на момент чтения
+}
+
+const chatShareColumns = `sh.id, sh.chat_session_uuid, s.title, s.created_at, sh.user_id, sh.last_dialogue_id, sh.redact_attachments,
+	sh.expires_at, sh.revoked_at, sh.view_count, sh.last_viewed_at, sh.created_at`
+
+const chatShareFrom = ` FROM chat_shares sh JOIN chat_sessions s ON s.uuid = sh.chat_session_uuid`
+
+func scanChatShare(row rowScanner) (*ChatShare, error) {
+	sh := &ChatShare{}
+	var title sql.NullString
+	var sessionCreatedAt time.Time
+	var expiresAt, revokedAt, lastViewedAt sql.NullTime
+	if err := row.Scan(&sh.ID, &sh.ChatSessionUUID, &title, &sessionCreatedAt, &sh.UserID, &sh.LastDialogueID, &sh.RedactAttachments,
+		&expiresAt, &revokedAt, &sh.ViewCount, &lastViewedAt, &sh.CreatedAt); err != nil {
+		return nil, err
+	}
+	sh.SessionTitle = chatSessionTitle(title, sessionCreatedAt)
+	sh.ExpiresAt = nullTimePtr(expiresAt)
+	sh.RevokedAt = nullTimePtr(revokedAt)
+	sh.LastViewedAt = nullTimePtr(lastViewedAt)
+	sh.Active = sh.RevokedAt == nil && (sh.ExpiresAt == nil || time.Now().Before(*sh.ExpiresAt))
+	return sh, nil
+}
+
+// CreateChatShare создает ссылку на текущую ветку диалога в ее нынешнем состоянии: обмены, добавленные
+// позже, и другие ветки по ссылке не видны. Возвращает ID ссылки.
+func CreateChatShare(userID int64, chatSessionUUID, rawToken string, expiresAt *time.Time, redactAttachments bool) (int64, error) {
+	if DB == nil {
+		return 0, errors.New("БД не инициализирована")
+	}
+	res, err := DB.Exec(`INSERT INTO chat_shares (token_hash, chat_session_uuid, user_id, last_dialogue_id, redact_attachments, expires_at)
+		VALUES (?, ?, ?, (SELECT COALESCE(MAX(id), 0) FROM dialogues WHERE chat_session_uuid = ? AND active_branch = TRUE), ?, ?)`,
+		HashToken(rawToken), chatSessionUUID, userID, chatSessionUUID, redactAttachments, expiresAt)
+	if err != nil {
+		slog.Error("Ошибка создания ссылки на диалог", "uuid", chatSessionUUID, "user_id", userID, "error", err)
+		return 0, fmt.Errorf("не удалось создать ссылку на диалог: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// GetChatShareByToken находит ссылку по токену из адреса, в том числе отозванную или истекшую
+func GetChatShareByToken(rawToken string) (*ChatShare, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	sh, err := scanChatShare(DB.QueryRow(`SELECT `+chatShareColumns+chatShareFrom+` WHERE sh.token_hash = ?`, HashToken(rawToken)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("ссылка на диалог не найдена: %w", sql.ErrNoRows)
+		}
+		return nil, fmt.Errorf("ошибка получения ссылки на диалог: %w", err)
+	}
+	return sh, nil
+}
+
+// GetChatShare возвращает ссылку по ID
+func GetChatShare(id int64) (*ChatShare, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	sh, err := scanChatShare(DB.QueryRow(`SELECT `+chatShareColumns+chatShareFrom+` WHERE sh.id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("ссылка на диалог %d не найдена: %w", id, sql.ErrNoRows)
+		}
+		return nil, fmt.Errorf("ошибка получения ссылки на диалог: %w", err)
+	}
+	return sh, nil
+}
+
+// GetUserChatShares возвращает ссылки пользователя, новые сначала, включая отозванные и истекшие.
+// Если chatSessionUUID не пуст - только ссылки на этот диалог.
+func GetUserChatShares(userID int64, chatSessionUUID string) ([]ChatShare, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	query := `SELECT ` + chatShareColumns + chatShareFrom + ` WHERE sh.user_id = ?`
+	args := []interface{}{userID}
+	if chatSessionUUID != "" {
+		query += ` AND sh.chat_session_uuid = ?`
+		args = append(args, chatSessionUUID)
+	}
+	query += ` ORDER BY sh.created_at DESC, sh.id DESC`
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ссылок на диалоги: %w", err)
+	}
+	defer rows.Close()
+
+	shares := []ChatShare{}
+	for rows.Next() {
+		sh, err := scanChatShare(rows)
+		if err != nil {
+			slog.Error("Ошибка сканирования ссылки на диалог", "error", err)
+			continue
+		}
+		shares = append(shares, *sh)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при получении ссылок на диалоги: %w", err)
+	}
+	return shares, nil
+}
+
+// RevokeChatShare отзывает ссылку; повторный отзыв не меняет время первого
+func RevokeChatShare(id int64) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	_, err := DB.Exec(`UPDATE chat_shares SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now(), id)
+	if err != nil {
+		slog.Error("Ошибка отзыва ссылки на диалог", "share_id", id, "error", err)
+		return fmt.Errorf("не удалось отозвать ссылку на диалог: %w", err)
+	}
+	return nil
+}
+
+// RecordChatShareView учитывает просмотр диалога по ссылке
+func RecordChatShareView(id int64) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	_, err := DB.Exec(`UPDATE chat_shares SET view_count = view_count + 1, last_viewed_at = ? WHERE id = ?`, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("не удалось учесть просмотр ссылки на диалог: %w", err)
+	}
+	return nil
+}
+
+// GetChatShareAttachment возвращает вложение, только если оно прикреплено к обмену, попавшему в ссылку
+func GetChatShareAttachment(share *ChatShare, attachmentID int64) (*MessageAttachment, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	notFound := fmt.Errorf("вложение %d не найдено в ссылке %d: %w", attachmentID, share.ID, sql.ErrNoRows)
+	row := DB.QueryRow(`SELECT `+attachmentColumns+` FROM message_attachments a JOIN dialogues d ON d.id = a.dialogue_id
+		WHERE a.id = ? AND d.chat_session_uuid = ?`, attachmentID, share.ChatSessionUUID)
+	a, err := scanAttachment(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notFound
+		}
+		return nil, fmt.Errorf("ошибка получения вложения: %w", err)
+	}
+	path, err := dialoguePathIDs(share.ChatSessionUUID, share.LastDialogueID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range path {
+		if id == a.DialogueID {
+			return a, nil
+		}
+	}
+	return nil, notFound
+}
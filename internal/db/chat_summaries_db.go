@@ -0,0 +1,115 @@
+// internal/db/chat_summaries_db.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// DialogueTurn - один обмен "сообщение пользователя - ответ ассистента"
+type DialogueTurn struct {
+	ID         int64
+	UserPrompt string
+	AIResponse string
+}
+
+// ChatSessionSummary - резюме старой части диалога
+type ChatSessionSummary struct {
+	SessionUUID    string
+	Summary        string
+	CoveredUntilID int64 // ID последнего обмена, вошедшего в резюме
+	UpdatedAt      time.Time
+}
+
+func queryDialogueTurns(query string, args ...interface{}) ([]DialogueTurn, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения обменов сессии: %w", err)
+	}
+	defer rows.Close()
+
+	var turns []DialogueTurn
+	for rows.Next() {
+		var turn DialogueTurn
+		var aiResponse sql.NullString
+		if err := rows.Scan(&turn.ID, &turn.UserPrompt, &aiResponse); err != nil {
+			slog.Error("Ошибка сканирования обмена сессии", "error", err)
+			continue
+		}
+		turn.AIResponse = aiResponse.String
+		turns = append(turns, turn)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при получении обменов сессии: %w", err)
+	}
+	return turns, nil
+}
+
+// GetRecentDialogueTurns возвращает последние limit обменов текущей ветки сессии в хронологическом порядке
+func GetRecentDialogueTurns(chatSessionUUID string, limit int) ([]DialogueTurn, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	return queryDialogueTurns(`SELECT id, user_prompt, ai_response FROM (
+			SELECT id, user_prompt, ai_response FROM dialogues WHERE chat_session_uuid = ? AND active_branch = TRUE ORDER BY id DESC LIMIT ?
+		) recent ORDER BY id ASC`, chatSessionUUID, limit)
+}
+
+// GetDialogueTurnsBetween возвращает обмены текущей ветки с afterID < id < beforeID в хронологическом порядке
+func GetDialogueTurnsBetween(chatSessionUUID string, afterID, beforeID int64, limit int) ([]DialogueTurn, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	return queryDialogueTurns(`SELECT id, user_prompt, ai_response FROM dialogues
+		WHERE chat_session_uuid = ? AND active_branch = TRUE AND id > ? AND id < ? ORDER BY id ASC LIMIT ?`, chatSessionUUID, afterID, beforeID, limit)
+}
+
+// CountDialogueTurnsBetween считает обмены текущей ветки с afterID < id < beforeID
+func CountDialogueTurnsBetween(chatSessionUUID string, afterID, beforeID int64) (int, error) {
+	if DB == nil {
+		return 0, errors.New("БД не инициализирована")
+	}
+	var count int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM dialogues WHERE chat_session_uuid = ? AND active_branch = TRUE AND id > ? AND id < ?`,
+		chatSessionUUID, afterID, beforeID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка подсчета обменов сессии: %w", err)
+	}
+	return count, nil
+}
+
+// GetChatSessionSummary возвращает резюме сессии или nil, если его еще нет
+func GetChatSessionSummary(chatSessionUUID string) (*ChatSessionSummary, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	s := &ChatSessionSummary{}
+	err := DB.QueryRow(`SELECT chat_session_uuid, summary, covered_until_dialogue_id, updated_at FROM chat_session_summaries WHERE chat_session_uuid = ?`,
+		chatSessionUUID).Scan(&s.SessionUUID, &s.Summary, &s.CoveredUntilID, &s.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения резюме сессии: %w", err)
+	}
+	return s, nil
+}
+
+// SaveChatSessionSummary создает или заменяет резюме сессии
+func SaveChatSessionSummary(chatSessionUUID, summary string, coveredUntilID int64) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	_, err := DB.Exec(`INSERT INTO chat_session_summaries (chat_session_uuid, summary, covered_until_dialogue_id, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE summary = VALUES(summary), covered_until_dialogue_id = VALUES(covered_until_dialogue_id), updated_at = VALUES(updated_at)`,
+		chatSessionUUID, summary, coveredUntilID, time.Now())
+	if err != nil {
+		slog.Error("Ошибка сохранения резюме сессии", "chat_uuid", chatSessionUUID, "error", err)
+		return fmt.Errorf("не удалось сохранить резюме сессии: %w", err)
+	}
+	return nil
+}
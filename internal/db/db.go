@@ -18,8 +18,6 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/mysql"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-
-	"github.com/google/uuid"
 )
 
 var DB *sql.DB
@@ -52,6 +50,9 @@ func RunMigrations(dbConn *sql.DB, dbName string) error {
 
 	slog.Info("Применение миграций MariaDB...", "path", migrationsURL)
 	err = m.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) && recoverGatewayFieldsMigration(dbConn, m) {
+		err = m.Up()
+	}
 
 	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
 		version, dirty, verr := m.Version()
@@ -72,6 +73,34 @@ func RunMigrations(dbConn *sql.DB, dbName string) error {
 	return nil
 }
 
+// Миграция 000015 добавляет столбцы шлюза и затем выполняет COMMENT ON COLUMN - синтаксис PostgreSQL,
+// который MariaDB отклоняет. Столбцы к этому моменту уже добавлены, а версия остается "грязной".
+// Выпущенную миграцию не меняем: если столбцы на месте, снимаем пометку и продолжаем,
+// комментарии к столбцам задает миграция 000032.
+const gatewayFieldsMigrationVersion = 15
+
+// recoverGatewayFieldsMigration возвращает true, если пометка с миграции 000015 снята и Up можно повторить
+func recoverGatewayFieldsMigration(dbConn *sql.DB, m *migrate.Migrate) bool {
+	version, dirty, err := m.Version()
+	if err != nil || !dirty || version != gatewayFieldsMigrationVersion {
+		return false
+	}
+	var columns int
+	err = dbConn.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = 'payments' AND column_name IN ('gateway_order_id', 'gateway_name')`).Scan(&columns)
+	if err != nil || columns != 2 {
+		slog.Error("Миграция 000015 не применилась: столбцы шлюза в payments не найдены", "found_columns", columns, "error", err)
+		return false
+	}
+	if err := m.Force(gatewayFieldsMigrationVersion); err != nil {
+		slog.Error("Не удалось снять пометку с миграции 000015", "error", err)
+		return false
+	}
+	slog.Warn("Миграция 000015 остановилась на COMMENT ON, столбцы шлюза уже добавлены - продолжаем применение миграций")
+	return true
+}
+
 func InitDB(appConfig *config.Config) error {
 	var err error
 	var dsn string
@@ -192,20 +221,34 @@ func InitDB(appConfig *config.Config) error {
 
 // Функции для Chat Sessions
 type ChatSessionMeta struct {
-	UUID      string    `json:"uuid"`
-	UserID    int64     `json:"user_id"`
-	Title     string    `json:"title"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	UUID   string `json:"uuid"`
+	UserID int64  `json:"user_id"`
+	Title  string `json:"title"`
+	// Откуда взят заголовок (TitleSource*); пользовательский заголовок автоматически не меняется
+	TitleSource   string `json:"title_source"`
+	TitleAttempts int    `json:"-"` // Сколько раз пытались сгенерировать заголовок
+	Mode   string `json:"mode,omitempty"` // Закрепленный режим диалога, пусто - еще не определен
+	// Персона, выбранная пользователем при создании диалога; пусто - режим выбирается автоматически
+	PersonaSlug string     `json:"persona_slug,omitempty"`
+	PinnedAt    *time.Time `json:"pinned_at,omitempty"`
+	ArchivedAt  *time.Time `json:"archived_at,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"` // Диалог в корзине, его можно восстановить
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
 }
 
-func CreateChatSession(userID int64, sessionUUID, title string) error {
+// CreateChatSession создает сессию чата. Пустой personaSlug означает автоматический выбор режима.
+func CreateChatSession(userID int64, sessionUUID, title, personaSlug string) error {
 	if DB == nil {
 		return errors.New("БД не инициализирована")
 	}
-	query := `INSERT INTO chat_sessions (uuid, user_id, title, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`
+	query := `INSERT INTO chat_sessions (uuid, user_id, title, persona_slug, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`
 	now := time.Now()
-	_, err := DB.Exec(query, sessionUUID, userID, title, now, now)
+	var persona sql.NullString
+	if personaSlug != "" {
+		persona = sql.NullString{String: personaSlug, Valid: true}
+	}
+	_, err := DB.Exec(query, sessionUUID, userID, title, persona, now, now)
 	if err != nil {
 		slog.Error("Ошибка создания сессии чата", "userID", userID, "uuid", sessionUUID, "error", err)
 		return fmt.Errorf("не удалось создать сессию чата: %w", err)
@@ -226,63 +269,66 @@ func UpdateChatSessionTimestamp(sessionUUID string) error {
 	return nil
 }
 
-func GetUserChatSessions(userID int64, limit int) ([]ChatSessionMeta, error) {
-	if DB == nil {
-		return nil, errors.New("БД не инициализирована")
-	}
-	query := `SELECT uuid, user_id, title, created_at, updated_at FROM chat_sessions WHERE user_id = ? ORDER BY updated_at DESC LIMIT ?`
-	rows, err := DB.Query(query, userID, limit)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка получения сессий пользователя: %w", err)
-	}
-	defer rows.Close()
-
-	var sessions []ChatSessionMeta
-	for rows.Next() {
-		var s ChatSessionMeta
-		var title sql.NullString
-		if err := rows.Scan(&s.UUID, &s.UserID, &title, &s.CreatedAt, &s.UpdatedAt); err != nil {
-			slog.Error("Ошибка сканирования сессии", "error", err)
-			continue
-		}
-		if title.Valid && strings.TrimSpace(title.String) != "" { 
-			s.Title = title.String
-		} else {
-			s.Title = "Диалог от " + s.CreatedAt.Format("02.01.06 15:04")
-		}
-		sessions = append(sessions, s)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("ошибка итерации при получении сессий: %w", err)
-	}
-	return sessions, nil
-}
-
 // Функции для Сообщений Диалога
 type Message struct {
-	Role    string `json:"Role"`
-	Content string `json:"Content"`
+	Role        string              `json:"Role"`
+	Content     string              `json:"Content"`
+	DialogueID  int64               `json:"DialogueID,omitempty"`
+	Attachments []MessageAttachment `json:"Attachments,omitempty"` // Только у сообщений пользователя
+	// Варианты обмена на этом месте (другие ответы и отредактированные сообщения), включая текущий.
+	// Только у сообщений пользователя и только если вариантов больше одного.
+	Alternatives []int64 `json:"Alternatives,omitempty"`
+	// Оценка ответа пользователем: 1, -1 или 0 - нет оценки. Только у ответов ассистента.
+	Rating int `json:"Rating,omitempty"`
+}
+
+// DialogueOrigin - чем получен ответ обмена: режим диалога и модель
+type DialogueOrigin struct {
+	PersonaSlug string
+	Model       string
 }
 
-func SaveChatMessage(userID int64, chatSessionUUID string, userPrompt, aiResponse string) error {
+// SaveChatMessage сохраняет обмен в конец текущей ветки диалога и возвращает его ID в dialogues
+func SaveChatMessage(userID int64, chatSessionUUID string, userPrompt, aiResponse string, origin DialogueOrigin) (int64, error) {
 	if DB == nil {
-		return errors.New("БД не инициализирована")
+		return 0, errors.New("БД не инициализирована")
 	}
-	query := `INSERT INTO dialogues (user_id, chat_session_uuid, user_prompt, ai_response, created_at) VALUES (?, ?, ?, ?, ?)`
-	_, err := DB.Exec(query, userID, chatSessionUUID, userPrompt, aiResponse, time.Now())
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка сохранения сообщения: %w", err)
+	}
+	defer tx.Rollback()
+
+	var parentID sql.NullInt64
+	err = tx.QueryRow(`SELECT MAX(id) FROM dialogues WHERE chat_session_uuid = ? AND active_branch = TRUE FOR UPDATE`, chatSessionUUID).Scan(&parentID)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения текущей ветки диалога: %w", err)
+	}
+	query := `INSERT INTO dialogues (user_id, chat_session_uuid, parent_id, user_prompt, ai_response, persona_slug, model, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := tx.Exec(query, userID, chatSessionUUID, parentID, userPrompt, aiResponse, nullString(origin.PersonaSlug), nullString(origin.Model), time.Now())
 	if err != nil {
 		slog.Error("Ошибка сохранения сообщения", "userID", userID, "chatUUID", chatSessionUUID, "error", err)
-		return fmt.Errorf("ошибка сохранения сообщения: %w", err)
+		return 0, fmt.Errorf("ошибка сохранения сообщения: %w", err)
+	}
+	dialogueID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("не удалось получить ID сообщения: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("ошибка сохранения сообщения: %w", err)
 	}
 	go UpdateChatSessionTimestamp(chatSessionUUID) // Обновляем время последнего сообщения в сессии
-	return nil
+	return dialogueID, nil
 }
 
+// GetMessagesForChatSession возвращает сообщения последних limit обменов текущей ветки сессии в хронологическом порядке
 func GetMessagesForChatSession(chatSessionUUID string, limit int) ([]Message, error) {
 	if DB == nil {
 		return nil, errors.New("БД не инициализирована")
 	}
-	query := `SELECT user_prompt, ai_response FROM dialogues WHERE chat_session_uuid = ? ORDER BY created_at ASC LIMIT ?`
+	query := `SELECT id, user_prompt, ai_response FROM (
+			SELECT id, user_prompt, ai_response FROM dialogues WHERE chat_session_uuid = ? AND active_branch = TRUE ORDER BY id DESC LIMIT ?
+		) recent ORDER BY id ASC`
 	rows, err := DB.Query(query, chatSessionUUID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка получения сообщений сессии: %w", err)
@@ -291,15 +337,16 @@ func GetMessagesForChatSession(chatSessionUUID string, limit int) ([]Message, er
 
 	var messages []Message
 	for rows.Next() {
+		var dialogueID int64
 		var userPrompt string
 		var aiResponse sql.NullString 
-		if err := rows.Scan(&userPrompt, &aiResponse); err != nil {
+		if err := rows.Scan(&dialogueID, &userPrompt, &aiResponse); err != nil {
 			slog.Error("Ошибка сканирования сообщения сессии", "chatUUID", chatSessionUUID, "error", err)
 			continue
 		}
-		messages = append(messages, Message{Role: "user", Content: userPrompt})
+		messages = append(messages, Message{Role: "user", Content: userPrompt, DialogueID: dialogueID})
 		if aiResponse.Valid && aiResponse.String != "" { // Добавляем ответ ассистента только если он не пустой
-			messages = append(messages, Message{Role: "assistant", Content: aiResponse.String})
+			messages = append(messages, Message{Role: "assistant", Content: aiResponse.String, DialogueID: dialogueID})
 		}
 	}
 	if err := rows.Err(); err != nil {
@@ -312,26 +359,28 @@ func GetChatSessionMeta(sessionUUID string) (*ChatSessionMeta, error) {
 	if DB == nil {
 		return nil, errors.New("БД не инициализирована")
 	}
-	query := `SELECT uuid, user_id, title, created_at, updated_at FROM chat_sessions WHERE uuid = ?`
-	row := DB.QueryRow(query, sessionUUID)
-
-	var s ChatSessionMeta
-	var dbTitle sql.NullString
-
-	err := row.Scan(&s.UUID, &s.UserID, &dbTitle, &s.CreatedAt, &s.UpdatedAt)
+	query := `SELECT ` + chatSessionColumns + ` FROM chat_sessions WHERE uuid = ?`
+	s, err := scanChatSession(DB.QueryRow(query, sessionUUID))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("сессия чата с UUID %s не найдена: %w", sessionUUID, sql.ErrNoRows)
 		}
 		return nil, fmt.Errorf("ошибка сканирования метаданных сессии чата: %w", err)
 	}
+	return s, nil
+}
 
-	if dbTitle.Valid && strings.TrimSpace(dbTitle.String) != "" { // Проверка на пустую строку после TrimSpace
-		s.Title = dbTitle.String
-	} else {
-		s.Title = "Диалог от " + s.CreatedAt.Format("02.01.06 15:04")
+// SetChatSessionMode закрепляет режим диалога за сессией
+func SetChatSessionMode(sessionUUID, mode string) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	_, err := DB.Exec(`UPDATE chat_sessions SET mode = ? WHERE uuid = ?`, mode, sessionUUID)
+	if err != nil {
+		slog.Error("Ошибка обновления режима сессии", "uuid", sessionUUID, "mode", mode, "error", err)
+		return fmt.Errorf("не удалось обновить режим сессии: %w", err)
 	}
-	return &s, nil
+	return nil
 }
 
 // Функции для подписок и платежей
@@ -383,68 +432,24 @@ func CreateOrUpdateSubscription(sub *models.Subscription) error {
 	return nil
 }
 
-func CreatePayment(payment *models.Payment) error {
-	if DB == nil {
-		return errors.New("БД не инициализирована")
-	}
-	if payment.ID == "" {
-		payment.ID = "pay_" + uuid.NewString()[:12]
-	}
-	query := `
-	INSERT INTO payments (id, user_id, subscription_id, payment_gateway_transaction_id, amount, currency, status, payment_date, created_at, updated_at)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
-	`
-	now := time.Now()
-	var createdAt, paymentDate, updatedAt time.Time
-
-	if payment.CreatedAt.IsZero() {
-		createdAt = now
-	} else {
-		createdAt = payment.CreatedAt
-	}
-	if payment.PaymentDate.IsZero() {
-		paymentDate = now
-	} else {
-		paymentDate = payment.PaymentDate
-	}
-	updatedAt = now 
-
-	_, err := DB.Exec(query,
-		payment.ID,
-		payment.UserID,
-		sql.NullString{String: payment.SubscriptionID, Valid: payment.SubscriptionID != ""},
-		payment.PaymentGatewayTransactionID,
-		payment.Amount,
-		payment.Currency,
-		payment.Status,
-		paymentDate,
-		createdAt,
-		updatedAt, 
-	)
-	if err != nil {
-		slog.Error("Ошибка создания записи о платеже в БД", "transactionID", payment.PaymentGatewayTransactionID, "userID", payment.UserID, "error", err)
-		return fmt.Errorf("не удалось сохранить платеж: %w", err)
-	}
-	return nil
-}
-
 func GetSubscriptionByGatewayID(gatewaySubscriptionID string) (*models.Subscription, error) {
 	if DB == nil {
 		return nil, errors.New("БД не инициализирована")
 	}
 	query := `SELECT id, user_id, payment_gateway_subscription_id, plan_id, status,
 	                 start_date, end_date, current_period_start, current_period_end,
-	                 cancel_at_period_end, created_at, updated_at
+	                 cancel_at_period_end, created_at, updated_at, recurring_token, recurring_gateway, billing_period_months
 	          FROM subscriptions WHERE payment_gateway_subscription_id = ?`
 	row := DB.QueryRow(query, gatewaySubscriptionID)
 	var sub models.Subscription
 	var startDate, endDate, currentPeriodStart, currentPeriodEnd sql.NullTime
 	var createdAt, updatedAt sql.NullTime
+	var recurringToken, recurringGateway sql.NullString
 
 	err := row.Scan(
 		&sub.ID, &sub.UserID, &sub.PaymentGatewaySubscriptionID, &sub.PlanID, &sub.Status,
 		&startDate, &endDate, &currentPeriodStart, &currentPeriodEnd,
-		&sub.CancelAtPeriodEnd, &createdAt, &updatedAt,
+		&sub.CancelAtPeriodEnd, &createdAt, &updatedAt, &recurringToken, &recurringGateway, &sub.BillingPeriodMonths,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -471,6 +476,7 @@ func GetSubscriptionByGatewayID(gatewaySubscriptionID string) (*models.Subscript
 	if updatedAt.Valid {
 		sub.UpdatedAt = updatedAt.Time
 	}
+	sub.RecurringToken, sub.RecurringGateway = recurringToken.String, recurringGateway.String
 
 	return &sub, nil
 }
\ No newline at end of file
@@ -0,0 +1,232 @@
+// internal/db/dialogue_branches_db.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Dialogue - один обмен с его местом в дереве веток диалога
+type Dialogue struct {
+	ID              int64
+	UserID          int64
+	ChatSessionUUID string
+	ParentID        int64 // 0 - первый обмен диалога
+	ActiveBranch    bool
+	UserPrompt      string
+	AIResponse      string
+	CreatedAt       time.Time
+}
+
+// GetDialogue возвращает обмен по ID
+func GetDialogue(id int64) (*Dialogue, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	d := &Dialogue{}
+	var parentID sql.NullInt64
+	var aiResponse sql.NullString
+	err := DB.QueryRow(`SELECT id, user_id, chat_session_uuid, parent_id, active_branch, user_prompt, ai_response, created_at FROM dialogues WHERE id = ?`, id).
+		Scan(&d.ID, &d.UserID, &d.ChatSessionUUID, &parentID, &d.ActiveBranch, &d.UserPrompt, &aiResponse, &d.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("обмен %d не найден: %w", id, sql.ErrNoRows)
+		}
+		return nil, fmt.Errorf("ошибка получения обмена: %w", err)
+	}
+	d.ParentID = parentID.Int64
+	d.AIResponse = aiResponse.String
+	return d, nil
+}
+
+// dialogueNode - обмен без текста, для вычисления веток
+type dialogueNode struct {
+	id       int64
+	parentID int64
+	active   bool
+}
+
+// dialogueTree - все обмены сессии: по ID и дети каждого обмена по возрастанию ID (0 - первые обмены)
+type dialogueTree struct {
+	nodes    map[int64]dialogueNode
+	children map[int64][]int64
+}
+
+func loadDialogueTree(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, chatSessionUUID string, forUpdate bool) (*dialogueTree, error) {
+	query := `SELECT id, parent_id, active_branch FROM dialogues WHERE chat_session_uuid = ? ORDER BY id ASC`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	rows, err := q.Query(query, chatSessionUUID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения веток диалога: %w", err)
+	}
+	defer rows.Close()
+
+	tree := &dialogueTree{nodes: make(map[int64]dialogueNode), children: make(map[int64][]int64)}
+	for rows.Next() {
+		var n dialogueNode
+		var parentID sql.NullInt64
+		if err := rows.Scan(&n.id, &parentID, &n.active); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования ветки диалога: %w", err)
+		}
+		n.parentID = parentID.Int64
+		tree.nodes[n.id] = n
+		tree.children[n.parentID] = append(tree.children[n.parentID], n.id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при получении веток диалога: %w", err)
+	}
+	return tree, nil
+}
+
+// path возвращает ID обменов от первого до leafID включительно (leafID 0 - пустой путь)
+func (t *dialogueTree) path(leafID int64) []int64 {
+	var ids []int64
+	for id := leafID; id != 0; id = t.nodes[id].parentID {
+		if _, ok := t.nodes[id]; !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	return ids
+}
+
+// newestLeaf спускается от id по самым новым ответвлениям до последнего обмена
+func (t *dialogueTree) newestLeaf(id int64) int64 {
+	for {
+		children := t.children[id]
+		if len(children) == 0 {
+			return id
+		}
+		id = children[len(children)-1]
+	}
+}
+
+// activeLeaf - последний обмен текущей ветки, 0 если диалог пуст
+func (t *dialogueTree) activeLeaf() int64 {
+	var leaf int64
+	for id, n := range t.nodes {
+		if n.active && id > leaf {
+			leaf = id
+		}
+	}
+	return leaf
+}
+
+// setActiveDialogueBranch делает текущей ветку, которая заканчивается обменом leafID
+// (0 - ни одного обмена в текущей ветке). Резюме сессии, захватившее обмены, которые
+// перестали быть в текущей ветке, удаляется и будет построено заново.
+// Возвращает последний обмен прежней ветки.
+func setActiveDialogueBranch(chatSessionUUID string, leafID int64, descend bool) (int64, error) {
+	if DB == nil {
+		return 0, errors.New("БД не инициализирована")
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback()
+
+	tree, err := loadDialogueTree(tx, chatSessionUUID, true)
+	if err != nil {
+		return 0, err
+	}
+	if leafID != 0 {
+		if _, ok := tree.nodes[leafID]; !ok {
+			return 0, fmt.Errorf("обмен %d не найден в сессии: %w", leafID, sql.ErrNoRows)
+		}
+		if descend {
+			leafID = tree.newestLeaf(leafID)
+		}
+	}
+	previousLeaf := tree.activeLeaf()
+
+	path := tree.path(leafID)
+	onPath := make(map[int64]bool, len(path))
+	for _, id := range path {
+		onPath[id] = true
+	}
+	var firstChanged int64
+	for id, n := range tree.nodes {
+		if n.active != onPath[id] && (firstChanged == 0 || id < firstChanged) {
+			firstChanged = id
+		}
+	}
+	if firstChanged == 0 {
+		return previousLeaf, nil
+	}
+
+	query := `UPDATE dialogues SET active_branch = FALSE WHERE chat_session_uuid = ?`
+	args := []interface{}{chatSessionUUID}
+	if len(path) > 0 {
+		query = `UPDATE dialogues SET active_branch = id IN (?` + strings.Repeat(",?", len(path)-1) + `) WHERE chat_session_uuid = ?`
+		args = make([]interface{}, 0, len(path)+1)
+		for _, id := range path {
+			args = append(args, id)
+		}
+		args = append(args, chatSessionUUID)
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		return 0, fmt.Errorf("не удалось переключить ветку диалога: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM chat_session_summaries WHERE chat_session_uuid = ? AND covered_until_dialogue_id >= ?`, chatSessionUUID, firstChanged); err != nil {
+		return 0, fmt.Errorf("не удалось сбросить резюме сессии: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("не удалось переключить ветку диалога: %w", err)
+	}
+	slog.Info("Ветка диалога переключена", "chat_uuid", chatSessionUUID, "leaf_id", leafID, "previous_leaf_id", previousLeaf)
+	return previousLeaf, nil
+}
+
+// ForkDialogueBranch обрезает текущую ветку перед обменом dialogueID: следующий сохраненный обмен
+// станет его соседом, то есть новой веткой. Прежние обмены не удаляются.
+// Возвращает последний обмен прежней ветки, чтобы вернуться к ней, если ответ не удалось получить.
+func ForkDialogueBranch(d *Dialogue) (int64, error) {
+	return setActiveDialogueBranch(d.ChatSessionUUID, d.ParentID, false)
+}
+
+// SelectDialogueBranch делает текущей ветку, проходящую через обмен dialogueID,
+// и продолжает ее по самым новым ответвлениям до конца
+func SelectDialogueBranch(chatSessionUUID string, dialogueID int64) error {
+	_, err := setActiveDialogueBranch(chatSessionUUID, dialogueID, true)
+	return err
+}
+
+// GetDialogueAlternatives возвращает для каждого обмена текущей ветки ID всех вариантов на его
+// месте (соседей с тем же родителем, включая сам обмен) по возрастанию. Обмены без вариантов не попадают.
+func GetDialogueAlternatives(chatSessionUUID string) (map[int64][]int64, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	tree, err := loadDialogueTree(DB, chatSessionUUID, false)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int64][]int64)
+	for id, n := range tree.nodes {
+		if siblings := tree.children[n.parentID]; n.active && len(siblings) > 1 {
+			result[id] = siblings
+		}
+	}
+	return result, nil
+}
+
+// dialoguePathIDs возвращает обмены ветки, заканчивающейся leafID, по порядку
+func dialoguePathIDs(chatSessionUUID string, leafID int64) ([]int64, error) {
+	tree, err := loadDialogueTree(DB, chatSessionUUID, false)
+	if err != nil {
+		return nil, err
+	}
+	return tree.path(leafID), nil
+}
@@ -0,0 +1,140 @@
+// internal/db/guardrail_events_db.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// GuardrailEvent - запись журнала о сработавшей проверке безопасности
+type GuardrailEvent struct {
+	ID              int64
+	UserID          int64
+	UserEmail       string // Только при чтении
+	ChatSessionUUID string
+	DialogueID      int64 // 0 - обмен не сохранен
+	PersonaSlug     string
+	Stage           string   // input или output (guardrails.Stage*)
+	Action          string   // notice или block (guardrails.Action*)
+	Categories      []string // guardrails.Category*
+	Rules           []string
+	Sources         []string // rule, llm
+	Excerpt         string
+	CreatedAt       time.Time
+}
+
+// GuardrailEventFilter - условия выборки журнала; пустые поля не ограничивают выборку
+type GuardrailEventFilter struct {
+	Stage    string
+	Action   string
+	Category string
+	From     *time.Time // Включительно
+	To       *time.Time // Не включительно
+}
+
+// Длина столбцов categories и rules
+const guardrailListMaxLength = 255
+
+func joinGuardrailList(list []string) string {
+	joined := strings.Join(list, ",")
+	if len(joined) > guardrailListMaxLength {
+		joined = joined[:guardrailListMaxLength]
+	}
+	return joined
+}
+
+func splitGuardrailList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// CreateGuardrailEvent записывает срабатывание проверки в журнал
+func CreateGuardrailEvent(e GuardrailEvent) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	dialogueID := sql.NullInt64{Int64: e.DialogueID, Valid: e.DialogueID != 0}
+	_, err := DB.Exec(`INSERT INTO guardrail_events (user_id, chat_session_uuid, dialogue_id, persona_slug, stage, action, categories, rules, sources, excerpt, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.UserID, e.ChatSessionUUID, dialogueID, nullString(e.PersonaSlug), e.Stage, e.Action,
+		joinGuardrailList(e.Categories), joinGuardrailList(e.Rules), joinGuardrailList(e.Sources), nullString(e.Excerpt), time.Now())
+	if err != nil {
+		slog.Error("Ошибка записи в журнал проверок безопасности", "user_id", e.UserID, "chat_uuid", e.ChatSessionUUID, "action", e.Action, "error", err)
+		return fmt.Errorf("не удалось записать срабатывание проверки безопасности: %w", err)
+	}
+	return nil
+}
+
+// GetGuardrailEvents возвращает записи журнала по фильтру, новые первыми, и их общее количество
+func GetGuardrailEvents(filter GuardrailEventFilter, limit, offset int) ([]GuardrailEvent, int, error) {
+	if DB == nil {
+		return nil, 0, errors.New("БД не инициализирована")
+	}
+	var conds []string
+	var args []interface{}
+	if filter.Stage != "" {
+		conds = append(conds, "g.stage = ?")
+		args = append(args, filter.Stage)
+	}
+	if filter.Action != "" {
+		conds = append(conds, "g.action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Category != "" {
+		conds = append(conds, "FIND_IN_SET(?, g.categories) > 0")
+		args = append(args, filter.Category)
+	}
+	if filter.From != nil {
+		conds = append(conds, "g.created_at >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conds = append(conds, "g.created_at < ?")
+		args = append(args, *filter.To)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	var total int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM guardrail_events g`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("ошибка подсчета записей журнала проверок: %w", err)
+	}
+
+	rows, err := DB.Query(`SELECT g.id, g.user_id, u.email, g.chat_session_uuid, g.dialogue_id, g.persona_slug, g.stage, g.action,
+			g.categories, g.rules, g.sources, g.excerpt, g.created_at
+		FROM guardrail_events g JOIN users u ON u.id = g.user_id`+where+` ORDER BY g.created_at DESC, g.id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка получения журнала проверок: %w", err)
+	}
+	defer rows.Close()
+
+	events := []GuardrailEvent{}
+	for rows.Next() {
+		var e GuardrailEvent
+		var dialogueID sql.NullInt64
+		var persona, excerpt sql.NullString
+		var categories, rules, sources string
+		if err := rows.Scan(&e.ID, &e.UserID, &e.UserEmail, &e.ChatSessionUUID, &dialogueID, &persona, &e.Stage, &e.Action,
+			&categories, &rules, &sources, &excerpt, &e.CreatedAt); err != nil {
+			slog.Error("Ошибка сканирования записи журнала проверок", "error", err)
+			continue
+		}
+		e.DialogueID = dialogueID.Int64
+		e.PersonaSlug, e.Excerpt = persona.String, excerpt.String
+		e.Categories, e.Rules, e.Sources = splitGuardrailList(categories), splitGuardrailList(rules), splitGuardrailList(sources)
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ошибка итерации при получении журнала проверок: %w", err)
+	}
+	return events, total, nil
+}
@@ -0,0 +1,284 @@
+// internal/db/message_feedback_db.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	FeedbackRatingUp   = 1
+	FeedbackRatingDown = -1
+)
+
+// FeedbackReasons - допустимые причины оценки и их названия для админки
+var FeedbackReasons = map[string]string{
+	"inaccurate": "Неточный или неверный ответ",
+	"unsafe":     "Опасный совет",
+	"unhelpful":  "Не помог",
+	"off_topic":  "Не по теме",
+	"tone":       "Неподходящий тон",
+	"helpful":    "Полезный ответ",
+	"other":      "Другое",
+}
+
+// MessageFeedback - оценка ответа пользователем
+type MessageFeedback struct {
+	ID         int64     `json:"id"`
+	DialogueID int64     `json:"dialogue_id"`
+	UserID     int64     `json:"-"`
+	Rating     int       `json:"rating"`
+	Reason     string    `json:"reason,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// FeedbackItem - отзыв в очереди разбора вместе с обменом, к которому он относится
+type FeedbackItem struct {
+	MessageFeedback
+	UserEmail       string
+	ChatSessionUUID string
+	PersonaSlug     string
+	Model           string
+	UserPrompt      string
+	AIResponse      string
+	ReviewedAt      *time.Time
+	ReviewedByEmail string
+}
+
+// FeedbackFilter - условия выборки очереди отзывов; пустые поля не ограничивают выборку
+type FeedbackFilter struct {
+	Rating      int    // 1, -1 или 0 - любые
+	PersonaSlug string // "-" - обмены без режима (до появления учета)
+	Model       string
+	From        *time.Time // Включительно
+	To          *time.Time // Не включительно
+	Status      string     // "new" - не разобранные, "reviewed" - разобранные, пусто - все
+}
+
+// SaveMessageFeedback сохраняет оценку обмена, заменяя прежнюю; после изменения отзыв снова попадает в очередь
+func SaveMessageFeedback(f *MessageFeedback) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	_, err := DB.Exec(`INSERT INTO message_feedback (dialogue_id, user_id, rating, reason, comment, created_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE rating = VALUES(rating), reason = VALUES(reason), comment = VALUES(comment), reviewed_at = NULL, reviewed_by_user_id = NULL`,
+		f.DialogueID, f.UserID, f.Rating, nullString(f.Reason), nullString(f.Comment), time.Now())
+	if err != nil {
+		slog.Error("Ошибка сохранения оценки ответа", "dialogue_id", f.DialogueID, "user_id", f.UserID, "error", err)
+		return fmt.Errorf("не удалось сохранить оценку ответа: %w", err)
+	}
+	return nil
+}
+
+// DeleteMessageFeedback снимает оценку обмена
+func DeleteMessageFeedback(dialogueID int64) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	if _, err := DB.Exec(`DELETE FROM message_feedback WHERE dialogue_id = ?`, dialogueID); err != nil {
+		slog.Error("Ошибка удаления оценки ответа", "dialogue_id", dialogueID, "error", err)
+		return fmt.Errorf("не удалось удалить оценку ответа: %w", err)
+	}
+	return nil
+}
+
+// GetFeedbackRatings возвращает оценки указанных обменов: dialogue_id -> rating
+func GetFeedbackRatings(dialogueIDs []int64) (map[int64]int, error) {
+	result := make(map[int64]int)
+	if len(dialogueIDs) == 0 {
+		return result, nil
+	}
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	args := make([]interface{}, len(dialogueIDs))
+	for i, id := range dialogueIDs {
+		args[i] = id
+	}
+	rows, err := DB.Query(`SELECT dialogue_id, rating FROM message_feedback WHERE dialogue_id IN (?`+strings.Repeat(",?", len(dialogueIDs)-1)+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения оценок ответов: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dialogueID int64
+		var rating int
+		if err := rows.Scan(&dialogueID, &rating); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования оценки ответа: %w", err)
+		}
+		result[dialogueID] = rating
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при получении оценок ответов: %w", err)
+	}
+	return result, nil
+}
+
+const feedbackItemSelect = `SELECT f.id, f.dialogue_id, f.user_id, f.rating, f.reason, f.comment, f.created_at, f.updated_at,
+		u.email, d.chat_session_uuid, d.persona_slug, d.model, d.user_prompt, d.ai_response, f.reviewed_at, r.email
+	FROM message_feedback f
+	JOIN dialogues d ON d.id = f.dialogue_id
+	JOIN users u ON u.id = f.user_id
+	LEFT JOIN users r ON r.id = f.reviewed_by_user_id`
+
+func (filter FeedbackFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if filter.Rating != 0 {
+		conds = append(conds, "f.rating = ?")
+		args = append(args, filter.Rating)
+	}
+	switch filter.PersonaSlug {
+	case "":
+	case "-":
+		conds = append(conds, "d.persona_slug IS NULL")
+	default:
+		conds = append(conds, "d.persona_slug = ?")
+		args = append(args, filter.PersonaSlug)
+	}
+	if filter.Model != "" {
+		conds = append(conds, "d.model = ?")
+		args = append(args, filter.Model)
+	}
+	if filter.From != nil {
+		conds = append(conds, "f.created_at >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conds = append(conds, "f.created_at < ?")
+		args = append(args, *filter.To)
+	}
+	switch filter.Status {
+	case "new":
+		conds = append(conds, "f.reviewed_at IS NULL")
+	case "reviewed":
+		conds = append(conds, "f.reviewed_at IS NOT NULL")
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func scanFeedbackItem(row rowScanner) (*FeedbackItem, error) {
+	item := &FeedbackItem{}
+	var reason, comment, persona, model, aiResponse, reviewer sql.NullString
+	var reviewedAt sql.NullTime
+	if err := row.Scan(&item.ID, &item.DialogueID, &item.UserID, &item.Rating, &reason, &comment, &item.CreatedAt, &item.UpdatedAt,
+		&item.UserEmail, &item.ChatSessionUUID, &persona, &model, &item.UserPrompt, &aiResponse, &reviewedAt, &reviewer); err != nil {
+		return nil, err
+	}
+	item.Reason, item.Comment = reason.String, comment.String
+	item.PersonaSlug, item.Model, item.AIResponse = persona.String, model.String, aiResponse.String
+	item.ReviewedAt = nullTimePtr(reviewedAt)
+	item.ReviewedByEmail = reviewer.String
+	return item, nil
+}
+
+// GetFeedbackQueue возвращает отзывы по фильтру, новые первыми, и их общее количество
+func GetFeedbackQueue(filter FeedbackFilter, limit, offset int) ([]FeedbackItem, int, error) {
+	if DB == nil {
+		return nil, 0, errors.New("БД не инициализирована")
+	}
+	where, args := filter.where()
+
+	var total int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM message_feedback f JOIN dialogues d ON d.id = f.dialogue_id`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("ошибка подсчета отзывов: %w", err)
+	}
+
+	rows, err := DB.Query(feedbackItemSelect+where+` ORDER BY f.created_at DESC, f.id DESC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка получения отзывов: %w", err)
+	}
+	defer rows.Close()
+
+	items := []FeedbackItem{}
+	for rows.Next() {
+		item, err := scanFeedbackItem(rows)
+		if err != nil {
+			slog.Error("Ошибка сканирования отзыва", "error", err)
+			continue
+		}
+		items = append(items, *item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ошибка итерации при получении отзывов: %w", err)
+	}
+	return items, total, nil
+}
+
+// EachFeedbackItem вызывает fn для каждого отзыва по фильтру в порядке поступления,
+// не загружая всю выборку в память. Ошибка fn прерывает обход.
+func EachFeedbackItem(filter FeedbackFilter, fn func(*FeedbackItem) error) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	where, args := filter.where()
+	rows, err := DB.Query(feedbackItemSelect+where+` ORDER BY f.id ASC`, args...)
+	if err != nil {
+		return fmt.Errorf("ошибка получения отзывов: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item, err := scanFeedbackItem(rows)
+		if err != nil {
+			return fmt.Errorf("ошибка сканирования отзыва: %w", err)
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка итерации при получении отзывов: %w", err)
+	}
+	return nil
+}
+
+// GetFeedbackModels возвращает модели, ответы которых получили отзывы, для фильтра очереди
+func GetFeedbackModels() ([]string, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	rows, err := DB.Query(`SELECT DISTINCT d.model FROM message_feedback f JOIN dialogues d ON d.id = f.dialogue_id WHERE d.model IS NOT NULL ORDER BY d.model`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения моделей отзывов: %w", err)
+	}
+	defer rows.Close()
+	var list []string
+	for rows.Next() {
+		var model string
+		if err := rows.Scan(&model); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования модели отзыва: %w", err)
+		}
+		list = append(list, model)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при получении моделей отзывов: %w", err)
+	}
+	return list, nil
+}
+
+// SetFeedbackReviewed отмечает отзыв разобранным (reviewed = false - возвращает в очередь)
+func SetFeedbackReviewed(id, adminUserID int64, reviewed bool) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	var err error
+	if reviewed {
+		_, err = DB.Exec(`UPDATE message_feedback SET reviewed_at = ?, reviewed_by_user_id = ?, updated_at = updated_at WHERE id = ?`, time.Now(), adminUserID, id)
+	} else {
+		_, err = DB.Exec(`UPDATE message_feedback SET reviewed_at = NULL, reviewed_by_user_id = NULL, updated_at = updated_at WHERE id = ?`, id)
+	}
+	if err != nil {
+		slog.Error("Ошибка изменения статуса отзыва", "feedback_id", id, "error", err)
+		return fmt.Errorf("не удалось изменить статус отзыва: %w", err)
+	}
+	return nil
+}
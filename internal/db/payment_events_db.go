@@ -0,0 +1,269 @@
+// internal/db/payment_events_db.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Статусы уведомления платежного шлюза
+const (
+	PaymentEventPending    = "pending"    // Ждет обработки
+	PaymentEventProcessing = "processing" // Обрабатывается
+	PaymentEventFailed     = "failed"     // Ошибка, будет повтор в NextAttemptAt
+	PaymentEventProcessed  = "processed"
+	PaymentEventDead       = "dead" // Попытки исчерпаны, нужен разбор вручную
+)
+
+// PaymentEvent - уведомление платежного шлюза во входящей очереди
+type PaymentEvent struct {
+	ID             int64
+	GatewayName    string
+	EventID        string // ID события в шлюзе, уникален в пределах шлюза
+	GatewayOrderID string
+	EventStatus    string // Статус заказа из уведомления, для журнала
+	Payload        string // Тело уведомления как есть
+	Status         string // PaymentEvent*
+	Attempts       int
+	LastError      string
+	NextAttemptAt  time.Time
+	ProcessedAt    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Длина столбца last_error в записи; полный текст ошибки остается в логах
+const paymentEventErrorMaxLength = 2000
+
+// truncateUTF8 обрезает текст до maxBytes байт, не разрезая символ: ошибки обычно на русском
+func truncateUTF8(text string, maxBytes int) string {
+	if len(text) <= maxBytes {
+		return text
+	}
+	for maxBytes > 0 && !utf8.RuneStart(text[maxBytes]) {
+		maxBytes--
+	}
+	return text[:maxBytes]
+}
+
+const paymentEventColumns = `id, gateway_name, event_id, gateway_order_id, event_status, payload, status, attempts, last_error,
+	next_attempt_at, processed_at, created_at, updated_at`
+
+func scanPaymentEvent(row scanner) (*PaymentEvent, error) {
+	var e PaymentEvent
+	var eventStatus, lastError sql.NullString
+	var processedAt sql.NullTime
+	err := row.Scan(&e.ID, &e.GatewayName, &e.EventID, &e.GatewayOrderID, &eventStatus, &e.Payload, &e.Status, &e.Attempts, &lastError,
+		&e.NextAttemptAt, &processedAt, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	e.EventStatus, e.LastError = eventStatus.String, lastError.String
+	if processedAt.Valid {
+		e.ProcessedAt = &processedAt.Time
+	}
+	return &e, nil
+}
+
+// CreatePaymentEvent сохраняет уведомление в очередь. false - уведомление с таким ID события
+// уже получено (повтор), запись не меняется.
+func CreatePaymentEvent(e *PaymentEvent) (bool, error) {
+	if DB == nil {
+		return false, errors.New("БД не инициализирована")
+	}
+	now := time.Now()
+	// TIMESTAMP хранит секунды, а MySQL округляет дробную часть вверх: без усечения уведомление
+	// оказалось бы запланированным в будущем и не бралось в обработку сразу после получения
+	firstAttempt := now.Truncate(time.Second)
+	res, err := DB.Exec(`INSERT INTO payment_events (gateway_name, event_id, gateway_order_id, event_status, payload, status, attempts,
+			next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE id = id`,
+		e.GatewayName, e.EventID, e.GatewayOrderID, nullString(e.EventStatus), e.Payload, PaymentEventPending, firstAttempt, now, now)
+	if err != nil {
+		slog.Error("Ошибка сохранения уведомления платежного шлюза", "gateway", e.GatewayName, "eventID", e.EventID, "error", err)
+		return false, fmt.Errorf("не удалось сохранить уведомление: %w", err)
+	}
+	if affected, _ := res.RowsAffected(); affected != 1 {
+		return false, nil
+	}
+	e.ID, err = res.LastInsertId()
+	if err != nil {
+		return false, fmt.Errorf("не удалось получить ID уведомления: %w", err)
+	}
+	e.Status, e.NextAttemptAt, e.CreatedAt, e.UpdatedAt = PaymentEventPending, firstAttempt, now, now
+	return true, nil
+}
+
+// GetPaymentEventByID возвращает уведомление; nil - не найдено
+func GetPaymentEventByID(id int64) (*PaymentEvent, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	e, err := scanPaymentEvent(DB.QueryRow(`SELECT `+paymentEventColumns+` FROM payment_events WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения уведомления %d: %w", id, err)
+	}
+	return e, nil
+}
+
+// ClaimPaymentEvent забирает уведомление в обработку и увеличивает счетчик попыток. false - уведомление
+// уже обработано, обрабатывается или время повтора не наступило. Обработка, зависшая дольше
+// staleAfter (например, из-за перезапуска), забирается заново.
+func ClaimPaymentEvent(id int64, staleAfter time.Duration) (bool, error) {
+	if DB == nil {
+		return false, errors.New("БД не инициализирована")
+	}
+	now := time.Now()
+	res, err := DB.Exec(`UPDATE payment_events SET status = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id = ? AND ((status IN (?, ?) AND next_attempt_at <= ?) OR (status = ? AND updated_at < ?))`,
+		PaymentEventProcessing, now, id, PaymentEventPending, PaymentEventFailed, now, PaymentEventProcessing, now.Add(-staleAfter))
+	if err != nil {
+		return false, fmt.Errorf("не удалось взять уведомление %d в обработку: %w", id, err)
+	}
+	affected, _ := res.RowsAffected()
+	return affected == 1, nil
+}
+
+// MarkPaymentEventProcessed отмечает уведомление обработанным
+func MarkPaymentEventProcessed(id int64) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	now := time.Now()
+	_, err := DB.Exec(`UPDATE payment_events SET status = ?, last_error = NULL, processed_at = ?, updated_at = ? WHERE id = ?`,
+		PaymentEventProcessed, now, now, id)
+	if err != nil {
+		return fmt.Errorf("не удалось отметить уведомление %d обработанным: %w", id, err)
+	}
+	return nil
+}
+
+// MarkPaymentEventFailed сохраняет ошибку обработки: уведомление повторяется в nextAttemptAt,
+// а при dead уходит в dead-letter и ждет разбора в админке
+func MarkPaymentEventFailed(id int64, processErr error, nextAttemptAt time.Time, dead bool) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	status := PaymentEventFailed
+	if dead {
+		status = PaymentEventDead
+	}
+	message := truncateUTF8(processErr.Error(), paymentEventErrorMaxLength)
+	_, err := DB.Exec(`UPDATE payment_events SET status = ?, last_error = ?, next_attempt_at = ?, updated_at = ? WHERE id = ?`,
+		status, message, nextAttemptAt, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("не удалось сохранить ошибку обработки уведомления %d: %w", id, err)
+	}
+	return nil
+}
+
+// GetDuePaymentEventIDs возвращает уведомления, которые пора обработать: новые, с наступившим временем
+// повтора и зависшие в обработке дольше staleAfter, старые первыми
+func GetDuePaymentEventIDs(staleAfter time.Duration, limit int) ([]int64, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	now := time.Now()
+	rows, err := DB.Query(`SELECT id FROM payment_events
+		WHERE (status IN (?, ?) AND next_attempt_at <= ?) OR (status = ? AND updated_at < ?)
+		ORDER BY next_attempt_at, id LIMIT ?`,
+		PaymentEventPending, PaymentEventFailed, now, PaymentEventProcessing, now.Add(-staleAfter), limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения уведомлений для обработки: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования уведомления: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RequeuePaymentEvent возвращает уведомление из dead-letter или с ошибкой в очередь с новым счетчиком попыток.
+// false - уведомление не найдено или не в этих статусах.
+func RequeuePaymentEvent(id int64) (bool, error) {
+	if DB == nil {
+		return false, errors.New("БД не инициализирована")
+	}
+	now := time.Now()
+	res, err := DB.Exec(`UPDATE payment_events SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ?
+		WHERE id = ? AND status IN (?, ?)`,
+		PaymentEventPending, now, now, id, PaymentEventDead, PaymentEventFailed)
+	if err != nil {
+		return false, fmt.Errorf("не удалось вернуть уведомление %d в очередь: %w", id, err)
+	}
+	affected, _ := res.RowsAffected()
+	return affected == 1, nil
+}
+
+// PaymentEventFilter - условия выборки уведомлений; пустые поля не ограничивают выборку
+type PaymentEventFilter struct {
+	Status  string
+	Gateway string
+	OrderID string // ID заказа в шлюзе
+}
+
+// GetPaymentEvents возвращает уведомления по фильтру, новые первыми, и их общее количество
+func GetPaymentEvents(filter PaymentEventFilter, limit, offset int) ([]PaymentEvent, int, error) {
+	if DB == nil {
+		return nil, 0, errors.New("БД не инициализирована")
+	}
+	var conds []string
+	var args []interface{}
+	if filter.Status != "" {
+		conds = append(conds, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.Gateway != "" {
+		conds = append(conds, "gateway_name = ?")
+		args = append(args, filter.Gateway)
+	}
+	if filter.OrderID != "" {
+		conds = append(conds, "gateway_order_id = ?")
+		args = append(args, filter.OrderID)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	var total int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM payment_events`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("ошибка подсчета уведомлений платежных шлюзов: %w", err)
+	}
+
+	rows, err := DB.Query(`SELECT `+paymentEventColumns+` FROM payment_events`+where+` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка получения уведомлений платежных шлюзов: %w", err)
+	}
+	defer rows.Close()
+
+	events := []PaymentEvent{}
+	for rows.Next() {
+		e, err := scanPaymentEvent(rows)
+		if err != nil {
+			slog.Error("Ошибка сканирования уведомления платежного шлюза", "error", err)
+			continue
+		}
+		events = append(events, *e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ошибка итерации при получении уведомлений платежных шлюзов: %w", err)
+	}
+	return events, total, nil
+}
@@ -0,0 +1,33 @@
+// internal/db/payment_events_db_test.go
+package db
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateUTF8(t *testing.T) {
+	cases := []struct {
+		text     string
+		maxBytes int
+		want     string
+	}{
+		{"ошибка", 20, "ошибка"},
+		{"ошибка", 12, "ошибка"},
+		{"ошибка", 11, "ошибк"}, // Cyrillic letters take two bytes
+		{"ошибка", 1, ""},
+		{"bank: отказ", 7, "bank: "},
+		{"ok 🙂", 5, "ok "},
+	}
+	for _, tc := range cases {
+		if got := truncateUTF8(tc.text, tc.maxBytes); got != tc.want {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", tc.text, tc.maxBytes, got, tc.want)
+		}
+	}
+
+	long := truncateUTF8("a"+strings.Repeat("я", paymentEventErrorMaxLength), paymentEventErrorMaxLength)
+	if len(long) > paymentEventErrorMaxLength || !utf8.ValidString(long) {
+		t.Fatalf("Truncated error is %d bytes, valid UTF-8: %v", len(long), utf8.ValidString(long))
+	}
+}
@@ -2,89 +2,254 @@
 package db
 
 import (
-	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
-	"shaman-ai.kz/internal/models"
 	"time"
+
+	"shaman-ai.kz/internal/models"
+
+	"github.com/google/uuid"
 )
 
-// GetPaymentByID находит платеж по его ID (вашему order_id)
-func GetPaymentByID(paymentID string) (*models.Payment, error) {
+// CreatePayment сохраняет новый платеж; ID генерируется, если не задан
+func CreatePayment(payment *models.Payment) error {
 	if DB == nil {
-		return nil, errors.New("БД не инициализирована")
+		return errors.New("БД не инициализирована")
+	}
+	if payment.ID == "" {
+		payment.ID = "pay_" + uuid.NewString()[:12]
+	}
+	if payment.Status == "" {
+		payment.Status = models.PaymentStatusPending
+	}
+	now := time.Now()
+	if payment.CreatedAt.IsZero() {
+		payment.CreatedAt = now
+	}
+	payment.UpdatedAt = now
+	if payment.PeriodMonths == 0 {
+		payment.PeriodMonths = 1
 	}
-	query := `SELECT id, user_id, subscription_id, payment_gateway_transaction_id, amount, currency, status, payment_date, created_at, updated_at FROM payments WHERE id = ?`
-	row := DB.QueryRow(query, paymentID)
-
-	var p models.Payment
-	var subID, gatewayTxID sql.NullString
-	var paymentDate, createdAt, updatedAt sql.NullTime
 
-	err := row.Scan(
-		&p.ID, &p.UserID, &subID, &gatewayTxID,
-		&p.Amount, &p.Currency, &p.Status, &paymentDate, &createdAt, &updatedAt,
+	query := `INSERT INTO payments (id, user_id, subscription_id, payment_gateway_transaction_id, amount, currency, status,
+			gateway_order_id, gateway_name, is_renewal, plan_id, period_months, payment_date, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := DB.Exec(query,
+		payment.ID,
+		payment.UserID,
+		nullString(payment.SubscriptionID),
+		nullString(payment.PaymentGatewayTransactionID),
+		payment.Amount,
+		payment.Currency,
+		payment.Status,
+		nullString(payment.GatewayOrderID),
+		payment.GatewayName,
+		payment.Renewal,
+		nullString(payment.PlanID),
+		payment.PeriodMonths,
+		sql.NullTime{Time: payment.PaymentDate, Valid: !payment.PaymentDate.IsZero()},
+		payment.CreatedAt,
+		payment.UpdatedAt,
 	)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil // Платеж не найден, это не всегда ошибка
-		}
-		slog.Error("Ошибка получения платежа по ID", "paymentID", paymentID, "error", err)
-		return nil, fmt.Errorf("ошибка получения платежа: %w", err)
+		slog.Error("Ошибка создания записи о платеже в БД", "paymentID", payment.ID, "userID", payment.UserID, "error", err)
+		return fmt.Errorf("не удалось сохранить платеж: %w", err)
 	}
+	return nil
+}
+
+const paymentColumns = `id, user_id, subscription_id, payment_gateway_transaction_id, amount, currency, status,
+	gateway_order_id, gateway_name, is_renewal, plan_id, period_months, payment_date, created_at, updated_at`
 
+func scanPayment(row scanner) (*models.Payment, error) {
+	var p models.Payment
+	var subID, gatewayTxID, gatewayOrderID, gatewayName, planID sql.NullString
+	var paymentDate sql.NullTime
+	err := row.Scan(&p.ID, &p.UserID, &subID, &gatewayTxID, &p.Amount, &p.Currency, &p.Status,
+		&gatewayOrderID, &gatewayName, &p.Renewal, &planID, &p.PeriodMonths, &paymentDate, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
 	p.SubscriptionID = subID.String
 	p.PaymentGatewayTransactionID = gatewayTxID.String
+	p.GatewayOrderID = gatewayOrderID.String
+	p.GatewayName = gatewayName.String
+	p.PlanID = planID.String
 	p.PaymentDate = paymentDate.Time
-	p.CreatedAt = createdAt.Time
-	p.UpdatedAt = updatedAt.Time
-
 	return &p, nil
 }
 
-// UpdateGatewayInfo обновляет информацию о платеже от шлюза
-func (pdb *PaymentsDB) UpdateGatewayInfo(ctx context.Context, internalPaymentID int64, gatewayOrderID, status string) error {
-	query := `UPDATE payments SET gateway_order_id = $1, status = $2, updated_at = NOW() WHERE id = $3`
-	_, err := pdb.db.ExecContext(ctx, query, gatewayOrderID, status, internalPaymentID)
-	return err
-}
-
-// UpdateStatusByGatewayID обновляет статус платежа по ID заказа в шлюзе
-func (pdb *PaymentsDB) UpdateStatusByGatewayID(ctx context.Context, gatewayOrderID, status string) error {
-	query := `UPDATE payments SET status = $1, updated_at = NOW() WHERE gateway_order_id = $2`
-	_, err := pdb.db.ExecContext(ctx, query, status, gatewayOrderID)
-	return err
+// GetPaymentByID находит платеж по его ID (нашему order_id); nil - платеж не найден
+func GetPaymentByID(paymentID string) (*models.Payment, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	p, err := scanPayment(DB.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE id = ?`, paymentID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Платеж не найден, это не всегда ошибка
+		}
+		slog.Error("Ошибка получения платежа по ID", "paymentID", paymentID, "error", err)
+		return nil, fmt.Errorf("ошибка получения платежа: %w", err)
+	}
+	return p, nil
 }
 
-// GetPaymentByGatewayID находит платеж по ID заказа в шлюзе
-func (pdb *PaymentsDB) GetPaymentByGatewayID(ctx context.Context, gatewayOrderID string) (*models.Payment, error) {
-	query := `SELECT id, user_id, subscription_id, amount, currency, status, created_at, updated_at FROM payments WHERE gateway_order_id = $1`
-	row := pdb.db.QueryRowContext(ctx, query, gatewayOrderID)
-	
-	var p models.Payment
-	err := row.Scan(&p.ID, &p.UserID, &p.SubscriptionID, &p.Amount, &p.Currency, &p.Status, &p.CreatedAt, &p.UpdatedAt)
+// GetPaymentByGatewayOrderID находит платеж по ID заказа в шлюзе; nil - платеж не найден
+func GetPaymentByGatewayOrderID(gatewayName, gatewayOrderID string) (*models.Payment, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	p, err := scanPayment(DB.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE gateway_name = ? AND gateway_order_id = ?`,
+		gatewayName, gatewayOrderID))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // Платеж не найден
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
 		}
-		return nil, err
+		slog.Error("Ошибка получения платежа по ID заказа в шлюзе", "gateway", gatewayName, "gatewayOrderID", gatewayOrderID, "error", err)
+		return nil, fmt.Errorf("ошибка получения платежа: %w", err)
 	}
-	return &p, nil
+	return p, nil
 }
 
+// SetPaymentGatewayOrder сохраняет ID заказа, созданного в шлюзе, и новый статус платежа
+func SetPaymentGatewayOrder(paymentID, gatewayOrderID string, status models.PaymentStatus) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	_, err := DB.Exec(`UPDATE payments SET gateway_order_id = ?, status = ?, updated_at = ? WHERE id = ?`,
+		gatewayOrderID, status, time.Now(), paymentID)
+	if err != nil {
+		slog.Error("Ошибка сохранения ID заказа в шлюзе", "paymentID", paymentID, "gatewayOrderID", gatewayOrderID, "error", err)
+		return fmt.Errorf("не удалось сохранить ID заказа в шлюзе: %w", err)
+	}
+	return nil
+}
 
-// UpdatePaymentStatus обновляет статус и ID транзакции для существующего платежа
+// UpdatePaymentStatus обновляет статус и ID транзакции для существующего платежа.
+// Проведенный платеж не меняется: статус success ставит только CompletePayment.
 func UpdatePaymentStatus(paymentID string, newStatus models.PaymentStatus, gatewayTxID string) error {
 	if DB == nil {
 		return errors.New("БД не инициализирована")
 	}
-	query := `UPDATE payments SET status = ?, payment_gateway_transaction_id = ?, updated_at = ? WHERE id = ?`
-	_, err := DB.Exec(query, newStatus, gatewayTxID, time.Now(), paymentID)
+	query := `UPDATE payments SET status = ?, payment_gateway_transaction_id = COALESCE(?, payment_gateway_transaction_id), updated_at = ?
+		WHERE id = ? AND status <> ?`
+	_, err := DB.Exec(query, newStatus, nullString(gatewayTxID), time.Now(), paymentID, models.PaymentStatusSuccess)
 	if err != nil {
 		slog.Error("Ошибка обновления статуса платежа", "paymentID", paymentID, "newStatus", newStatus, "error", err)
 		return fmt.Errorf("не удалось обновить статус платежа: %w", err)
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// CompletePayment проводит оплаченный платеж: отмечает его успешным и продлевает подписку пользователя
+// на оплаченный тариф и период платежа (plan_id, period_months) в одной транзакции. Действующий период продлевается с его конца, иначе новый период
+// начинается сейчас. В той же транзакции начинается расчетный период токенов оплаченного периода:
+// счетчики обнуляются, billing_cycle_anchor_date - начало оплаченного периода (current_period_start),
+// при автопродлении до конца периода - дата в будущем, от нее отсчитываются следующие месяцы.
+// recurringToken - карта, сохраненная шлюзом для автопродления;
+// пусто - сохраненная ранее карта не меняется. Повторный вызов для проведенного платежа
+// (возврат пользователя и уведомление шлюза приходят независимо) ничего не меняет и возвращает false.
+func CompletePayment(paymentID, gatewayTxID, recurringToken string) (bool, error) {
+	if DB == nil {
+		return false, errors.New("БД не инициализирована")
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var status models.PaymentStatus
+	var renewal bool
+	var months int
+	var gatewayName, planID sql.NullString
+	err = tx.QueryRow(`SELECT user_id, status, is_renewal, plan_id, period_months, gateway_name FROM payments WHERE id = ? FOR UPDATE`, paymentID).
+		Scan(&userID, &status, &renewal, &planID, &months, &gatewayName)
+	if err != nil {
+		return false, fmt.Errorf("не удалось получить платеж %s: %w", paymentID, err)
+	}
+	if status == models.PaymentStatusSuccess {
+		return false, nil
+	}
+
+	// Текущая подписка пользователя: users.subscription_id ссылается на payment_gateway_subscription_id
+	var subID string
+	var periodEnd sql.NullTime
+	err = tx.QueryRow(`SELECT s.id, s.current_period_end FROM subscriptions s
+		JOIN users u ON u.subscription_id = s.payment_gateway_subscription_id
+		WHERE u.id = ? FOR UPDATE`, userID).Scan(&subID, &periodEnd)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("не удалось получить подписку пользователя: %w", err)
+	}
+
+	now := time.Now()
+	start := now
+	newPeriod := true
+	if periodEnd.Valid && periodEnd.Time.After(now) {
+		start = periodEnd.Time
+		newPeriod = false
+	}
+	end := start.AddDate(0, months, 0)
+
+	token := nullString(recurringToken)
+	tokenGateway := sql.NullString{String: gatewayName.String, Valid: token.Valid}
+	if subID == "" {
+		subID = "sub_" + uuid.NewString()[:12]
+		if !planID.Valid {
+			planID = sql.NullString{String: models.DefaultPlanSlug, Valid: true}
+		}
+		_, err = tx.Exec(`INSERT INTO subscriptions (id, user_id, payment_gateway_subscription_id, plan_id, status,
+				start_date, current_period_start, current_period_end, cancel_at_period_end, recurring_token, recurring_gateway,
+				billing_period_months, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, FALSE, ?, ?, ?, ?, ?)`,
+			subID, userID, subID, planID, models.SubscriptionStatusActive, now, start, end, token, tokenGateway, months, now, now)
+	} else {
+		// Оплата закрывает долг: повторы списания и напоминания о неоплате начинаются заново.
+		// Оплата другого тарифа меняет тариф сразу, оставшиеся дни прежнего периода сохраняются.
+		_, err = tx.Exec(`UPDATE subscriptions SET plan_id = COALESCE(?, plan_id), status = ?, current_period_start = ?,
+				current_period_end = ?, end_date = NULL, cancel_at_period_end = FALSE, recurring_token = COALESCE(?, recurring_token),
+				recurring_gateway = COALESCE(?, recurring_gateway), billing_period_months = ?, renewal_attempts = 0,
+				next_renewal_attempt_at = NULL, past_due_since = NULL, dunning_stage = 0, updated_at = ?
+			WHERE id = ?`,
+			planID, models.SubscriptionStatusActive, start, end, token, tokenGateway, months, now, subID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("не удалось сохранить подписку: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE payments SET status = ?, subscription_id = ?, payment_gateway_transaction_id = ?, payment_date = ?, updated_at = ?
+		WHERE id = ?`,
+		models.PaymentStatusSuccess, subID, nullString(gatewayTxID), now, now, paymentID)
+	if err != nil {
+		return false, fmt.Errorf("не удалось обновить статус платежа: %w", err)
+	}
+
+	// Израсходованное после оплаты продления засчитывается в оплаченный период
+	if newPeriod {
+		_, err = tx.Exec(`UPDATE users SET subscription_id = ?, subscription_status = ?, subscription_start_date = ?,
+				subscription_end_date = ?, current_period_end = ?, tokens_used_input_this_period = 0,
+				tokens_used_output_this_period = 0, billing_cycle_anchor_date = ?, updated_at = ?
+			WHERE id = ?`,
+			subID, models.SubscriptionStatusActive, now, end, end, start, now, userID)
+	} else {
+		_, err = tx.Exec(`UPDATE users SET subscription_id = ?, subscription_status = ?, subscription_end_date = ?,
+				current_period_end = ?, tokens_used_input_this_period = 0, tokens_used_output_this_period = 0,
+				billing_cycle_anchor_date = ?, updated_at = ?
+			WHERE id = ?`,
+			subID, models.SubscriptionStatusActive, end, end, start, now, userID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("не удалось обновить подписку пользователя: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("не удалось сохранить оплату: %w", err)
+	}
+	slog.Info("Платеж проведен, подписка продлена", "paymentID", paymentID, "userID", userID, "subscriptionID", subID,
+		"plan", planID.String, "months", months, "periodEnd", end, "newPeriod", newPeriod, "renewal", renewal)
+	return true, nil
+}
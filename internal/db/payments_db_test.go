@@ -0,0 +1,177 @@
+// internal/db/payments_db_test.go
+package db
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"shaman-ai.kz/internal/models"
+)
+
+// completeTestPayment creates a payment for a month and completes it
+func completeTestPayment(t *testing.T, payment *models.Payment) {
+	t.Helper()
+	payment.Amount, payment.Currency, payment.GatewayName = 449900, "KZT", "sandbox"
+	payment.PlanID, payment.PeriodMonths = models.DefaultPlanSlug, 1
+	if err := CreatePayment(payment); err != nil {
+		t.Fatalf("CreatePayment failed: %v", err)
+	}
+	applied, err := CompletePayment(payment.ID, "tx-"+payment.ID, "")
+	if err != nil || !applied {
+		t.Fatalf("CompletePayment = %v, %v", applied, err)
+	}
+}
+
+func TestCompletePaymentEarlyRenewalStartsTokenPeriodInTransaction(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New failed: %v", err)
+	}
+	prev := DB
+	DB = conn
+	t.Cleanup(func() {
+		DB = prev
+		conn.Close()
+	})
+
+	// The scheduler charges the renewal a day before the period ends
+	periodEnd := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	end := periodEnd.AddDate(0, 1, 0)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT user_id, status, is_renewal, plan_id, period_months, gateway_name FROM payments WHERE id = ? FOR UPDATE`)).
+		WithArgs("pay-1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status", "is_renewal", "plan_id", "period_months", "gateway_name"}).
+			AddRow(int64(7), models.PaymentStatusProcessing, true, models.DefaultPlanSlug, 1, "sandbox"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT s.id, s.current_period_end FROM subscriptions s`)).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "current_period_end"}).AddRow("sub-1", periodEnd))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE subscriptions SET`)).
+		WithArgs(models.DefaultPlanSlug, models.SubscriptionStatusActive, periodEnd, end, nil, nil, 1, sqlmock.AnyArg(), "sub-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE payments SET status = ?`)).
+		WithArgs(models.PaymentStatusSuccess, "sub-1", "tx-1", sqlmock.AnyArg(), sqlmock.AnyArg(), "pay-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Counters are reset and the anchor moves to the new period start before the commit
+	mock.ExpectExec(`UPDATE users SET .*tokens_used_input_this_period = 0, tokens_used_output_this_period = 0,\s+billing_cycle_anchor_date = \?`).
+		WithArgs("sub-1", models.SubscriptionStatusActive, end, end, periodEnd, sqlmock.AnyArg(), int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	applied, err := CompletePayment("pay-1", "tx-1", "")
+	if err != nil || !applied {
+		t.Fatalf("CompletePayment = %v, %v", applied, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompletePaymentEarlyRenewalAnchorsNextPeriod(t *testing.T) {
+	OpenTestDB(t)
+	userID := CreateTestUser(t)
+
+	first := &models.Payment{UserID: userID}
+	completeTestPayment(t, first)
+	if err := IncrementTokenUsage(userID, 1000, 200); err != nil {
+		t.Fatalf("IncrementTokenUsage failed: %v", err)
+	}
+	before, err := GetUserByID(userID)
+	if err != nil || before.CurrentPeriodEnd == nil || before.BillingCycleAnchorDate == nil {
+		t.Fatalf("Unexpected user after the first payment: %+v, %v", before, err)
+	}
+
+	// The scheduler charges the renewal a day before the period ends
+	completed, err := GetPaymentByID(first.ID)
+	if err != nil {
+		t.Fatalf("GetPaymentByID failed: %v", err)
+	}
+	completeTestPayment(t, &models.Payment{UserID: userID, SubscriptionID: completed.SubscriptionID, Renewal: true})
+
+	after, err := GetUserByID(userID)
+	if err != nil {
+		t.Fatalf("GetUserByID failed: %v", err)
+	}
+	if after.TokensUsedInputThisPeriod != 0 || after.TokensUsedOutputThisPeriod != 0 {
+		t.Fatalf("The renewal must reset the counters, got %d/%d", after.TokensUsedInputThisPeriod, after.TokensUsedOutputThisPeriod)
+	}
+	if after.BillingCycleAnchorDate == nil || after.BillingCycleAnchorDate.Sub(*before.CurrentPeriodEnd).Abs() > time.Second {
+		t.Fatalf("Expected the anchor at the new period start %v, got %v", *before.CurrentPeriodEnd, after.BillingCycleAnchorDate)
+	}
+	if want := before.CurrentPeriodEnd.AddDate(0, 1, 0); after.CurrentPeriodEnd == nil || after.CurrentPeriodEnd.Sub(want).Abs() > time.Second {
+		t.Fatalf("Expected the period extended to %v, got %v", want, after.CurrentPeriodEnd)
+	}
+
+	// The anchor is in the future: no monthly rollover until a month after the new period starts
+	if _, err := RollTokenPeriods(time.Now()); err != nil {
+		t.Fatalf("RollTokenPeriods failed: %v", err)
+	}
+	rolled, err := GetUserByID(userID)
+	if err != nil {
+		t.Fatalf("GetUserByID failed: %v", err)
+	}
+	if !rolled.BillingCycleAnchorDate.Equal(*after.BillingCycleAnchorDate) {
+		t.Fatalf("Unexpected rollover of the renewed period: %v", rolled.BillingCycleAnchorDate)
+	}
+}
+
+func TestRollTokenPeriodsStartsNextMonth(t *testing.T) {
+	OpenTestDB(t)
+	userID := CreateTestUser(t)
+	completeTestPayment(t, &models.Payment{UserID: userID})
+	if err := IncrementTokenUsage(userID, 1000, 200); err != nil {
+		t.Fatalf("IncrementTokenUsage failed: %v", err)
+	}
+	// An annual subscription paid 40 days ago
+	anchor := time.Now().AddDate(0, 0, -40).Truncate(time.Second)
+	if _, err := DB.Exec(`UPDATE users SET billing_cycle_anchor_date = ? WHERE id = ?`, anchor, userID); err != nil {
+		t.Fatalf("Failed to move the anchor: %v", err)
+	}
+	if _, err := RollTokenPeriods(time.Now()); err != nil {
+		t.Fatalf("RollTokenPeriods failed: %v", err)
+	}
+	user, err := GetUserByID(userID)
+	if err != nil {
+		t.Fatalf("GetUserByID failed: %v", err)
+	}
+	if user.TokensUsedInputThisPeriod != 0 || user.TokensUsedOutputThisPeriod != 0 {
+		t.Fatalf("A new month must reset the counters, got %d/%d", user.TokensUsedInputThisPeriod, user.TokensUsedOutputThisPeriod)
+	}
+	if want := anchor.AddDate(0, 1, 0); user.BillingCycleAnchorDate == nil || user.BillingCycleAnchorDate.Sub(want).Abs() > 24*time.Hour {
+		t.Fatalf("Expected the anchor moved a month to %v, got %v", want, user.BillingCycleAnchorDate)
+	}
+}
+
+func TestCompletePaymentAfterPeriodEndStartsNewTokenPeriod(t *testing.T) {
+	OpenTestDB(t)
+	userID := CreateTestUser(t)
+
+	first := &models.Payment{UserID: userID}
+	completeTestPayment(t, first)
+	if err := IncrementTokenUsage(userID, 1000, 200); err != nil {
+		t.Fatalf("IncrementTokenUsage failed: %v", err)
+	}
+	completed, err := GetPaymentByID(first.ID)
+	if err != nil {
+		t.Fatalf("GetPaymentByID failed: %v", err)
+	}
+	// The period has ended unpaid: the next payment starts a new period now
+	ended := time.Now().Add(-time.Hour)
+	if _, err := DB.Exec(`UPDATE subscriptions SET current_period_end = ? WHERE id = ?`, ended, completed.SubscriptionID); err != nil {
+		t.Fatalf("Failed to end the period: %v", err)
+	}
+	completeTestPayment(t, &models.Payment{UserID: userID, SubscriptionID: completed.SubscriptionID, Renewal: true})
+
+	after, err := GetUserByID(userID)
+	if err != nil {
+		t.Fatalf("GetUserByID failed: %v", err)
+	}
+	if after.TokensUsedInputThisPeriod != 0 || after.TokensUsedOutputThisPeriod != 0 {
+		t.Fatalf("A new period must reset the counters, got %d/%d", after.TokensUsedInputThisPeriod, after.TokensUsedOutputThisPeriod)
+	}
+	if after.BillingCycleAnchorDate == nil || time.Since(*after.BillingCycleAnchorDate) > time.Minute {
+		t.Fatalf("Expected the anchor at the new period start, got %v", after.BillingCycleAnchorDate)
+	}
+}
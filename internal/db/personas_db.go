@@ -0,0 +1,177 @@
+// internal/db/personas_db.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"shaman-ai.kz/internal/models"
+)
+
+const personaColumns = `id, slug, name, description, system_prompt, model, temperature, max_tokens,
+	routing_keywords, auto_route, is_active, is_builtin, sort_order, created_at, updated_at`
+
+// rowScanner - общий интерфейс *sql.Row и *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPersona(row rowScanner) (*models.Persona, error) {
+	p := &models.Persona{}
+	var description, systemPrompt, model, keywords sql.NullString
+	var temperature sql.NullFloat64
+	var maxTokens sql.NullInt64
+	err := row.Scan(&p.ID, &p.Slug, &p.Name, &description, &systemPrompt, &model, &temperature, &maxTokens,
+		&keywords, &p.AutoRoute, &p.IsActive, &p.IsBuiltin, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.Description = description.String
+	p.SystemPrompt = systemPrompt.String
+	p.Model = model.String
+	if temperature.Valid {
+		t := temperature.Float64
+		p.Temperature = &t
+	}
+	if maxTokens.Valid {
+		m := int(maxTokens.Int64)
+		p.MaxTokens = &m
+	}
+	p.RoutingKeywords = splitKeywords(keywords.String)
+	return p, nil
+}
+
+// splitKeywords разбирает ключевые слова, записанные через запятую или с новой строки
+func splitKeywords(value string) []string {
+	var keywords []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			keywords = append(keywords, item)
+		}
+	}
+	return keywords
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+func queryPersonas(query string, args ...interface{}) ([]models.Persona, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения персон: %w", err)
+	}
+	defer rows.Close()
+
+	var personas []models.Persona
+	for rows.Next() {
+		p, err := scanPersona(rows)
+		if err != nil {
+			slog.Error("Ошибка сканирования персоны", "error", err)
+			continue
+		}
+		personas = append(personas, *p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при получении персон: %w", err)
+	}
+	return personas, nil
+}
+
+// GetAllPersonas возвращает все персоны, включая отключенные (для админки)
+func GetAllPersonas() ([]models.Persona, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	return queryPersonas(`SELECT ` + personaColumns + ` FROM personas ORDER BY sort_order, id`)
+}
+
+// GetActivePersonas возвращает персоны, доступные пользователям
+func GetActivePersonas() ([]models.Persona, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	return queryPersonas(`SELECT ` + personaColumns + ` FROM personas WHERE is_active = TRUE ORDER BY sort_order, id`)
+}
+
+func GetPersonaByID(id int64) (*models.Persona, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	p, err := scanPersona(DB.QueryRow(`SELECT `+personaColumns+` FROM personas WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("персона с ID %d не найдена: %w", id, sql.ErrNoRows)
+		}
+		return nil, fmt.Errorf("ошибка получения персоны: %w", err)
+	}
+	return p, nil
+}
+
+func personaArgs(p *models.Persona) []interface{} {
+	var temperature sql.NullFloat64
+	if p.Temperature != nil {
+		temperature = sql.NullFloat64{Float64: *p.Temperature, Valid: true}
+	}
+	var maxTokens sql.NullInt64
+	if p.MaxTokens != nil {
+		maxTokens = sql.NullInt64{Int64: int64(*p.MaxTokens), Valid: true}
+	}
+	return []interface{}{p.Slug, p.Name, nullString(p.Description), nullString(p.SystemPrompt), nullString(p.Model),
+		temperature, maxTokens, nullString(strings.Join(p.RoutingKeywords, ", ")), p.AutoRoute, p.IsActive, p.SortOrder}
+}
+
+func CreatePersona(p *models.Persona) (int64, error) {
+	if DB == nil {
+		return 0, errors.New("БД не инициализирована")
+	}
+	query := `INSERT INTO personas (slug, name, description, system_prompt, model, temperature, max_tokens,
+		routing_keywords, auto_route, is_active, sort_order) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := DB.Exec(query, personaArgs(p)...)
+	if err != nil {
+		slog.Error("Ошибка создания персоны", "slug", p.Slug, "error", err)
+		return 0, fmt.Errorf("не удалось создать персону: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// UpdatePersona обновляет персону. Slug встроенных персон не меняется.
+func UpdatePersona(p *models.Persona) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	query := `UPDATE personas SET slug = IF(is_builtin, slug, ?), name = ?, description = ?, system_prompt = ?, model = ?,
+		temperature = ?, max_tokens = ?, routing_keywords = ?, auto_route = ?, is_active = ?, sort_order = ? WHERE id = ?`
+	args := append(personaArgs(p), p.ID)
+	if _, err := DB.Exec(query, args...); err != nil {
+		slog.Error("Ошибка обновления персоны", "id", p.ID, "error", err)
+		return fmt.Errorf("не удалось обновить персону: %w", err)
+	}
+	return nil
+}
+
+// UpdatePersonaPrompt меняет только системный промпт персоны (откат к ревизии)
+func UpdatePersonaPrompt(slug, prompt string) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	if _, err := DB.Exec(`UPDATE personas SET system_prompt = ? WHERE slug = ?`, nullString(prompt), slug); err != nil {
+		slog.Error("Ошибка обновления промпта персоны", "slug", slug, "error", err)
+		return fmt.Errorf("не удалось обновить промпт персоны: %w", err)
+	}
+	return nil
+}
+
+func SetPersonaActive(id int64, active bool) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	if _, err := DB.Exec(`UPDATE personas SET is_active = ? WHERE id = ?`, active, id); err != nil {
+		slog.Error("Ошибка изменения активности персоны", "id", id, "error", err)
+		return fmt.Errorf("не удалось изменить активность персоны: %w", err)
+	}
+	return nil
+}
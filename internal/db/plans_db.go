@@ -0,0 +1,228 @@
+// internal/db/plans_db.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"shaman-ai.kz/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const planColumns = `p.id, p.slug, p.name, p.description, p.monthly_price, p.annual_price, p.token_limit_kzt,
+	p.allow_file_uploads, p.allow_shaman_mode, p.allow_vision, p.trial_days, p.is_visible, p.sort_order, p.created_at, p.updated_at`
+
+func scanPlan(row rowScanner) (*models.Plan, error) {
+	p := &models.Plan{}
+	var description sql.NullString
+	err := row.Scan(&p.ID, &p.Slug, &p.Name, &description, &p.MonthlyPrice, &p.AnnualPrice, &p.TokenLimitKZT,
+		&p.AllowFileUploads, &p.AllowShamanMode, &p.AllowVision, &p.TrialDays, &p.IsVisible, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.Description = description.String
+	return p, nil
+}
+
+func queryPlans(query string, args ...interface{}) ([]models.Plan, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения тарифов: %w", err)
+	}
+	defer rows.Close()
+
+	var plans []models.Plan
+	for rows.Next() {
+		p, err := scanPlan(rows)
+		if err != nil {
+			slog.Error("Ошибка сканирования тарифа", "error", err)
+			continue
+		}
+		plans = append(plans, *p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при получении тарифов: %w", err)
+	}
+	return plans, nil
+}
+
+// GetAllPlans возвращает все тарифы, включая скрытые (для админки)
+func GetAllPlans() ([]models.Plan, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	return queryPlans(`SELECT ` + planColumns + ` FROM plans p ORDER BY p.sort_order, p.id`)
+}
+
+// GetVisiblePlans возвращает каталог тарифов для страницы подписки
+func GetVisiblePlans() ([]models.Plan, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	return queryPlans(`SELECT ` + planColumns + ` FROM plans p WHERE p.is_visible = TRUE ORDER BY p.sort_order, p.id`)
+}
+
+func GetPlanByID(id int64) (*models.Plan, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	p, err := scanPlan(DB.QueryRow(`SELECT `+planColumns+` FROM plans p WHERE p.id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("тариф с ID %d не найден: %w", id, sql.ErrNoRows)
+		}
+		return nil, fmt.Errorf("ошибка получения тарифа: %w", err)
+	}
+	return p, nil
+}
+
+// GetPlanBySlug находит тариф по slug; nil - тарифа нет в каталоге
+func GetPlanBySlug(slug string) (*models.Plan, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	p, err := scanPlan(DB.QueryRow(`SELECT `+planColumns+` FROM plans p WHERE p.slug = ?`, slug))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения тарифа %s: %w", slug, err)
+	}
+	return p, nil
+}
+
+// GetPlanOrDefault находит тариф по slug, а если его нет в каталоге - тариф по умолчанию
+func GetPlanOrDefault(slug string) (*models.Plan, error) {
+	if slug != "" {
+		p, err := GetPlanBySlug(slug)
+		if err != nil || p != nil {
+			return p, err
+		}
+		slog.Warn("Тариф подписки не найден в каталоге, используется тариф по умолчанию", "plan", slug)
+	}
+	p, err := GetPlanBySlug(models.DefaultPlanSlug)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, fmt.Errorf("тариф по умолчанию %s не найден", models.DefaultPlanSlug)
+	}
+	return p, nil
+}
+
+// GetUserPlan возвращает тариф текущей подписки пользователя; без подписки - тариф по умолчанию
+func GetUserPlan(userID int64) (*models.Plan, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	var planID sql.NullString
+	err := DB.QueryRow(`SELECT s.plan_id FROM users u
+		JOIN subscriptions s ON s.payment_gateway_subscription_id = u.subscription_id
+		WHERE u.id = ?`, userID).Scan(&planID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("ошибка получения тарифа пользователя %d: %w", userID, err)
+	}
+	return GetPlanOrDefault(planID.String)
+}
+
+func planArgs(p *models.Plan) []interface{} {
+	return []interface{}{p.Slug, p.Name, nullString(p.Description), p.MonthlyPrice, p.AnnualPrice, p.TokenLimitKZT,
+		p.AllowFileUploads, p.AllowShamanMode, p.AllowVision, p.TrialDays, p.IsVisible, p.SortOrder}
+}
+
+func CreatePlan(p *models.Plan) (int64, error) {
+	if DB == nil {
+		return 0, errors.New("БД не инициализирована")
+	}
+	query := `INSERT INTO plans (slug, name, description, monthly_price, annual_price, token_limit_kzt,
+		allow_file_uploads, allow_shaman_mode, allow_vision, trial_days, is_visible, sort_order)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := DB.Exec(query, planArgs(p)...)
+	if err != nil {
+		slog.Error("Ошибка создания тарифа", "slug", p.Slug, "error", err)
+		return 0, fmt.Errorf("не удалось создать тариф: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// UpdatePlan обновляет тариф. Slug не меняется: он записан в подписках.
+func UpdatePlan(p *models.Plan) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	query := `UPDATE plans SET name = ?, description = ?, monthly_price = ?, annual_price = ?, token_limit_kzt = ?,
+		allow_file_uploads = ?, allow_shaman_mode = ?, allow_vision = ?, trial_days = ?, is_visible = ?, sort_order = ?
+		WHERE id = ?`
+	args := append(planArgs(p)[1:], p.ID)
+	if _, err := DB.Exec(query, args...); err != nil {
+		slog.Error("Ошибка обновления тарифа", "id", p.ID, "error", err)
+		return fmt.Errorf("не удалось обновить тариф: %w", err)
+	}
+	return nil
+}
+
+// StartTrial оформляет пробный период тарифа без оплаты. Пробный период дается один раз:
+// false - у пользователя уже была подписка.
+func StartTrial(userID int64, plan *models.Plan) (bool, error) {
+	if DB == nil {
+		return false, errors.New("БД не инициализирована")
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Блокировка пользователя не дает оформить два пробных периода параллельными запросами
+	var existingSubID sql.NullString
+	if err := tx.QueryRow(`SELECT subscription_id FROM users WHERE id = ? FOR UPDATE`, userID).Scan(&existingSubID); err != nil {
+		return false, fmt.Errorf("не удалось получить пользователя %d: %w", userID, err)
+	}
+	var subscriptions int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM subscriptions WHERE user_id = ?`, userID).Scan(&subscriptions); err != nil {
+		return false, fmt.Errorf("не удалось проверить подписки пользователя: %w", err)
+	}
+	if existingSubID.String != "" || subscriptions > 0 {
+		return false, nil
+	}
+
+	now := time.Now()
+	end := now.AddDate(0, 0, plan.TrialDays)
+	subID := "sub_" + uuid.NewString()[:12]
+	_, err = tx.Exec(`INSERT INTO subscriptions (id, user_id, payment_gateway_subscription_id, plan_id, status,
+			start_date, current_period_start, current_period_end, end_date, cancel_at_period_end, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, FALSE, ?, ?)`,
+		subID, userID, subID, plan.Slug, models.SubscriptionStatusTrial, now, now, end, end, now, now)
+	if err != nil {
+		return false, fmt.Errorf("не удалось сохранить пробную подписку: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE users SET subscription_id = ?, subscription_status = ?, subscription_start_date = ?,
+			subscription_end_date = ?, current_period_end = ?, tokens_used_input_this_period = 0,
+			tokens_used_output_this_period = 0, billing_cycle_anchor_date = ?, updated_at = ?
+		WHERE id = ?`,
+		subID, models.SubscriptionStatusTrial, now, end, end, now, now, userID)
+	if err != nil {
+		return false, fmt.Errorf("не удалось обновить подписку пользователя: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("не удалось сохранить пробный период: %w", err)
+	}
+	slog.Info("Оформлен пробный период", "userID", userID, "plan", plan.Slug, "subscriptionID", subID, "periodEnd", end)
+	return true, nil
+}
+
+// HadSubscription - у пользователя уже была подписка (платная или пробная)
+func HadSubscription(userID int64) (bool, error) {
+	if DB == nil {
+		return false, errors.New("БД не инициализирована")
+	}
+	var count int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM subscriptions WHERE user_id = ?`, userID).Scan(&count); err != nil {
+		return false, fmt.Errorf("не удалось проверить подписки пользователя: %w", err)
+	}
+	return count > 0, nil
+}
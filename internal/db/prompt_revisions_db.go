@@ -0,0 +1,113 @@
+// internal/db/prompt_revisions_db.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// PersonaPromptKeyPrefix - префикс ключа ревизий для промптов персон ("persona:<slug>")
+const PersonaPromptKeyPrefix = "persona:"
+
+// PromptRevision - сохраненная версия системного промпта
+type PromptRevision struct {
+	ID              int64     `json:"id"`
+	PromptKey       string    `json:"prompt_key"`
+	Content         string    `json:"content"`
+	Comment         string    `json:"comment,omitempty"`
+	CreatedByUserID int64     `json:"created_by_user_id,omitempty"`
+	CreatedByEmail  string    `json:"created_by_email,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// CreatePromptRevision сохраняет новую версию промпта. userID = 0 - изменение без автора (система).
+func CreatePromptRevision(promptKey, content, comment string, userID int64) (int64, error) {
+	if DB == nil {
+		return 0, errors.New("БД не инициализирована")
+	}
+	var createdBy sql.NullInt64
+	if userID != 0 {
+		createdBy = sql.NullInt64{Int64: userID, Valid: true}
+	}
+	res, err := DB.Exec(`INSERT INTO prompt_revisions (prompt_key, content, comment, created_by_user_id, created_at) VALUES (?, ?, ?, ?, ?)`,
+		promptKey, content, nullString(comment), createdBy, time.Now())
+	if err != nil {
+		slog.Error("Ошибка сохранения ревизии промпта", "key", promptKey, "error", err)
+		return 0, fmt.Errorf("не удалось сохранить ревизию промпта: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+const promptRevisionSelect = `SELECT r.id, r.prompt_key, r.content, r.comment, r.created_by_user_id, u.email, r.created_at
+	FROM prompt_revisions r LEFT JOIN users u ON u.id = r.created_by_user_id`
+
+func scanPromptRevision(row rowScanner) (*PromptRevision, error) {
+	rev := &PromptRevision{}
+	var comment, email sql.NullString
+	var createdBy sql.NullInt64
+	if err := row.Scan(&rev.ID, &rev.PromptKey, &rev.Content, &comment, &createdBy, &email, &rev.CreatedAt); err != nil {
+		return nil, err
+	}
+	rev.Comment = comment.String
+	rev.CreatedByUserID = createdBy.Int64
+	rev.CreatedByEmail = email.String
+	return rev, nil
+}
+
+// GetPromptRevisions возвращает версии промпта, новые первыми
+func GetPromptRevisions(promptKey string, limit int) ([]PromptRevision, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	rows, err := DB.Query(promptRevisionSelect+` WHERE r.prompt_key = ? ORDER BY r.id DESC LIMIT ?`, promptKey, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ревизий промпта: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []PromptRevision
+	for rows.Next() {
+		rev, err := scanPromptRevision(rows)
+		if err != nil {
+			slog.Error("Ошибка сканирования ревизии промпта", "key", promptKey, "error", err)
+			continue
+		}
+		revisions = append(revisions, *rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при получении ревизий промпта: %w", err)
+	}
+	return revisions, nil
+}
+
+func GetPromptRevisionByID(id int64) (*PromptRevision, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	rev, err := scanPromptRevision(DB.QueryRow(promptRevisionSelect+` WHERE r.id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("ревизия промпта %d не найдена: %w", id, sql.ErrNoRows)
+		}
+		return nil, fmt.Errorf("ошибка получения ревизии промпта: %w", err)
+	}
+	return rev, nil
+}
+
+// GetLatestPromptRevision возвращает последнюю версию промпта или nil, если истории еще нет
+func GetLatestPromptRevision(promptKey string) (*PromptRevision, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	rev, err := scanPromptRevision(DB.QueryRow(promptRevisionSelect+` WHERE r.prompt_key = ? ORDER BY r.id DESC LIMIT 1`, promptKey))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения последней ревизии промпта: %w", err)
+	}
+	return rev, nil
+}
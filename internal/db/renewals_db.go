@@ -0,0 +1,249 @@
+// internal/db/renewals_db.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"shaman-ai.kz/internal/models"
+)
+
+// BillingSubscription - текущая подписка пользователя, которой нужно продление, повтор списания
+// или напоминание о неоплате
+type BillingSubscription struct {
+	ID                   string
+	UserID               int64
+	Email                string
+	Phone                string // Только подтвержденный номер
+	FirstName            string
+	PlanID               string
+	PeriodMonths         int // Период оплаты: 1 - помесячно, 12 - за год
+	Status               models.SubscriptionStatus
+	CurrentPeriodEnd     time.Time
+	CancelAtPeriodEnd    bool
+	RecurringToken       string
+	RecurringGateway     string
+	RenewalAttempts      int
+	NextRenewalAttemptAt *time.Time // nil - списание еще не пробовали
+	PastDueSince         *time.Time // Начало льготного периода
+	DunningStage         int        // Сколько напоминаний о неоплате отправлено
+}
+
+// BillingDue - по чему GetSubscriptionsForBilling отбирает подписки, с которыми пора что-то делать
+type BillingDue struct {
+	Now             time.Time
+	RenewBefore     time.Time // Продление списывается, когда период заканчивается раньше
+	GracePeriodDays int
+	DunningDays     []int    // Дни напоминаний о неоплате от начала льготного периода
+	ManualGateways  []string // Подключенные шлюзы, которые не умеют списывать с сохраненной карты
+}
+
+// GetSubscriptionsForBilling возвращает подписки, с которыми пора что-то делать: списать продление
+// или повторить списание, перевести в past_due или завершить закончившийся период, отправить
+// наступившее напоминание о неоплате или отменить после льготного периода. Подписки, ждущие
+// повтора списания или очередного напоминания, не возвращаются и не занимают место в limit.
+func GetSubscriptionsForBilling(due BillingDue, limit int) ([]BillingSubscription, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	// Карту можно списать (см. billing.canRenew), время попытки списания наступило (billing.renewalDue)
+	renewable := `s.recurring_token IS NOT NULL AND s.recurring_token <> ''`
+	var renewableArgs []interface{}
+	if len(due.ManualGateways) > 0 {
+		renewable += ` AND (s.recurring_gateway IS NULL OR s.recurring_gateway NOT IN (?` + strings.Repeat(`, ?`, len(due.ManualGateways)-1) + `))`
+		for _, name := range due.ManualGateways {
+			renewableArgs = append(renewableArgs, name)
+		}
+	}
+	attemptDue := `(s.next_renewal_attempt_at IS NULL OR s.next_renewal_attempt_at <= ?)`
+
+	// Действующая: период закончился или пора списывать продление
+	where := `(s.status = ? AND (s.current_period_end <= ? OR (s.current_period_end <= ? AND NOT s.cancel_at_period_end AND ` +
+		renewable + ` AND ` + attemptDue + `)))`
+	args := []interface{}{models.SubscriptionStatusActive, due.Now, due.RenewBefore}
+	args = append(args, renewableArgs...)
+	args = append(args, due.Now)
+
+	// past_due: льготный период закончился, пора повторить списание или наступило неотправленное напоминание
+	where += ` OR (s.status = ? AND s.past_due_since IS NOT NULL AND (s.past_due_since <= ? OR (` + renewable + ` AND ` + attemptDue + `)`
+	args = append(args, models.SubscriptionStatusPastDue, due.Now.AddDate(0, 0, -due.GracePeriodDays))
+	args = append(args, renewableArgs...)
+	args = append(args, due.Now)
+	if len(due.DunningDays) > 0 {
+		stages := make([]string, len(due.DunningDays))
+		for i := range stages {
+			stages[i] = `(s.dunning_stage <= ? AND s.past_due_since <= ?)`
+		}
+		where += ` OR (` + renewable + ` AND (` + strings.Join(stages, ` OR `) + `))`
+		args = append(args, renewableArgs...)
+		for i, days := range due.DunningDays {
+			args = append(args, i, due.Now.AddDate(0, 0, -days))
+		}
+	}
+	where += `))`
+	args = append(args, limit)
+
+	rows, err := DB.Query(`SELECT s.id, s.user_id, u.email, IF(u.is_phone_verified, u.phone, NULL), u.first_name, s.plan_id, s.status,
+			s.current_period_end, s.cancel_at_period_end, s.recurring_token, s.recurring_gateway, s.renewal_attempts,
+			s.next_renewal_attempt_at, s.past_due_since, s.dunning_stage, s.billing_period_months
+		FROM subscriptions s JOIN users u ON u.subscription_id = s.payment_gateway_subscription_id
+		WHERE `+where+`
+		ORDER BY s.current_period_end LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения подписок для продления: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []BillingSubscription
+	for rows.Next() {
+		var s BillingSubscription
+		var phone, firstName, planID, token, tokenGateway sql.NullString
+		var nextAttempt, pastDueSince sql.NullTime
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Email, &phone, &firstName, &planID, &s.Status, &s.CurrentPeriodEnd, &s.CancelAtPeriodEnd,
+			&token, &tokenGateway, &s.RenewalAttempts, &nextAttempt, &pastDueSince, &s.DunningStage, &s.PeriodMonths); err != nil {
+			slog.Error("Ошибка сканирования подписки для продления", "error", err)
+			continue
+		}
+		s.Phone, s.FirstName, s.PlanID = phone.String, firstName.String, planID.String
+		s.RecurringToken, s.RecurringGateway = token.String, tokenGateway.String
+		if nextAttempt.Valid {
+			s.NextRenewalAttemptAt = &nextAttempt.Time
+		}
+		if pastDueSince.Valid {
+			s.PastDueSince = &pastDueSince.Time
+		}
+		subs = append(subs, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации при получении подписок для продления: %w", err)
+	}
+	return subs, nil
+}
+
+// GetOpenRenewalPayment возвращает незавершенное списание автопродления по подписке; nil - такого нет
+func GetOpenRenewalPayment(subscriptionID string) (*models.Payment, error) {
+	if DB == nil {
+		return nil, errors.New("БД не инициализирована")
+	}
+	p, err := scanPayment(DB.QueryRow(`SELECT `+paymentColumns+` FROM payments
+		WHERE subscription_id = ? AND is_renewal AND status IN (?, ?) ORDER BY created_at DESC LIMIT 1`,
+		subscriptionID, models.PaymentStatusPending, models.PaymentStatusProcessing))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения списания автопродления: %w", err)
+	}
+	return p, nil
+}
+
+// ScheduleRenewalRetry сохраняет неудачную попытку списания и время следующей
+func ScheduleRenewalRetry(subscriptionID string, nextAttemptAt time.Time) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	_, err := DB.Exec(`UPDATE subscriptions SET renewal_attempts = renewal_attempts + 1, next_renewal_attempt_at = ?, updated_at = ? WHERE id = ?`,
+		nextAttemptAt, time.Now(), subscriptionID)
+	if err != nil {
+		return fmt.Errorf("не удалось сохранить попытку продления подписки %s: %w", subscriptionID, err)
+	}
+	return nil
+}
+
+// MarkSubscriptionPastDue переводит подписку с закончившимся неоплаченным периодом в past_due:
+// начинается льготный период. false - подписка уже не активна (продлена или отменена параллельно).
+func MarkSubscriptionPastDue(subscriptionID string, userID int64) (bool, error) {
+	if DB == nil {
+		return false, errors.New("БД не инициализирована")
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	res, err := tx.Exec(`UPDATE subscriptions SET status = ?, past_due_since = ?, dunning_stage = 0, updated_at = ?
+		WHERE id = ? AND status = ? AND current_period_end <= ?`,
+		models.SubscriptionStatusPastDue, now, now, subscriptionID, models.SubscriptionStatusActive, now)
+	if err != nil {
+		return false, fmt.Errorf("не удалось перевести подписку %s в past_due: %w", subscriptionID, err)
+	}
+	if affected, _ := res.RowsAffected(); affected != 1 {
+		return false, nil
+	}
+	if _, err := tx.Exec(`UPDATE users SET subscription_status = ?, updated_at = ? WHERE id = ?`,
+		models.SubscriptionStatusPastDue, now, userID); err != nil {
+		return false, fmt.Errorf("не удалось обновить статус подписки пользователя: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("не удалось сохранить статус past_due: %w", err)
+	}
+	return true, nil
+}
+
+// SetSubscriptionDunningStage сохраняет, сколько напоминаний о неоплате отправлено
+func SetSubscriptionDunningStage(subscriptionID string, stage int) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	if _, err := DB.Exec(`UPDATE subscriptions SET dunning_stage = ?, updated_at = ? WHERE id = ?`, stage, time.Now(), subscriptionID); err != nil {
+		return fmt.Errorf("не удалось сохранить этап напоминаний подписки %s: %w", subscriptionID, err)
+	}
+	return nil
+}
+
+// EndSubscription отменяет подписку сейчас: по окончании оплаченного периода с cancel_at_period_end
+// или после льготного периода без оплаты. Сохраненная карта забывается. false - подписка уже
+// продлена или отменена.
+func EndSubscription(subscriptionID string, userID int64) (bool, error) {
+	if DB == nil {
+		return false, errors.New("БД не инициализирована")
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	res, err := tx.Exec(`UPDATE subscriptions SET status = ?, end_date = ?, recurring_token = NULL, recurring_gateway = NULL,
+			next_renewal_attempt_at = NULL, updated_at = ?
+		WHERE id = ? AND (status = ? OR (status = ? AND current_period_end <= ?))`,
+		models.SubscriptionStatusCanceled, now, now, subscriptionID, models.SubscriptionStatusPastDue, models.SubscriptionStatusActive, now)
+	if err != nil {
+		return false, fmt.Errorf("не удалось отменить подписку %s: %w", subscriptionID, err)
+	}
+	if affected, _ := res.RowsAffected(); affected != 1 {
+		return false, nil
+	}
+	if _, err := tx.Exec(`UPDATE users SET subscription_status = ?, subscription_end_date = ?, updated_at = ? WHERE id = ?`,
+		models.SubscriptionStatusCanceled, now, now, userID); err != nil {
+		return false, fmt.Errorf("не удалось обновить статус подписки пользователя: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("не удалось сохранить отмену подписки: %w", err)
+	}
+	slog.Info("Подписка отменена", "subscriptionID", subscriptionID, "userID", userID)
+	return true, nil
+}
+
+// SetSubscriptionCancelAtPeriodEnd отключает автопродление: подписка действует до конца оплаченного
+// периода, сохраненная карта забывается
+func SetSubscriptionCancelAtPeriodEnd(subscriptionID string) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	_, err := DB.Exec(`UPDATE subscriptions SET cancel_at_period_end = TRUE, end_date = current_period_end, recurring_token = NULL,
+			recurring_gateway = NULL, next_renewal_attempt_at = NULL, updated_at = ?
+		WHERE id = ?`, time.Now(), subscriptionID)
+	if err != nil {
+		slog.Error("Ошибка отключения автопродления подписки", "subscriptionID", subscriptionID, "error", err)
+		return fmt.Errorf("не удалось отключить автопродление: %w", err)
+	}
+	return nil
+}
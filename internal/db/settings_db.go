@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,6 +19,28 @@ type AppSetting struct {
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+var (
+	settingHooksMu      sync.RWMutex
+	settingChangedHooks []func(key string)
+)
+
+// OnSettingChanged регистрирует обработчик, вызываемый после каждого успешного UpdateSetting
+// (например, для сброса кеша, зависящего от настройки).
+func OnSettingChanged(hook func(key string)) {
+	settingHooksMu.Lock()
+	defer settingHooksMu.Unlock()
+	settingChangedHooks = append(settingChangedHooks, hook)
+}
+
+func notifySettingChanged(key string) {
+	settingHooksMu.RLock()
+	hooks := settingChangedHooks
+	settingHooksMu.RUnlock()
+	for _, hook := range hooks {
+		hook(key)
+	}
+}
+
 // GetSetting извлекает одну настройку по ключу.
 func GetSetting(key string) (*AppSetting, error) {
 	if DB == nil {
@@ -107,6 +130,7 @@ func UpdateSetting(key string, value string, description ...string) error {
 		return fmt.Errorf("не удалось обновить/вставить настройку '%s': %w", key, err)
 	}
 	slog.Info("Настройка приложения обновлена/вставлена", "key", key, "value", value)
+	notifySettingChanged(key)
 	return nil
 }
 
@@ -2,11 +2,59 @@
 package db
 
 import (
+	"database/sql"
 	"fmt"
+	"os"
 	"shaman-ai.kz/internal/models"
 	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 )
 
+// OpenTestDB connects to the MariaDB from TEST_DATABASE_DSN (e.g. "user:pass@tcp(127.0.0.1:3306)/shaman_test")
+// and applies migrations. DB-backed tests are skipped when the variable is not set.
+func OpenTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN not set, skipping DB-backed test")
+		return
+	}
+	if DB != nil {
+		return
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("Invalid TEST_DATABASE_DSN: %v", err)
+	}
+	cfg.ParseTime = true
+	cfg.MultiStatements = true
+	conn, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatalf("Failed to open test DB: %v", err)
+	}
+	if err := conn.Ping(); err != nil {
+		t.Fatalf("Failed to connect to test DB: %v", err)
+	}
+	if err := RunMigrations(conn, cfg.DBName); err != nil {
+		t.Fatalf("Failed to migrate test DB: %v", err)
+	}
+	DB = conn
+}
+
+// CreateTestUser creates a user with the default role and a unique email and returns its ID
+func CreateTestUser(t *testing.T) int64 {
+	t.Helper()
+	SeedDefaultRolesForTest(t)
+	email := fmt.Sprintf("test-%d@example.com", time.Now().UnixNano())
+	id, err := CreateUser(&models.User{Email: email, PasswordHash: "x", FirstName: "Test", Gender: "male", Birthday: "1990-01-01"}, models.RoleUser)
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	return id
+}
+
 func ClearTestDBTables(t *testing.T, tableNames ...string) {
 	if DB == nil {
 		t.Skip("DB not initialized, skipping table clear")
@@ -0,0 +1,40 @@
+// internal/db/upload_audit_db.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// UploadAuditEntry - запись журнала об отклоненной загрузке
+type UploadAuditEntry struct {
+	UserID       int64
+	OriginalName string
+	DetectedType string
+	Size         int64
+	SHA256       string
+	Reason       string // Код причины (uploadcheck.Reason*)
+	Detail       string
+	IPAddress    string
+}
+
+// CreateUploadAuditEntry записывает отклоненную загрузку в журнал
+func CreateUploadAuditEntry(e UploadAuditEntry) error {
+	if DB == nil {
+		return errors.New("БД не инициализирована")
+	}
+	userID := sql.NullInt64{Int64: e.UserID, Valid: e.UserID != 0}
+	originalName := []rune(e.OriginalName)
+	if len(originalName) > 255 {
+		originalName = originalName[:255]
+	}
+	_, err := DB.Exec(`INSERT INTO upload_audit_log (user_id, original_name, detected_type, size, content_sha256, reason, detail, ip_address) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, string(originalName), nullString(e.DetectedType), e.Size, nullString(e.SHA256), e.Reason, nullString(e.Detail), nullString(e.IPAddress))
+	if err != nil {
+		slog.Error("Ошибка записи в журнал загрузок", "user_id", e.UserID, "reason", e.Reason, "error", err)
+		return fmt.Errorf("не удалось записать отклоненную загрузку в журнал: %w", err)
+	}
+	return nil
+}
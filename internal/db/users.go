@@ -513,6 +513,23 @@ func IncrementTokenUsage(userID int64, inputTokens, outputTokens int) error {
 	return nil
 }
 
+// RollTokenPeriods начинает новый месяц расчетного периода без оплаты (годовая подписка): у пользователей,
+// чей месяц от billing_cycle_anchor_date истек к now, счетчики токенов обнуляются, дата отсчета
+// сдвигается на месяц. Оплаченный период начинает CompletePayment. Возвращает число пользователей.
+func RollTokenPeriods(now time.Time) (int64, error) {
+	if DB == nil {
+		return 0, errors.New("БД не инициализирована")
+	}
+	res, err := DB.Exec(`UPDATE users SET tokens_used_input_this_period = 0, tokens_used_output_this_period = 0,
+			billing_cycle_anchor_date = DATE_ADD(billing_cycle_anchor_date, INTERVAL 1 MONTH), updated_at = ?
+		WHERE billing_cycle_anchor_date IS NOT NULL AND DATE_ADD(billing_cycle_anchor_date, INTERVAL 1 MONTH) <= ?`, now, now)
+	if err != nil {
+		slog.Error("Ошибка сброса счетчиков токенов", "error", err)
+		return 0, fmt.Errorf("не удалось сбросить счетчики токенов: %w", err)
+	}
+	return res.RowsAffected()
+}
+
 
 // --- Helper-функции для уменьшения дублирования кода ---
 
@@ -0,0 +1,60 @@
+// internal/docextract/chunk.go
+package docextract
+
+import (
+	"strings"
+	"unicode"
+
+	"shaman-ai.kz/internal/llm"
+)
+
+// Chunks делит текст на части не длиннее maxTokens (по оценке llm.EstimateTokens).
+// Разрез делается по символам, а не байтам, и по возможности - на границе абзаца,
+// строки или слова в последней трети части.
+func Chunks(text string, maxTokens int) []string {
+	limit := llm.RunesForTokens(maxTokens)
+	if limit <= 0 {
+		return nil
+	}
+	runes := []rune(text)
+	var chunks []string
+	for len(runes) > 0 {
+		if len(runes) <= limit {
+			chunks = append(chunks, string(runes))
+			break
+		}
+		cut := cutPoint(runes[:limit])
+		if chunk := strings.TrimSpace(string(runes[:cut])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		runes = runes[cut:]
+	}
+	return chunks
+}
+
+// cutPoint ищет, где лучше разрезать окно: после пустой строки, переноса строки
+// или пробела; если ничего не нашлось - по границе окна
+func cutPoint(window []rune) int {
+	minCut := len(window) * 2 / 3
+	for _, sep := range []func(i int) bool{
+		func(i int) bool { return window[i] == '\n' && i > 0 && window[i-1] == '\n' },
+		func(i int) bool { return window[i] == '\n' },
+		func(i int) bool { return unicode.IsSpace(window[i]) },
+	} {
+		for i := len(window) - 1; i >= minCut; i-- {
+			if sep(i) {
+				return i + 1
+			}
+		}
+	}
+	return len(window)
+}
+
+// Truncate оставляет начало текста в пределах maxTokens и сообщает, был ли текст сокращен
+func Truncate(text string, maxTokens int) (string, bool) {
+	chunks := Chunks(text, maxTokens)
+	if len(chunks) <= 1 {
+		return text, false
+	}
+	return chunks[0], true
+}
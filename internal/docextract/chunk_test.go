@@ -0,0 +1,93 @@
+// internal/docextract/chunk_test.go
+package docextract
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"shaman-ai.kz/internal/llm"
+)
+
+const chunkTokens = 10
+
+var chunkRunes = llm.RunesForTokens(chunkTokens)
+
+func checkChunkSizes(t *testing.T, chunks []string) {
+	t.Helper()
+	for i, chunk := range chunks {
+		if !utf8.ValidString(chunk) {
+			t.Fatalf("Chunk %d is not valid UTF-8: %q", i, chunk)
+		}
+		if n := utf8.RuneCountInString(chunk); n > chunkRunes {
+			t.Fatalf("Chunk %d has %d runes, limit %d", i, n, chunkRunes)
+		}
+	}
+}
+
+func TestChunksCutsOnRunes(t *testing.T) {
+	// No spaces to cut at: the text is cut by runes, not in the middle of a two-byte letter
+	text := strings.Repeat("ж", 2*chunkRunes+10)
+	chunks := Chunks(text, chunkTokens)
+	checkChunkSizes(t, chunks)
+	if len(chunks) != 3 || utf8.RuneCountInString(chunks[2]) != 10 {
+		t.Fatalf("Expected chunks of %d, %d and 10 runes, got %q", chunkRunes, chunkRunes, chunks)
+	}
+	if strings.Join(chunks, "") != text {
+		t.Fatal("Chunks lost a part of the text")
+	}
+}
+
+func TestChunksCutsOnWords(t *testing.T) {
+	text := "Съешь же ещё этих мягких французских булок да выпей чаю, широкая электрификация южных губерний"
+	chunks := Chunks(text, chunkTokens)
+	checkChunkSizes(t, chunks)
+	if len(chunks) < 3 {
+		t.Fatalf("Expected the text to be split, got %q", chunks)
+	}
+	var words []string
+	for _, chunk := range chunks {
+		words = append(words, strings.Fields(chunk)...)
+	}
+	if !slices.Equal(words, strings.Fields(text)) {
+		t.Fatalf("A word was cut between chunks: %q", chunks)
+	}
+}
+
+func TestChunksCutsOnParagraphs(t *testing.T) {
+	const first = "Первый абзац про погоду."
+	const second = "Второй абзац длиннее и тоже делится на части по словам."
+	chunks := Chunks(first+"\n\n"+second, chunkTokens)
+	checkChunkSizes(t, chunks)
+	// The window also has spaces after the paragraph break, but the break is preferred
+	if len(chunks) < 2 || chunks[0] != first {
+		t.Fatalf("Expected the first chunk to be the first paragraph, got %q", chunks)
+	}
+	if rest := strings.Join(chunks[1:], " "); strings.Join(strings.Fields(rest), " ") != second {
+		t.Fatalf("Unexpected rest of the text: %q", chunks[1:])
+	}
+
+	// A break too early in the window is not worth a short chunk: the cut falls on a word
+	chunks = Chunks("Да.\n\n"+second, chunkTokens)
+	checkChunkSizes(t, chunks)
+	if !strings.HasPrefix(chunks[0], "Да.\n\nВторой абзац") {
+		t.Fatalf("Expected the short paragraph to share a chunk, got %q", chunks[0])
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	short := "Короткий текст"
+	if got, truncated := Truncate(short, chunkTokens); got != short || truncated {
+		t.Fatalf("Truncate(short) = %q, %v", got, truncated)
+	}
+
+	long := "Длинный текст из множества слов, который не помещается в лимит токенов модели"
+	got, truncated := Truncate(long, chunkTokens)
+	if !truncated || !strings.HasPrefix(long, got) || utf8.RuneCountInString(got) > chunkRunes {
+		t.Fatalf("Truncate(long) = %q, %v", got, truncated)
+	}
+	if next := long[len(got):]; next[0] != ' ' {
+		t.Fatalf("Truncate cut a word: %q|%q", got, next)
+	}
+}
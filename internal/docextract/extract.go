@@ -0,0 +1,222 @@
+// internal/docextract/extract.go
+//
+// Package docextract извлекает текст из прикрепленных документов. Тип файла определяется
+// по содержимому (а не по Content-Type от клиента), для каждого MIME-типа
+// зарегистрирован свой извлекатель. Все реализации на чистом Go.
+package docextract
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+const (
+	MIMEPDF      = "application/pdf"
+	MIMEDOCX     = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	MIMEXLSX     = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	MIMEODT      = "application/vnd.oasis.opendocument.text"
+	MIMEODS      = "application/vnd.oasis.opendocument.spreadsheet"
+	MIMECSV      = "text/csv"
+	MIMEHTML     = "text/html"
+	MIMEMarkdown = "text/markdown"
+	MIMEText     = "text/plain"
+	MIMEZip      = "application/zip"
+)
+
+// Защита от zip-бомб и гигантских документов: столько байт максимум читается
+// из одного файла внутри архива и столько текста максимум возвращается
+const (
+	maxEntrySize = 50 << 20
+	maxTextSize  = 5 << 20
+)
+
+var (
+	// ErrUnsupported - для этого типа файла извлекатель не зарегистрирован
+	ErrUnsupported = errors.New("тип документа не поддерживается")
+	// ErrNoText - документ прочитан, но текста в нем нет (например, скан PDF)
+	ErrNoText = errors.New("в документе не найден текст")
+)
+
+// Extractor извлекает текст из документа одного типа
+type Extractor func(r io.ReaderAt, size int64) (string, error)
+
+var (
+	mu         sync.RWMutex
+	extractors = map[string]Extractor{
+		MIMEPDF:      extractPDF,
+		MIMEDOCX:     extractDOCX,
+		MIMEXLSX:     extractXLSX,
+		MIMEODT:      extractODF,
+		MIMEODS:      extractODF,
+		MIMECSV:      extractCSV,
+		MIMEHTML:     extractHTML,
+		MIMEMarkdown: extractPlain,
+		MIMEText:     extractPlain,
+	}
+)
+
+// Register добавляет или заменяет извлекатель для MIME-типа
+func Register(mimeType string, extractor Extractor) {
+	mu.Lock()
+	defer mu.Unlock()
+	extractors[mimeType] = extractor
+}
+
+// Supported сообщает, есть ли извлекатель для MIME-типа
+func Supported(mimeType string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := extractors[baseType(mimeType)]
+	return ok
+}
+
+func baseType(mimeType string) string {
+	if t, _, err := mime.ParseMediaType(mimeType); err == nil {
+		return t
+	}
+	return mimeType
+}
+
+// Result - извлеченный текст и определенный тип документа
+type Result struct {
+	MIMEType string
+	Text     string
+}
+
+// DetectFile определяет MIME-тип файла по его содержимому. Имя файла используется только
+// для уточнения текстовых форматов, которые по содержимому не различить (Markdown, CSV с ';').
+func DetectFile(path, filename string) (string, error) {
+	m, err := mimetype.DetectFile(path)
+	if err != nil {
+		return "", fmt.Errorf("ошибка определения типа файла: %w", err)
+	}
+	detected := baseType(m.String())
+
+	switch detected {
+	case MIMEText:
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".md", ".markdown":
+			return MIMEMarkdown, nil
+		case ".csv":
+			return MIMECSV, nil
+		}
+	case MIMEZip:
+		// Офисные документы, у которых служебные файлы лежат не первыми в архиве,
+		// по сигнатуре определяются как обычный zip - смотрим на содержимое
+		if officeType := detectOfficeZip(path); officeType != "" {
+			return officeType, nil
+		}
+	}
+	return detected, nil
+}
+
+func detectOfficeZip(path string) string {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return ""
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		switch f.Name {
+		case "word/document.xml":
+			return MIMEDOCX
+		case "xl/workbook.xml":
+			return MIMEXLSX
+		case "mimetype":
+			rc, err := f.Open()
+			if err != nil {
+				return ""
+			}
+			data, _ := io.ReadAll(io.LimitReader(rc, 128))
+			rc.Close()
+			switch t := strings.TrimSpace(string(data)); t {
+			case MIMEODT, MIMEODS:
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+// ExtractFile определяет тип файла и извлекает из него текст
+func ExtractFile(path, filename string) (*Result, error) {
+	mimeType, err := DetectFile(path, filename)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{MIMEType: mimeType}
+
+	mu.RLock()
+	extractor, ok := extractors[mimeType]
+	mu.RUnlock()
+	if !ok {
+		return res, fmt.Errorf("%w: %s", ErrUnsupported, mimeType)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return res, fmt.Errorf("ошибка открытия документа: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return res, fmt.Errorf("ошибка чтения документа: %w", err)
+	}
+
+	text, err := safeExtract(extractor, f, info.Size())
+	if err != nil {
+		return res, fmt.Errorf("ошибка извлечения текста (%s): %w", mimeType, err)
+	}
+	text = normalizeText(text)
+	if text == "" {
+		return res, ErrNoText
+	}
+	res.Text = text
+	return res, nil
+}
+
+// safeExtract защищает от паник в разборщиках форматов на поврежденных файлах
+func safeExtract(extractor Extractor, r io.ReaderAt, size int64) (text string, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("документ поврежден или не поддерживается: %v", rec)
+		}
+	}()
+	return extractor(r, size)
+}
+
+// normalizeText приводит текст к валидному UTF-8, убирает лишние пробелы и пустые строки
+func normalizeText(text string) string {
+	text = strings.ToValidUTF8(text, "")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\x00", "")
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+	blank := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank++
+			if blank > 1 {
+				continue
+			}
+		} else {
+			blank = 0
+		}
+		out = append(out, line)
+	}
+	text = strings.TrimSpace(strings.Join(out, "\n"))
+	if len(text) > maxTextSize {
+		text = strings.ToValidUTF8(text[:maxTextSize], "")
+	}
+	return text
+}
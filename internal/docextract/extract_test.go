@@ -0,0 +1,87 @@
+// internal/docextract/extract_test.go
+package docextract
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const docxText = "Договор аренды"
+
+// writeDOCX builds a minimal DOCX; with documentFirst the document goes before [Content_Types].xml,
+// so the zip signature alone does not reveal the format
+func writeDOCX(t *testing.T, name string, documentFirst bool) string {
+	t.Helper()
+	entries := [][2]string{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/></Types>`},
+		{"word/document.xml", `<?xml version="1.0" encoding="UTF-8"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body><w:p><w:r><w:t>` + docxText + `</w:t></w:r></w:p></w:body></w:document>`},
+	}
+	if documentFirst {
+		entries[0], entries[1] = entries[1], entries[0]
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.Create(entry[0])
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", entry[0], err)
+		}
+		if _, err := w.Write([]byte(entry[1])); err != nil {
+			t.Fatalf("Failed to write %s: %v", entry[0], err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to build DOCX: %v", err)
+	}
+	return writeFile(t, name, buf.Bytes())
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// The client's Content-Type never reaches detection: a DOCX sent as text/plain under a .txt name
+// is still read as a DOCX, and a text file named .docx stays text
+func TestDetectFileIgnoresClaimedType(t *testing.T) {
+	cases := []struct {
+		name     string
+		path     string
+		filename string
+		want     string
+	}{
+		{"docx named as text", writeDOCX(t, "upload", false), "notes.txt", MIMEDOCX},
+		{"docx with the document first", writeDOCX(t, "upload", true), "notes.txt", MIMEDOCX},
+		{"text named as docx", writeFile(t, "upload", []byte("Просто текст, а не документ Word")), "report.docx", MIMEText},
+		{"text named as markdown", writeFile(t, "upload", []byte("# Заголовок\n\nТекст")), "README.md", MIMEMarkdown},
+		{"text named as csv", writeFile(t, "upload", []byte("имя;сумма\nАлия;100\n")), "table.csv", MIMECSV},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DetectFile(tc.path, tc.filename)
+			if err != nil {
+				t.Fatalf("DetectFile failed: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("DetectFile = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExtractFileUsesDetectedType(t *testing.T) {
+	res, err := ExtractFile(writeDOCX(t, "upload", true), "notes.txt")
+	if err != nil {
+		t.Fatalf("ExtractFile failed: %v", err)
+	}
+	if res.MIMEType != MIMEDOCX || res.Text != docxText {
+		t.Fatalf("Expected the DOCX text, got %+v", res)
+	}
+}
@@ -0,0 +1,277 @@
+// internal/docextract/office.go
+package docextract
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errNoEntry = errors.New("файл не найден в архиве")
+
+func openZipEntry(zr *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, f := range zr.File {
+		if f.Name == name {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			return struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(rc, maxEntrySize), rc}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", errNoEntry, name)
+}
+
+// walkXML обходит элементы XML-файла из архива. onStart/onEnd получают локальное имя элемента,
+// onText - текст; onStart может вернуть false, чтобы пропустить элемент целиком.
+func walkXML(zr *zip.Reader, name string, onStart func(el xml.StartElement) bool, onEnd func(local string), onText func(text string)) error {
+	rc, err := openZipEntry(zr, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dec := xml.NewDecoder(rc)
+	dec.Strict = false
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка разбора %s: %w", name, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if onStart != nil && !onStart(t) {
+				if err := dec.Skip(); err != nil {
+					return fmt.Errorf("ошибка разбора %s: %w", name, err)
+				}
+			}
+		case xml.EndElement:
+			if onEnd != nil {
+				onEnd(t.Name.Local)
+			}
+		case xml.CharData:
+			if onText != nil {
+				onText(string(t))
+			}
+		}
+	}
+}
+
+func attr(el xml.StartElement, local string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// extractDOCX читает текст абзацев из word/document.xml
+func extractDOCX(r io.ReaderAt, size int64) (string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	inText := false
+	err = walkXML(zr, "word/document.xml",
+		func(el xml.StartElement) bool {
+			switch el.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				sb.WriteByte('\t')
+			case "br", "cr":
+				sb.WriteByte('\n')
+			case "delText", "instrText", "pPr", "rPr", "sectPr":
+				return false // Удаленный при рецензировании текст, коды полей и свойства форматирования
+			}
+			return true
+		},
+		func(local string) {
+			switch local {
+			case "t":
+				inText = false
+			case "p":
+				sb.WriteByte('\n')
+			case "tc":
+				sb.WriteByte('\t')
+			}
+		},
+		func(text string) {
+			if inText {
+				sb.WriteString(text)
+			}
+		})
+	return sb.String(), err
+}
+
+// extractODF читает текст из content.xml документов и таблиц OpenDocument
+func extractODF(r io.ReaderAt, size int64) (string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	inBody := false
+	err = walkXML(zr, "content.xml",
+		func(el xml.StartElement) bool {
+			switch el.Name.Local {
+			case "body":
+				inBody = true
+			case "s":
+				n, _ := strconv.Atoi(attr(el, "c"))
+				sb.WriteString(strings.Repeat(" ", max(n, 1)))
+			case "tab":
+				sb.WriteByte('\t')
+			case "line-break":
+				sb.WriteByte('\n')
+			case "annotation", "tracked-changes":
+				return false
+			}
+			return true
+		},
+		func(local string) {
+			switch local {
+			case "p", "h", "table-row":
+				sb.WriteByte('\n')
+			case "table-cell":
+				sb.WriteByte('\t')
+			}
+		},
+		func(text string) {
+			if inBody {
+				sb.WriteString(text)
+			}
+		})
+	return sb.String(), err
+}
+
+// extractXLSX выводит ячейки листов построчно, значения разделены табуляцией
+func extractXLSX(r io.ReaderAt, size int64) (string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", err
+	}
+
+	// Общие строки: ячейки с t="s" хранят индекс в этом списке
+	var shared []string
+	var current strings.Builder
+	inText := false
+	if err := walkXML(zr, "xl/sharedStrings.xml",
+		func(el xml.StartElement) bool {
+			switch el.Name.Local {
+			case "si":
+				current.Reset()
+			case "t":
+				inText = true
+			case "rPh":
+				return false // Фонетические подсказки
+			}
+			return true
+		},
+		func(local string) {
+			switch local {
+			case "t":
+				inText = false
+			case "si":
+				shared = append(shared, current.String())
+			}
+		},
+		func(text string) {
+			if inText {
+				current.WriteString(text)
+			}
+		}); err != nil && !errors.Is(err, errNoEntry) {
+		return "", err
+	}
+
+	var sheetNames []string
+	_ = walkXML(zr, "xl/workbook.xml", func(el xml.StartElement) bool {
+		if el.Name.Local == "sheet" {
+			sheetNames = append(sheetNames, attr(el, "name"))
+		}
+		return true
+	}, nil, nil)
+
+	type sheetFile struct {
+		num  int
+		name string
+	}
+	var sheets []sheetFile
+	for _, f := range zr.File {
+		if numStr, ok := strings.CutPrefix(f.Name, "xl/worksheets/sheet"); ok {
+			if n, err := strconv.Atoi(strings.TrimSuffix(numStr, ".xml")); err == nil {
+				sheets = append(sheets, sheetFile{num: n, name: f.Name})
+			}
+		}
+	}
+	sort.Slice(sheets, func(i, j int) bool { return sheets[i].num < sheets[j].num })
+
+	var sb strings.Builder
+	for i, sheet := range sheets {
+		title := fmt.Sprintf("Лист %d", sheet.num)
+		if len(sheetNames) == len(sheets) && sheetNames[i] != "" {
+			title = sheetNames[i]
+		}
+		fmt.Fprintf(&sb, "[%s]\n", title)
+
+		var cellType string
+		var value strings.Builder
+		inValue := false
+		var row []string
+		err := walkXML(zr, sheet.name,
+			func(el xml.StartElement) bool {
+				switch el.Name.Local {
+				case "c":
+					cellType = attr(el, "t")
+					value.Reset()
+				case "v", "t":
+					inValue = true
+				case "f":
+					return false // Формулы не нужны, берем вычисленное значение
+				}
+				return true
+			},
+			func(local string) {
+				switch local {
+				case "v", "t":
+					inValue = false
+				case "c":
+					cell := value.String()
+					if cellType == "s" {
+						if idx, err := strconv.Atoi(cell); err == nil && idx >= 0 && idx < len(shared) {
+							cell = shared[idx]
+						}
+					}
+					row = append(row, cell)
+				case "row":
+					if line := strings.TrimRight(strings.Join(row, "\t"), "\t"); line != "" {
+						sb.WriteString(line + "\n")
+					}
+					row = row[:0]
+				}
+			},
+			func(text string) {
+				if inValue {
+					value.WriteString(text)
+				}
+			})
+		if err != nil {
+			return sb.String(), err
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String(), nil
+}
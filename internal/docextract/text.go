@@ -0,0 +1,142 @@
+// internal/docextract/text.go
+package docextract
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/net/html"
+)
+
+func readAll(r io.ReaderAt, size int64) ([]byte, error) {
+	return io.ReadAll(io.NewSectionReader(r, 0, min(size, maxEntrySize)))
+}
+
+func extractPlain(r io.ReaderAt, size int64) (string, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))), nil
+}
+
+// extractPDF извлекает текстовый слой PDF. Отсканированные страницы без текстового слоя
+// дают пустой результат (ErrNoText) - для них нужен OCR, которого здесь нет.
+func extractPDF(r io.ReaderAt, size int64) (string, error) {
+	reader, err := pdf.NewReader(r, size)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		rows, err := page.GetTextByRow()
+		if err != nil {
+			return sb.String(), err
+		}
+		for _, row := range rows {
+			var line strings.Builder
+			for _, word := range row.Content {
+				line.WriteString(word.S)
+			}
+			sb.WriteString(line.String() + "\n")
+		}
+		sb.WriteString("\n")
+		if sb.Len() > maxTextSize {
+			break
+		}
+	}
+	return sb.String(), nil
+}
+
+// extractCSV выводит строки таблицы с ячейками через табуляцию; разделитель (',' или ';')
+// определяется по первой строке
+func extractCSV(r io.ReaderAt, size int64) (string, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return "", err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+
+	var sb strings.Builder
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Некорректный CSV все равно остается читаемым текстом
+			return string(data), nil
+		}
+		sb.WriteString(strings.Join(record, "\t") + "\n")
+	}
+	return sb.String(), nil
+}
+
+// Элементы HTML, после которых в тексте нужен перенос строки
+var htmlBlockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "section": true, "article": true, "blockquote": true, "pre": true, "table": true,
+}
+
+// extractHTML оставляет видимый текст страницы без скриптов и стилей
+func extractHTML(r io.ReaderAt, size int64) (string, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return "", err
+	}
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	var sb strings.Builder
+	skip := 0
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if errors.Is(tokenizer.Err(), io.EOF) {
+				return sb.String(), nil
+			}
+			return sb.String(), tokenizer.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			switch tag := string(name); {
+			case tag == "script" || tag == "style" || tag == "noscript" || tag == "template":
+				skip++
+			case htmlBlockElements[tag]:
+				sb.WriteByte('\n')
+			case tag == "td" || tag == "th":
+				sb.WriteByte('\t')
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch tag := string(name); {
+			case tag == "script" || tag == "style" || tag == "noscript" || tag == "template":
+				if skip > 0 {
+					skip--
+				}
+			case htmlBlockElements[tag]:
+				sb.WriteByte('\n')
+			}
+		case html.TextToken:
+			if skip == 0 {
+				text := strings.Join(strings.Fields(string(tokenizer.Text())), " ")
+				if text != "" {
+					sb.WriteString(text + " ")
+				}
+			}
+		}
+	}
+}
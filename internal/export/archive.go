@@ -0,0 +1,151 @@
+// internal/export/archive.go
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/models"
+	"shaman-ai.kz/internal/timeutil"
+)
+
+const archiveReadme = `Выгрузка данных аккаунта Shaman AI
+
+account.json      - данные профиля и подписки
+chats/*.md        - диалоги в читаемом виде
+chats/*.json      - те же диалоги в машиночитаемом виде (поле attachments[].path - путь к файлу в архиве)
+attachments/      - файлы, прикрепленные к сообщениям
+
+Время указано по Алматы (Asia/Almaty). Диалоги из архива и корзины тоже включены,
+у них заполнены поля archived_at и deleted_at.
+`
+
+// accountData - данные профиля для выгрузки: только то, что пользователь вводил сам или видит в профиле
+type accountData struct {
+	Email              string     `json:"email"`
+	Phone              *string    `json:"phone,omitempty"`
+	FirstName          string     `json:"first_name"`
+	LastName           string     `json:"last_name"`
+	Gender             string     `json:"gender,omitempty"`
+	Birthday           string     `json:"birthday,omitempty"`
+	IsEmailVerified    bool       `json:"is_email_verified"`
+	IsPhoneVerified    bool       `json:"is_phone_verified"`
+	SubscriptionStatus string     `json:"subscription_status"`
+	SubscriptionEnd    *time.Time `json:"subscription_end_date,omitempty"`
+	RegisteredAt       time.Time  `json:"registered_at"`
+	ExportedAt         time.Time  `json:"exported_at"`
+}
+
+// AttachmentOpener открывает файл вложения для чтения
+type AttachmentOpener func(ctx context.Context, a *db.MessageAttachment) (io.ReadCloser, error)
+
+// WriteArchive записывает в w zip-архив со всеми данными пользователя: профиль, все диалоги
+// в Markdown и JSON и файлы вложений. Диалоги читаются из БД по одному, чтобы не держать
+// в памяти всю историю. personaName возвращает название режима диалога.
+func WriteArchive(ctx context.Context, w io.Writer, user *models.User, personaName func(db.ChatSessionMeta) string, openAttachment AttachmentOpener) error {
+	sessions, err := db.GetAllUserChatSessions(user.ID)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	now := time.Now()
+	if err := writeZipFile(zw, "README.txt", now, func(f io.Writer) error {
+		_, err := io.WriteString(f, archiveReadme)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	account := accountData{
+		Email:              user.Email,
+		Phone:              user.Phone,
+		FirstName:          user.FirstName,
+		LastName:           user.LastName,
+		Gender:             user.Gender,
+		Birthday:           user.Birthday,
+		IsEmailVerified:    user.IsEmailVerified,
+		IsPhoneVerified:    user.IsPhoneVerified,
+		SubscriptionStatus: string(user.SubscriptionStatus),
+		SubscriptionEnd:    inLocation(user.SubscriptionEndDate),
+		RegisteredAt:       user.CreatedAt.In(timeutil.Location),
+		ExportedAt:         now.In(timeutil.Location),
+	}
+	if err := writeZipFile(zw, "account.json", now, func(f io.Writer) error {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(account)
+	}); err != nil {
+		return err
+	}
+
+	for i := range sessions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		session := sessions[i]
+		conv, err := Load(&session, personaName(session))
+		if err != nil {
+			return err
+		}
+		conv.ExportedAt = now
+
+		// Сначала файлы вложений: в JSON попадают пути только тех, что удалось прочитать
+		paths := make(map[int64]string)
+		for _, turn := range conv.Turns {
+			for j := range turn.Attachments {
+				a := &turn.Attachments[j]
+				path := fmt.Sprintf("attachments/%d-%s", a.ID, safeName(a.OriginalName, 100))
+				if err := writeAttachment(ctx, zw, path, a, openAttachment); err != nil {
+					slog.Warn("Вложение не попало в выгрузку", "user_id", user.ID, "attachment_id", a.ID, "error", err)
+					continue
+				}
+				paths[a.ID] = path
+			}
+		}
+
+		base := "chats/" + conv.Filename("") + " " + session.UUID[:8]
+		if err := writeZipFile(zw, base+".md", session.UpdatedAt, func(f io.Writer) error {
+			return Markdown(f, conv)
+		}); err != nil {
+			return err
+		}
+		if err := writeZipFile(zw, base+".json", session.UpdatedAt, func(f io.Writer) error {
+			return writeJSON(f, conv, func(a db.MessageAttachment) string { return paths[a.ID] })
+		}); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeZipFile(zw *zip.Writer, name string, modified time.Time, write func(io.Writer) error) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return fmt.Errorf("ошибка записи архива (%s): %w", name, err)
+	}
+	if err := write(f); err != nil {
+		return fmt.Errorf("ошибка записи архива (%s): %w", name, err)
+	}
+	return nil
+}
+
+// writeAttachment копирует файл вложения в архив. Файл открывается до создания записи в архиве,
+// поэтому недоступное вложение просто пропускается.
+func writeAttachment(ctx context.Context, zw *zip.Writer, path string, a *db.MessageAttachment, open AttachmentOpener) error {
+	content, err := open(ctx, a)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	return writeZipFile(zw, path, a.CreatedAt, func(f io.Writer) error {
+		_, err := io.Copy(f, content)
+		return err
+	})
+}
@@ -0,0 +1,239 @@
+// internal/export/export.go
+//
+// Package export выгружает диалоги в Markdown, JSON и PDF, чтобы пользователь мог сохранить
+// консультацию или показать ее врачу. Время во всех форматах - по Алматы.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/timeutil"
+)
+
+// Formats - поддерживаемые форматы выгрузки одного диалога
+var Formats = map[string]struct{ ContentType, Extension string }{
+	"md":   {"text/markdown; charset=utf-8", ".md"},
+	"json": {"application/json; charset=utf-8", ".json"},
+	"pdf":  {"application/pdf", ".pdf"},
+}
+
+const timeLayout = "02.01.2006 15:04"
+
+func formatTime(t time.Time) string {
+	return t.In(timeutil.Location).Format(timeLayout)
+}
+
+// Disclaimer добавляется в Markdown и PDF: выгрузку часто показывают врачу
+const Disclaimer = "Ответы ассистента сгенерированы ИИ и не являются медицинским заключением."
+
+// Turn - обмен в диалоге с прикрепленными к сообщению пользователя файлами
+type Turn struct {
+	db.TranscriptTurn
+	Attachments []db.MessageAttachment
+}
+
+// Conversation - диалог целиком, готовый к выгрузке
+type Conversation struct {
+	Session     db.ChatSessionMeta
+	PersonaName string // Пусто, если режим не был выбран
+	Turns       []Turn
+	ExportedAt  time.Time
+}
+
+// Load читает все обмены текущей ветки сессии и вложения к ним
+func Load(session *db.ChatSessionMeta, personaName string) (*Conversation, error) {
+	transcript, err := db.GetChatSessionTranscript(session.UUID)
+	if err != nil {
+		return nil, err
+	}
+	return load(session, personaName, transcript)
+}
+
+// LoadBranch читает обмены ветки, заканчивающейся обменом leafID, и вложения к ним
+func LoadBranch(session *db.ChatSessionMeta, personaName string, leafID int64) (*Conversation, error) {
+	transcript, err := db.GetDialogueBranchTranscript(session.UUID, leafID)
+	if err != nil {
+		return nil, err
+	}
+	return load(session, personaName, transcript)
+}
+
+func load(session *db.ChatSessionMeta, personaName string, transcript []db.TranscriptTurn) (*Conversation, error) {
+	ids := make([]int64, len(transcript))
+	for i, turn := range transcript {
+		ids[i] = turn.ID
+	}
+	attachments, err := db.GetAttachmentsForDialogues(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := &Conversation{Session: *session, PersonaName: personaName, ExportedAt: time.Now(), Turns: make([]Turn, len(transcript))}
+	for i, turn := range transcript {
+		conv.Turns[i] = Turn{TranscriptTurn: turn, Attachments: attachments[turn.ID]}
+	}
+	return conv, nil
+}
+
+// Filename - имя файла выгрузки: дата создания диалога и заголовок
+func (c *Conversation) Filename(ext string) string {
+	name := c.Session.CreatedAt.In(timeutil.Location).Format("2006-01-02")
+	if title := safeName(c.Session.Title, 60); title != "" {
+		name += " " + title
+	}
+	return name + ext
+}
+
+// safeName убирает из name символы, недопустимые в именах файлов, и ограничивает длину
+func safeName(name string, maxRunes int) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 0x20 {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+	if runes := []rune(name); len(runes) > maxRunes {
+		name = strings.TrimSpace(string(runes[:maxRunes]))
+	}
+	return name
+}
+
+// Write выгружает диалог в формате format (md, json или pdf)
+func Write(w io.Writer, c *Conversation, format string, pdf *PDFRenderer) error {
+	switch format {
+	case "md":
+		return Markdown(w, c)
+	case "json":
+		return JSON(w, c)
+	case "pdf":
+		return pdf.Render(w, c)
+	default:
+		return fmt.Errorf("неизвестный формат выгрузки: %s", format)
+	}
+}
+
+// Markdown выгружает диалог в Markdown
+func Markdown(w io.Writer, c *Conversation) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s\n\n", c.Session.Title)
+	if c.PersonaName != "" {
+		fmt.Fprintf(&sb, "- Режим: %s\n", c.PersonaName)
+	}
+	fmt.Fprintf(&sb, "- Начат: %s\n", formatTime(c.Session.CreatedAt))
+	fmt.Fprintf(&sb, "- Выгружен: %s (время %s)\n\n", formatTime(c.ExportedAt), timeutil.TimezoneName)
+	fmt.Fprintf(&sb, "> %s\n", Disclaimer)
+
+	for _, turn := range c.Turns {
+		fmt.Fprintf(&sb, "\n---\n\n### Вы · %s\n\n%s\n", formatTime(turn.CreatedAt), strings.TrimSpace(turn.UserPrompt))
+		if len(turn.Attachments) > 0 {
+			sb.WriteString("\nВложения:\n")
+			for _, a := range turn.Attachments {
+				fmt.Fprintf(&sb, "- %s (%s, %s)\n", a.OriginalName, a.MIMEType, formatSize(a.Size))
+			}
+		}
+		if turn.AIResponse != "" {
+			fmt.Fprintf(&sb, "\n### Ассистент\n\n%s\n", strings.TrimSpace(turn.AIResponse))
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+type jsonAttachment struct {
+	Name     string `json:"name"`
+	MIMEType string `json:"type"`
+	Size     int64  `json:"size"`
+	Path     string `json:"path,omitempty"` // Путь к файлу внутри архива полной выгрузки
+}
+
+type jsonMessage struct {
+	ID          int64            `json:"id"`
+	CreatedAt   time.Time        `json:"created_at"`
+	User        string           `json:"user"`
+	Assistant   string           `json:"assistant"`
+	Attachments []jsonAttachment `json:"attachments,omitempty"`
+}
+
+type jsonConversation struct {
+	UUID        string        `json:"uuid"`
+	Title       string        `json:"title"`
+	PersonaSlug string        `json:"persona_slug,omitempty"`
+	PersonaName string        `json:"persona_name,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	ArchivedAt  *time.Time    `json:"archived_at,omitempty"`
+	DeletedAt   *time.Time    `json:"deleted_at,omitempty"`
+	ExportedAt  time.Time     `json:"exported_at"`
+	Timezone    string        `json:"timezone"`
+	Messages    []jsonMessage `json:"messages"`
+}
+
+// JSON выгружает диалог в JSON
+func JSON(w io.Writer, c *Conversation) error {
+	return writeJSON(w, c, nil)
+}
+
+// writeJSON выгружает диалог в JSON; attachmentPath возвращает путь вложения в архиве (nil - без путей)
+func writeJSON(w io.Writer, c *Conversation, attachmentPath func(db.MessageAttachment) string) error {
+	s := c.Session
+	out := jsonConversation{
+		UUID:        s.UUID,
+		Title:       s.Title,
+		PersonaSlug: PersonaSlug(s),
+		PersonaName: c.PersonaName,
+		CreatedAt:   s.CreatedAt.In(timeutil.Location),
+		UpdatedAt:   s.UpdatedAt.In(timeutil.Location),
+		ArchivedAt:  inLocation(s.ArchivedAt),
+		DeletedAt:   inLocation(s.DeletedAt),
+		ExportedAt:  c.ExportedAt.In(timeutil.Location),
+		Timezone:    timeutil.TimezoneName,
+		Messages:    make([]jsonMessage, len(c.Turns)),
+	}
+	for i, turn := range c.Turns {
+		msg := jsonMessage{ID: turn.ID, CreatedAt: turn.CreatedAt.In(timeutil.Location), User: turn.UserPrompt, Assistant: turn.AIResponse}
+		for _, a := range turn.Attachments {
+			ja := jsonAttachment{Name: a.OriginalName, MIMEType: a.MIMEType, Size: a.Size}
+			if attachmentPath != nil {
+				ja.Path = attachmentPath(a)
+			}
+			msg.Attachments = append(msg.Attachments, ja)
+		}
+		out.Messages[i] = msg
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// PersonaSlug - персона, выбранная пользователем, или режим, закрепленный автоматически
+func PersonaSlug(s db.ChatSessionMeta) string {
+	if s.PersonaSlug != "" {
+		return s.PersonaSlug
+	}
+	return s.Mode
+}
+
+func inLocation(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	local := t.In(timeutil.Location)
+	return &local
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f МБ", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%d КБ", size>>10)
+	default:
+		return fmt.Sprintf("%d Б", size)
+	}
+}
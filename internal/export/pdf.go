@@ -0,0 +1,141 @@
+// internal/export/pdf.go
+package export
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/timeutil"
+)
+
+// Системные пути DejaVu Sans (Alpine: font-dejavu, Debian/Ubuntu: fonts-dejavu-core)
+var dejaVuPaths = [][2]string{
+	{"/usr/share/fonts/dejavu/DejaVuSans.ttf", "/usr/share/fonts/dejavu/DejaVuSans-Bold.ttf"},
+	{"/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf", "/usr/share/fonts/truetype/dejavu/DejaVuSans-Bold.ttf"},
+}
+
+const pdfFontFamily = "main"
+
+// PDFRenderer строит PDF средствами Go (gofpdf) со шрифтом, загруженным при старте
+type PDFRenderer struct {
+	regular []byte
+	bold    []byte
+}
+
+// NewPDFRenderer загружает шрифты: из конфигурации, иначе DejaVu Sans, иначе встроенный шрифт Go
+func NewPDFRenderer(cfg config.ExportConfig) (*PDFRenderer, error) {
+	if cfg.PDFFontPath != "" {
+		regular, err := os.ReadFile(cfg.PDFFontPath)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать шрифт для PDF: %w", err)
+		}
+		bold := regular
+		if cfg.PDFBoldFontPath != "" {
+			if bold, err = os.ReadFile(cfg.PDFBoldFontPath); err != nil {
+				return nil, fmt.Errorf("не удалось прочитать полужирный шрифт для PDF: %w", err)
+			}
+		}
+		return &PDFRenderer{regular: regular, bold: bold}, nil
+	}
+
+	for _, paths := range dejaVuPaths {
+		regular, err := os.ReadFile(paths[0])
+		if err != nil {
+			continue
+		}
+		bold, err := os.ReadFile(paths[1])
+		if err != nil {
+			bold = regular
+		}
+		return &PDFRenderer{regular: regular, bold: bold}, nil
+	}
+	slog.Warn("Шрифт DejaVu Sans не найден, в PDF используется встроенный шрифт Go: казахские буквы не отобразятся")
+	return &PDFRenderer{regular: goregular.TTF, bold: gobold.TTF}, nil
+}
+
+// Render выгружает диалог в PDF (A4)
+func (p *PDFRenderer) Render(w io.Writer, c *Conversation) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes(pdfFontFamily, "", p.regular)
+	pdf.AddUTF8FontFromBytes(pdfFontFamily, "B", p.bold)
+	pdf.SetTitle(c.Session.Title, true)
+	pdf.SetCreator("Shaman AI", true)
+	pdf.SetMargins(18, 18, 18)
+	pdf.SetAutoPageBreak(true, 18)
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-13)
+		pdf.SetFont(pdfFontFamily, "", 8)
+		pdf.SetTextColor(128, 128, 128)
+		pdf.CellFormat(0, 5, fmt.Sprintf("%s · стр. %d", c.Session.Title, pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	pdf.SetFont(pdfFontFamily, "B", 16)
+	pdf.MultiCell(0, 8, c.Session.Title, "", "L", false)
+	pdf.Ln(2)
+
+	pdf.SetFont(pdfFontFamily, "", 9)
+	pdf.SetTextColor(96, 96, 96)
+	var meta []string
+	if c.PersonaName != "" {
+		meta = append(meta, "Режим: "+c.PersonaName)
+	}
+	meta = append(meta,
+		"Начат: "+formatTime(c.Session.CreatedAt),
+		fmt.Sprintf("Выгружен: %s (время %s)", formatTime(c.ExportedAt), timeutil.TimezoneName),
+		Disclaimer)
+	for _, line := range meta {
+		pdf.MultiCell(0, 5, line, "", "L", false)
+	}
+
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	for _, turn := range c.Turns {
+		pdf.Ln(4)
+		pdf.SetDrawColor(210, 210, 210)
+		pdf.Line(left, pdf.GetY(), pageWidth-right, pdf.GetY())
+		pdf.Ln(3)
+
+		pdf.SetFont(pdfFontFamily, "B", 10)
+		pdf.SetTextColor(40, 40, 40)
+		pdf.MultiCell(0, 6, "Вы · "+formatTime(turn.CreatedAt), "", "L", false)
+		pdf.SetFont(pdfFontFamily, "", 10)
+		pdf.MultiCell(0, 5, pdfText(turn.UserPrompt), "", "L", false)
+		if len(turn.Attachments) > 0 {
+			pdf.SetFont(pdfFontFamily, "", 9)
+			pdf.SetTextColor(96, 96, 96)
+			for _, a := range turn.Attachments {
+				pdf.MultiCell(0, 5, fmt.Sprintf("Вложение: %s (%s, %s)", a.OriginalName, a.MIMEType, formatSize(a.Size)), "", "L", false)
+			}
+		}
+
+		if turn.AIResponse != "" {
+			pdf.Ln(2)
+			pdf.SetFont(pdfFontFamily, "B", 10)
+			pdf.SetTextColor(20, 80, 140)
+			pdf.MultiCell(0, 6, "Ассистент", "", "L", false)
+			pdf.SetFont(pdfFontFamily, "", 10)
+			pdf.SetTextColor(40, 40, 40)
+			pdf.MultiCell(0, 5, pdfText(turn.AIResponse), "", "L", false)
+		}
+	}
+
+	if err := pdf.Error(); err != nil {
+		return fmt.Errorf("ошибка формирования PDF: %w", err)
+	}
+	return pdf.Output(w)
+}
+
+// pdfText готовит текст для MultiCell: табуляции и переводы строк Windows шрифт не отображает
+func pdfText(text string) string {
+	text = strings.ReplaceAll(strings.TrimSpace(text), "\r\n", "\n")
+	return strings.ReplaceAll(text, "\t", "    ")
+}
@@ -0,0 +1,201 @@
+// internal/guardrails/guardrails.go
+//
+// Package guardrails проверяет сообщения пользователя до запроса к LLM и ответы модели после него
+// на признаки неотложных состояний (боль в груди, суицидальные мысли, анафилаксия и т.п.)
+// и опасные советы (отказ от медицинской помощи). Проверка - набор правил по ключевым словам
+// и, если включено, запрос к LLM-модератору. Сработавшая проверка добавляет к ответу предупреждение
+// с номерами экстренных служб Казахстана или скрывает ответ целиком.
+package guardrails
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/llm"
+)
+
+// Stage - когда выполняется проверка
+type Stage string
+
+const (
+	StageInput  Stage = "input"  // Сообщение пользователя до запроса к LLM
+	StageOutput Stage = "output" // Ответ модели до отправки пользователю
+)
+
+// Action - что делать, если проверка сработала
+type Action string
+
+const (
+	ActionNotice Action = "notice" // Добавить к ответу предупреждение с номерами экстренных служб
+	ActionBlock  Action = "block"  // Не показывать ответ, вместо него - предупреждение
+)
+
+// stricter возвращает более строгое из двух действий (пустое - ничего не делать)
+func stricter(a, b Action) Action {
+	if a == ActionBlock || b == ActionBlock {
+		return ActionBlock
+	}
+	if a == ActionNotice || b == ActionNotice {
+		return ActionNotice
+	}
+	return ""
+}
+
+// Источники решения
+const (
+	SourceRule = "rule"
+	SourceLLM  = "llm"
+)
+
+// Verdict - результат проверки. Пустое Action - ничего не найдено.
+type Verdict struct {
+	Stage      Stage
+	Action     Action
+	Categories []Category
+	Rules      []string // Сработавшие правила; "llm" - решение модератора
+	Sources    []string
+}
+
+func (v Verdict) Triggered() bool {
+	return v.Action != ""
+}
+
+func (v *Verdict) add(action Action, category Category, rule, source string) {
+	v.Action = stricter(v.Action, action)
+	if !containsCategory(v.Categories, category) {
+		v.Categories = append(v.Categories, category)
+	}
+	if !containsString(v.Rules, rule) {
+		v.Rules = append(v.Rules, rule)
+	}
+	if !containsString(v.Sources, source) {
+		v.Sources = append(v.Sources, source)
+	}
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Guard выполняет проверки для режимов диалога из конфигурации
+type Guard struct {
+	cfg       config.GuardrailsConfig
+	rules     []Rule
+	personas  map[string]bool
+	allModes  bool
+	moderator *moderator // nil - только правила
+}
+
+// New собирает проверки по конфигурации: встроенные правила, дополненные файлом rules_path,
+// и LLM-модератор, если он включен
+func New(cfg config.GuardrailsConfig, llmClient llm.Client, llmCfg config.RemoteLLMConfig) (*Guard, error) {
+	g := &Guard{cfg: cfg, personas: make(map[string]bool)}
+	for _, slug := range cfg.Personas {
+		if slug == "*" {
+			g.allModes = true
+		}
+		g.personas[slug] = true
+	}
+
+	rules := defaultRules()
+	if cfg.RulesPath != "" {
+		custom, err := LoadRules(cfg.RulesPath)
+		if err != nil {
+			return nil, err
+		}
+		rules = mergeRules(rules, custom)
+	}
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return nil, err
+		}
+		if !rules[i].Disabled {
+			g.rules = append(g.rules, rules[i])
+		}
+	}
+
+	if cfg.Moderation.Enabled {
+		g.moderator = newModerator(llmClient, llmCfg, cfg.Moderation)
+	}
+	slog.Info("Проверки безопасности инициализированы", "disabled", cfg.Disabled, "personas", cfg.Personas, "rules", len(g.rules),
+		"rules_path", cfg.RulesPath, "moderation", cfg.Moderation.Enabled)
+	return g, nil
+}
+
+// Applies сообщает, проверяются ли сообщения в этом режиме диалога
+func (g *Guard) Applies(personaSlug string) bool {
+	if g == nil || g.cfg.Disabled {
+		return false
+	}
+	return g.allModes || g.personas[personaSlug]
+}
+
+// CheckInput проверяет сообщение пользователя до запроса к LLM
+func (g *Guard) CheckInput(ctx context.Context, prompt string) Verdict {
+	return g.check(ctx, StageInput, prompt, "")
+}
+
+// CheckOutput проверяет ответ модели на сообщение prompt
+func (g *Guard) CheckOutput(ctx context.Context, prompt, response string) Verdict {
+	return g.check(ctx, StageOutput, prompt, response)
+}
+
+func (g *Guard) check(ctx context.Context, stage Stage, prompt, response string) Verdict {
+	verdict := Verdict{Stage: stage}
+	text := prompt
+	if stage == StageOutput {
+		text = response
+	}
+	tokens := tokenize(text)
+	for _, rule := range g.rules {
+		if rule.appliesTo(stage) && rule.matches(tokens) {
+			verdict.add(rule.action(), rule.Category, rule.Name, SourceRule)
+		}
+	}
+
+	// Если ответ и так скрывается, модератор ничего не изменит
+	if g.moderator != nil && verdict.Action != ActionBlock {
+		categories, err := g.moderator.classify(ctx, stage, prompt, response)
+		if err != nil {
+			// Модератор недоступен - остаются только правила
+			slog.Warn("LLM-модератор не дал решения, используются только правила", "stage", stage, "error", err)
+		}
+		for _, category := range categories {
+			verdict.add(defaultAction(stage, category), category, SourceLLM, SourceLLM)
+		}
+	}
+	return verdict
+}
+
+// SystemInstruction - дополнение к системному промпту, если в сообщении пользователя
+// найдены признаки неотложного состояния. Персона не должна подменять медицинскую помощь.
+func SystemInstruction(v Verdict) string {
+	if v.Stage != StageInput || !v.Triggered() {
+		return ""
+	}
+	names := make([]string, 0, len(v.Categories))
+	for _, c := range v.Categories {
+		names = append(names, categoryInfo(c).description)
+	}
+	return fmt.Sprintf("\n\nВАЖНО: в сообщении пользователя есть признаки возможного неотложного состояния (%s). "+
+		"Не предлагай психосоматических, эмоциональных или альтернативных объяснений вместо медицинской помощи. "+
+		"В первую очередь прямо и спокойно посоветуй немедленно обратиться за экстренной помощью: в Казахстане 112 или 103.",
+		strings.Join(names, "; "))
+}
+
+// Excerpt обрезает текст для журнала проверок
+func Excerpt(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "…"
+}
@@ -0,0 +1,102 @@
+// internal/guardrails/moderation.go
+package guardrails
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/llm"
+)
+
+// Сколько символов сообщения и ответа передается модератору
+const moderationMaxRunes = 2000
+
+// moderator - дешевый запрос к LLM, который дополняет правила: находит риски, описанные другими словами
+type moderator struct {
+	client  llm.Client
+	llmCfg  config.RemoteLLMConfig
+	timeout time.Duration
+}
+
+func newModerator(client llm.Client, llmCfg config.RemoteLLMConfig, cfg config.GuardrailModerationConfig) *moderator {
+	if cfg.Model != "" {
+		llmCfg.ModelSpec = cfg.Model
+	}
+	// Ответ короткий, а разброс не нужен
+	llmCfg.MaxTokens = 60
+	llmCfg.Temperature = 0
+	llmCfg.Images = nil
+	return &moderator{client: client, llmCfg: llmCfg, timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}
+}
+
+// moderationSystemPrompt перечисляет категории, которые модератор может вернуть на этом этапе
+func moderationSystemPrompt(stage Stage) string {
+	keys := make([]string, 0, len(categories))
+	for c := range categories {
+		if c == CategoryMedicalRefusal && stage == StageInput {
+			continue
+		}
+		keys = append(keys, string(c))
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("Ты модератор безопасности сервиса, который отвечает на вопросы о самочувствии. ")
+	if stage == StageInput {
+		b.WriteString("Определи, описывает ли сообщение пользователя неотложное состояние, при котором нужна экстренная помощь прямо сейчас. ")
+	} else {
+		b.WriteString("Определи, есть ли в ответе ассистента опасные рекомендации или описание неотложного состояния, требующего экстренной помощи. ")
+	}
+	b.WriteString("Общие жалобы, хронические состояния и вопросы из любопытства неотложными не считаются. Категории:\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%q - %s.\n", key, categories[Category(key)].description)
+	}
+	b.WriteString("Текст может быть на русском, казахском или английском.\n")
+	b.WriteString(`Ответь только JSON без пояснений: {"categories": ["<категория>", ...]}, пустой список - рисков нет.`)
+	return b.String()
+}
+
+type moderationResult struct {
+	Categories []string `json:"categories"`
+}
+
+func (m *moderator) classify(ctx context.Context, stage Stage, prompt, response string) ([]Category, error) {
+	text := "Сообщение пользователя:\n" + Excerpt(prompt, moderationMaxRunes)
+	if stage == StageOutput {
+		text += "\n\nОтвет ассистента:\n" + Excerpt(response, moderationMaxRunes)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	answer, _, err := m.client.GenerateRemoteResponse(ctx, m.llmCfg, moderationSystemPrompt(stage), nil, text)
+	if err != nil {
+		return nil, fmt.Errorf("модератор недоступен: %w", err)
+	}
+
+	start := strings.Index(answer, "{")
+	end := strings.LastIndex(answer, "}")
+	if start < 0 || end <= start {
+		return nil, fmt.Errorf("ответ модератора не содержит JSON: %q", Excerpt(answer, 100))
+	}
+	var result moderationResult
+	if err := json.Unmarshal([]byte(answer[start:end+1]), &result); err != nil {
+		return nil, fmt.Errorf("некорректный JSON модератора: %w", err)
+	}
+
+	var found []Category
+	for _, label := range result.Categories {
+		c := Category(strings.ToLower(strings.TrimSpace(label)))
+		if _, ok := categories[c]; !ok || (c == CategoryMedicalRefusal && stage == StageInput) {
+			continue // Неизвестные метки игнорируем
+		}
+		if !containsCategory(found, c) {
+			found = append(found, c)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,174 @@
+// internal/guardrails/notice.go
+package guardrails
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Category - вид риска
+type Category string
+
+const (
+	CategoryCardiac        Category = "cardiac"         // Боль в груди, признаки инфаркта
+	CategoryStroke         Category = "stroke"          // Признаки инсульта
+	CategoryAnaphylaxis    Category = "anaphylaxis"     // Тяжелая аллергическая реакция
+	CategoryBreathing      Category = "breathing"       // Затрудненное дыхание
+	CategoryUnconscious    Category = "unconscious"     // Потеря сознания, судороги
+	CategoryBleeding       Category = "bleeding"        // Сильное кровотечение
+	CategoryOverdose       Category = "overdose"        // Отравление, передозировка
+	CategorySuicide        Category = "suicide"         // Суицидальные мысли, самоповреждение
+	CategoryViolence       Category = "violence"        // Насилие, угроза жизни
+	CategoryHazard         Category = "hazard"          // Утечка газа, угарный газ
+	CategoryMedicalRefusal Category = "medical_refusal" // Совет отказаться от медицинской помощи (в ответе модели)
+)
+
+// Экстренные номера Казахстана
+const (
+	numberUnified   = "112" // Единая служба спасения
+	numberFire      = "101"
+	numberPolice    = "102"
+	numberAmbulance = "103"
+	numberHelpline  = "150" // Телефон доверия
+)
+
+type categoryMeta struct {
+	description string   // Для модератора и журнала
+	numbers     []string // Номера в предупреждении, по порядку
+}
+
+var categories = map[Category]categoryMeta{
+	CategoryCardiac:        {"боль в груди или признаки инфаркта", []string{numberAmbulance, numberUnified}},
+	CategoryStroke:         {"признаки инсульта: онемение, перекос лица, нарушение речи", []string{numberAmbulance, numberUnified}},
+	CategoryAnaphylaxis:    {"тяжелая аллергическая реакция, отек горла или языка", []string{numberAmbulance, numberUnified}},
+	CategoryBreathing:      {"затрудненное дыхание, удушье", []string{numberAmbulance, numberUnified}},
+	CategoryUnconscious:    {"потеря сознания или судороги", []string{numberAmbulance, numberUnified}},
+	CategoryBleeding:       {"сильное кровотечение, кровь в рвоте или при кашле", []string{numberAmbulance, numberUnified}},
+	CategoryOverdose:       {"отравление или передозировка", []string{numberAmbulance, numberUnified}},
+	CategorySuicide:        {"суицидальные мысли или самоповреждение", []string{numberHelpline, numberAmbulance, numberUnified}},
+	CategoryViolence:       {"насилие или угроза жизни", []string{numberPolice, numberUnified}},
+	CategoryHazard:         {"утечка газа, угарный газ, пожар", []string{numberFire, numberUnified}},
+	CategoryMedicalRefusal: {"совет отказаться от медицинской помощи или лекарств", []string{numberAmbulance, numberUnified}},
+}
+
+func categoryInfo(c Category) categoryMeta {
+	if meta, ok := categories[c]; ok {
+		return meta
+	}
+	return categoryMeta{description: string(c), numbers: []string{numberUnified}}
+}
+
+func containsCategory(list []Category, value Category) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultAction - действие для категории, найденной модератором
+func defaultAction(stage Stage, c Category) Action {
+	if stage == StageOutput && c == CategoryMedicalRefusal {
+		return ActionBlock
+	}
+	return ActionNotice
+}
+
+// Язык предупреждения
+const (
+	langRU = "ru"
+	langKK = "kk"
+	langEN = "en"
+)
+
+type noticeText struct {
+	header  string
+	blocked string
+	numbers map[string]string
+}
+
+var notices = map[string]noticeText{
+	langRU: {
+		header:  "⚠️ То, что вы описываете, может быть опасно для жизни. Не откладывайте - обратитесь за экстренной помощью:",
+		blocked: "Ответ скрыт системой безопасности: он мог содержать небезопасные рекомендации. Пожалуйста, обратитесь к врачу.",
+		numbers: map[string]string{
+			numberUnified:   "единая служба спасения",
+			numberAmbulance: "скорая медицинская помощь",
+			numberFire:      "противопожарная служба",
+			numberPolice:    "полиция",
+			numberHelpline:  "телефон доверия, бесплатно и круглосуточно",
+		},
+	},
+	langKK: {
+		header:  "⚠️ Сіз сипаттаған жағдай өмірге қауіпті болуы мүмкін. Кешіктірмей жедел көмекке жүгініңіз:",
+		blocked: "Жауап қауіпсіздік жүйесімен жасырылды: онда қауіпті кеңестер болуы мүмкін. Дәрігерге жүгініңіз.",
+		numbers: map[string]string{
+			numberUnified:   "бірыңғай құтқару қызметі",
+			numberAmbulance: "жедел медициналық жәрдем",
+			numberFire:      "өрт сөндіру қызметі",
+			numberPolice:    "полиция",
+			numberHelpline:  "сенім телефоны, тегін және тәулік бойы",
+		},
+	},
+	langEN: {
+		header:  "⚠️ What you describe may be life-threatening. Please don't wait - get emergency help now (Kazakhstan):",
+		blocked: "This answer was hidden by the safety system because it may have contained unsafe advice. Please see a doctor.",
+		numbers: map[string]string{
+			numberUnified:   "unified emergency line",
+			numberAmbulance: "ambulance",
+			numberFire:      "fire service",
+			numberPolice:    "police",
+			numberHelpline:  "helpline, free and 24/7",
+		},
+	},
+}
+
+// detectLanguage определяет язык текста по буквам: казахские буквы - казахский,
+// преобладает латиница - английский, иначе русский
+func detectLanguage(text string) string {
+	var cyrillic, latin int
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune("әғқңөұүһіӘҒҚҢӨҰҮҺІ", r):
+			return langKK
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	if latin > cyrillic {
+		return langEN
+	}
+	return langRU
+}
+
+// Notice - предупреждение с номерами экстренных служб для найденных категорий на языке сообщения
+// пользователя. blocked - ответ модели скрыт, об этом сообщается в начале.
+func Notice(found []Category, blocked bool, userText string) string {
+	text := notices[detectLanguage(userText)]
+	var numbers []string
+	for _, c := range found {
+		for _, n := range categoryInfo(c).numbers {
+			if !containsString(numbers, n) {
+				numbers = append(numbers, n)
+			}
+		}
+	}
+	if !containsString(numbers, numberUnified) {
+		numbers = append(numbers, numberUnified)
+	}
+
+	var b strings.Builder
+	if blocked {
+		b.WriteString(text.blocked)
+		b.WriteString("\n\n")
+	}
+	b.WriteString(text.header)
+	for _, n := range numbers {
+		fmt.Fprintf(&b, "\n%s - %s", n, text.numbers[n])
+	}
+	return b.String()
+}
@@ -0,0 +1,223 @@
+// internal/guardrails/rules.go
+package guardrails
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule - правило проверки: сработало, если в тексте есть хотя бы одна из фраз. Совпадение ищется
+// по целым словам; "*" в конце означает любое окончание ("инфаркт*" - инфаркт, инфаркта).
+type Rule struct {
+	Name     string   `yaml:"name"`
+	Category Category `yaml:"category"`
+	Stages   []Stage  `yaml:"stages"` // Пусто - только сообщение пользователя
+	Action   Action   `yaml:"action"` // Пусто - предупреждение
+	Keywords []string `yaml:"keywords"`
+	Disabled bool     `yaml:"disabled"` // Отключает встроенное правило с тем же именем
+
+	phrases []phrase
+}
+
+// rulesFile - формат файла rules_path
+type rulesFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// LoadRules читает правила из YAML-файла
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать правила проверок безопасности: %w", err)
+	}
+	var file rulesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("некорректный файл правил проверок безопасности %s: %w", path, err)
+	}
+	return file.Rules, nil
+}
+
+// mergeRules дополняет встроенные правила своими; правило с тем же именем заменяет встроенное
+func mergeRules(base, custom []Rule) []Rule {
+	index := make(map[string]int, len(base))
+	for i, rule := range base {
+		index[rule.Name] = i
+	}
+	for _, rule := range custom {
+		if i, ok := index[rule.Name]; ok {
+			if rule.Disabled && len(rule.Keywords) == 0 {
+				base[i].Disabled = true
+				continue
+			}
+			base[i] = rule
+			continue
+		}
+		index[rule.Name] = len(base)
+		base = append(base, rule)
+	}
+	return base
+}
+
+func (r *Rule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("правило проверки безопасности без имени")
+	}
+	if _, ok := categories[r.Category]; !ok {
+		return fmt.Errorf("правило %q: неизвестная категория %q", r.Name, r.Category)
+	}
+	if r.Action != "" && r.Action != ActionNotice && r.Action != ActionBlock {
+		return fmt.Errorf("правило %q: неизвестное действие %q", r.Name, r.Action)
+	}
+	for _, stage := range r.Stages {
+		if stage != StageInput && stage != StageOutput {
+			return fmt.Errorf("правило %q: неизвестный этап %q", r.Name, stage)
+		}
+	}
+	r.phrases = compilePhrases(r.Keywords)
+	if len(r.phrases) == 0 && !r.Disabled {
+		return fmt.Errorf("правило %q: не заданы ключевые слова", r.Name)
+	}
+	return nil
+}
+
+func (r *Rule) appliesTo(stage Stage) bool {
+	if len(r.Stages) == 0 {
+		return stage == StageInput
+	}
+	for _, s := range r.Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) action() Action {
+	if r.Action == "" {
+		return ActionNotice
+	}
+	return r.Action
+}
+
+func (r *Rule) matches(tokens []string) bool {
+	for _, p := range r.phrases {
+		for i := range tokens {
+			if p.matchAt(tokens, i) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// phrase - последовательность слов; prefix - последнее слово может иметь любое окончание
+type phrase struct {
+	words  []string
+	prefix bool
+}
+
+func compilePhrases(list []string) []phrase {
+	phrases := make([]phrase, 0, len(list))
+	for _, item := range list {
+		var p phrase
+		item = strings.ToLower(item)
+		if strings.HasSuffix(item, "*") {
+			p.prefix = true
+			item = strings.TrimSuffix(item, "*")
+		}
+		p.words = tokenize(item)
+		if len(p.words) > 0 {
+			phrases = append(phrases, p)
+		}
+	}
+	return phrases
+}
+
+// tokenize разбивает текст на слова в нижнем регистре; "ё" приравнивается к "е"
+func tokenize(text string) []string {
+	text = strings.ReplaceAll(strings.ToLower(text), "ё", "е")
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (p phrase) matchAt(tokens []string, i int) bool {
+	if i+len(p.words) > len(tokens) {
+		return false
+	}
+	last := len(p.words) - 1
+	for j, word := range p.words {
+		token := tokens[i+j]
+		if j == last && p.prefix {
+			if !strings.HasPrefix(token, word) {
+				return false
+			}
+		} else if token != word {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultRules - встроенные правила на русском, казахском и английском. Отрицания ("нет боли в груди")
+// не распознаются: лишнее предупреждение безопаснее пропущенного.
+func defaultRules() []Rule {
+	return []Rule{
+		{Name: "chest_pain", Category: CategoryCardiac, Keywords: []string{
+			"боль в груди", "боли в груди", "болит в груди", "болит грудь", "давит в груди", "давит грудь", "жжет в груди",
+			"сдавливает грудь", "колет в сердце", "болит сердце", "инфаркт*", "сердечный приступ",
+			"кеудем ауырады", "кеуде ауруы", "жүрегім ауырады", "жүрек талмасы",
+			"chest pain", "chest pains", "chest tightness", "heart attack",
+		}},
+		{Name: "stroke", Category: CategoryStroke, Keywords: []string{
+			"инсульт*", "перекосило лицо", "лицо перекосило", "отнялась рука", "отнялась нога", "онемела половина",
+			"невнятная речь", "stroke", "face drooping", "slurred speech",
+		}},
+		{Name: "anaphylaxis", Category: CategoryAnaphylaxis, Keywords: []string{
+			"анафилакси*", "отек квинке", "отек горла", "отекло горло", "отекает горло", "опухло горло", "опух язык",
+			"тамағым ісіп", "anaphyla*", "throat swelling", "throat is swelling", "swollen tongue",
+		}},
+		{Name: "breathing", Category: CategoryBreathing, Keywords: []string{
+			"не могу дышать", "нечем дышать", "задыхаюсь", "задыхается", "посинели губы", "синеют губы",
+			"тыныс ала алмаймын", "демім жетпейді", "can't breathe", "cannot breathe", "struggling to breathe",
+		}},
+		{Name: "unconscious", Category: CategoryUnconscious, Keywords: []string{
+			"потерял сознание", "потеряла сознание", "без сознания", "судороги не проходят",
+			"есінен танды", "passed out", "unconscious", "seizure*",
+		}},
+		{Name: "bleeding", Category: CategoryBleeding, Keywords: []string{
+			"сильное кровотечение", "кровотечение не останавливается", "кровь не останавливается", "рвота кровью",
+			"кашляю кровью", "қан тоқтамай", "heavy bleeding", "bleeding heavily", "vomiting blood", "coughing up blood",
+		}},
+		{Name: "overdose", Category: CategoryOverdose, Keywords: []string{
+			"передозировк*", "отравился", "отравилась", "наглотался", "наглоталась", "выпил много таблеток",
+			"выпила много таблеток", "уландым", "улану", "overdose*", "poisoned",
+		}},
+		{Name: "suicide", Category: CategorySuicide, Keywords: []string{
+			"суицид*", "самоубийств*", "покончить с собой", "покончу с собой", "убить себя", "убью себя",
+			"не хочу жить", "хочу умереть", "свести счеты с жизнью", "порезать вены", "режу себя",
+			"өзімді өлтіргім", "өмір сүргім келмейді", "өлгім келеді",
+			"suicid*", "kill myself", "want to die", "end my life", "self harm", "hurt myself",
+		}},
+		{Name: "violence", Category: CategoryViolence, Keywords: []string{
+			"меня избивают", "меня бьет", "бьет меня", "угрожает убить", "домашнее насилие",
+			"мені ұрады", "domestic violence", "threatening to kill",
+		}},
+		{Name: "hazard", Category: CategoryHazard, Keywords: []string{
+			"утечка газа", "пахнет газом", "угарный газ", "угарным газом", "газ иісі", "gas leak", "carbon monoxide",
+		}},
+		{Name: "medical_refusal", Category: CategoryMedicalRefusal, Stages: []Stage{StageOutput}, Action: ActionBlock, Keywords: []string{
+			"не обращайтесь к врачу", "не нужно обращаться к врачу", "не ходите к врачу", "не вызывайте скорую",
+			"откажитесь от лечения", "откажитесь от лекарств", "откажитесь от химиотерапии", "откажитесь от инсулина",
+			"прекратите принимать лекарства", "перестаньте принимать лекарства", "не принимайте лекарства",
+			"лекарства вам не нужны", "врачи вам не помогут",
+			"дәрігерге барудың қажеті жоқ", "дәрі ішуді тоқтатыңыз",
+			"don't see a doctor", "do not see a doctor", "no need to see a doctor", "stop taking your medication",
+			"don't call an ambulance", "do not call an ambulance",
+		}},
+	}
+}
@@ -0,0 +1,180 @@
+// internal/handlers/admin/admin_feedback.go
+package adminhandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/handlers"
+	"shaman-ai.kz/internal/timeutil"
+)
+
+const feedbackPerPage = 20
+
+// parseFeedbackFilter разбирает параметры очереди отзывов: rating, persona, model,
+// from и to (ГГГГ-ММ-ДД по Алматы, to включительно), status. Без rating показываются только плохие оценки.
+func parseFeedbackFilter(query url.Values) db.FeedbackFilter {
+	filter := db.FeedbackFilter{
+		Rating:      db.FeedbackRatingDown,
+		PersonaSlug: query.Get("persona"),
+		Model:       query.Get("model"),
+		Status:      query.Get("status"),
+	}
+	switch query.Get("rating") {
+	case "up":
+		filter.Rating = db.FeedbackRatingUp
+	case "all":
+		filter.Rating = 0
+	}
+	if from, err := time.ParseInLocation("2006-01-02", query.Get("from"), timeutil.Location); err == nil {
+		filter.From = &from
+	}
+	if to, err := time.ParseInLocation("2006-01-02", query.Get("to"), timeutil.Location); err == nil {
+		to = to.AddDate(0, 0, 1)
+		filter.To = &to
+	}
+	return filter
+}
+
+// AdminFeedbackPageHandler показывает очередь отзывов на ответы с фильтрами по режиму, модели и дате
+func AdminFeedbackPageHandler(app *handlers.AppHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := app.NewPageData(r)
+		data.AdminPageTitle = "Отзывы на ответы"
+
+		query := r.URL.Query()
+		filter := parseFeedbackFilter(query)
+		page, _ := strconv.Atoi(query.Get("page"))
+		if page < 1 {
+			page = 1
+		}
+
+		items, total, err := db.GetFeedbackQueue(filter, feedbackPerPage, (page-1)*feedbackPerPage)
+		if err != nil {
+			slog.Error("AdminFeedbackPageHandler: не удалось получить отзывы", "error", err)
+			http.Error(w, "Ошибка сервера при загрузке отзывов", http.StatusInternalServerError)
+			return
+		}
+		if data.Personas, err = db.GetAllPersonas(); err != nil {
+			slog.Error("AdminFeedbackPageHandler: не удалось получить персоны", "error", err)
+		}
+		if data.FeedbackModels, err = db.GetFeedbackModels(); err != nil {
+			slog.Error("AdminFeedbackPageHandler: не удалось получить модели", "error", err)
+		}
+
+		data.FeedbackItems = items
+		data.FeedbackReasons = db.FeedbackReasons
+		data.FormValues = query
+		data.CurrentPage = page
+		data.Limit = feedbackPerPage
+		data.TotalPages = int(math.Ceil(float64(total) / float64(feedbackPerPage)))
+
+		app.RenderAdminPage(w, r, "feedback_queue.html", data)
+	}
+}
+
+// AdminFeedbackReviewHandler отмечает отзыв разобранным или возвращает его в очередь (reviewed=0)
+func AdminFeedbackReviewHandler(app *handlers.AppHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		redirectURL := "/admin/feedback"
+		if back := r.FormValue("back"); back != "" {
+			redirectURL += "?" + back
+		}
+		id, err := strconv.ParseInt(r.FormValue("feedback_id"), 10, 64)
+		if err != nil || id == 0 {
+			app.SessionManager.Put(r.Context(), "flash_error", "Неверный ID отзыва.")
+			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+			return
+		}
+		reviewed := r.FormValue("reviewed") != "0"
+		if err := db.SetFeedbackReviewed(id, adminUserID(r), reviewed); err != nil {
+			app.SessionManager.Put(r.Context(), "flash_error", "Не удалось изменить статус отзыва.")
+			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+			return
+		}
+		slog.Info("Статус отзыва изменен администратором", "feedback_id", id, "reviewed", reviewed, "user_id", adminUserID(r))
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	}
+}
+
+// feedbackExportTurn - обмен диалога в выгрузке отзывов
+type feedbackExportTurn struct {
+	DialogueID int64  `json:"dialogue_id"`
+	User       string `json:"user"`
+	Assistant  string `json:"assistant"`
+}
+
+// feedbackExportRecord - строка выгрузки: отзыв и диалог до оцененного ответа включительно
+type feedbackExportRecord struct {
+	FeedbackID   int64                `json:"feedback_id"`
+	DialogueID   int64                `json:"dialogue_id"`
+	SessionUUID  string               `json:"session_uuid"`
+	Rating       int                  `json:"rating"`
+	Reason       string               `json:"reason,omitempty"`
+	Comment      string               `json:"comment,omitempty"`
+	Persona      string               `json:"persona,omitempty"`
+	Model        string               `json:"model,omitempty"`
+	CreatedAt    time.Time            `json:"created_at"`
+	Reviewed     bool                 `json:"reviewed"`
+	Conversation []feedbackExportTurn `json:"conversation"`
+}
+
+// AdminFeedbackExportHandler выгружает отзывы с диалогами в JSON Lines для настройки промптов.
+// Фильтры те же, что у очереди; по умолчанию - только плохие оценки. Email пользователей в выгрузку не попадает.
+func AdminFeedbackExportHandler(app *handlers.AppHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		filter := parseFeedbackFilter(r.URL.Query())
+
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="feedback-%s.jsonl"`, time.Now().In(timeutil.Location).Format("2006-01-02")))
+		w.Header().Set("Cache-Control", "no-store")
+
+		enc := json.NewEncoder(w)
+		count := 0
+		err := db.EachFeedbackItem(filter, func(item *db.FeedbackItem) error {
+			transcript, err := db.GetDialogueBranchTranscript(item.ChatSessionUUID, item.DialogueID)
+			if err != nil {
+				return err
+			}
+			record := feedbackExportRecord{
+				FeedbackID:   item.ID,
+				DialogueID:   item.DialogueID,
+				SessionUUID:  item.ChatSessionUUID,
+				Rating:       item.Rating,
+				Reason:       item.Reason,
+				Comment:      item.Comment,
+				Persona:      item.PersonaSlug,
+				Model:        item.Model,
+				CreatedAt:    item.CreatedAt,
+				Reviewed:     item.ReviewedAt != nil,
+				Conversation: make([]feedbackExportTurn, 0, len(transcript)),
+			}
+			for _, turn := range transcript {
+				record.Conversation = append(record.Conversation, feedbackExportTurn{DialogueID: turn.ID, User: turn.UserPrompt, Assistant: turn.AIResponse})
+			}
+			count++
+			return enc.Encode(record)
+		})
+		if err != nil {
+			// Заголовки уже отправлены: выгрузка оборвется, ошибка остается в логе
+			slog.Error("AdminFeedbackExportHandler: ошибка выгрузки отзывов", "exported", count, "error", err)
+			return
+		}
+		slog.Info("Отзывы выгружены администратором", "count", count, "user_id", adminUserID(r))
+	}
+}
@@ -0,0 +1,58 @@
+// internal/handlers/admin/admin_guardrails.go
+package adminhandlers
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/handlers"
+	"shaman-ai.kz/internal/timeutil"
+)
+
+const guardrailEventsPerPage = 30
+
+// AdminGuardrailEventsPageHandler показывает журнал сработавших проверок безопасности.
+// Фильтры: stage, action, category, from и to (ГГГГ-ММ-ДД по Алматы, to включительно).
+func AdminGuardrailEventsPageHandler(app *handlers.AppHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := app.NewPageData(r)
+		data.AdminPageTitle = "Проверки безопасности"
+
+		query := r.URL.Query()
+		filter := db.GuardrailEventFilter{
+			Stage:    query.Get("stage"),
+			Action:   query.Get("action"),
+			Category: query.Get("category"),
+		}
+		if from, err := time.ParseInLocation("2006-01-02", query.Get("from"), timeutil.Location); err == nil {
+			filter.From = &from
+		}
+		if to, err := time.ParseInLocation("2006-01-02", query.Get("to"), timeutil.Location); err == nil {
+			to = to.AddDate(0, 0, 1)
+			filter.To = &to
+		}
+		page, _ := strconv.Atoi(query.Get("page"))
+		if page < 1 {
+			page = 1
+		}
+
+		events, total, err := db.GetGuardrailEvents(filter, guardrailEventsPerPage, (page-1)*guardrailEventsPerPage)
+		if err != nil {
+			slog.Error("AdminGuardrailEventsPageHandler: не удалось получить журнал проверок", "error", err)
+			http.Error(w, "Ошибка сервера при загрузке журнала проверок", http.StatusInternalServerError)
+			return
+		}
+
+		data.GuardrailEvents = events
+		data.FormValues = query
+		data.CurrentPage = page
+		data.Limit = guardrailEventsPerPage
+		data.TotalPages = int(math.Ceil(float64(total) / float64(guardrailEventsPerPage)))
+
+		app.RenderAdminPage(w, r, "guardrail_events.html", data)
+	}
+}
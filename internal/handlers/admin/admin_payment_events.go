@@ -0,0 +1,91 @@
+// internal/handlers/admin/admin_payment_events.go
+package adminhandlers
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"shaman-ai.kz/internal/billing"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/handlers"
+)
+
+const paymentEventsPerPage = 30
+
+// AdminPaymentEventsPageHandler показывает очередь уведомлений платежных шлюзов. Без фильтра
+// открывается dead-letter; фильтры: status (all - все), gateway, order_id.
+func AdminPaymentEventsPageHandler(app *handlers.AppHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := app.NewPageData(r)
+		data.AdminPageTitle = "Уведомления оплаты"
+
+		query := r.URL.Query()
+		filter := db.PaymentEventFilter{
+			Status:  query.Get("status"),
+			Gateway: query.Get("gateway"),
+			OrderID: query.Get("order_id"),
+		}
+		switch filter.Status {
+		case "":
+			filter.Status = db.PaymentEventDead
+		case "all":
+			filter.Status = ""
+		}
+		page, _ := strconv.Atoi(query.Get("page"))
+		if page < 1 {
+			page = 1
+		}
+
+		events, total, err := db.GetPaymentEvents(filter, paymentEventsPerPage, (page-1)*paymentEventsPerPage)
+		if err != nil {
+			slog.Error("AdminPaymentEventsPageHandler: не удалось получить уведомления платежных шлюзов", "error", err)
+			http.Error(w, "Ошибка сервера при загрузке уведомлений", http.StatusInternalServerError)
+			return
+		}
+
+		data.PaymentEvents = events
+		data.FormValues = query
+		data.CurrentPage = page
+		data.Limit = paymentEventsPerPage
+		data.TotalPages = int(math.Ceil(float64(total) / float64(paymentEventsPerPage)))
+
+		app.RenderAdminPage(w, r, "payment_events.html", data)
+	}
+}
+
+// AdminRetryPaymentEventHandler возвращает уведомление из dead-letter в очередь и сразу запускает обработку
+func AdminRetryPaymentEventHandler(app *handlers.AppHandlers, billingService *billing.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		redirectURL := "/admin/payments/events"
+		if back := r.FormValue("back"); back != "" {
+			redirectURL += "?" + back
+		}
+		id, err := strconv.ParseInt(r.FormValue("event_id"), 10, 64)
+		if err != nil || id == 0 {
+			app.SessionManager.Put(r.Context(), "flash_error", "Неверный ID уведомления.")
+			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+			return
+		}
+		requeued, err := db.RequeuePaymentEvent(id)
+		if err != nil {
+			app.SessionManager.Put(r.Context(), "flash_error", "Не удалось вернуть уведомление в очередь.")
+			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+			return
+		}
+		if !requeued {
+			app.SessionManager.Put(r.Context(), "flash_error", "Уведомление уже обработано или обрабатывается.")
+			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+			return
+		}
+		billingService.Enqueue(id)
+		slog.Info("Уведомление платежного шлюза возвращено в очередь администратором", "event_id", id, "user_id", adminUserID(r))
+		app.SessionManager.Put(r.Context(), "flash_success", "Уведомление возвращено в очередь.")
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	}
+}
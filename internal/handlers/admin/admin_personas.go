@@ -0,0 +1,232 @@
+// internal/handlers/admin/admin_personas.go
+package adminhandlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/handlers"
+	"shaman-ai.kz/internal/models"
+	"shaman-ai.kz/internal/personas"
+)
+
+var personaSlugRegex = regexp.MustCompile(`^[a-z0-9_-]{2,64}$`)
+
+// AdminPersonasListPageHandler отображает список персон, включая отключенные.
+func AdminPersonasListPageHandler(app *handlers.AppHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := app.NewPageData(r)
+		data.AdminPageTitle = "Персоны ассистента"
+
+		list, err := db.GetAllPersonas()
+		if err != nil {
+			slog.Error("AdminPersonasListPageHandler: не удалось получить персоны", "error", err)
+			http.Error(w, "Ошибка сервера при загрузке персон", http.StatusInternalServerError)
+			return
+		}
+		data.Personas = list
+
+		app.RenderAdminPage(w, r, "personas_list.html", data)
+	}
+}
+
+// AdminEditPersonaPageHandler отображает форму создания (без id) или редактирования персоны.
+func AdminEditPersonaPageHandler(app *handlers.AppHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := app.NewPageData(r)
+		data.FormAction = "/admin/personas/save"
+
+		idStr := r.URL.Query().Get("id")
+		if idStr == "" {
+			data.AdminPageTitle = "Новая персона"
+			data.EditingPersona = &models.Persona{IsActive: true}
+			app.RenderAdminPage(w, r, "persona_edit.html", data)
+			return
+		}
+
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || id == 0 {
+			http.Error(w, "Неверный ID персоны", http.StatusBadRequest)
+			return
+		}
+		persona, err := db.GetPersonaByID(id)
+		if err != nil {
+			slog.Error("AdminEditPersonaPageHandler: персона не найдена", "id", id, "error", err)
+			http.NotFound(w, r)
+			return
+		}
+		data.EditingPersona = persona
+		data.AdminPageTitle = fmt.Sprintf("Редактирование персоны: %s", persona.Name)
+
+		app.RenderAdminPage(w, r, "persona_edit.html", data)
+	}
+}
+
+// personaFromForm разбирает форму персоны и возвращает ошибки валидации по полям.
+func personaFromForm(r *http.Request) (*models.Persona, map[string]string) {
+	errs := make(map[string]string)
+	p := &models.Persona{
+		Slug:            strings.ToLower(strings.TrimSpace(r.PostForm.Get("slug"))),
+		Name:            strings.TrimSpace(r.PostForm.Get("name")),
+		Description:     strings.TrimSpace(r.PostForm.Get("description")),
+		SystemPrompt:    strings.TrimSpace(r.PostForm.Get("system_prompt")),
+		Model:           strings.TrimSpace(r.PostForm.Get("model")),
+		RoutingKeywords: strings.Split(strings.ReplaceAll(r.PostForm.Get("routing_keywords"), "\n", ","), ","),
+		AutoRoute:       r.PostForm.Get("auto_route") == "on",
+		IsActive:        r.PostForm.Get("is_active") == "on",
+	}
+
+	var keywords []string
+	for _, k := range p.RoutingKeywords {
+		if k = strings.TrimSpace(k); k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	p.RoutingKeywords = keywords
+
+	if !personaSlugRegex.MatchString(p.Slug) {
+		errs["slug"] = "Slug: 2-64 символа, латиница в нижнем регистре, цифры, '-' и '_'."
+	}
+	if p.Name == "" {
+		errs["name"] = "Название обязательно."
+	}
+	if value := strings.TrimSpace(r.PostForm.Get("temperature")); value != "" {
+		t, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", "."), 64)
+		if err != nil || t < 0 || t > 2 {
+			errs["temperature"] = "Temperature должна быть числом от 0 до 2."
+		} else {
+			p.Temperature = &t
+		}
+	}
+	if value := strings.TrimSpace(r.PostForm.Get("max_tokens")); value != "" {
+		m, err := strconv.Atoi(value)
+		if err != nil || m < 1 || m > 32000 {
+			errs["max_tokens"] = "Max tokens должно быть целым числом от 1 до 32000."
+		} else {
+			p.MaxTokens = &m
+		}
+	}
+	if value := strings.TrimSpace(r.PostForm.Get("sort_order")); value != "" {
+		order, err := strconv.Atoi(value)
+		if err != nil {
+			errs["sort_order"] = "Порядок должен быть целым числом."
+		} else {
+			p.SortOrder = order
+		}
+	}
+	return p, errs
+}
+
+// AdminSavePersonaHandler создает или обновляет персону.
+func AdminSavePersonaHandler(app *handlers.AppHandlers, personaRegistry *personas.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			slog.Error("AdminSavePersonaHandler: ошибка парсинга формы", "error", err)
+			app.SessionManager.Put(r.Context(), "flash_error", "Ошибка обработки данных формы.")
+			http.Redirect(w, r, "/admin/personas", http.StatusSeeOther)
+			return
+		}
+
+		persona, errs := personaFromForm(r)
+		id, _ := strconv.ParseInt(r.PostForm.Get("id"), 10, 64)
+		persona.ID = id
+
+		var previousPrompt string
+		if id != 0 {
+			existing, err := db.GetPersonaByID(id)
+			if err != nil {
+				app.SessionManager.Put(r.Context(), "flash_error", "Персона не найдена.")
+				http.Redirect(w, r, "/admin/personas", http.StatusSeeOther)
+				return
+			}
+			persona.IsBuiltin = existing.IsBuiltin
+			previousPrompt = existing.SystemPrompt
+			if existing.IsBuiltin {
+				persona.Slug = existing.Slug // Slug встроенных персон используется в коде
+				delete(errs, "slug")
+			}
+			if existing.Slug == personas.SlugGeneral && !persona.IsActive {
+				errs["is_active"] = "Общую персону нельзя отключить: она используется по умолчанию."
+			}
+		}
+
+		if len(errs) > 0 {
+			data := app.NewPageData(r)
+			data.AdminPageTitle = "Персона: исправьте ошибки"
+			data.FormAction = "/admin/personas/save"
+			data.EditingPersona = persona
+			data.Errors = url.Values{}
+			for field, msg := range errs {
+				data.Errors.Add(field, msg)
+			}
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			app.RenderAdminPage(w, r, "persona_edit.html", data)
+			return
+		}
+
+		var err error
+		if id == 0 {
+			_, err = db.CreatePersona(persona)
+		} else {
+			err = db.UpdatePersona(persona)
+		}
+		if err != nil {
+			slog.Error("AdminSavePersonaHandler: не удалось сохранить персону", "slug", persona.Slug, "error", err)
+			app.SessionManager.Put(r.Context(), "flash_error", "Не удалось сохранить персону (возможно, такой slug уже существует).")
+			http.Redirect(w, r, "/admin/personas", http.StatusSeeOther)
+			return
+		}
+		personaRegistry.Invalidate()
+		recordPromptRevision(db.PersonaPromptKeyPrefix+persona.Slug, previousPrompt, persona.SystemPrompt, "", adminUserID(r))
+
+		slog.Info("Персона сохранена администратором", "slug", persona.Slug, "id", id)
+		app.SessionManager.Put(r.Context(), "flash_success", "Персона сохранена.")
+		http.Redirect(w, r, "/admin/personas", http.StatusSeeOther)
+	}
+}
+
+// AdminTogglePersonaHandler включает или отключает персону. Персоны не удаляются,
+// потому что на них ссылаются существующие диалоги.
+func AdminTogglePersonaHandler(app *handlers.AppHandlers, personaRegistry *personas.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil || id == 0 {
+			app.SessionManager.Put(r.Context(), "flash_error", "Неверный ID персоны.")
+			http.Redirect(w, r, "/admin/personas", http.StatusSeeOther)
+			return
+		}
+		persona, err := db.GetPersonaByID(id)
+		if err != nil {
+			app.SessionManager.Put(r.Context(), "flash_error", "Персона не найдена.")
+			http.Redirect(w, r, "/admin/personas", http.StatusSeeOther)
+			return
+		}
+		if persona.Slug == personas.SlugGeneral && persona.IsActive {
+			app.SessionManager.Put(r.Context(), "flash_error", "Общую персону нельзя отключить.")
+			http.Redirect(w, r, "/admin/personas", http.StatusSeeOther)
+			return
+		}
+
+		if err := db.SetPersonaActive(id, !persona.IsActive); err != nil {
+			app.SessionManager.Put(r.Context(), "flash_error", "Не удалось изменить состояние персоны.")
+		} else {
+			personaRegistry.Invalidate()
+			app.SessionManager.Put(r.Context(), "flash_success", "Состояние персоны изменено.")
+		}
+		http.Redirect(w, r, "/admin/personas", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,201 @@
+// internal/handlers/admin/admin_plans.go
+package adminhandlers
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/handlers"
+	"shaman-ai.kz/internal/models"
+)
+
+var planSlugRegex = regexp.MustCompile(`^[a-z0-9_-]{2,64}$`)
+
+// AdminPlansListPageHandler отображает каталог тарифов, включая скрытые.
+func AdminPlansListPageHandler(app *handlers.AppHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := app.NewPageData(r)
+		data.AdminPageTitle = "Тарифы"
+
+		list, err := db.GetAllPlans()
+		if err != nil {
+			slog.Error("AdminPlansListPageHandler: не удалось получить тарифы", "error", err)
+			http.Error(w, "Ошибка сервера при загрузке тарифов", http.StatusInternalServerError)
+			return
+		}
+		data.Plans = list
+
+		app.RenderAdminPage(w, r, "plans_list.html", data)
+	}
+}
+
+// AdminEditPlanPageHandler отображает форму создания (без id) или редактирования тарифа.
+func AdminEditPlanPageHandler(app *handlers.AppHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := app.NewPageData(r)
+		data.FormAction = "/admin/plans/save"
+
+		idStr := r.URL.Query().Get("id")
+		if idStr == "" {
+			data.AdminPageTitle = "Новый тариф"
+			data.EditingPlan = &models.Plan{AllowFileUploads: true, AllowShamanMode: true, AllowVision: true}
+			app.RenderAdminPage(w, r, "plan_edit.html", data)
+			return
+		}
+
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || id == 0 {
+			http.Error(w, "Неверный ID тарифа", http.StatusBadRequest)
+			return
+		}
+		plan, err := db.GetPlanByID(id)
+		if err != nil {
+			slog.Error("AdminEditPlanPageHandler: тариф не найден", "id", id, "error", err)
+			http.NotFound(w, r)
+			return
+		}
+		data.EditingPlan = plan
+		data.AdminPageTitle = fmt.Sprintf("Редактирование тарифа: %s", plan.Name)
+
+		app.RenderAdminPage(w, r, "plan_edit.html", data)
+	}
+}
+
+// parseTenge разбирает сумму в тенге (допускается запятая) и возвращает ее в тиынах
+func parseTenge(value string) (int64, error) {
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(value), ",", "."), 64)
+	if err != nil || amount < 0 || math.IsInf(amount, 0) {
+		return 0, fmt.Errorf("некорректная сумма %q", value)
+	}
+	return int64(math.Round(amount * 100)), nil
+}
+
+// planFromForm разбирает форму тарифа и возвращает ошибки валидации по полям. Цены вводятся в тенге.
+func planFromForm(r *http.Request) (*models.Plan, map[string]string) {
+	errs := make(map[string]string)
+	p := &models.Plan{
+		Slug:             strings.ToLower(strings.TrimSpace(r.PostForm.Get("slug"))),
+		Name:             strings.TrimSpace(r.PostForm.Get("name")),
+		Description:      strings.TrimSpace(r.PostForm.Get("description")),
+		AllowFileUploads: r.PostForm.Get("allow_file_uploads") == "on",
+		AllowShamanMode:  r.PostForm.Get("allow_shaman_mode") == "on",
+		AllowVision:      r.PostForm.Get("allow_vision") == "on",
+		IsVisible:        r.PostForm.Get("is_visible") == "on",
+	}
+
+	if !planSlugRegex.MatchString(p.Slug) {
+		errs["slug"] = "Slug: 2-64 символа, латиница в нижнем регистре, цифры, '-' и '_'."
+	}
+	if p.Name == "" {
+		errs["name"] = "Название обязательно."
+	}
+	if price, err := parseTenge(r.PostForm.Get("monthly_price")); err != nil || price == 0 {
+		errs["monthly_price"] = "Цена за месяц должна быть положительной суммой в тенге."
+	} else {
+		p.MonthlyPrice = price
+	}
+	if value := strings.TrimSpace(r.PostForm.Get("annual_price")); value != "" {
+		if price, err := parseTenge(value); err != nil {
+			errs["annual_price"] = "Цена за год должна быть суммой в тенге; пусто или 0 - без годовой оплаты."
+		} else {
+			p.AnnualPrice = price
+		}
+	}
+	limit, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(r.PostForm.Get("token_limit_kzt")), ",", "."), 64)
+	if err != nil || limit <= 0 || math.IsInf(limit, 0) {
+		errs["token_limit_kzt"] = "Лимит токенов должен быть положительной суммой в тенге."
+	} else {
+		p.TokenLimitKZT = limit
+	}
+	if value := strings.TrimSpace(r.PostForm.Get("trial_days")); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 || days > 365 {
+			errs["trial_days"] = "Пробный период - целое число дней от 0 до 365."
+		} else {
+			p.TrialDays = days
+		}
+	}
+	if value := strings.TrimSpace(r.PostForm.Get("sort_order")); value != "" {
+		order, err := strconv.Atoi(value)
+		if err != nil {
+			errs["sort_order"] = "Порядок должен быть целым числом."
+		} else {
+			p.SortOrder = order
+		}
+	}
+	return p, errs
+}
+
+// AdminSavePlanHandler создает или обновляет тариф. Новые цены действуют для следующих оплат
+// и продлений, уже оплаченные периоды не меняются.
+func AdminSavePlanHandler(app *handlers.AppHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			slog.Error("AdminSavePlanHandler: ошибка парсинга формы", "error", err)
+			app.SessionManager.Put(r.Context(), "flash_error", "Ошибка обработки данных формы.")
+			http.Redirect(w, r, "/admin/plans", http.StatusSeeOther)
+			return
+		}
+
+		plan, errs := planFromForm(r)
+		id, _ := strconv.ParseInt(r.PostForm.Get("id"), 10, 64)
+		plan.ID = id
+
+		if id != 0 {
+			existing, err := db.GetPlanByID(id)
+			if err != nil {
+				app.SessionManager.Put(r.Context(), "flash_error", "Тариф не найден.")
+				http.Redirect(w, r, "/admin/plans", http.StatusSeeOther)
+				return
+			}
+			plan.Slug = existing.Slug // Slug записан в подписках и не меняется
+			delete(errs, "slug")
+		}
+		if plan.Slug == models.DefaultPlanSlug && !plan.IsVisible {
+			errs["is_visible"] = "Тариф по умолчанию нельзя скрыть: его получают пользователи без выбранного тарифа."
+		}
+
+		if len(errs) > 0 {
+			data := app.NewPageData(r)
+			data.AdminPageTitle = "Тариф: исправьте ошибки"
+			data.FormAction = "/admin/plans/save"
+			data.EditingPlan = plan
+			data.Errors = url.Values{}
+			for field, msg := range errs {
+				data.Errors.Add(field, msg)
+			}
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			app.RenderAdminPage(w, r, "plan_edit.html", data)
+			return
+		}
+
+		var err error
+		if id == 0 {
+			_, err = db.CreatePlan(plan)
+		} else {
+			err = db.UpdatePlan(plan)
+		}
+		if err != nil {
+			slog.Error("AdminSavePlanHandler: не удалось сохранить тариф", "slug", plan.Slug, "error", err)
+			app.SessionManager.Put(r.Context(), "flash_error", "Не удалось сохранить тариф (возможно, такой slug уже существует).")
+			http.Redirect(w, r, "/admin/plans", http.StatusSeeOther)
+			return
+		}
+
+		slog.Info("Тариф сохранен администратором", "slug", plan.Slug, "id", id, "monthly_price", plan.MonthlyPrice,
+			"annual_price", plan.AnnualPrice, "token_limit_kzt", plan.TokenLimitKZT, "user_id", adminUserID(r))
+		app.SessionManager.Put(r.Context(), "flash_success", "Тариф сохранен.")
+		http.Redirect(w, r, "/admin/plans", http.StatusSeeOther)
+	}
+}
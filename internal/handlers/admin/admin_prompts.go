@@ -0,0 +1,213 @@
+// internal/handlers/admin/admin_prompts.go
+package adminhandlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/handlers"
+	"shaman-ai.kz/internal/middleware"
+	"shaman-ai.kz/internal/personas"
+	"shaman-ai.kz/internal/prompts"
+	"shaman-ai.kz/internal/utils"
+)
+
+const promptRevisionsLimit = 100
+
+// Промпты встроенных персон, которые хранятся в app_settings
+var settingPromptKeys = []string{
+	prompts.ContentKey(personas.SlugShaman),
+	prompts.ContentKey(personas.SlugGeneral),
+}
+
+func isSettingPromptKey(key string) bool {
+	for _, k := range settingPromptKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// promptKeyValid проверяет, что ключ ревизии относится к промпту, который можно откатить
+func promptKeyValid(key string) bool {
+	if isSettingPromptKey(key) {
+		return true
+	}
+	slug, ok := strings.CutPrefix(key, db.PersonaPromptKeyPrefix)
+	return ok && personaSlugRegex.MatchString(slug)
+}
+
+func adminUserID(r *http.Request) int64 {
+	userID, _ := r.Context().Value(middleware.UserIDContextKey).(int64)
+	return userID
+}
+
+// recordPromptRevision сохраняет новую версию промпта, если текст изменился. Если истории
+// по ключу еще нет, сначала сохраняется предыдущий текст, чтобы к нему можно было вернуться.
+func recordPromptRevision(key, oldContent, newContent, comment string, userID int64) {
+	if oldContent == newContent {
+		return
+	}
+	latest, err := db.GetLatestPromptRevision(key)
+	if err != nil {
+		slog.Error("Не удалось проверить историю промпта", "key", key, "error", err)
+		return
+	}
+	if latest == nil {
+		if _, err := db.CreatePromptRevision(key, oldContent, "Исходная версия", 0); err != nil {
+			return
+		}
+	}
+	if _, err := db.CreatePromptRevision(key, newContent, comment, userID); err != nil {
+		return
+	}
+	slog.Info("Сохранена новая ревизия промпта", "key", key, "user_id", userID)
+}
+
+// AdminPromptHistoryPageHandler показывает историю версий промпта (?key=...).
+func AdminPromptHistoryPageHandler(app *handlers.AppHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := app.NewPageData(r)
+
+		keys := append([]string{}, settingPromptKeys...)
+		if list, err := db.GetAllPersonas(); err != nil {
+			slog.Error("AdminPromptHistoryPageHandler: не удалось получить персоны", "error", err)
+		} else {
+			for _, p := range list {
+				keys = append(keys, db.PersonaPromptKeyPrefix+p.Slug)
+			}
+		}
+		data.PromptKeys = keys
+
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			key = settingPromptKeys[0]
+		}
+		if !promptKeyValid(key) {
+			http.Error(w, "Неизвестный ключ промпта", http.StatusBadRequest)
+			return
+		}
+		data.PromptKey = key
+		data.AdminPageTitle = fmt.Sprintf("История промпта: %s", key)
+
+		revisions, err := db.GetPromptRevisions(key, promptRevisionsLimit)
+		if err != nil {
+			slog.Error("AdminPromptHistoryPageHandler: не удалось получить ревизии", "key", key, "error", err)
+			http.Error(w, "Ошибка сервера при загрузке истории промпта", http.StatusInternalServerError)
+			return
+		}
+		data.PromptRevisions = revisions
+
+		app.RenderAdminPage(w, r, "prompt_history.html", data)
+	}
+}
+
+// AdminPromptDiffPageHandler показывает построчный diff двух ревизий (?from=&to=).
+// Без to сравнивает с последней ревизией того же промпта.
+func AdminPromptDiffPageHandler(app *handlers.AppHandlers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fromID, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		if err != nil || fromID == 0 {
+			http.Error(w, "Неверный ID ревизии", http.StatusBadRequest)
+			return
+		}
+		from, err := db.GetPromptRevisionByID(fromID)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		var to *db.PromptRevision
+		if toStr := r.URL.Query().Get("to"); toStr != "" {
+			toID, err := strconv.ParseInt(toStr, 10, 64)
+			if err != nil || toID == 0 {
+				http.Error(w, "Неверный ID ревизии", http.StatusBadRequest)
+				return
+			}
+			to, err = db.GetPromptRevisionByID(toID)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			if to.PromptKey != from.PromptKey {
+				http.Error(w, "Ревизии относятся к разным промптам", http.StatusBadRequest)
+				return
+			}
+		} else {
+			to, err = db.GetLatestPromptRevision(from.PromptKey)
+			if err != nil || to == nil {
+				slog.Error("AdminPromptDiffPageHandler: не удалось получить последнюю ревизию", "key", from.PromptKey, "error", err)
+				http.Error(w, "Ошибка сервера при загрузке ревизии", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		data := app.NewPageData(r)
+		data.AdminPageTitle = fmt.Sprintf("Сравнение ревизий #%d и #%d", from.ID, to.ID)
+		data.PromptKey = from.PromptKey
+		data.PromptRevisions = []db.PromptRevision{*from, *to}
+		data.PromptDiff = utils.LineDiff(from.Content, to.Content)
+
+		app.RenderAdminPage(w, r, "prompt_diff.html", data)
+	}
+}
+
+// AdminPromptRollbackHandler возвращает промпт к выбранной ревизии. Откат сам
+// сохраняется как новая ревизия, поэтому его тоже можно отменить.
+func AdminPromptRollbackHandler(app *handlers.AppHandlers, personaRegistry *personas.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseInt(r.FormValue("revision_id"), 10, 64)
+		if err != nil || id == 0 {
+			app.SessionManager.Put(r.Context(), "flash_error", "Неверный ID ревизии.")
+			http.Redirect(w, r, "/admin/prompts", http.StatusSeeOther)
+			return
+		}
+		rev, err := db.GetPromptRevisionByID(id)
+		if err != nil {
+			app.SessionManager.Put(r.Context(), "flash_error", "Ревизия не найдена.")
+			http.Redirect(w, r, "/admin/prompts", http.StatusSeeOther)
+			return
+		}
+		historyURL := "/admin/prompts?key=" + url.QueryEscape(rev.PromptKey)
+		if !promptKeyValid(rev.PromptKey) {
+			app.SessionManager.Put(r.Context(), "flash_error", "Этот промпт нельзя откатить.")
+			http.Redirect(w, r, "/admin/prompts", http.StatusSeeOther)
+			return
+		}
+
+		if slug, ok := strings.CutPrefix(rev.PromptKey, db.PersonaPromptKeyPrefix); ok {
+			err = db.UpdatePersonaPrompt(slug, rev.Content)
+			if err == nil {
+				personaRegistry.Invalidate()
+			}
+		} else {
+			// Описание настройки не передаем: UpdateSetting сохранит текущее.
+			// Кеш промпта сбросится через db.OnSettingChanged.
+			err = db.UpdateSetting(rev.PromptKey, rev.Content)
+		}
+		if err != nil {
+			slog.Error("AdminPromptRollbackHandler: не удалось откатить промпт", "key", rev.PromptKey, "revision_id", id, "error", err)
+			app.SessionManager.Put(r.Context(), "flash_error", "Не удалось откатить промпт.")
+			http.Redirect(w, r, historyURL, http.StatusSeeOther)
+			return
+		}
+
+		userID := adminUserID(r)
+		if _, err := db.CreatePromptRevision(rev.PromptKey, rev.Content, fmt.Sprintf("Откат к ревизии #%d", id), userID); err != nil {
+			slog.Error("AdminPromptRollbackHandler: откат выполнен, но ревизия не сохранена", "key", rev.PromptKey, "error", err)
+		}
+		slog.Info("Промпт откатен администратором", "key", rev.PromptKey, "revision_id", id, "user_id", userID)
+		app.SessionManager.Put(r.Context(), "flash_success", fmt.Sprintf("Промпт возвращен к ревизии #%d.", id))
+		http.Redirect(w, r, historyURL, http.StatusSeeOther)
+	}
+}
@@ -86,15 +86,20 @@ func AdminUpdateSettingsHandler(app *handlers.AppHandlers) http.HandlerFunc {
 		for key, value := range settingsToUpdate {
 			// Получаем описание для существующей настройки, чтобы не затереть его
 			currentSetting, _ := db.GetSetting(key)
-			var currentDesc string
+			var currentDesc, currentValue string
 			if currentSetting != nil {
 				currentDesc = currentSetting.Description
+				currentValue = currentSetting.Value
 			}
 
 			errDb := db.UpdateSetting(key, value, currentDesc)
 			if errDb != nil {
 				slog.Error("AdminUpdateSettingsHandler: не удалось обновить настройку", "key", key, "error", errDb)
 				updateErrors = append(updateErrors, fmt.Sprintf("Ошибка сохранения '%s'", key))
+				continue
+			}
+			if isSettingPromptKey(key) {
+				recordPromptRevision(key, currentValue, value, r.PostForm.Get("prompt_comment"), adminUserID(r))
 			}
 		}
 
@@ -107,7 +112,7 @@ func AdminUpdateSettingsHandler(app *handlers.AppHandlers) http.HandlerFunc {
 		// Важно: Изменение этих настроек в БД не означает, что приложение сразу их подхватит.
 		// Для `site_name` и `site_description` из `config.Config` это потребует перезапуска или механизма горячей перезагрузки конфига.
 		// `maintenance_mode` должен проверяться в middleware на каждом запросе.
-		// Системные промпты, если хранятся в БД, должны загружаться перед каждым запросом к LLM или кешироваться.
+		// Системные промпты подхватываются сразу: prompts.Provider сбрасывает кеш при UpdateSetting.
 
 		http.Redirect(w, r, "/admin/settings", http.StatusSeeOther)
 	}
@@ -0,0 +1,132 @@
+// internal/handlers/attachment_handler.go
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/middleware"
+	"shaman-ai.kz/internal/storage"
+)
+
+// DownloadAttachmentHandler отдает файл вложения его владельцу (GET /api/attachment?id=...).
+// Чужие и несуществующие вложения неразличимы для клиента - оба дают 404.
+func DownloadAttachmentHandler(appConfig *config.Config, fileStore storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(middleware.UserIDContextKey).(int64)
+		if !ok || userID == 0 {
+			http.Error(w, "Не авторизован", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "Неверный ID вложения", http.StatusBadRequest)
+			return
+		}
+
+		attachment, ownerID, err := db.GetAttachmentWithOwner(id)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				slog.Error("Ошибка получения вложения", "attachment_id", id, "user_id", userID, "error", err)
+				http.Error(w, "Ошибка сервера при получении вложения", http.StatusInternalServerError)
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+		if ownerID != userID {
+			slog.Warn("Попытка скачать чужое вложение", "attachment_id", id, "user_id", userID, "owner_id", ownerID)
+			http.NotFound(w, r)
+			return
+		}
+
+		serveAttachment(w, r, appConfig, fileStore, attachment, "private, max-age=3600")
+	}
+}
+
+// serveAttachment отдает файл вложения. cacheControl - значение заголовка Cache-Control.
+func serveAttachment(w http.ResponseWriter, r *http.Request, appConfig *config.Config, fileStore storage.Storage, attachment *db.MessageAttachment, cacheControl string) {
+	content, modTime, err := openAttachment(r.Context(), appConfig, fileStore, attachment)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			slog.Error("Файл вложения не найден", "attachment_id", attachment.ID, "error", err)
+			http.NotFound(w, r)
+			return
+		}
+		slog.Error("Ошибка чтения вложения", "attachment_id", attachment.ID, "error", err)
+		http.Error(w, "Ошибка сервера при чтении вложения", http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	// Картинки показываем в чате, все остальное только скачивается: так загруженный
+	// HTML или SVG не выполнится в контексте нашего домена
+	disposition := "attachment"
+	if isInlineImage(attachment.MIMEType) {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", attachment.MIMEType)
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": attachment.OriginalName}); value != "" {
+		disposition = value
+	}
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeContent(w, r, "", modTime, content)
+}
+
+func isInlineImage(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "image/") && mimeType != "image/svg+xml"
+}
+
+// openAttachment открывает файл вложения: из хранилища или, для вложений, загруженных
+// до его появления, с диска по server_path
+func openAttachment(ctx context.Context, appConfig *config.Config, fileStore storage.Storage, attachment *db.MessageAttachment) (io.ReadSeekCloser, time.Time, error) {
+	if attachment.StorageKey != "" {
+		content, info, err := fileStore.Open(ctx, attachment.StorageKey)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return content, info.ModTime, nil
+	}
+
+	// Путь берется из БД, но все равно проверяем, что он внутри каталога загрузок
+	uploadDir, errAbs := filepath.Abs(appConfig.UploadPath)
+	filePath, errPath := filepath.Abs(attachment.ServerPath)
+	if errAbs != nil || errPath != nil || !strings.HasPrefix(filePath, uploadDir+string(filepath.Separator)) {
+		return nil, time.Time{}, fmt.Errorf("%w: путь вложения вне каталога загрузок: %s", storage.ErrNotFound, attachment.ServerPath)
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, time.Time{}, fmt.Errorf("%w: %s", storage.ErrNotFound, filePath)
+		}
+		return nil, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	return f, info.ModTime(), nil
+}
@@ -244,7 +244,7 @@ isLocalDevelopment := h.AppConfig.AppEnv == "development"
 if isLocalDevelopment && userID != 0 {
 	slog.Info("Локальная разработка: автоматическое создание тестовой подписки для нового пользователя", "userID", userID, "email", user.Email)
 
-	priceIDForDev := h.AppConfig.Billing.PriceID
+	priceIDForDev := models.DefaultPlanSlug
 	customerIDForDev := "dev_cust_" + fmt.Sprintf("%d", userID)
 	subscriptionIDForDev := "dev_sub_" + fmt.Sprintf("%d", userID)
 	status := models.SubscriptionStatusActive
@@ -2,255 +2,310 @@
 package handlers
 
 import (
-	"log"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/xml"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
-	"strconv"
-	"sort"
-	"time"
+	"net/url"
+	"strings"
 
+	"shaman-ai.kz/internal/billing"
 	"shaman-ai.kz/internal/config"
 	"shaman-ai.kz/internal/db"
 	"shaman-ai.kz/internal/middleware"
 	"shaman-ai.kz/internal/models"
-	"shaman-ai.kz/internal/session"
+	paymentgateway "shaman-ai.kz/internal/payment_gateway"
 	"shaman-ai.kz/internal/payment_gateway/bcc"
 
 	"github.com/alexedwards/scs/v2"
 )
 
-// ... (структуры Request, Attribute, Signature, Response, Transaction, WebhookNotification без изменений) ...
-type Request struct {
-	XMLName    xml.Name    `xml:"request"`
-	Point      string      `xml:"point,attr"`
-	Action     string      `xml:"action,attr"`
-	Timestamp  string      `xml:"datetime,attr"`
-	Attributes []Attribute `xml:"attribute"`
-	Signature  Signature   `xml:"signature"`
-}
+// Максимальный размер уведомления шлюза
+const maxWebhookBodySize = 64 * 1024
 
-type Attribute struct {
-	XMLName xml.Name `xml:"attribute"`
-	Name    string   `xml:"name,attr"`
-	Value   string   `xml:"value,attr"`
+type BillingHandlers struct {
+	SessionManager *scs.SessionManager
+	Config         *config.Config
+	AppHandlers    *AppHandlers
+	Billing        *billing.Service
 }
 
-type Signature struct {
-	XMLName xml.Name `xml:"signature"`
-	Type    string   `xml:"type,attr"`
-	Value   string   `xml:"chardata"`
+func NewBillingHandlers(sm *scs.SessionManager, cfg *config.Config, ah *AppHandlers, billingService *billing.Service) *BillingHandlers {
+	return &BillingHandlers{SessionManager: sm, Config: cfg, AppHandlers: ah, Billing: billingService}
 }
 
-type Response struct {
-	XMLName     xml.Name    `xml:"response"`
-	Code        int         `xml:"code"`
-	Message     string      `xml:"message"`
-	PayURL      string      `xml:"pay-url"`
-	Transaction Transaction `xml:"transaction"`
+// wantsJSON - запрос отправлен скриптом страницы и ждет JSON вместо перенаправления
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
 }
 
-type Transaction struct {
-	ID        string `xml:"id,attr"`
-	PaymentID string `xml:"payment_id,attr"`
+// paymentReturnURL добавляет ID платежа к адресу возврата: по нему платеж находится,
+// даже если банк не передает ID заказа при перенаправлении
+func paymentReturnURL(returnURL, paymentID string) string {
+	u, err := url.Parse(returnURL)
+	if err != nil {
+		return returnURL
+	}
+	q := u.Query()
+	q.Set("payment_id", paymentID)
+	u.RawQuery = q.Encode()
+	return u.String()
 }
 
-type WebhookNotification struct {
-	XMLName   xml.Name `xml:"notification"`
-	OrderID   string   `xml:"order_id"`   
-	PaymentID string   `xml:"payment_id"` 
-	Status    string   `xml:"status"`     
-	Amount    int64    `xml:"amount"`
-	Signature string   `xml:"signature"`
-}
-type BillingHandlers struct {
-	SessionManager *scs.SessionManager
-	Config         *config.Config
-	AppHandlers    *AppHandlers
-	BCCClient *bcc.Client
+// checkoutError сообщает об ошибке оформления оплаты: JSON для скрипта, иначе flash и возврат на страницу подписки
+func (bh *BillingHandlers) checkoutError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+		return
+	}
+	bh.SessionManager.Put(r.Context(), "flash_error", message)
+	http.Redirect(w, r, "/subscribe", http.StatusSeeOther)
 }
 
-// HandleCreateBCCPayment - новый обработчик для создания платежа
-func (h *BillingHandler) HandleCreateBCCPayment(w http.ResponseWriter, r *http.Request) {
-	// 1. Получаем пользователя из сессии
-	userID, ok := session.GetUserID(r.Context())
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+// CreatePaymentLinkHandler создает платеж и заказ в шлюзе и перенаправляет пользователя на страницу оплаты.
+// Тариф выбирается полем plan (slug, пусто - тариф по умолчанию), период оплаты - полем period (monthly или annual).
+// Шлюз можно выбрать полем gateway, иначе используется billing.gateway.
+// Скрипту страницы (Accept: application/json) возвращает ссылку на оплату.
+func (bh *BillingHandlers) CreatePaymentLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
 	}
-
-	// 2. Получаем детали подписки из формы/запроса
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+	currentUser, ok := r.Context().Value(middleware.UserContextKey).(*models.User)
+	if !ok || currentUser == nil {
+		http.Error(w, "Пользователь не аутентифицирован", http.StatusUnauthorized)
 		return
 	}
-	subscriptionID, err := strconv.ParseInt(r.FormValue("subscription_id"), 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+	gateway, ok := bh.Billing.Gateways().Get(r.FormValue("gateway"))
+	if !ok {
+		slog.Warn("Запрошен неподключенный платежный шлюз", "gateway", r.FormValue("gateway"), "userID", currentUser.ID)
+		bh.checkoutError(w, r, "Выбранный способ оплаты недоступен.", http.StatusBadRequest)
 		return
 	}
-	
-	// Здесь вы должны получить детали подписки (цену) из БД
-	// Для примера, хардкодим
-	subscription, err := h.DB.GetSubscriptionByID(r.Context(), subscriptionID)
-	if err != nil {
-		log.Printf("Error getting subscription %d: %v", subscriptionID, err)
-		http.Error(w, "Subscription not found", http.StatusNotFound)
+	plan, ok := bh.checkoutPlan(w, r, currentUser.ID)
+	if !ok {
 		return
 	}
-
-
-	// 3. Создаем запись о платеже в нашей БД со статусом "pending"
-	newPayment := &models.Payment{
-		UserID:         userID,
-		SubscriptionID: subscription.ID,
-		Amount:         subscription.Price,
-		Currency:       h.Cfg.BCCGateway.Currency,
-		Status:         "pending",
-		GatewayName:    "bcc",
-	}
-	
-	err = h.DB.CreatePayment(r.Context(), newPayment)
-	if err != nil {
-		log.Printf("Error creating payment record for user %d: %v", userID, err)
-		http.Error(w, "Failed to initialize payment", http.StatusInternalServerError)
+	months := models.PeriodMonths(r.FormValue("period"))
+	amount := plan.Price(months)
+	if amount <= 0 {
+		bh.checkoutError(w, r, "Годовая оплата для этого тарифа недоступна.", http.StatusBadRequest)
 		return
 	}
 
-	// 4. Формируем запрос к API банка
-	user, err := h.DB.GetUserByID(r.Context(), userID)
-	if err != nil {
-		log.Printf("Error getting user %d: %v", userID, err)
-		http.Error(w, "User not found", http.StatusInternalServerError)
-		return
+	payment := &models.Payment{
+		UserID:       currentUser.ID,
+		Amount:       amount,
+		Currency:     bh.Config.Billing.Currency, // Цены тарифов - в валюте billing.currency, как и у автопродления
+		Status:       models.PaymentStatusPending,
+		GatewayName:  gateway.Name(),
+		PlanID:       plan.Slug,
+		PeriodMonths: months,
 	}
-	
-	createOrderReq := bcc.CreateOrderRequest{
-		Amount:          newPayment.Amount,
-		MerchantOrderID: strconv.FormatInt(newPayment.ID, 10), // Используем наш ID платежа как ID заказа
-		Currency:        newPayment.Currency,
-		Description:     "Оплата подписки: " + subscription.Name,
-		Client: bcc.ClientInfo{
-			Email: user.Email,
-			Name:  user.Name,
-			Phone: user.Phone,
-		},
-		Options: bcc.Options{
-			ReturnURL: h.Cfg.BCCGateway.ReturnURL,
-		},
+	if err := db.CreatePayment(payment); err != nil {
+		bh.checkoutError(w, r, "Не удалось начать оплату. Попробуйте позже.", http.StatusInternalServerError)
+		return
 	}
 
-	// 5. Отправляем запрос в банк
-	result, err := h.BCCClient.CreateOrder(r.Context(), createOrderReq)
+	customer := paymentgateway.Customer{Email: currentUser.Email, Name: strings.TrimSpace(currentUser.FirstName + " " + currentUser.LastName)}
+	if currentUser.Phone != nil {
+		customer.Phone = *currentUser.Phone
+	}
+	order, err := gateway.CreateOrder(r.Context(), paymentgateway.OrderRequest{
+		PaymentID:   payment.ID,
+		Amount:      payment.Amount,
+		Currency:    payment.Currency,
+		Description: fmt.Sprintf("Подписка Sham'an AI «%s» на %d мес.", plan.Name, months),
+		Customer:    customer,
+		ReturnURL:   paymentReturnURL(bh.Config.BCCGateway.ReturnURL, payment.ID),
+		// Автопродление включено, если его не отключили на странице подписки и шлюз умеет списывать с сохраненной карты
+		SaveCard: gateway.SupportsRecurring() && r.FormValue("auto_renew") != "0",
+	})
 	if err != nil {
-		log.Printf("Error creating BCC order for payment %d: %v", newPayment.ID, err)
-		// Обновляем статус нашего платежа на "failed"
-		_ = h.DB.UpdateGatewayInfo(r.Context(), newPayment.ID, "", "failed")
-		http.Error(w, "Could not contact payment provider", http.StatusInternalServerError)
+		slog.Error("Ошибка создания заказа в платежном шлюзе", "gateway", gateway.Name(), "paymentID", payment.ID, "userID", currentUser.ID, "error", err)
+		_ = db.UpdatePaymentStatus(payment.ID, models.PaymentStatusFailed, "")
+		bh.checkoutError(w, r, "Платежный сервис временно недоступен. Попробуйте позже.", http.StatusBadGateway)
 		return
 	}
-	
-	// 6. Сохраняем ID заказа от банка и обновляем статус
-	err = h.DB.UpdateGatewayInfo(r.Context(), newPayment.ID, result.GatewayOrderID, "processing")
-	if err != nil {
-		log.Printf("CRITICAL: Failed to save GatewayOrderID %s for payment %d: %v", result.GatewayOrderID, newPayment.ID, err)
-		http.Error(w, "Payment processing error", http.StatusInternalServerError)
+	if err := db.SetPaymentGatewayOrder(payment.ID, order.GatewayOrderID, models.PaymentStatusProcessing); err != nil {
+		// Без ID заказа оплату не сопоставить с платежом, поэтому на оплату не отправляем
+		slog.Error("КРИТИЧНО: не удалось сохранить ID заказа в шлюзе", "gateway", gateway.Name(), "paymentID", payment.ID, "gatewayOrderID", order.GatewayOrderID, "error", err)
+		bh.checkoutError(w, r, "Не удалось начать оплату. Попробуйте позже.", http.StatusInternalServerError)
 		return
 	}
+	slog.Info("Создан заказ на оплату подписки", "gateway", gateway.Name(), "paymentID", payment.ID, "gatewayOrderID", order.GatewayOrderID,
+		"userID", currentUser.ID, "plan", plan.Slug, "months", months, "amount", payment.Amount)
 
-
-	// 7. Перенаправляем пользователя на страницу оплаты
-	http.Redirect(w, r, result.PaymentURL, http.StatusSeeOther)
-}
-
-
-// HandleBCCSuccess - обработчик для успешного callback
-func (h *BillingHandler) HandleBCCSuccess(w http.ResponseWriter, r *http.Request) {
-    // Внимание: В документации банка неясно, как именно передается ID заказа при редиректе.
-    // Предположим, что он будет в параметре ?order_id=
-    // Это нужно будет уточнить у банка и скорректировать.
-	gatewayOrderID := r.URL.Query().Get("order_id")
-	if gatewayOrderID == "" {
-		log.Println("BCC Success callback: gateway_order_id not found in query params")
-		http.Error(w, "Invalid payment callback", http.StatusBadRequest)
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"payment_id": payment.ID, "payment_url": order.PaymentURL})
 		return
 	}
+	http.Redirect(w, r, order.PaymentURL, http.StatusSeeOther)
+}
 
-	// Проверяем статус заказа в системе банка
-	statusResp, err := h.BCCClient.GetOrderStatus(r.Context(), gatewayOrderID)
+// checkoutPlan находит тариф, выбранный на странице подписки. Скрытый тариф можно оплатить,
+// только если пользователь уже на нем: продление прежних условий.
+func (bh *BillingHandlers) checkoutPlan(w http.ResponseWriter, r *http.Request, userID int64) (*models.Plan, bool) {
+	slug := strings.TrimSpace(r.FormValue("plan"))
+	if slug == "" {
+		slug = models.DefaultPlanSlug
+	}
+	plan, err := db.GetPlanBySlug(slug)
 	if err != nil {
-		log.Printf("Error getting order status for %s: %v", gatewayOrderID, err)
-		http.Redirect(w, r, "/payment-failed", http.StatusSeeOther) // Перенаправляем на страницу ошибки
-		return
+		slog.Error("Не удалось получить тариф для оплаты", "plan", slug, "userID", userID, "error", err)
+		bh.checkoutError(w, r, "Не удалось начать оплату. Попробуйте позже.", http.StatusInternalServerError)
+		return nil, false
 	}
-	
-	if len(statusResp.Orders) > 0 {
-		orderStatus := statusResp.Orders[0].Status
-		
-		// "charged" - средства списаны (для одностадийной схемы)
-		// "authorized" - средства захолдированы (для двухстадийной)
-		if orderStatus == "charged" || orderStatus == "authorized" {
-			// Находим наш платеж по gateway_order_id
-			payment, err := h.DB.GetPaymentByGatewayID(r.Context(), gatewayOrderID)
-			if err != nil || payment == nil {
-				log.Printf("CRITICAL: Payment not found for gateway_order_id %s", gatewayOrderID)
-				http.Error(w, "Payment data mismatch", http.StatusInternalServerError)
-				return
-			}
-			
-			// Обновляем статус платежа в нашей БД
-			_ = h.DB.UpdateStatusByGatewayID(r.Context(), gatewayOrderID, "success")
-			
-			// Активируем подписку для пользователя
-			_ = h.DB.ActivateUserSubscription(r.Context(), payment.UserID, payment.SubscriptionID) // Вам нужно будет реализовать этот метод
-			
-			// Перенаправляем на страницу успеха в личном кабинете
-			http.Redirect(w, r, "/dashboard?payment=success", http.StatusSeeOther)
-			return
+	if plan != nil && !plan.IsVisible {
+		current, err := db.GetUserPlan(userID)
+		if err != nil || current.Slug != plan.Slug {
+			plan = nil
 		}
 	}
-	
-	// Если статус другой, считаем платеж неуспешным
-	_ = h.DB.UpdateStatusByGatewayID(r.Context(), gatewayOrderID, "failed")
-	http.Redirect(w, r, "/payment-failed", http.StatusSeeOther)
-}
-
-func NewBillingHandlers(sm *scs.SessionManager, cfg *config.Config, ah *AppHandlers) *BillingHandlers {
-	return &BillingHandlers{SessionManager: sm, Config: cfg, AppHandlers: ah}
+	if plan == nil {
+		slog.Warn("Запрошен недоступный тариф", "plan", slug, "userID", userID)
+		bh.checkoutError(w, r, "Выбранный тариф недоступен.", http.StatusBadRequest)
+		return nil, false
+	}
+	return plan, true
 }
 
-func generateXMLSignature(params []Attribute, secretKey string) string {
-	sort.Slice(params, func(i, j int) bool {
-		return params[i].Name < params[j].Name
-	})
-
-	var signatureString string
-	for _, attr := range params {
-		signatureString += attr.Value
+// StartTrialHandler оформляет пробный период выбранного тарифа (поле plan). Пробный период дается
+// только пользователю, у которого еще не было подписки; по его окончании тариф нужно оплатить.
+func (bh *BillingHandlers) StartTrialHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	currentUser, ok := r.Context().Value(middleware.UserContextKey).(*models.User)
+	if !ok || currentUser == nil {
+		http.Error(w, "Пользователь не аутентифицирован", http.StatusUnauthorized)
+		return
+	}
+	plan, ok := bh.checkoutPlan(w, r, currentUser.ID)
+	if !ok {
+		return
+	}
+	if plan.TrialDays <= 0 {
+		bh.checkoutError(w, r, "Для этого тарифа нет пробного периода.", http.StatusBadRequest)
+		return
+	}
+	started, err := db.StartTrial(currentUser.ID, plan)
+	if err != nil {
+		slog.Error("Не удалось оформить пробный период", "userID", currentUser.ID, "plan", plan.Slug, "error", err)
+		bh.checkoutError(w, r, "Не удалось оформить пробный период. Попробуйте позже.", http.StatusInternalServerError)
+		return
+	}
+	if !started {
+		bh.checkoutError(w, r, "Пробный период доступен только при первой подписке.", http.StatusConflict)
+		return
 	}
-	signatureString += secretKey
-
-	h := sha256.New()
-	h.Write([]byte(signatureString))
-	return hex.EncodeToString(h.Sum(nil))
-}
 
-func (bh *BillingHandlers) CreatePaymentLinkHandler(w http.ResponseWriter, r *http.Request) {
-    // ... (код этой функции остается без изменений) ...
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"plan": plan.Slug, "trial_days": plan.TrialDays})
+		return
+	}
+	bh.SessionManager.Put(r.Context(), "flash_success", fmt.Sprintf("Пробный период тарифа «%s» оформлен на %d дн.", plan.Name, plan.TrialDays))
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 }
 
+// PaymentSuccessPageHandler - адрес возврата из банка. Проверяет заказ в банке и продлевает подписку,
+// не дожидаясь уведомления; повторное уведомление потом ничего не изменит.
 func (bh *BillingHandlers) PaymentSuccessPageHandler(w http.ResponseWriter, r *http.Request) {
-    // ... (код этой функции остается без изменений) ...
+	query := r.URL.Query()
+	var payment *models.Payment
+	var err error
+	if paymentID := query.Get("payment_id"); paymentID != "" {
+		payment, err = db.GetPaymentByID(paymentID)
+	} else if orderID := query.Get("order_id"); orderID != "" {
+		gatewayName := query.Get("gateway")
+		if gatewayName == "" {
+			gatewayName = bh.Config.Billing.Gateway
+		}
+		payment, err = db.GetPaymentByGatewayOrderID(gatewayName, orderID)
+	}
+	if err != nil || payment == nil {
+		slog.Warn("Возврат из банка для неизвестного платежа", "query", r.URL.RawQuery, "error", err)
+		bh.SessionManager.Put(r.Context(), "flash_error", "Платеж не найден. Если деньги списались, свяжитесь с поддержкой.")
+		http.Redirect(w, r, "/subscribe", http.StatusSeeOther)
+		return
+	}
+
+	status, err := bh.Billing.SyncPayment(r.Context(), payment)
+	if err != nil {
+		slog.Error("Не удалось проверить оплату при возврате из банка", "paymentID", payment.ID, "error", err)
+	}
+	switch status {
+	case models.PaymentStatusSuccess:
+		bh.SessionManager.Put(r.Context(), "flash_success", "Оплата прошла успешно, подписка активна.")
+		redirectURL := bh.SessionManager.PopString(r.Context(), "redirectAfterSubscription")
+		if redirectURL == "" || !strings.HasPrefix(redirectURL, "/") || strings.HasPrefix(redirectURL, "//") {
+			redirectURL = "/dashboard"
+		}
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	case models.PaymentStatusFailed:
+		http.Redirect(w, r, "/billing/failure", http.StatusSeeOther)
+	default:
+		bh.SessionManager.Put(r.Context(), "flash_success", "Платеж обрабатывается банком. Подписка активируется автоматически после подтверждения оплаты.")
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+	}
 }
 
 func (bh *BillingHandlers) PaymentFailurePageHandler(w http.ResponseWriter, r *http.Request) {
-    // ... (код этой функции остается без изменений) ...
+	bh.SessionManager.Put(r.Context(), "flash_error", "Оплата не прошла. Деньги не списаны - попробуйте еще раз или используйте другую карту.")
+	http.Redirect(w, r, "/subscribe", http.StatusSeeOther)
 }
 
+// PaymentWebhookHandler принимает уведомление шлюза о смене статуса заказа: /api/billing/webhook/{gateway},
+// без имени шлюза - bcc. Уведомление с неверной подписью отклоняется (401), проверенное сохраняется
+// в очередь payment_events и обрабатывается в фоне; повтор с тем же ID события отбрасывается.
+// Ошибка сохранения - 500, чтобы шлюз повторил уведомление.
 func (bh *BillingHandlers) PaymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
-    // ... (код этой функции остается без изменений, включая // TODO: для подписи) ...
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	gatewayName := r.PathValue("gateway")
+	if gatewayName == "" {
+		gatewayName = bcc.Name // Адрес уведомлений, заданный в BCC до подключения других шлюзов
+	}
+	gateway, ok := bh.Billing.Gateways().Get(gatewayName)
+	if !ok {
+		http.Error(w, "Платежный шлюз не подключен", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+	if err != nil {
+		http.Error(w, "Не удалось прочитать уведомление", http.StatusBadRequest)
+		return
+	}
+	event, err := gateway.VerifyWebhook(r, body)
+	if errors.Is(err, paymentgateway.ErrInvalidSignature) {
+		slog.Warn("Отклонено уведомление платежного шлюза с неверной подписью", "gateway", gatewayName, "remote_addr", r.RemoteAddr)
+		http.Error(w, "Неверная подпись", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		slog.Warn("Отклонено уведомление платежного шлюза", "gateway", gatewayName, "content_type", r.Header.Get("Content-Type"), "error", err)
+		http.Error(w, "Некорректное уведомление", http.StatusBadRequest)
+		return
+	}
+
+	created, err := bh.Billing.ReceiveWebhook(gatewayName, event, body)
+	if err != nil {
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		slog.Info("Повторное уведомление платежного шлюза пропущено", "gateway", gatewayName, "eventID", event.EventID, "gatewayOrderID", event.GatewayOrderID)
+	}
+	w.WriteHeader(http.StatusOK)
 }
 
 func (bh *BillingHandlers) CancelSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
@@ -273,11 +328,6 @@ func (bh *BillingHandlers) CancelSubscriptionHandler(w http.ResponseWriter, r *h
 	}
 	gatewaySubscriptionID := *currentUser.SubscriptionID
 
-	// TODO: Здесь должна быть реальная логика отмены подписки в API Unicornlab/BCC, если она у них есть.
-	// Например: err := unicorn.CancelSubscription(gatewaySubscriptionID)
-	// Поскольку API для отмены подписки может не быть, мы эмулируем отмену только в нашей системе.
-	slog.Info("ЗАГЛУШКА: Отмена подписки для", "userID", currentUser.ID, "subscriptionID", gatewaySubscriptionID)
-
 	sub, err := db.GetSubscriptionByGatewayID(gatewaySubscriptionID) // Используем db
 	if err != nil || sub == nil {
 		slog.Error("Ошибка получения подписки из БД для отмены или подписка не найдена", "userID", currentUser.ID, "subscriptionID", gatewaySubscriptionID, "error", err)
@@ -285,36 +335,25 @@ func (bh *BillingHandlers) CancelSubscriptionHandler(w http.ResponseWriter, r *h
 		http.Redirect(w, r, "/profile", http.StatusSeeOther)
 		return
 	}
-
-	sub.Status = models.SubscriptionStatusCanceled
-	sub.CancelAtPeriodEnd = true
-	sub.UpdatedAt = time.Now()
-	if sub.CurrentPeriodEnd.After(time.Now()) {
-		sub.EndDate = sub.CurrentPeriodEnd // Устанавливаем дату окончания в конец текущего периода
-	} else {
-		sub.EndDate = time.Now()
+	if sub.Status == models.SubscriptionStatusCanceled || sub.CancelAtPeriodEnd {
+		bh.AppHandlers.SessionManager.Put(r.Context(), "flash_success", "Автоматическое продление уже отключено.")
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+		return
 	}
 
-	if err := db.CreateOrUpdateSubscription(sub); err != nil { // Используем db
-		slog.Error("Ошибка обновления записи в таблице subscriptions при отмене", "userID", currentUser.ID, "error", err)
-		bh.AppHandlers.SessionManager.Put(r.Context(), "flash_error", "Подписка отменена, но произошла ошибка при обновлении статуса в вашем аккаунте. Свяжитесь с поддержкой.")
+	// Подписка действует до конца оплаченного периода, затем ее завершит планировщик продлений
+	if err := bh.Billing.CancelAutoRenewal(r.Context(), sub); err != nil {
+		slog.Error("Ошибка отключения автопродления подписки", "userID", currentUser.ID, "subscriptionID", sub.ID, "error", err)
+		bh.AppHandlers.SessionManager.Put(r.Context(), "flash_error", "Не удалось отменить подписку. Попробуйте позже или свяжитесь с поддержкой.")
 		http.Redirect(w, r, "/profile", http.StatusSeeOther)
 		return
 	}
 
-	var customerIDStr string
-	if currentUser.CustomerID != nil {
-		customerIDStr = *currentUser.CustomerID
-	}
-	errUpdateUser := db.UpdateUserSubscriptionDetails( // Используем db
-		currentUser.ID, gatewaySubscriptionID, customerIDStr, models.SubscriptionStatusCanceled,
-		sub.StartDate, sub.EndDate, sub.CurrentPeriodEnd,
-	)
-	if errUpdateUser != nil {
-		slog.Error("Ошибка обновления статуса подписки в таблице users при отмене", "userID", currentUser.ID, "error", errUpdateUser)
+	slog.Info("Автопродление подписки успешно отменено для пользователя", "userID", currentUser.ID, "subscriptionID", gatewaySubscriptionID, "status", sub.Status)
+	if sub.Status == models.SubscriptionStatusPastDue {
+		bh.AppHandlers.SessionManager.Put(r.Context(), "flash_success", "Подписка отменена.")
+	} else {
+		bh.AppHandlers.SessionManager.Put(r.Context(), "flash_success", "Автоматическое продление вашей подписки было успешно отменено. Доступ сохранится до конца оплаченного периода.")
 	}
-
-	slog.Info("Автопродление подписки успешно отменено для пользователя", "userID", currentUser.ID, "subscriptionID", gatewaySubscriptionID)
-	bh.AppHandlers.SessionManager.Put(r.Context(), "flash_success", "Автоматическое продление вашей подписки было успешно отменено. Доступ сохранится до конца оплаченного периода.")
 	http.Redirect(w, r, "/profile", http.StatusSeeOther)
-}
\ No newline at end of file
+}
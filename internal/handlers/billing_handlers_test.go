@@ -0,0 +1,405 @@
+// internal/handlers/billing_handlers_test.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/google/uuid"
+
+	"shaman-ai.kz/internal/billing"
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/middleware"
+	"shaman-ai.kz/internal/models"
+	paymentgateway "shaman-ai.kz/internal/payment_gateway"
+	"shaman-ai.kz/internal/payment_gateway/bcc"
+	"shaman-ai.kz/internal/payment_gateway/sandbox"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+// fakeBCC serves the part of the BCC API used by the checkout: order creation and order status
+type fakeBCC struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	orders  map[string]*bcc.Order
+	created []bcc.CreateOrderRequest
+}
+
+func newFakeBCC(t *testing.T) *fakeBCC {
+	t.Helper()
+	f := &fakeBCC{orders: make(map[string]*bcc.Order)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /orders/create", f.createOrder)
+	mux.HandleFunc("GET /orders/{id}", f.orderStatus)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeBCC) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if login, password, ok := r.BasicAuth(); !ok || login != "merchant" || password != "merchant-password" {
+		http.Error(w, `{"failure_type": "auth"}`, http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (f *fakeBCC) createOrder(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+	var req bcc.CreateOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.created = append(f.created, req)
+	order := &bcc.Order{
+		ID:              "bcc-" + uuid.NewString(), // Unique across runs: orders are kept in the shared test DB
+		Status:          bcc.OrderStatusNew,
+		Amount:          fmt.Sprintf("%.2f", req.Amount),
+		MerchantOrderID: req.MerchantOrderID,
+		Currency:        req.Currency,
+		Description:     req.Description,
+	}
+	f.orders[order.ID] = order
+	f.mu.Unlock()
+
+	w.Header().Set("Location", "https://pay.bcc.test/"+order.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(bcc.OrderResponse{Orders: []bcc.Order{*order}})
+}
+
+func (f *fakeBCC) orderStatus(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+	f.mu.Lock()
+	order, ok := f.orders[r.PathValue("id")]
+	var resp bcc.OrderResponse
+	if ok {
+		resp.Orders = []bcc.Order{*order}
+	}
+	f.mu.Unlock()
+	if !ok {
+		http.Error(w, `{"failure_type": "not_found"}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// update changes the order as the bank would after the customer pays
+func (f *fakeBCC) update(orderID string, change func(order *bcc.Order)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	change(f.orders[orderID])
+}
+
+func (f *fakeBCC) lastCreated() bcc.CreateOrderRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.created[len(f.created)-1]
+}
+
+// billingTestEnv - billing handlers wired like in cmd/server, with BCC replaced by fakeBCC
+type billingTestEnv struct {
+	bank     *fakeBCC
+	sandbox  *sandbox.Gateway // Notifies the application served at its webhook URL
+	handlers *BillingHandlers
+	sessions *scs.SessionManager
+	user     *models.User
+}
+
+func newBillingTestEnv(t *testing.T) *billingTestEnv {
+	t.Helper()
+	db.OpenTestDB(t)
+	bank := newFakeBCC(t)
+
+	cfg := &config.Config{}
+	cfg.Billing.Gateway = bcc.Name
+	cfg.Billing.Currency = "KZT"
+	cfg.Billing.WebhookSecret = testWebhookSecret
+	cfg.Billing.Webhooks = config.PaymentWebhookConfig{MaxAttempts: 3, RetryIntervalSeconds: 1}
+	cfg.BCCGateway = config.BCCGatewayConfig{BaseURL: bank.URL, Login: "merchant", Password: "merchant-password",
+		ReturnURL: "https://shaman.test/billing/success"}
+
+	env := &billingTestEnv{bank: bank, sessions: scs.New()}
+	app := http.NewServeMux()
+	app.HandleFunc("POST /api/billing/webhook/{gateway}", func(w http.ResponseWriter, r *http.Request) {
+		env.sessions.LoadAndSave(http.HandlerFunc(env.handlers.PaymentWebhookHandler)).ServeHTTP(w, r)
+	})
+	appServer := httptest.NewServer(app)
+	t.Cleanup(appServer.Close)
+	env.sandbox = sandbox.New(config.PaymentSandboxConfig{WebhookDelaySeconds: 1}, cfg.Billing.WebhookSecret, appServer.URL+"/api/billing/webhook/"+sandbox.Name)
+
+	gateways := paymentgateway.NewRegistry(cfg.Billing.Gateway)
+	gateways.Register(bcc.NewGateway(bcc.NewClient(cfg.BCCGateway.BaseURL, cfg.BCCGateway.Login, cfg.BCCGateway.Password), cfg.Billing.WebhookSecret))
+	gateways.Register(env.sandbox)
+	env.handlers = NewBillingHandlers(env.sessions, cfg, nil, billing.NewService(cfg, gateways))
+
+	user, err := db.GetUserByID(db.CreateTestUser(t))
+	if err != nil {
+		t.Fatalf("Failed to load user: %v", err)
+	}
+	env.user = user
+	return env
+}
+
+// serve runs a handler behind the session middleware, as the router does
+func (e *billingTestEnv) serve(handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.sessions.LoadAndSave(handler).ServeHTTP(rec, r)
+	return rec
+}
+
+// checkout starts a payment for the default plan through BCC
+func (e *billingTestEnv) checkout(t *testing.T) *models.Payment {
+	t.Helper()
+	payment, paymentURL := e.checkoutWith(t, "")
+	if paymentURL != "https://pay.bcc.test/"+payment.GatewayOrderID {
+		t.Fatalf("Unexpected payment URL %q for order %q", paymentURL, payment.GatewayOrderID)
+	}
+	return payment
+}
+
+// checkoutWith starts a payment for the default plan through the gateway the way the subscription page script does
+func (e *billingTestEnv) checkoutWith(t *testing.T, gateway string) (*models.Payment, string) {
+	t.Helper()
+	form := url.Values{"period": {"monthly"}}
+	if gateway != "" {
+		form.Set("gateway", gateway)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/billing/create-payment", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, e.user))
+
+	rec := e.serve(e.handlers.CreatePaymentLinkHandler, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Checkout returned %d: %s", rec.Code, rec.Body.String())
+	}
+	var created map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Bad checkout response: %v", err)
+	}
+	payment, err := db.GetPaymentByID(created["payment_id"])
+	if err != nil || payment == nil {
+		t.Fatalf("Payment %q not saved: %v", created["payment_id"], err)
+	}
+	return payment, created["payment_url"]
+}
+
+// returnFromBank opens the return URL the bank redirects the customer to and returns the redirect target
+func (e *billingTestEnv) returnFromBank(t *testing.T, returnURL string) string {
+	t.Helper()
+	rec := e.serve(e.handlers.PaymentSuccessPageHandler, httptest.NewRequest(http.MethodGet, returnURL, nil))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Return URL returned %d: %s", rec.Code, rec.Body.String())
+	}
+	return rec.Header().Get("Location")
+}
+
+// notify sends a BCC notification in the signed XML request format
+func (e *billingTestEnv) notify(orderID, status, secret string) int {
+	attrs := []bcc.Attribute{
+		{Name: "event_id", Value: "evt-" + orderID + "-" + status},
+		{Name: "order_id", Value: orderID},
+		{Name: "status", Value: status},
+	}
+	body, _ := xml.Marshal(bcc.Request{Attributes: attrs, Signature: bcc.Signature{Type: "sha256", Value: bcc.Sign(attrs, secret)}})
+	req := httptest.NewRequest(http.MethodPost, "/api/billing/webhook", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/xml")
+	return e.serve(e.handlers.PaymentWebhookHandler, req).Code
+}
+
+func (e *billingTestEnv) payment(t *testing.T, paymentID string) *models.Payment {
+	t.Helper()
+	payment, err := db.GetPaymentByID(paymentID)
+	if err != nil || payment == nil {
+		t.Fatalf("Failed to load payment %s: %v", paymentID, err)
+	}
+	return payment
+}
+
+func (e *billingTestEnv) reloadUser(t *testing.T) *models.User {
+	t.Helper()
+	user, err := db.GetUserByID(e.user.ID)
+	if err != nil {
+		t.Fatalf("Failed to load user: %v", err)
+	}
+	return user
+}
+
+func TestBCCCheckoutEndToEnd(t *testing.T) {
+	env := newBillingTestEnv(t)
+	plan, err := db.GetPlanBySlug(models.DefaultPlanSlug)
+	if err != nil || plan == nil {
+		t.Fatalf("Default plan not found: %v", err)
+	}
+
+	// Checkout: the payment waits for the bank, the order is created for its amount in tenge
+	payment := env.checkout(t)
+	if payment.Status != models.PaymentStatusProcessing || payment.GatewayName != bcc.Name || payment.Amount != plan.MonthlyPrice || payment.Currency != "KZT" {
+		t.Fatalf("Unexpected pending payment: %+v", payment)
+	}
+	order := env.bank.lastCreated()
+	if order.MerchantOrderID != payment.ID || order.Amount != float64(plan.MonthlyPrice)/100 || order.Currency != "KZT" {
+		t.Fatalf("Unexpected order request: %+v", order)
+	}
+	returnURL, err := url.Parse(order.Options.ReturnURL)
+	if err != nil || returnURL.Query().Get("payment_id") != payment.ID {
+		t.Fatalf("Return URL %q must carry the payment ID", order.Options.ReturnURL)
+	}
+
+	// The customer returns before the bank has charged the card: nothing is activated yet
+	if location := env.returnFromBank(t, returnURL.RequestURI()); location != "/profile" {
+		t.Fatalf("Expected the pending payment page, got redirect to %q", location)
+	}
+	if p := env.payment(t, payment.ID); p.Status != models.PaymentStatusProcessing {
+		t.Fatalf("Payment must stay processing, got %s", p.Status)
+	}
+	if user := env.reloadUser(t); user.SubscriptionStatus == models.SubscriptionStatusActive {
+		t.Fatal("Subscription activated before the bank confirmed the payment")
+	}
+
+	// The bank charges the card and notifies; the notification is checked against the order status
+	env.bank.update(payment.GatewayOrderID, func(o *bcc.Order) { o.Status = bcc.OrderStatusCharged })
+	if code := env.notify(payment.GatewayOrderID, bcc.OrderStatusCharged, "wrong-secret"); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a forged notification, got %d", code)
+	}
+	if code := env.notify(payment.GatewayOrderID, bcc.OrderStatusCharged, testWebhookSecret); code != http.StatusOK {
+		t.Fatalf("Expected 200 for the notification, got %d", code)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for env.payment(t, payment.ID).Status != models.PaymentStatusSuccess {
+		if time.Now().After(deadline) {
+			t.Fatalf("Notification not processed, payment is %s", env.payment(t, payment.ID).Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	user := env.reloadUser(t)
+	if user.SubscriptionStatus != models.SubscriptionStatusActive || user.CurrentPeriodEnd == nil {
+		t.Fatalf("Subscription not activated: %+v", user)
+	}
+	if want := time.Now().AddDate(0, 1, 0); user.CurrentPeriodEnd.Sub(want).Abs() > time.Hour {
+		t.Fatalf("Expected the period to end around %v, got %v", want, *user.CurrentPeriodEnd)
+	}
+	if userPlan, err := db.GetUserPlan(user.ID); err != nil || userPlan.Slug != plan.Slug {
+		t.Fatalf("Expected plan %s, got %+v, %v", plan.Slug, userPlan, err)
+	}
+
+	// A repeated notification and a late return change nothing
+	periodEnd := *user.CurrentPeriodEnd
+	if code := env.notify(payment.GatewayOrderID, bcc.OrderStatusCharged, testWebhookSecret); code != http.StatusOK {
+		t.Fatalf("Expected 200 for a repeated notification, got %d", code)
+	}
+	if location := env.returnFromBank(t, returnURL.RequestURI()); location != "/dashboard" {
+		t.Fatalf("Expected the dashboard after a confirmed payment, got %q", location)
+	}
+	if user := env.reloadUser(t); !user.CurrentPeriodEnd.Equal(periodEnd) {
+		t.Fatalf("The period was extended twice: %v, then %v", periodEnd, *user.CurrentPeriodEnd)
+	}
+}
+
+func TestBCCReturnRejectsMismatchedOrder(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(o *bcc.Order)
+	}{
+		{"amount", func(o *bcc.Order) { o.Amount = "1.00" }},
+		{"currency", func(o *bcc.Order) { o.Currency = "USD" }},
+		{"merchant order", func(o *bcc.Order) { o.MerchantOrderID = "pay_other" }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newBillingTestEnv(t)
+			payment := env.checkout(t)
+			env.bank.update(payment.GatewayOrderID, func(o *bcc.Order) {
+				o.Status = bcc.OrderStatusCharged
+				tc.change(o)
+			})
+
+			// The return URL is found by the bank order ID too, when the bank passes it instead of ours
+			if location := env.returnFromBank(t, "/billing/success?order_id="+payment.GatewayOrderID); location != "/billing/failure" {
+				t.Fatalf("Expected the failure page, got redirect to %q", location)
+			}
+			if p := env.payment(t, payment.ID); p.Status != models.PaymentStatusFailed {
+				t.Fatalf("Mismatched payment must fail, got %s", p.Status)
+			}
+			if user := env.reloadUser(t); user.SubscriptionStatus == models.SubscriptionStatusActive {
+				t.Fatal("Subscription activated by a mismatched order")
+			}
+		})
+	}
+}
+
+func TestSandboxCheckoutReturnWebhook(t *testing.T) {
+	env := newBillingTestEnv(t)
+
+	payment, paymentURL := env.checkoutWith(t, sandbox.Name)
+	if payment.Status != models.PaymentStatusProcessing || payment.GatewayName != sandbox.Name {
+		t.Fatalf("Unexpected pending payment: %+v", payment)
+	}
+
+	// The customer picks the delayed notification scenario on the sandbox pay page
+	payURL, err := url.Parse(paymentURL)
+	if err != nil || payURL.Path != sandbox.PayPath {
+		t.Fatalf("Unexpected payment URL %q", paymentURL)
+	}
+	form := url.Values{"order": {payURL.Query().Get("order")}, "scenario": {sandbox.ScenarioDelayedWebhook}}
+	req := httptest.NewRequest(http.MethodPost, sandbox.PayPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	env.sandbox.PayPageHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Pay page returned %d", rec.Code)
+	}
+	returnURL, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || returnURL.Query().Get("payment_id") != payment.ID {
+		t.Fatalf("Return URL %q must carry the payment ID", rec.Header().Get("Location"))
+	}
+
+	// The customer returns before the sandbox charges: the payment waits for the notification
+	if location := env.returnFromBank(t, returnURL.RequestURI()); location != "/profile" {
+		t.Fatalf("Expected the pending payment page, got redirect to %q", location)
+	}
+	if user := env.reloadUser(t); user.SubscriptionStatus == models.SubscriptionStatusActive {
+		t.Fatal("Subscription activated before the sandbox confirmed the payment")
+	}
+
+	// The signed notification arrives at /api/billing/webhook/sandbox and activates the subscription
+	deadline := time.Now().Add(10 * time.Second)
+	for env.payment(t, payment.ID).Status != models.PaymentStatusSuccess {
+		if time.Now().After(deadline) {
+			t.Fatalf("Notification not processed, payment is %s", env.payment(t, payment.ID).Status)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if user := env.reloadUser(t); user.SubscriptionStatus != models.SubscriptionStatusActive {
+		t.Fatalf("Subscription not activated: %+v", user)
+	}
+	if p := env.payment(t, payment.ID); p.GatewayOrderID != payURL.Query().Get("order") {
+		t.Fatalf("Payment matched to the wrong order: %+v", p)
+	}
+	var saved string
+	if err := db.DB.QueryRow(`SELECT COALESCE(recurring_token, '') FROM subscriptions WHERE id = ?`, env.payment(t, payment.ID).SubscriptionID).Scan(&saved); err != nil || saved == "" {
+		t.Fatalf("Expected the sandbox card saved for renewals: %q, %v", saved, err)
+	}
+}
@@ -9,34 +9,35 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"shaman-ai.kz/internal/config"
 	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/docextract"
+	"shaman-ai.kz/internal/guardrails"
+	"shaman-ai.kz/internal/history"
+	"shaman-ai.kz/internal/imageproc"
 	"shaman-ai.kz/internal/llm"
 	"shaman-ai.kz/internal/middleware"
+	"shaman-ai.kz/internal/models"
+	"shaman-ai.kz/internal/moderouter"
+	"shaman-ai.kz/internal/personas"
+	"shaman-ai.kz/internal/storage"
+	"shaman-ai.kz/internal/titlegen"
+	"shaman-ai.kz/internal/uploadcheck"
 
 	"github.com/google/uuid"
 )
 
 const maxUploadSize = 10 * 1024 * 1024 // 10 MB
 
-func DialogueWithFileHandler(appConfig *config.Config, shamanSystemPrompt string, generalSystemPrompt string) http.HandlerFunc {
-	if appConfig.UploadPath == "" {
-		slog.Error("Критическая ошибка: путь для загрузки файлов (UploadPath) не сконфигурирован!")
-	} else {
-		if err := os.MkdirAll(appConfig.UploadPath, os.ModePerm); err != nil {
-			slog.Error("Не удалось создать папку для загрузок при инициализации DialogueWithFileHandler", "path", appConfig.UploadPath, "error", err)
-		} else {
-			slog.Info("Директория для загрузки файлов проверена/создана", "path", appConfig.UploadPath)
-		}
-	}
+// Сколько токенов текста прикрепленного документа попадает в запрос к LLM
+const maxDocumentTokens = 3000
 
+func DialogueWithFileHandler(appConfig *config.Config, llmClient llm.Client, modeRouter moderouter.Router, personaRegistry *personas.Registry, historyBuilder *history.Builder, titleGenerator *titlegen.Generator, guard *guardrails.Guard, fileStore storage.Storage, uploadChecker *uploadcheck.Checker) http.HandlerFunc {
+	responder := &dialogueResponder{appConfig: appConfig, llmClient: llmClient, modeRouter: modeRouter, personaRegistry: personaRegistry, historyBuilder: historyBuilder, titleGenerator: titleGenerator, guard: guard}
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
@@ -83,53 +84,105 @@ func DialogueWithFileHandler(appConfig *config.Config, shamanSystemPrompt string
 			http.Error(w, "Доступ запрещен к данной сессии чата", http.StatusForbidden)
 			return
 		}
+		if sessionMeta.DeletedAt != nil {
+			http.Error(w, "Сессия чата удалена", http.StatusNotFound)
+			return
+		}
 
 		slog.Info("Получен запрос (возможно с файлом) от пользователя", "user_id", userID, "chat_uuid", chatSessionUUID, "prompt_length", len(userPrompt))
 
-		var uploadedFile multipart.File
 		var originalFilename string
-		var savedFilePath string
-		var fileType string
+		var savedFilePath string             // Временная копия загруженного файла, удаляется после ответа
+		var attachment *db.MessageAttachment // Запись о вложении, сохраняется вместе с обменом
 
 		file, header, errFile := r.FormFile("file")
 		if errFile == nil {
 			defer file.Close()
-			uploadedFile = file
+			if plan := userPlan(r, userID); plan != nil && !plan.AllowFileUploads {
+				slog.Info("Загрузка файла недоступна на тарифе пользователя", "userID", userID, "plan", plan.Slug)
+				http.Error(w, "Загрузка файлов недоступна на вашем тарифе.", http.StatusForbidden)
+				return
+			}
 			originalFilename = header.Filename
 
 			slog.Info("Получен файл", "filename", originalFilename, "size", header.Size, "mime_header", header.Header.Get("Content-Type"))
 
-			uploadDir := appConfig.UploadPath
-			if uploadDir == "" {
-				slog.Error("UploadPath не установлен в конфигурации, используется временный fallback './uploads_emergency'")
-				uploadDir = "./uploads_emergency"
-				_ = os.MkdirAll(uploadDir, os.ModePerm)
-			}
-
-			fileExtension := filepath.Ext(originalFilename)
-			newFilename := fmt.Sprintf("%d_%s%s", userID, uuid.NewString(), fileExtension)
-			savedFilePath = filepath.Join(uploadDir, newFilename)
-
-			dst, errCreate := os.Create(savedFilePath)
-			if errCreate != nil {
-				slog.Error("Не удалось создать файл на сервере", "path", savedFilePath, "error", errCreate)
+			upload, errSpool := storage.Spool(file)
+			if errSpool != nil {
+				slog.Error("Не удалось сохранить загруженный файл", "userID", userID, "error", errSpool)
 				http.Error(w, "Ошибка сервера при сохранении файла", http.StatusInternalServerError)
 				return
 			}
-			defer dst.Close()
+			defer upload.Remove()
+			savedFilePath = upload.Path
+
+			// До сохранения: тип по содержимому и расширение, архивы, антивирус.
+			// Изображения перекодируются без метаданных, поэтому хеш считаем заново.
+			checked, errCheck := uploadChecker.Check(r.Context(), upload.Path, originalFilename)
+			if errCheck != nil {
+				var rejectErr *uploadcheck.RejectError
+				if errors.As(errCheck, &rejectErr) {
+					rejectUpload(w, r, userID, originalFilename, upload, rejectErr)
+					return
+				}
+				slog.Error("Ошибка проверки загруженного файла", "userID", userID, "filename", originalFilename, "error", errCheck)
+				http.Error(w, "Ошибка сервера при обработке файла", http.StatusInternalServerError)
+				return
+			}
+			if checked.Sanitized {
+				if errHash := upload.Rehash(); errHash != nil {
+					slog.Error("Не удалось пересчитать хеш файла", "userID", userID, "error", errHash)
+					http.Error(w, "Ошибка сервера при обработке файла", http.StatusInternalServerError)
+					return
+				}
+			}
+			originalFilename = checked.Filename
+			storageKey := upload.Key()
+
+			// Повторная загрузка файла, который у пользователя уже есть, квоту не расходует
+			if quotaMB := appConfig.Storage.UserQuotaMB; quotaMB > 0 {
+				alreadyStored, errOwned := db.UserHasStorageKey(userID, storageKey)
+				if errOwned != nil {
+					slog.Error("Ошибка проверки вложений пользователя", "userID", userID, "error", errOwned)
+				}
+				if !alreadyStored {
+					used, errUsage := db.GetUserStorageUsage(userID)
+					if errUsage != nil {
+						slog.Error("Ошибка подсчета объема вложений пользователя", "userID", userID, "error", errUsage)
+					} else if used+upload.Size > quotaMB<<20 {
+						rejectUpload(w, r, userID, originalFilename, upload, &uploadcheck.RejectError{
+							Reason:  uploadcheck.ReasonQuotaExceeded,
+							Message: fmt.Sprintf("Превышен лимит хранилища файлов (%d МБ)", quotaMB),
+							Detail:  fmt.Sprintf("занято %d байт, файл %d байт", used, upload.Size),
+						})
+						return
+					}
+				}
+			}
 
-			if _, errCopy := io.Copy(dst, uploadedFile); errCopy != nil {
-				slog.Error("Не удалось скопировать содержимое файла", "path", savedFilePath, "error", errCopy)
+			// Если такой файл уже прикреплен к какому-либо сообщению, второй раз его не сохраняем
+			referenced, errRef := db.IsStorageKeyReferenced(storageKey)
+			if errRef != nil {
+				slog.Error("Ошибка проверки наличия файла в хранилище", "key", storageKey, "error", errRef)
+			}
+			if referenced {
+				slog.Info("Файл уже есть в хранилище", "key", storageKey, "size", upload.Size)
+			} else if errPut := storage.PutUpload(r.Context(), fileStore, upload); errPut != nil {
+				slog.Error("Не удалось сохранить файл в хранилище", "key", storageKey, "error", errPut)
 				http.Error(w, "Ошибка сервера при сохранении файла", http.StatusInternalServerError)
 				return
+			} else {
+				slog.Info("Файл сохранен в хранилище", "key", storageKey, "size", upload.Size)
 			}
-			slog.Info("Файл успешно сохранен", "path", savedFilePath)
 
-			contentType := header.Header.Get("Content-Type")
-			if strings.HasPrefix(contentType, "image/") {
-				fileType = "image"
-			} else if strings.Contains(contentType, "pdf") || strings.Contains(contentType, "document") || strings.Contains(contentType, "text") {
-				fileType = "document"
+			// Тип определен по содержимому: Content-Type от клиента может быть любым
+			slog.Info("Тип файла определен по содержимому", "filename", originalFilename, "mime", checked.MIMEType, "mime_header", header.Header.Get("Content-Type"))
+			attachment = &db.MessageAttachment{
+				OriginalName: originalFilename,
+				SHA256:       upload.SHA256,
+				StorageKey:   storageKey,
+				MIMEType:     checked.MIMEType,
+				Size:         upload.Size,
 			}
 
 		} else if !errors.Is(errFile, http.ErrMissingFile) {
@@ -138,153 +191,373 @@ func DialogueWithFileHandler(appConfig *config.Config, shamanSystemPrompt string
 			return
 		}
 
-		llmPrompt := userPrompt
-		if savedFilePath != "" {
-			if fileType == "image" {
-				llmPrompt += fmt.Sprintf("\n\n[Прикреплено изображение: %s. Опиши его или ответь на вопрос с его учетом.]", originalFilename)
-				slog.Warn("Обработка изображений для LLM не реализована в текущем API клиенте. Передан только текст.")
-			}
-			if fileType == "document" {
-				extractedText := ""
-				if strings.HasSuffix(strings.ToLower(originalFilename), ".txt") {
-					contentBytes, errRead := os.ReadFile(savedFilePath)
-					if errRead == nil {
-						extractedText = string(contentBytes)
-					} else {
-						slog.Error("Ошибка чтения текстового файла", "path", savedFilePath, "error", errRead)
-					}
-				} else {
-					slog.Warn("Извлечение текста из не-TXT документов не реализовано.", "filename", originalFilename)
-				}
+		responder.respond(w, r, userID, sessionMeta, userMessage{Prompt: userPrompt, FilePath: savedFilePath, Attachment: attachment})
+	}
+}
 
-				if extractedText != "" {
-					maxTextLength := 4000
-					if len(extractedText) > maxTextLength {
-						extractedText = extractedText[:maxTextLength] + "...\n[текст документа был сокращен]"
-					}
-					llmPrompt += fmt.Sprintf("\n\n[Извлеченный текст из документа '%s']:\n%s\n[/конец текста из документа]", originalFilename, extractedText)
-				} else if savedFilePath != "" {
-					llmPrompt += fmt.Sprintf("\n\n[Прикреплен документ: %s. Извлечение текста не удалось или не поддерживается для этого типа.]", originalFilename)
-				}
-			}
-		}
+// attachmentNote - пометка о файле, которая сохраняется в тексте сообщения пользователя
+func attachmentNote(filename string) string {
+	return fmt.Sprintf(" (Прикреплен файл: %s)", filename)
+}
 
-		currentSystemPrompt := generalSystemPrompt
-		if isShamanRequest(llmPrompt) {
-			currentSystemPrompt = shamanSystemPrompt
-			slog.Info("Активирован режим 'Шаман' для запроса (с файлом).", "userID", userID, "chat_uuid", chatSessionUUID)
+// userMessage - сообщение пользователя, на которое нужно ответить
+type userMessage struct {
+	Prompt     string                // Текст сообщения без пометки о файле
+	FilePath   string                // Локальная копия прикрепленного файла, пусто - без файла
+	Attachment *db.MessageAttachment // Запись о вложении, сохраняется вместе с обменом
+}
+
+// dialogueResponder отвечает на сообщение пользователя: выбирает режим, собирает историю текущей ветки,
+// запрашивает LLM, сохраняет обмен в конец ветки и учитывает токены. Общий для нового сообщения,
+// повторного ответа и отредактированного сообщения.
+type dialogueResponder struct {
+	appConfig       *config.Config
+	llmClient       llm.Client
+	modeRouter      moderouter.Router
+	personaRegistry *personas.Registry
+	historyBuilder  *history.Builder
+	titleGenerator  *titlegen.Generator
+	guard           *guardrails.Guard
+}
+
+// respond отправляет ответ (потоком или JSON) и возвращает ID сохраненного обмена; 0 - обмен не сохранен
+func (d *dialogueResponder) respond(w http.ResponseWriter, r *http.Request, userID int64, sessionMeta *db.ChatSessionMeta, msg userMessage) int64 {
+	appConfig, llmClient, personaRegistry := d.appConfig, d.llmClient, d.personaRegistry
+	chatSessionUUID := sessionMeta.UUID
+	userPrompt, savedFilePath, attachment := msg.Prompt, msg.FilePath, msg.Attachment
+	var originalFilename, fileType string
+	if attachment != nil {
+		originalFilename = attachment.OriginalName
+		if strings.HasPrefix(attachment.MIMEType, "image/") {
+			fileType = "image"
 		} else {
-			slog.Info("Активирован общий режим для запроса (с файлом).", "userID", userID, "chat_uuid", chatSessionUUID)
+			fileType = "document"
 		}
+	}
 
-		const historyLimit = 10
-		history, errHist := db.GetMessagesForChatSession(chatSessionUUID, historyLimit)
-		if errHist != nil {
-			slog.Error("Ошибка получения истории для сессии (с файлом)", "chat_uuid", chatSessionUUID, "userID", userID, "error", errHist)
-			history = []db.Message{}
+	llmPrompt := userPrompt
+	if savedFilePath != "" {
+		if fileType == "image" {
+			llmPrompt += fmt.Sprintf("\n\n[Прикреплено изображение: %s.]", originalFilename)
 		}
+		if fileType == "document" {
+			extractedText := ""
+			extracted, errExtract := docextract.ExtractFile(savedFilePath, originalFilename)
+			switch {
+			case errExtract == nil:
+				extractedText = extracted.Text
+			case errors.Is(errExtract, docextract.ErrUnsupported), errors.Is(errExtract, docextract.ErrNoText):
+				slog.Warn("Текст из документа не извлечен", "filename", originalFilename, "reason", errExtract)
+			default:
+				slog.Error("Ошибка извлечения текста из документа", "path", savedFilePath, "error", errExtract)
+			}
 
-		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(appConfig.RemoteLLM.RequestTimeoutSeconds+20)*time.Second)
-		defer cancel()
-
-		aiResponse, usage, errAI := llm.GenerateRemoteResponse(ctx, appConfig.RemoteLLM, currentSystemPrompt, history, llmPrompt)
-		if errAI != nil {
-			slog.Error("Ошибка при генерации ответа Remote LLM (с файлом)", "user_id", userID, "chat_uuid", chatSessionUUID, "error", errAI)
-			http.Error(w, fmt.Sprintf("Ошибка взаимодействия с ИИ: %v", errAI), http.StatusInternalServerError)
-			return
+			if extractedText != "" {
+				// Режем по границе символов и, по возможности, абзацев, а не посреди байтов UTF-8
+				if truncated, cut := docextract.Truncate(extractedText, maxDocumentTokens); cut {
+					extractedText = truncated + "...\n[текст документа был сокращен]"
+				}
+				llmPrompt += fmt.Sprintf("\n\n[Извлеченный текст из документа '%s']:\n%s\n[/конец текста из документа]", originalFilename, extractedText)
+			} else if savedFilePath != "" {
+				llmPrompt += fmt.Sprintf("\n\n[Прикреплен документ: %s. Извлечение текста не удалось или не поддерживается для этого типа.]", originalFilename)
+			}
 		}
-		slog.Info("Ответ от Remote LLM получен (с файлом)", "user_id", userID, "chat_uuid", chatSessionUUID, "response_length", len(aiResponse))
+	}
 
-		promptToSave := userPrompt
-		if originalFilename != "" {
-			promptToSave += fmt.Sprintf(" (Прикреплен файл: %s)", originalFilename)
+	plan := userPlan(r, userID)
+	var persona models.Persona
+	if sessionMeta.PersonaSlug != "" {
+		// Персона выбрана пользователем явно - автоматический выбор не нужен
+		persona = personaRegistry.Resolve(sessionMeta.PersonaSlug)
+		slog.Info("Выбран режим диалога", "userID", userID, "chat_uuid", chatSessionUUID, "mode", persona.Slug, "source", "user")
+	} else {
+		// Для выбора режима достаточно нескольких последних обменов
+		const routingHistoryTurns = 2
+		recent, errHist := db.GetMessagesForChatSession(chatSessionUUID, routingHistoryTurns)
+		if errHist != nil {
+			slog.Error("Ошибка получения истории для выбора режима", "chat_uuid", chatSessionUUID, "userID", userID, "error", errHist)
 		}
-		errSave := db.SaveChatMessage(userID, chatSessionUUID, promptToSave, aiResponse)
-		if errSave != nil {
-			slog.Error("Не удалось сохранить сообщение в БД (с файлом)", "user_id", userID, "chat_uuid", chatSessionUUID, "error", errSave)
+		decision, errRoute := d.modeRouter.Route(r.Context(), moderouter.Request{
+			SessionUUID: chatSessionUUID,
+			SessionMode: sessionMeta.Mode,
+			Prompt:      llmPrompt,
+			History:     recent,
+			Candidates:  routingCandidates(planPersonas(plan, personaRegistry.Active())),
+		})
+		if decision.Usage != nil {
+			if errToken := db.IncrementTokenUsage(userID, decision.Usage.PromptTokens, decision.Usage.CompletionTokens); errToken != nil {
+				slog.Error("Не удалось учесть токены выбора режима", "user_id", userID, "error", errToken)
+			}
 		}
-
-		// Инкрементируем счетчик токенов пользователя
-		if usage != nil {
-			errToken := db.IncrementTokenUsage(userID, usage.PromptTokens, usage.CompletionTokens)
-			if errToken != nil {
-				// Это не критичная ошибка для пользователя, но важная для нас, поэтому логируем
-				slog.Error("Не удалось обновить счетчик токенов для пользователя", "user_id", userID, "error", errToken)
+		if errRoute != nil {
+			slog.Error("Ошибка выбора режима диалога, используется общий режим", "userID", userID, "chat_uuid", chatSessionUUID, "error", errRoute)
+			decision = moderouter.Decision{Mode: moderouter.ModeGeneral}
+		}
+		persona = personaRegistry.Resolve(string(decision.Mode))
+		slog.Info("Выбран режим диалога", "userID", userID, "chat_uuid", chatSessionUUID, "mode", decision.Mode, "previous_mode", decision.PreviousMode,
+			"confidence", decision.Confidence, "source", decision.Source, "reason", decision.Reason)
+	}
+	if !planAllowsPersona(plan, persona.Slug) {
+		// Диалог начат в режиме, которого нет на текущем тарифе (например, после смены тарифа)
+		slog.Info("Режим недоступен на тарифе пользователя, используется общий режим", "userID", userID, "mode", persona.Slug, "plan", plan.Slug)
+		persona = personaRegistry.Default()
+	}
+	llmCfg := personas.LLMConfig(persona, appConfig.RemoteLLM)
+
+	// Проверка безопасности - до запроса к LLM, по тексту самого пользователя без вложений
+	turn := newGuardedTurn(d.guard, userID, sessionMeta, persona.Slug, userPrompt)
+	turn.checkInput(r.Context())
+
+	if fileType == "image" {
+		// Само изображение передаем, только если распознавание изображений есть на тарифе
+		// и выбранная модель указана в remote_llm.vision_models
+		imageSent := false
+		visionAllowed := plan == nil || plan.AllowVision
+		if vision, ok := llmClient.(llm.VisionChecker); ok && visionAllowed && vision.SupportsVision(llmCfg) {
+			imageURL, errImage := imageproc.DataURL(savedFilePath, appConfig.RemoteLLM.ImageMaxDimension, appConfig.RemoteLLM.ImageJPEGQuality)
+			if errImage != nil {
+				slog.Error("Не удалось подготовить изображение для LLM", "path", savedFilePath, "error", errImage)
 			} else {
-				slog.Info("Счетчик токенов успешно обновлен", "user_id", userID, "input_tokens", usage.PromptTokens, "output_tokens", usage.CompletionTokens)
+				llmCfg.Images = []string{imageURL}
+				imageSent = true
 			}
 		}
-
-		resp := DialogueResponse{
-			Response: aiResponse,
+		if imageSent {
+			llmPrompt += " Опиши его или ответь на вопрос с его учетом."
+		} else if !visionAllowed {
+			slog.Info("Изображение не передано в LLM, распознавание недоступно на тарифе", "userID", userID, "plan", plan.Slug)
+			llmPrompt += " Распознавание изображений недоступно на тарифе пользователя - сообщи об этом и ответь на текстовую часть вопроса."
+		} else {
+			slog.Info("Изображение не передано в LLM, модель не поддерживает изображения", "userID", userID, "mode", persona.Slug)
+			llmPrompt += " Текущая модель не может просмотреть изображение - сообщи об этом пользователю и ответь на текстовую часть вопроса."
 		}
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			slog.Error("Ошибка кодирования/отправки JSON-ответа (с файлом)", "user_id", userID, "error", err)
+	}
+
+	// Последние обмены в пределах бюджета токенов модели, более ранние - через резюме в системном промпте
+	hist, errHist := d.historyBuilder.Build(chatSessionUUID, llmCfg, personaRegistry.SystemPrompt(persona), llmPrompt)
+	if errHist != nil {
+		slog.Error("Ошибка получения истории для сессии (с файлом)", "chat_uuid", chatSessionUUID, "userID", userID, "error", errHist)
+	}
+	slog.Debug("История диалога собрана", "chat_uuid", chatSessionUUID, "budget", hist.Budget, "estimated_tokens", hist.EstimatedTokens,
+		"included_turns", hist.IncludedTurns, "dropped_turns", hist.DroppedTurns, "summary_used", hist.SummaryUsed)
+	if hist.SummaryRefresh != nil {
+		go chargeSummaryUsage(userID, chatSessionUUID, hist.SummaryRefresh)
+	}
+	currentSystemPrompt, dialogHistory := turn.systemPrompt(hist.SystemPrompt), hist.Messages
+
+	promptToSave := userPrompt
+	if originalFilename != "" {
+		promptToSave += attachmentNote(originalFilename)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(appConfig.RemoteLLM.RequestTimeoutSeconds+20)*time.Second)
+	defer cancel()
+
+	// Модель уточняется по ответу: при сбое основного endpoint отвечает резервный
+	origin := db.DialogueOrigin{PersonaSlug: persona.Slug, Model: llmCfg.ModelSpec}
+
+	if turn.inputBlocked() {
+		return d.respondWithNotice(w, r, userID, sessionMeta, turn, promptToSave, attachment, origin)
+	}
+	if wantsEventStream(r) {
+		return streamDialogueResponse(ctx, w, llmCfg, llmClient, d.titleGenerator, userID, sessionMeta, currentSystemPrompt, dialogHistory, llmPrompt, promptToSave, attachment, origin, turn)
+	}
+
+	aiResponse, usage, errAI := llmClient.GenerateRemoteResponse(ctx, llmCfg, currentSystemPrompt, dialogHistory, llmPrompt)
+	if errAI != nil {
+		slog.Error("Ошибка при генерации ответа Remote LLM (с файлом)", "user_id", userID, "chat_uuid", chatSessionUUID, "error", errAI)
+		// Текст ошибки провайдера пользователю не показываем, он остается только в логах
+		status, message := llmErrorResponse(errAI)
+		http.Error(w, message, status)
+		turn.record(0)
+		return 0
+	}
+	slog.Info("Ответ от Remote LLM получен (с файлом)", "user_id", userID, "chat_uuid", chatSessionUUID, "response_length", len(aiResponse))
+
+	// Сохраняется то, что видит пользователь: ответ с предупреждением или только предупреждение
+	aiResponse = turn.finalize(ctx, aiResponse)
+	dialogueID, errSave := saveDialogueTurn(d.titleGenerator, userID, sessionMeta, promptToSave, aiResponse, attachment, answeredBy(origin, usage))
+	if errSave != nil {
+		slog.Error("Не удалось сохранить сообщение в БД (с файлом)", "user_id", userID, "chat_uuid", chatSessionUUID, "error", errSave)
+	}
+	turn.record(dialogueID)
+
+	// Инкрементируем счетчик токенов пользователя
+	if usage != nil {
+		errToken := db.IncrementTokenUsage(userID, usage.PromptTokens, usage.CompletionTokens)
+		if errToken != nil {
+			// Это не критичная ошибка для пользователя, но важная для нас, поэтому логируем
+			slog.Error("Не удалось обновить счетчик токенов для пользователя", "user_id", userID, "error", errToken)
+		} else {
+			slog.Info("Счетчик токенов успешно обновлен", "user_id", userID, "model", usage.Model, "input_tokens", usage.PromptTokens, "output_tokens", usage.CompletionTokens)
 		}
 	}
-}
 
-type DialogueRequest struct {
-	Prompt          string `json:"prompt"`
-	ChatSessionUUID string `json:"chat_session_uuid"`
+	resp := DialogueResponse{
+		Response:   aiResponse,
+		DialogueID: dialogueID,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("Ошибка кодирования/отправки JSON-ответа (с файлом)", "user_id", userID, "error", err)
+	}
+	return dialogueID
 }
 
-type DialogueResponse struct {
-	Response string `json:"response"`
+// rejectUpload отвечает на отклоненную загрузку JSON-ошибкой с кодом причины и записывает ее в журнал
+func rejectUpload(w http.ResponseWriter, r *http.Request, userID int64, filename string, upload *storage.Upload, rejectErr *uploadcheck.RejectError) {
+	slog.Warn("Загрузка файла отклонена", "userID", userID, "filename", filename, "reason", rejectErr.Reason, "detail", rejectErr.Detail)
+	errAudit := db.CreateUploadAuditEntry(db.UploadAuditEntry{
+		UserID:       userID,
+		OriginalName: filename,
+		Size:         upload.Size,
+		SHA256:       upload.SHA256,
+		Reason:       rejectErr.Reason,
+		Detail:       rejectErr.Detail,
+		IPAddress:    middleware.ClientIP(r),
+	})
+	if errAudit != nil {
+		slog.Error("Не удалось записать отклоненную загрузку в журнал", "userID", userID, "error", errAudit)
+	}
+
+	status := http.StatusUnprocessableEntity
+	switch rejectErr.Reason {
+	case uploadcheck.ReasonExtensionNotAllowed, uploadcheck.ReasonTypeMismatch:
+		status = http.StatusUnsupportedMediaType
+	case uploadcheck.ReasonArchiveLimits, uploadcheck.ReasonQuotaExceeded:
+		status = http.StatusRequestEntityTooLarge
+	case uploadcheck.ReasonScanFailed:
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": rejectErr.Message, "reason": rejectErr.Reason})
 }
 
-var shamanKeywords = []string{
-	"здоровье", "симптом", "симптомы", "болит", "больно", "болезнь", "недомогание", "недуг",
-	"лечение", "лечить", "вылечить", "избавиться от", "помоги с", "проблема с",
-	"диагноз", "диагностика", "анализы", "обследование", "врач", "доктор", "медик", "медицина",
-	"клиника", "больница", "специалист", "рецепт", "таблетки", "лекарство",
-	"психосоматика", "гнм", "германская новая медицина", "хамер", "dhs", "сбп",
-	"биологический конфликт", "эмоциональная причина", "психолог", "психотерапевт", "психиатр",
-	"душа", "эмоции", "чувства", "переживания",
-	"аллергия", "астма", "давление", "мигрень", "бессонница", "депрессия", "апатия",
-	"тревога", "стресс", "паническая атака", "страх", "фобия",
-	"голова", "сердце", "желудок", "кишечник", "печень", "почки", "легкие", "бронхи",
-	"кожа", "сыпь", "зуд", "экзема", "псориаз",
-	"спина", "поясница", "шея", "сустав", "мышцы", "кость",
-	"ухо", "глаз", "нос", "горло", "зубы",
-	"свист", "шум", "звон", "онемение", "покалывание", "головокружение", "тошнота", "рвота",
-	"слабость", "усталость", "температура", "жар", "озноб", "кашель", "насморк",
-	"отек", "опухоль", "воспаление", "инфекция", "вирус", "бактерия",
-	"что со мной", "почему я так себя чувствую", "что это может быть", "из-за чего это",
-	"как мне быть", "что делать если",
+// answeredBy дополняет origin моделью, которая фактически ответила, если она известна
+func answeredBy(origin db.DialogueOrigin, usage *llm.Usage) db.DialogueOrigin {
+	if usage != nil && usage.Model != "" {
+		origin.Model = usage.Model
+	}
+	return origin
 }
 
-var personalIndicators = []string{
-	"у меня", "меня беспокоит", "я чувствую", "мои ", "мой ", "моя ", "мне ", "со мной",
-	"я страдаю", "я болею",
+// chargeSummaryUsage ждет фоновый пересчет резюме сессии и учитывает его токены в лимите пользователя
+func chargeSummaryUsage(userID int64, chatSessionUUID string, refresh <-chan *llm.Usage) {
+	usage := <-refresh
+	if usage == nil {
+		return
+	}
+	if errToken := db.IncrementTokenUsage(userID, usage.PromptTokens, usage.CompletionTokens); errToken != nil {
+		slog.Error("Не удалось учесть токены резюме сессии", "user_id", userID, "chat_uuid", chatSessionUUID, "error", errToken)
+		return
+	}
+	slog.Info("Токены резюме сессии учтены", "user_id", userID, "chat_uuid", chatSessionUUID, "model", usage.Model,
+		"input_tokens", usage.PromptTokens, "output_tokens", usage.CompletionTokens)
 }
 
-func isShamanRequest(prompt string) bool {
-	lowerPrompt := strings.ToLower(prompt)
-	for _, keyword := range shamanKeywords {
-		if strings.Contains(lowerPrompt, strings.ToLower(keyword)) {
-			return true
+// saveDialogueTurn сохраняет обмен и, если к сообщению был прикреплен файл, запись о вложении.
+// Если у диалога заголовок еще по дате создания, запускает генерацию заголовка.
+// Возвращает ID обмена; он заполнен, даже если не удалось сохранить вложение.
+func saveDialogueTurn(titleGenerator *titlegen.Generator, userID int64, session *db.ChatSessionMeta, promptToSave, aiResponse string, attachment *db.MessageAttachment, origin db.DialogueOrigin) (int64, error) {
+	dialogueID, err := db.SaveChatMessage(userID, session.UUID, promptToSave, aiResponse, origin)
+	if err != nil {
+		return 0, err
+	}
+	if session.TitleSource == db.TitleSourceDefault {
+		titleGenerator.Enqueue(session.UUID)
+	}
+	if attachment != nil {
+		attachment.DialogueID = dialogueID
+		if _, err := db.CreateMessageAttachment(attachment); err != nil {
+			return dialogueID, err
 		}
 	}
-	hasPersonalIndicator := false
-	for _, indicator := range personalIndicators {
-		if strings.Contains(lowerPrompt, strings.ToLower(indicator)) {
-			hasPersonalIndicator = true
-			break
+	return dialogueID, nil
+}
+
+// userPlan возвращает тариф пользователя: загруженный CheckTokenLimit или из БД. nil - тариф
+// не удалось получить, тогда возможности тарифа не ограничиваются.
+func userPlan(r *http.Request, userID int64) *models.Plan {
+	if plan := middleware.PlanFromContext(r.Context()); plan != nil {
+		return plan
+	}
+	plan, err := db.GetUserPlan(userID)
+	if err != nil {
+		slog.Error("Не удалось получить тариф пользователя", "userID", userID, "error", err)
+		return nil
+	}
+	return plan
+}
+
+// planAllowsPersona - режим доступен на тарифе. Режим шамана - возможность тарифа, остальные доступны всем.
+func planAllowsPersona(plan *models.Plan, slug string) bool {
+	return plan == nil || slug != personas.SlugShaman || plan.AllowShamanMode
+}
+
+// planPersonas оставляет персоны, доступные на тарифе
+func planPersonas(plan *models.Plan, list []models.Persona) []models.Persona {
+	allowed := make([]models.Persona, 0, len(list))
+	for _, p := range list {
+		if planAllowsPersona(plan, p.Slug) {
+			allowed = append(allowed, p)
 		}
 	}
-	if hasPersonalIndicator {
-		if strings.Contains(lowerPrompt, "проблем") || strings.Contains(lowerPrompt, "вопрос о здоровь") || strings.Contains(lowerPrompt, "самочувстви") {
-			return true
+	return allowed
+}
+
+// routingCandidates отбирает персоны, участвующие в автоматическом выборе режима
+func routingCandidates(active []models.Persona) []moderouter.Candidate {
+	candidates := []moderouter.Candidate{}
+	for _, p := range active {
+		if !p.AutoRoute || p.Slug == personas.SlugGeneral {
+			continue
 		}
+		candidates = append(candidates, moderouter.Candidate{
+			Mode:        moderouter.Mode(p.Slug),
+			Description: p.Description,
+			Keywords:    p.RoutingKeywords,
+		})
 	}
-	if strings.Contains(lowerPrompt, "помоги") && (strings.Contains(lowerPrompt, "здоровь") || strings.Contains(lowerPrompt, "симптом") || strings.Contains(lowerPrompt, "недуг")) {
-		return true
+	return candidates
+}
+
+// llmErrorResponse подбирает HTTP-статус и понятное пользователю сообщение для ошибки LLM
+func llmErrorResponse(err error) (int, string) {
+	switch {
+	case errors.Is(err, llm.ErrAllProvidersFailed):
+		return http.StatusServiceUnavailable, "Сервис ИИ временно недоступен. Попробуйте позже."
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "ИИ не ответил вовремя. Попробуйте позже."
+	default:
+		return http.StatusBadGateway, "Не удалось получить ответ от ИИ. Попробуйте позже."
 	}
-	return false
 }
 
+type DialogueRequest struct {
+	Prompt          string `json:"prompt"`
+	ChatSessionUUID string `json:"chat_session_uuid"`
+}
+
+type DialogueResponse struct {
+	Response   string `json:"response"`
+	DialogueID int64  `json:"dialogue_id,omitempty"` // ID сохраненного обмена
+}
+
+// Размер страницы списка диалогов: по умолчанию и наибольший, который может запросить клиент
+const (
+	defaultSessionPageSize = 30
+	maxSessionPageSize     = 100
+)
+
+// ChatSessionListResponse - страница списка диалогов. NextCursor пустой на последней странице.
+type ChatSessionListResponse struct {
+	Sessions   []db.ChatSessionMeta `json:"sessions"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// ListChatSessionsHandler возвращает диалоги пользователя постранично.
+// Параметры: filter (пусто, archived или deleted), cursor (next_cursor предыдущей страницы), limit.
 func ListChatSessionsHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -298,16 +571,35 @@ func ListChatSessionsHandler() http.HandlerFunc {
 			return
 		}
 
-		const sessionListLimit = 50
-		sessions, err := db.GetUserChatSessions(userID, sessionListLimit)
+		query := r.URL.Query()
+		filter := db.ChatSessionFilter(query.Get("filter"))
+		if filter != db.ChatSessionsActive && filter != db.ChatSessionsArchived && filter != db.ChatSessionsDeleted {
+			http.Error(w, "Параметр 'filter' может быть пустым, 'archived' или 'deleted'", http.StatusBadRequest)
+			return
+		}
+		var after *db.ChatSessionCursor
+		if cursor := query.Get("cursor"); cursor != "" {
+			var err error
+			if after, err = db.ParseChatSessionCursor(cursor); err != nil {
+				http.Error(w, "Некорректный параметр 'cursor'", http.StatusBadRequest)
+				return
+			}
+		}
+		limit := parseLimit(query.Get("limit"), defaultSessionPageSize, maxSessionPageSize)
+
+		sessions, next, err := db.GetUserChatSessions(userID, filter, after, limit)
 		if err != nil {
 			slog.Error("Ошибка получения списка сессий пользователя", "user_id", userID, "error", err)
 			http.Error(w, "Ошибка сервера при получении списка сессий", http.StatusInternalServerError)
 			return
 		}
 
+		resp := ChatSessionListResponse{Sessions: sessions}
+		if next != nil {
+			resp.NextCursor = next.String()
+		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(sessions)
+		json.NewEncoder(w).Encode(resp)
 	}
 }
 
@@ -330,8 +622,27 @@ func GetChatSessionMessagesHandler() http.HandlerFunc {
 			return
 		}
 
-		const messagesLimit = 200
-		messages, err := db.GetMessagesForChatSession(sessionUUID, messagesLimit)
+		sessionMeta, err := db.GetChatSessionMeta(sessionUUID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Сессия чата не найдена", http.StatusNotFound)
+				return
+			}
+			slog.Error("Ошибка получения метаданных сессии", "uuid", sessionUUID, "user_id", userID, "error", err)
+			http.Error(w, "Ошибка сервера при получении данных сессии", http.StatusInternalServerError)
+			return
+		}
+		if sessionMeta.UserID != userID {
+			slog.Warn("Попытка чтения чужой сессии чата", "user_id", userID, "session_owner_id", sessionMeta.UserID, "session_uuid", sessionUUID)
+			http.Error(w, "Доступ запрещен к данной сессии чата", http.StatusForbidden)
+			return
+		}
+		if sessionMeta.DeletedAt != nil {
+			http.Error(w, "Сессия чата удалена", http.StatusNotFound)
+			return
+		}
+
+		messages, err := chatSessionMessages(sessionUUID)
 		if err != nil {
 			slog.Error("Ошибка получения сообщений сессии", "uuid", sessionUUID, "user_id", userID, "error", err)
 			http.Error(w, "Ошибка сервера при получении сообщений сессии", http.StatusInternalServerError)
@@ -343,7 +654,50 @@ func GetChatSessionMessagesHandler() http.HandlerFunc {
 	}
 }
 
-func CreateNewChatSessionHandler() http.HandlerFunc {
+// chatSessionMessages возвращает сообщения текущей ветки диалога для показа в чате:
+// у сообщений пользователя заполнены вложения и варианты обмена, у ответов - оценка
+func chatSessionMessages(sessionUUID string) ([]db.Message, error) {
+	const messagesLimit = 200
+	messages, err := db.GetMessagesForChatSession(sessionUUID, messagesLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Вложения показываем у сообщений пользователя, к которым они были прикреплены
+	var dialogueIDs []int64
+	for _, msg := range messages {
+		if msg.Role == "user" {
+			dialogueIDs = append(dialogueIDs, msg.DialogueID)
+		}
+	}
+	attachments, err := db.GetAttachmentsForDialogues(dialogueIDs)
+	if err != nil {
+		slog.Error("Ошибка получения вложений сессии", "uuid", sessionUUID, "error", err)
+	}
+	alternatives, err := db.GetDialogueAlternatives(sessionUUID)
+	if err != nil {
+		slog.Error("Ошибка получения вариантов сообщений сессии", "uuid", sessionUUID, "error", err)
+	}
+	ratings, err := db.GetFeedbackRatings(dialogueIDs)
+	if err != nil {
+		slog.Error("Ошибка получения оценок ответов сессии", "uuid", sessionUUID, "error", err)
+	}
+	for i := range messages {
+		if messages[i].Role == "user" {
+			messages[i].Attachments = attachments[messages[i].DialogueID]
+			messages[i].Alternatives = alternatives[messages[i].DialogueID]
+		} else {
+			messages[i].Rating = ratings[messages[i].DialogueID]
+		}
+	}
+	return messages, nil
+}
+
+type CreateChatSessionRequest struct {
+	PersonaSlug string `json:"persona_slug"` // Пусто - режим выбирается автоматически
+}
+
+func CreateNewChatSessionHandler(personaRegistry *personas.Registry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
@@ -356,24 +710,59 @@ func CreateNewChatSessionHandler() http.HandlerFunc {
 			return
 		}
 
+		var req CreateChatSessionRequest
+		if r.Body != nil && r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+				http.Error(w, "Некорректный формат запроса", http.StatusBadRequest)
+				return
+			}
+		}
+		req.PersonaSlug = strings.TrimSpace(req.PersonaSlug)
+		if req.PersonaSlug != "" {
+			if _, ok := personaRegistry.Get(req.PersonaSlug); !ok {
+				http.Error(w, "Выбранный режим недоступен", http.StatusBadRequest)
+				return
+			}
+			if !planAllowsPersona(userPlan(r, userID), req.PersonaSlug) {
+				http.Error(w, "Выбранный режим недоступен на вашем тарифе", http.StatusForbidden)
+				return
+			}
+		}
+
 		newUUID := uuid.NewString()
 		initialTitle := "Новый диалог от " + time.Now().Format("02.01.06 15:04")
 
-		err := db.CreateChatSession(userID, newUUID, initialTitle)
+		err := db.CreateChatSession(userID, newUUID, initialTitle, req.PersonaSlug)
 		if err != nil {
 			slog.Error("Ошибка создания новой сессии в БД", "user_id", userID, "error", err)
 			http.Error(w, "Не удалось создать новую сессию", http.StatusInternalServerError)
 			return
 		}
 
-		slog.Info("Создана новая сессия чата", "user_id", userID, "session_uuid", newUUID)
+		slog.Info("Создана новая сессия чата", "user_id", userID, "session_uuid", newUUID, "persona", req.PersonaSlug)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"uuid":       newUUID,
-			"title":      initialTitle,
-			"created_at": time.Now(),
-			"updated_at": time.Now(),
+			"uuid":         newUUID,
+			"title":        initialTitle,
+			"persona_slug": req.PersonaSlug,
+			"created_at":   time.Now(),
+			"updated_at":   time.Now(),
 		})
 	}
-}
\ No newline at end of file
+}
+
+// ListPersonasHandler возвращает персоны, которые пользователь может выбрать для нового диалога на своем тарифе
+func ListPersonasHandler(personaRegistry *personas.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		userID, _ := r.Context().Value(middleware.UserIDContextKey).(int64)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(planPersonas(userPlan(r, userID), personaRegistry.Active())); err != nil {
+			slog.Error("Ошибка кодирования списка персон", "error", err)
+		}
+	}
+}
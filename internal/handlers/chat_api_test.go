@@ -0,0 +1,456 @@
+// internal/handlers/chat_api_test.go
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/guardrails"
+	"shaman-ai.kz/internal/history"
+	"shaman-ai.kz/internal/llm"
+	"shaman-ai.kz/internal/llm/fakellm"
+	"shaman-ai.kz/internal/middleware"
+	"shaman-ai.kz/internal/moderouter"
+	"shaman-ai.kz/internal/personas"
+	"shaman-ai.kz/internal/prompts"
+	"shaman-ai.kz/internal/titlegen"
+)
+
+// dialogueTestEnv - dialogue handlers wired like in cmd/server, with the LLM replaced by fakellm
+type dialogueTestEnv struct {
+	llm      *fakellm.Server
+	cfg      *config.Config
+	dialogue http.HandlerFunc
+	trial    http.HandlerFunc
+	userID   int64
+}
+
+// newDialogueTestEnv builds the handlers; configure, if set, adjusts the config before wiring
+func newDialogueTestEnv(t *testing.T, configure func(cfg *config.Config)) *dialogueTestEnv {
+	t.Helper()
+	db.OpenTestDB(t)
+
+	srv := fakellm.NewServer()
+	t.Cleanup(srv.Close)
+	cfg := &config.Config{RemoteLLM: srv.LLMConfig()}
+	cfg.Titles.Disabled = true
+	cfg.Guardrails.Disabled = true
+	cfg.History.DefaultTokenBudget = 4000
+	cfg.History.MaxTurns = 10
+	cfg.History.DisableSummary = true
+	if configure != nil {
+		configure(cfg)
+	}
+
+	llmClient, err := llm.NewRegistry(cfg.RemoteLLM)
+	if err != nil {
+		t.Fatalf("Failed to create LLM registry: %v", err)
+	}
+	guard, err := guardrails.New(cfg.Guardrails, llmClient, cfg.RemoteLLM)
+	if err != nil {
+		t.Fatalf("Failed to create guard: %v", err)
+	}
+	personaRegistry := personas.NewRegistry(prompts.NewProvider(cfg.RemoteLLM))
+	dialogue := DialogueWithFileHandler(cfg, llmClient, moderouter.New(cfg.ModeRouter, llmClient, cfg.RemoteLLM), personaRegistry,
+		history.NewBuilder(cfg.History, llmClient, cfg.RemoteLLM), titlegen.NewGenerator(cfg.Titles, llmClient, cfg.RemoteLLM), guard, nil, nil)
+
+	return &dialogueTestEnv{
+		llm:      srv,
+		cfg:      cfg,
+		dialogue: dialogue,
+		trial:    TrialDialogueHandler(cfg, llmClient, personaRegistry),
+		userID:   db.CreateTestUser(t),
+	}
+}
+
+func withRequestTimeout(seconds int) func(cfg *config.Config) {
+	return func(cfg *config.Config) { cfg.RemoteLLM.RequestTimeoutSeconds = seconds }
+}
+
+func (e *dialogueTestEnv) withUser(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), middleware.UserIDContextKey, e.userID))
+}
+
+// createSession creates a chat session through the API, as the chat page does before the first message
+func (e *dialogueTestEnv) createSession(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	CreateUserChatSessionHandler()(rec, e.withUser(httptest.NewRequest(http.MethodPost, "/api/chat-sessions", nil)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Session creation returned %d: %s", rec.Code, rec.Body.String())
+	}
+	var created map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil || created["session_uuid"] == "" {
+		t.Fatalf("Unexpected session creation response %q: %v", rec.Body.String(), err)
+	}
+	return created["session_uuid"]
+}
+
+// send posts a message to DialogueWithFileHandler the way the chat page does
+func (e *dialogueTestEnv) send(t *testing.T, sessionUUID, prompt string, stream bool) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("prompt", prompt)
+	_ = form.WriteField("chat_session_uuid", sessionUUID)
+	if stream {
+		_ = form.WriteField("stream", "true")
+	}
+	_ = form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/dialogue-with-file", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	e.dialogue(rec, e.withUser(req))
+	return rec
+}
+
+func (e *dialogueTestEnv) tokenUsage(t *testing.T) (int, int) {
+	t.Helper()
+	user, err := db.GetUserByID(e.userID)
+	if err != nil {
+		t.Fatalf("Failed to load user: %v", err)
+	}
+	return user.TokensUsedInputThisPeriod, user.TokensUsedOutputThisPeriod
+}
+
+func savedMessages(t *testing.T, sessionUUID string) []db.Message {
+	t.Helper()
+	messages, err := db.GetMessagesForChatSession(sessionUUID, 10)
+	if err != nil {
+		t.Fatalf("Failed to load messages: %v", err)
+	}
+	return messages
+}
+
+type sseEvent struct {
+	Name string
+	Data string
+}
+
+func parseSSE(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.Name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.Data = strings.TrimPrefix(line, "data: ")
+		case line == "" && current.Name != "":
+			events = append(events, current)
+			current = sseEvent{}
+		}
+	}
+	return events
+}
+
+func TestDialogueHandlerPlainReply(t *testing.T) {
+	env := newDialogueTestEnv(t, nil)
+	sessionUUID := env.createSession(t)
+	env.llm.Enqueue(fakellm.Response{Content: "Здравствуйте! Чем помочь?", PromptTokens: 120, CompletionTokens: 30})
+
+	rec := env.send(t, sessionUUID, "Привет", false)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp DialogueResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Response != "Здравствуйте! Чем помочь?" || resp.DialogueID == 0 {
+		t.Fatalf("Unexpected response: %+v", resp)
+	}
+
+	requests := env.llm.Requests()
+	if len(requests) != 1 || requests[0].LastUserMessage() != "Привет" || requests[0].Stream {
+		t.Fatalf("Unexpected LLM requests: %+v", requests)
+	}
+	messages := savedMessages(t, sessionUUID)
+	if len(messages) != 2 || messages[0].Content != "Привет" || messages[1].Content != resp.Response || messages[1].DialogueID != resp.DialogueID {
+		t.Fatalf("Unexpected saved messages: %+v", messages)
+	}
+	if in, out := env.tokenUsage(t); in != 120 || out != 30 {
+		t.Fatalf("Expected token usage 120/30, got %d/%d", in, out)
+	}
+
+	// Second message: the history goes to the LLM and the counters accumulate
+	env.llm.Enqueue(fakellm.Response{Content: "Второй ответ", PromptTokens: 200, CompletionTokens: 10})
+	if rec := env.send(t, sessionUUID, "Еще вопрос", false); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if last := env.llm.Requests()[1]; len(last.Messages) < 3 {
+		t.Fatalf("Expected previous exchange in the LLM request, got %+v", last.Messages)
+	}
+	if in, out := env.tokenUsage(t); in != 320 || out != 40 {
+		t.Fatalf("Expected token usage 320/40, got %d/%d", in, out)
+	}
+}
+
+func TestDialogueHandlerStreamedReply(t *testing.T) {
+	env := newDialogueTestEnv(t, nil)
+	sessionUUID := env.createSession(t)
+	const answer = "Потоковый ответ по частям"
+	env.llm.Enqueue(fakellm.Response{Content: answer, ChunkRunes: 5, PromptTokens: 80, CompletionTokens: 12})
+
+	rec := env.send(t, sessionUUID, "Расскажи", true)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("Expected SSE response, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	var streamed strings.Builder
+	var done *DialogueResponse
+	for _, event := range parseSSE(t, rec.Body.String()) {
+		switch event.Name {
+		case "delta":
+			var delta map[string]string
+			if err := json.Unmarshal([]byte(event.Data), &delta); err != nil {
+				t.Fatalf("Bad delta event %q: %v", event.Data, err)
+			}
+			streamed.WriteString(delta["content"])
+		case "done":
+			done = &DialogueResponse{}
+			if err := json.Unmarshal([]byte(event.Data), done); err != nil {
+				t.Fatalf("Bad done event %q: %v", event.Data, err)
+			}
+		default:
+			t.Fatalf("Unexpected event %q: %s", event.Name, event.Data)
+		}
+	}
+	if streamed.String() != answer {
+		t.Fatalf("Expected streamed %q, got %q", answer, streamed.String())
+	}
+	if done == nil || done.Response != answer || done.DialogueID == 0 {
+		t.Fatalf("Unexpected done event: %+v", done)
+	}
+
+	if requests := env.llm.Requests(); len(requests) != 1 || !requests[0].Stream {
+		t.Fatalf("Expected one streaming LLM request, got %+v", requests)
+	}
+	if messages := savedMessages(t, sessionUUID); len(messages) != 2 || messages[1].Content != answer {
+		t.Fatalf("Unexpected saved messages: %+v", messages)
+	}
+	if in, out := env.tokenUsage(t); in != 80 || out != 12 {
+		t.Fatalf("Expected token usage 80/12, got %d/%d", in, out)
+	}
+}
+
+func TestDialogueHandlerChargesModeClassifier(t *testing.T) {
+	env := newDialogueTestEnv(t, func(cfg *config.Config) {
+		cfg.ModeRouter = config.ModeRouterConfig{Strategy: "llm", MinConfidence: 0.6, TimeoutSeconds: 5, SwitchConfidence: 0.8}
+	})
+	sessionUUID := env.createSession(t)
+	env.llm.Enqueue(
+		fakellm.Response{Content: `{"label": "general", "confidence": 0.9}`, PromptTokens: 90, CompletionTokens: 6},
+		fakellm.Response{Content: "Ответ", PromptTokens: 120, CompletionTokens: 30},
+	)
+
+	if rec := env.send(t, sessionUUID, "Привет", false); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(env.llm.Requests()) != 2 {
+		t.Fatalf("Expected classifier and answer requests, got %d", len(env.llm.Requests()))
+	}
+	if in, out := env.tokenUsage(t); in != 210 || out != 36 {
+		t.Fatalf("Expected token usage 210/36 including the classifier, got %d/%d", in, out)
+	}
+}
+
+func TestDialogueHandlerChargesSessionSummary(t *testing.T) {
+	env := newDialogueTestEnv(t, func(cfg *config.Config) {
+		cfg.History.MaxTurns = 2
+		cfg.History.DisableSummary = false
+		cfg.History.SummaryTriggerTurns = 1
+		cfg.History.SummaryMaxTokens, cfg.History.SummaryInputBudget, cfg.History.SummaryTimeoutSeconds = 200, 6000, 5
+	})
+	sessionUUID := env.createSession(t)
+	env.llm.SetResponder(func(req fakellm.Request) fakellm.Response {
+		if len(req.Messages) > 0 && strings.Contains(req.Messages[0].Content, "резюме длинного диалога") {
+			return fakellm.Response{Content: "Резюме", PromptTokens: 70, CompletionTokens: 5}
+		}
+		return fakellm.Response{Content: "Ответ", PromptTokens: 100, CompletionTokens: 20}
+	})
+
+	// The fourth message leaves the first exchange outside the two-turn window and triggers the summary
+	for i := 0; i < 4; i++ {
+		if rec := env.send(t, sessionUUID, fmt.Sprintf("Вопрос %d", i+1), false); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	// The summary is computed in the background, its tokens are charged when it finishes
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		in, out := env.tokenUsage(t)
+		if in == 470 && out == 85 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected token usage 470/85 including the summary, got %d/%d", in, out)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestDialogueHandlerStreamBrokenMidway(t *testing.T) {
+	env := newDialogueTestEnv(t, nil)
+	sessionUUID := env.createSession(t)
+	env.llm.Enqueue(fakellm.Response{Content: "Начало ответа, которое оборвется", ChunkRunes: 6, FailAfterChunks: 2})
+
+	rec := env.send(t, sessionUUID, "Расскажи", true)
+	events := parseSSE(t, rec.Body.String())
+	if len(events) == 0 || events[len(events)-1].Name != "error" {
+		t.Fatalf("Expected the stream to end with an error event, got %+v", events)
+	}
+
+	// The received part is saved, and the tokens are charged by estimate because the stream had no usage
+	messages := savedMessages(t, sessionUUID)
+	if len(messages) != 2 || messages[1].Content != "Начало ответ" {
+		t.Fatalf("Expected the partial answer to be saved, got %+v", messages)
+	}
+	if in, out := env.tokenUsage(t); in == 0 || out != llm.EstimateTokens("Начало ответ") {
+		t.Fatalf("Expected estimated token usage, got %d/%d", in, out)
+	}
+}
+
+func TestDialogueHandlerStreamHeldForGuardrails(t *testing.T) {
+	env := newDialogueTestEnv(t, func(cfg *config.Config) {
+		cfg.Guardrails = config.GuardrailsConfig{Personas: []string{"*"}}
+	})
+	sessionUUID := env.createSession(t)
+
+	// doneEvent checks that nothing was streamed before the check and returns the done event
+	doneEvent := func(rec *httptest.ResponseRecorder) DialogueResponse {
+		t.Helper()
+		var done *DialogueResponse
+		for _, event := range parseSSE(t, rec.Body.String()) {
+			if event.Name != "done" {
+				t.Fatalf("Expected only the done event in a checked mode, got %q: %s", event.Name, event.Data)
+			}
+			done = &DialogueResponse{}
+			if err := json.Unmarshal([]byte(event.Data), done); err != nil {
+				t.Fatalf("Bad done event %q: %v", event.Data, err)
+			}
+		}
+		if done == nil {
+			t.Fatalf("No done event in %q", rec.Body.String())
+		}
+		return *done
+	}
+
+	const allowed = "Обычный ответ по частям"
+	env.llm.Enqueue(fakellm.Response{Content: allowed, ChunkRunes: 5})
+	if done := doneEvent(env.send(t, sessionUUID, "Расскажи", true)); done.Response != allowed {
+		t.Fatalf("Expected the checked answer %q, got %q", allowed, done.Response)
+	}
+
+	// A blocked answer never reaches the client, not even in part
+	const blocked = "Лекарства вам не нужны, не обращайтесь к врачу"
+	env.llm.Enqueue(fakellm.Response{Content: blocked, ChunkRunes: 5})
+	rec := env.send(t, sessionUUID, "Что делать с давлением?", true)
+	if strings.Contains(rec.Body.String(), "Лекарства") {
+		t.Fatalf("Blocked answer leaked into the stream: %s", rec.Body.String())
+	}
+	notice := guardrails.Notice([]guardrails.Category{guardrails.CategoryMedicalRefusal}, true, "Что делать с давлением?")
+	if done := doneEvent(rec); done.Response != notice {
+		t.Fatalf("Expected the block notice, got %q", done.Response)
+	}
+	if messages := savedMessages(t, sessionUUID); len(messages) != 4 || messages[3].Content != notice {
+		t.Fatalf("Expected the notice to be saved instead of the answer, got %+v", messages)
+	}
+}
+
+func TestDialogueHandlerLLMErrors(t *testing.T) {
+	env := newDialogueTestEnv(t, withRequestTimeout(1))
+	sessionUUID := env.createSession(t)
+
+	env.llm.Enqueue(fakellm.Response{StatusCode: http.StatusInternalServerError, ErrorMessage: "upstream failure"})
+	rec := env.send(t, sessionUUID, "Привет", false)
+	if rec.Code != http.StatusServiceUnavailable || strings.Contains(rec.Body.String(), "upstream failure") {
+		t.Fatalf("Expected 503 without provider details, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The provider does not answer within remote_llm.request_timeout_seconds
+	env.llm.Enqueue(fakellm.Response{Content: "слишком поздно", Delay: fakellm.Duration(1500 * time.Millisecond)})
+	rec = env.send(t, sessionUUID, "Привет", false)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 on timeout, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if messages := savedMessages(t, sessionUUID); len(messages) != 0 {
+		t.Fatalf("Expected nothing saved after failures, got %+v", messages)
+	}
+	if in, out := env.tokenUsage(t); in != 0 || out != 0 {
+		t.Fatalf("Expected no token usage after failures, got %d/%d", in, out)
+	}
+}
+
+func TestDialogueHandlerRejectsForeignSession(t *testing.T) {
+	env := newDialogueTestEnv(t, nil)
+	sessionUUID := env.createSession(t)
+	env.userID = db.CreateTestUser(t)
+
+	if rec := env.send(t, sessionUUID, "Привет", false); rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for another user's session, got %d", rec.Code)
+	}
+	if rec := env.send(t, "missing-session", "Привет", false); rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for unknown session, got %d", rec.Code)
+	}
+	if len(env.llm.Requests()) != 0 {
+		t.Fatal("LLM must not be called for rejected requests")
+	}
+}
+
+func TestTrialDialogueHandler(t *testing.T) {
+	env := newDialogueTestEnv(t, withRequestTimeout(2))
+	env.llm.Enqueue(
+		fakellm.Response{Content: "Пробный ответ"},
+		fakellm.Response{StatusCode: http.StatusServiceUnavailable},
+		fakellm.Response{Content: "слишком поздно", Delay: fakellm.Duration(1500 * time.Millisecond)},
+	)
+	trial := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		env.trial(rec, httptest.NewRequest(http.MethodPost, "/api/trial-dialogue", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := trial(`{"prompt": "Кто ты?"}`)
+	var resp DialogueResponse
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&resp) != nil || resp.Response != "Пробный ответ" {
+		t.Fatalf("Unexpected trial response %d: %s", rec.Code, rec.Body.String())
+	}
+	if requests := env.llm.Requests(); len(requests[0].Messages) != 2 || requests[0].Messages[0].Role != "system" {
+		t.Fatalf("Expected system prompt and the question only, got %+v", requests[0].Messages)
+	}
+
+	if rec := trial(`{"prompt": "Кто ты?"}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500 on LLM error, got %d", rec.Code)
+	}
+	// Trial requests get half of request_timeout_seconds
+	if rec := trial(`{"prompt": "Кто ты?"}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500 on timeout, got %d", rec.Code)
+	}
+	if rec := trial(`{"prompt": ""}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for empty prompt, got %d", rec.Code)
+	}
+	if len(env.llm.Requests()) != 3 {
+		t.Fatalf("Expected 3 LLM requests, got %d", len(env.llm.Requests()))
+	}
+	if in, out := env.tokenUsage(t); in != 0 || out != 0 {
+		t.Fatalf("Trial dialogue must not charge tokens, got %d/%d", in, out)
+	}
+}
@@ -0,0 +1,143 @@
+// internal/handlers/chat_guardrails.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/guardrails"
+)
+
+// Сколько символов сообщения или ответа сохраняется в журнале проверок
+const guardrailExcerptRunes = 2000
+
+// guardedTurn - проверки безопасности одного обмена: сообщение пользователя до запроса к LLM
+// и ответ модели после него. Для режимов без проверок все методы ничего не делают.
+type guardedTurn struct {
+	guard    *guardrails.Guard // nil - режим не проверяется
+	userID   int64
+	session  *db.ChatSessionMeta
+	persona  string
+	prompt   string
+	response string // Ответ модели до проверки
+	input    guardrails.Verdict
+	output   guardrails.Verdict
+}
+
+func newGuardedTurn(guard *guardrails.Guard, userID int64, session *db.ChatSessionMeta, personaSlug, prompt string) *guardedTurn {
+	t := &guardedTurn{userID: userID, session: session, persona: personaSlug, prompt: prompt}
+	if guard.Applies(personaSlug) {
+		t.guard = guard
+	}
+	return t
+}
+
+// checkInput проверяет сообщение пользователя до запроса к LLM
+func (t *guardedTurn) checkInput(ctx context.Context) {
+	if t.guard == nil {
+		return
+	}
+	t.input = t.guard.CheckInput(ctx, t.prompt)
+	if t.input.Triggered() {
+		slog.Warn("Сработала проверка безопасности сообщения", "user_id", t.userID, "chat_uuid", t.session.UUID, "mode", t.persona,
+			"action", t.input.Action, "categories", t.input.Categories, "rules", t.input.Rules)
+	}
+}
+
+// inputBlocked - сообщение не отправляется в LLM, пользователь сразу получает предупреждение
+func (t *guardedTurn) inputBlocked() bool {
+	return t.input.Action == guardrails.ActionBlock
+}
+
+// systemPrompt дополняет системный промпт указанием посоветовать экстренную помощь, если она может быть нужна
+func (t *guardedTurn) systemPrompt(base string) string {
+	return base + guardrails.SystemInstruction(t.input)
+}
+
+// checksOutput - ответ модели проверяется и может быть скрыт, поэтому его нельзя показывать до finalize
+func (t *guardedTurn) checksOutput() bool {
+	return t.guard != nil
+}
+
+// finalize проверяет ответ модели и возвращает текст, который увидит пользователь и который
+// сохранится в диалоге: ответ с предупреждением, только предупреждение или ответ без изменений
+func (t *guardedTurn) finalize(ctx context.Context, response string) string {
+	t.response = response
+	if t.guard == nil {
+		return response
+	}
+	if t.inputBlocked() {
+		return guardrails.Notice(t.input.Categories, false, t.prompt)
+	}
+	t.output = t.guard.CheckOutput(ctx, t.prompt, response)
+	if t.output.Triggered() {
+		slog.Warn("Сработала проверка безопасности ответа", "user_id", t.userID, "chat_uuid", t.session.UUID, "mode", t.persona,
+			"action", t.output.Action, "categories", t.output.Categories, "rules", t.output.Rules)
+	}
+	if !t.input.Triggered() && !t.output.Triggered() {
+		return response
+	}
+
+	found := append([]guardrails.Category{}, t.input.Categories...)
+	found = append(found, t.output.Categories...)
+	if t.output.Action == guardrails.ActionBlock {
+		return guardrails.Notice(found, true, t.prompt)
+	}
+	return response + "\n\n" + guardrails.Notice(found, false, t.prompt)
+}
+
+// record записывает сработавшие проверки в журнал для аудита. dialogueID 0 - обмен не сохранен.
+func (t *guardedTurn) record(dialogueID int64) {
+	for _, v := range []guardrails.Verdict{t.input, t.output} {
+		if !v.Triggered() {
+			continue
+		}
+		excerpt := t.prompt
+		if v.Stage == guardrails.StageOutput {
+			excerpt = t.response // Для скрытого ответа - сам ответ, иначе его не восстановить
+		}
+		categories := make([]string, 0, len(v.Categories))
+		for _, c := range v.Categories {
+			categories = append(categories, string(c))
+		}
+		// Ошибка уже записана в лог, на ответ пользователю она не влияет
+		_ = db.CreateGuardrailEvent(db.GuardrailEvent{
+			UserID:          t.userID,
+			ChatSessionUUID: t.session.UUID,
+			DialogueID:      dialogueID,
+			PersonaSlug:     t.persona,
+			Stage:           string(v.Stage),
+			Action:          string(v.Action),
+			Categories:      categories,
+			Rules:           v.Rules,
+			Sources:         v.Sources,
+			Excerpt:         guardrails.Excerpt(excerpt, guardrailExcerptRunes),
+		})
+	}
+}
+
+// respondWithNotice отвечает предупреждением без запроса к LLM, если сообщение заблокировано проверкой.
+// Обмен сохраняется, чтобы ответ остался в истории; токены не расходуются.
+func (d *dialogueResponder) respondWithNotice(w http.ResponseWriter, r *http.Request, userID int64, sessionMeta *db.ChatSessionMeta, turn *guardedTurn, promptToSave string, attachment *db.MessageAttachment, origin db.DialogueOrigin) int64 {
+	aiResponse := turn.finalize(r.Context(), "")
+	origin.Model = "" // Модель не отвечала
+	dialogueID, errSave := saveDialogueTurn(d.titleGenerator, userID, sessionMeta, promptToSave, aiResponse, attachment, origin)
+	if errSave != nil {
+		slog.Error("Не удалось сохранить заблокированное сообщение в БД", "user_id", userID, "chat_uuid", sessionMeta.UUID, "error", errSave)
+	}
+	turn.record(dialogueID)
+
+	resp := DialogueResponse{Response: aiResponse, DialogueID: dialogueID}
+	if wantsEventStream(r) {
+		_ = newSSEWriter(w).send("done", resp)
+		return dialogueID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("Ошибка кодирования/отправки JSON-ответа", "user_id", userID, "error", err)
+	}
+	return dialogueID
+}
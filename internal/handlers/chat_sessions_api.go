@@ -0,0 +1,298 @@
+// internal/handlers/chat_sessions_api.go
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/middleware"
+)
+
+// Наибольшая длина заголовка диалога (столбец chat_sessions.title - VARCHAR(255))
+const maxSessionTitleLength = 255
+
+// ChatSessionActionRequest - тело запросов на изменение диалога. Каждый обработчик читает только свои поля.
+type ChatSessionActionRequest struct {
+	UUID              string `json:"uuid"`
+	Title             string `json:"title"`
+	Pinned            *bool  `json:"pinned"`
+	Archived          *bool  `json:"archived"`
+	ExpiresInDays     int    `json:"expires_in_days"`    // Ссылка на диалог: срок действия, 0 - бессрочно
+	RedactAttachments bool   `json:"redact_attachments"` // Ссылка на диалог: скрыть вложения
+}
+
+// parseLimit разбирает параметр limit; пустое или некорректное значение заменяется на def, большое - на max
+func parseLimit(value string, def, max int) int {
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return def
+	}
+	if limit > max {
+		return max
+	}
+	return limit
+}
+
+// ownedChatSessionRequest разбирает POST-запрос на изменение диалога и проверяет, что диалог
+// принадлежит пользователю. При ошибке ответ уже отправлен и возвращается false.
+func ownedChatSessionRequest(w http.ResponseWriter, r *http.Request) (*ChatSessionActionRequest, *db.ChatSessionMeta, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return nil, nil, false
+	}
+
+	userID, ok := r.Context().Value(middleware.UserIDContextKey).(int64)
+	if !ok || userID == 0 {
+		http.Error(w, "Не авторизован", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	var req ChatSessionActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Некорректный формат запроса", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	if req.UUID == "" {
+		http.Error(w, "Параметр 'uuid' сессии чата обязателен", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	sessionMeta, err := db.GetChatSessionMeta(req.UUID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Сессия чата не найдена", http.StatusNotFound)
+			return nil, nil, false
+		}
+		slog.Error("Ошибка получения метаданных сессии", "uuid", req.UUID, "user_id", userID, "error", err)
+		http.Error(w, "Ошибка сервера при получении данных сессии", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if sessionMeta.UserID != userID {
+		slog.Warn("Попытка изменения чужой сессии чата", "user_id", userID, "session_owner_id", sessionMeta.UserID, "session_uuid", req.UUID)
+		http.Error(w, "Доступ запрещен к данной сессии чата", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return &req, sessionMeta, true
+}
+
+// writeUpdatedChatSession отвечает диалогом в состоянии после изменения
+func writeUpdatedChatSession(w http.ResponseWriter, sessionUUID string) {
+	sessionMeta, err := db.GetChatSessionMeta(sessionUUID)
+	if err != nil {
+		slog.Error("Ошибка получения метаданных сессии после изменения", "uuid", sessionUUID, "error", err)
+		http.Error(w, "Ошибка сервера при получении данных сессии", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessionMeta)
+}
+
+// RenameChatSessionHandler меняет заголовок диалога: {"uuid": "...", "title": "..."}
+func RenameChatSessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, sessionMeta, ok := ownedChatSessionRequest(w, r)
+		if !ok {
+			return
+		}
+		if sessionMeta.DeletedAt != nil {
+			http.Error(w, "Сессия чата удалена", http.StatusNotFound)
+			return
+		}
+		title := strings.Join(strings.FieldsFunc(req.Title, unicode.IsSpace), " ")
+		if title == "" {
+			http.Error(w, "Заголовок не может быть пустым", http.StatusBadRequest)
+			return
+		}
+		if utf8.RuneCountInString(title) > maxSessionTitleLength {
+			http.Error(w, "Заголовок слишком длинный", http.StatusBadRequest)
+			return
+		}
+
+		if err := db.RenameChatSession(req.UUID, title); err != nil {
+			http.Error(w, "Не удалось переименовать сессию", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Сессия чата переименована", "user_id", sessionMeta.UserID, "session_uuid", req.UUID)
+		writeUpdatedChatSession(w, req.UUID)
+	}
+}
+
+// PinChatSessionHandler закрепляет или открепляет диалог: {"uuid": "...", "pinned": true}
+func PinChatSessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, sessionMeta, ok := ownedChatSessionRequest(w, r)
+		if !ok {
+			return
+		}
+		if sessionMeta.DeletedAt != nil {
+			http.Error(w, "Сессия чата удалена", http.StatusNotFound)
+			return
+		}
+		if req.Pinned == nil {
+			http.Error(w, "Параметр 'pinned' обязателен", http.StatusBadRequest)
+			return
+		}
+
+		if err := db.SetChatSessionPinned(req.UUID, *req.Pinned); err != nil {
+			http.Error(w, "Не удалось обновить сессию", http.StatusInternalServerError)
+			return
+		}
+		writeUpdatedChatSession(w, req.UUID)
+	}
+}
+
+// ArchiveChatSessionHandler переносит диалог в архив или возвращает из него: {"uuid": "...", "archived": true}
+func ArchiveChatSessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, sessionMeta, ok := ownedChatSessionRequest(w, r)
+		if !ok {
+			return
+		}
+		if sessionMeta.DeletedAt != nil {
+			http.Error(w, "Сессия чата удалена", http.StatusNotFound)
+			return
+		}
+		if req.Archived == nil {
+			http.Error(w, "Параметр 'archived' обязателен", http.StatusBadRequest)
+			return
+		}
+
+		if err := db.SetChatSessionArchived(req.UUID, *req.Archived); err != nil {
+			http.Error(w, "Не удалось обновить сессию", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Изменено архивное состояние сессии чата", "user_id", sessionMeta.UserID, "session_uuid", req.UUID, "archived", *req.Archived)
+		writeUpdatedChatSession(w, req.UUID)
+	}
+}
+
+// DeleteChatSessionHandler переносит диалог в корзину: {"uuid": "..."}.
+// Сообщения и вложения остаются в БД, диалог можно восстановить.
+func DeleteChatSessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, sessionMeta, ok := ownedChatSessionRequest(w, r)
+		if !ok {
+			return
+		}
+		if sessionMeta.DeletedAt == nil {
+			if err := db.SetChatSessionDeleted(req.UUID, true); err != nil {
+				http.Error(w, "Не удалось удалить сессию", http.StatusInternalServerError)
+				return
+			}
+			slog.Info("Сессия чата перемещена в корзину", "user_id", sessionMeta.UserID, "session_uuid", req.UUID)
+		}
+		writeUpdatedChatSession(w, req.UUID)
+	}
+}
+
+// RestoreChatSessionHandler возвращает диалог из корзины: {"uuid": "..."}
+func RestoreChatSessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, sessionMeta, ok := ownedChatSessionRequest(w, r)
+		if !ok {
+			return
+		}
+		if sessionMeta.DeletedAt != nil {
+			if err := db.SetChatSessionDeleted(req.UUID, false); err != nil {
+				http.Error(w, "Не удалось восстановить сессию", http.StatusInternalServerError)
+				return
+			}
+			slog.Info("Сессия чата восстановлена из корзины", "user_id", sessionMeta.UserID, "session_uuid", req.UUID)
+		}
+		writeUpdatedChatSession(w, req.UUID)
+	}
+}
+
+// Сколько результатов поиска возвращается: по умолчанию и наибольшее количество
+const (
+	defaultSearchResults = 20
+	maxSearchResults     = 50
+)
+
+// Длина фрагмента текста вокруг найденного слова (в символах)
+const searchSnippetLength = 160
+
+// SearchDialoguesHandler ищет по своим диалогам пользователя: ?q=...&limit=...
+func SearchDialoguesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(middleware.UserIDContextKey).(int64)
+		if !ok || userID == 0 {
+			http.Error(w, "Не авторизован", http.StatusUnauthorized)
+			return
+		}
+
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		terms := db.SearchTerms(query)
+		if len(terms) == 0 {
+			http.Error(w, "Введите хотя бы одно слово длиной от 3 символов", http.StatusBadRequest)
+			return
+		}
+		limit := parseLimit(r.URL.Query().Get("limit"), defaultSearchResults, maxSearchResults)
+
+		results, err := db.SearchUserDialogues(userID, query, limit)
+		if err != nil {
+			slog.Error("Ошибка поиска по диалогам", "user_id", userID, "error", err)
+			http.Error(w, "Ошибка сервера при поиске", http.StatusInternalServerError)
+			return
+		}
+		for i := range results {
+			results[i].Snippet = searchSnippet(results[i].UserPrompt, results[i].AIResponse, terms)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+// searchSnippet вырезает фрагмент вокруг первого найденного слова: сначала в вопросе, затем в ответе.
+// Индекс ищет слова по началу, поэтому здесь достаточно поиска подстроки без учета регистра.
+func searchSnippet(userPrompt, aiResponse string, terms []string) string {
+	for _, text := range []string{userPrompt, aiResponse} {
+		runes := []rune(text)
+		lower := []rune(strings.Map(unicode.ToLower, text))
+		for _, term := range terms {
+			pos := runeIndex(lower, []rune(strings.Map(unicode.ToLower, term)))
+			if pos < 0 {
+				continue
+			}
+			start := max(pos-searchSnippetLength/3, 0)
+			end := min(start+searchSnippetLength, len(runes))
+			snippet := strings.Join(strings.Fields(string(runes[start:end])), " ")
+			if start > 0 {
+				snippet = "…" + snippet
+			}
+			if end < len(runes) {
+				snippet += "…"
+			}
+			return snippet
+		}
+	}
+	// Совпадение не нашлось поиском подстроки: показываем начало вопроса
+	runes := []rune(strings.Join(strings.Fields(userPrompt), " "))
+	if len(runes) > searchSnippetLength {
+		return string(runes[:searchSnippetLength]) + "…"
+	}
+	return string(runes)
+}
+
+func runeIndex(s, sub []rune) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if string(s[i:i+len(sub)]) == string(sub) {
+			return i
+		}
+	}
+	return -1
+}
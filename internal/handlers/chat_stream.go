@@ -0,0 +1,136 @@
+// internal/handlers/chat_stream.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/llm"
+	"shaman-ai.kz/internal/titlegen"
+)
+
+// wantsEventStream определяет, запросил ли клиент потоковый ответ (SSE)
+func wantsEventStream(r *http.Request) bool {
+	if r.FormValue("stream") == "true" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// sseWriter отправляет события Server-Sent Events клиенту
+type sseWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Отключаем буферизацию в nginx
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	// Генерация может идти дольше WriteTimeout сервера, снимаем дедлайн записи для этого ответа
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.Warn("Не удалось снять дедлайн записи для SSE-ответа", "error", err)
+	}
+	return &sseWriter{w: w, rc: rc}
+}
+
+func (s *sseWriter) send(event string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("ошибка кодирования SSE-события: %w", err)
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// keepAlive отправляет комментарий SSE, чтобы прокси не закрыли соединение, пока ответ не передается
+func (s *sseWriter) keepAlive() error {
+	if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// Как часто отправлять keepAlive, пока ответ накапливается до проверки
+const sseKeepAliveInterval = 15 * time.Second
+
+// streamDialogueResponse передает ответ LLM клиенту по мере генерации.
+// При отключении клиента контекст запроса отменяется, запрос к LLM прерывается,
+// а уже сгенерированная часть ответа все равно сохраняется в БД вместе с расходом токенов.
+// В режимах с проверками безопасности ответ не передается по частям: отправленный текст уже не отозвать,
+// поэтому клиент получает его одним событием только после проверки ответа целиком.
+// Возвращает ID сохраненного обмена; 0 - ответа нет и ничего не сохранено.
+func streamDialogueResponse(ctx context.Context, w http.ResponseWriter, llmCfg config.RemoteLLMConfig, llmClient llm.Client, titleGenerator *titlegen.Generator, userID int64, session *db.ChatSessionMeta, systemPrompt string, history []db.Message, llmPrompt, promptToSave string, attachment *db.MessageAttachment, origin db.DialogueOrigin, turn *guardedTurn) int64 {
+	sse := newSSEWriter(w)
+
+	held := turn.checksOutput()
+	lastWrite := time.Now()
+	aiResponse, usage, errAI := llmClient.GenerateRemoteResponseStream(ctx, llmCfg, systemPrompt, history, llmPrompt, func(delta string) error {
+		if !held {
+			return sse.send("delta", map[string]string{"content": delta})
+		}
+		if time.Since(lastWrite) < sseKeepAliveInterval {
+			return nil
+		}
+		lastWrite = time.Now()
+		return sse.keepAlive()
+	})
+	if errAI != nil {
+		slog.Error("Ошибка при потоковой генерации ответа Remote LLM", "user_id", userID, "chat_uuid", session.UUID, "received_length", len(aiResponse), "error", errAI)
+	} else {
+		slog.Info("Потоковый ответ от Remote LLM получен", "user_id", userID, "chat_uuid", session.UUID, "response_length", len(aiResponse))
+	}
+
+	var dialogueID int64
+	if aiResponse != "" {
+		// Если поток оборвался до последнего чанка, точного usage нет - учитываем оценку
+		if usage == nil {
+			usage = llm.EstimateUsage(systemPrompt, history, llmPrompt, aiResponse)
+			slog.Warn("Usage не получен из потока, используется оценка", "user_id", userID, "input_tokens", usage.PromptTokens, "output_tokens", usage.CompletionTokens)
+		}
+
+		// Проверяем и после отключения клиента: сохраненный ответ он увидит позже
+		aiResponse = turn.finalize(context.WithoutCancel(ctx), aiResponse)
+		var errSave error
+		if dialogueID, errSave = saveDialogueTurn(titleGenerator, userID, session, promptToSave, aiResponse, attachment, answeredBy(origin, usage)); errSave != nil {
+			slog.Error("Не удалось сохранить потоковое сообщение в БД", "user_id", userID, "chat_uuid", session.UUID, "error", errSave)
+		}
+	}
+	turn.record(dialogueID)
+	if usage != nil {
+		if errToken := db.IncrementTokenUsage(userID, usage.PromptTokens, usage.CompletionTokens); errToken != nil {
+			slog.Error("Не удалось обновить счетчик токенов для пользователя", "user_id", userID, "error", errToken)
+		} else {
+			slog.Info("Счетчик токенов успешно обновлен", "user_id", userID, "model", usage.Model, "input_tokens", usage.PromptTokens, "output_tokens", usage.CompletionTokens)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return dialogueID // Клиент отключился или истек таймаут - отправлять некому
+	}
+	if errAI != nil {
+		if held && aiResponse != "" {
+			// Сохраненную часть ответа показываем так же, как без проверок: до сообщения об ошибке
+			_ = sse.send("delta", map[string]string{"content": aiResponse})
+		}
+		_, message := llmErrorResponse(errAI)
+		_ = sse.send("error", map[string]string{"error": message})
+		return dialogueID
+	}
+	_ = sse.send("done", DialogueResponse{Response: aiResponse, DialogueID: dialogueID})
+	return dialogueID
+}
@@ -0,0 +1,217 @@
+// internal/handlers/dialogue_branches.go
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/guardrails"
+	"shaman-ai.kz/internal/history"
+	"shaman-ai.kz/internal/llm"
+	"shaman-ai.kz/internal/middleware"
+	"shaman-ai.kz/internal/moderouter"
+	"shaman-ai.kz/internal/personas"
+	"shaman-ai.kz/internal/storage"
+	"shaman-ai.kz/internal/titlegen"
+)
+
+// DialogueActionRequest - тело запросов к отдельному обмену диалога. Каждый обработчик читает только свои поля.
+type DialogueActionRequest struct {
+	DialogueID int64  `json:"dialogue_id"`
+	Prompt     string `json:"prompt"`  // Редактирование: новый текст сообщения
+	Rating     int    `json:"rating"`  // Оценка ответа: 1, -1 или 0 - снять оценку
+	Reason     string `json:"reason"`  // Оценка ответа: причина из db.FeedbackReasons
+	Comment    string `json:"comment"` // Оценка ответа: комментарий
+}
+
+// ownedDialogueRequest разбирает POST-запрос с ID обмена и проверяет, что обмен из диалога пользователя.
+// При ошибке ответ уже отправлен и возвращается false.
+func ownedDialogueRequest(w http.ResponseWriter, r *http.Request) (*DialogueActionRequest, *db.Dialogue, *db.ChatSessionMeta, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return nil, nil, nil, false
+	}
+
+	userID, ok := r.Context().Value(middleware.UserIDContextKey).(int64)
+	if !ok || userID == 0 {
+		http.Error(w, "Не авторизован", http.StatusUnauthorized)
+		return nil, nil, nil, false
+	}
+
+	var req DialogueActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Некорректный формат запроса", http.StatusBadRequest)
+		return nil, nil, nil, false
+	}
+	if req.DialogueID <= 0 {
+		http.Error(w, "Параметр 'dialogue_id' обязателен", http.StatusBadRequest)
+		return nil, nil, nil, false
+	}
+
+	dialogue, err := db.GetDialogue(req.DialogueID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Сообщение не найдено", http.StatusNotFound)
+			return nil, nil, nil, false
+		}
+		slog.Error("Ошибка получения обмена", "dialogue_id", req.DialogueID, "user_id", userID, "error", err)
+		http.Error(w, "Ошибка сервера при получении сообщения", http.StatusInternalServerError)
+		return nil, nil, nil, false
+	}
+	sessionMeta, err := db.GetChatSessionMeta(dialogue.ChatSessionUUID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Сессия чата не найдена", http.StatusNotFound)
+			return nil, nil, nil, false
+		}
+		slog.Error("Ошибка получения метаданных сессии", "uuid", dialogue.ChatSessionUUID, "user_id", userID, "error", err)
+		http.Error(w, "Ошибка сервера при получении данных сессии", http.StatusInternalServerError)
+		return nil, nil, nil, false
+	}
+	if sessionMeta.UserID != userID {
+		slog.Warn("Попытка изменения чужой сессии чата", "user_id", userID, "session_owner_id", sessionMeta.UserID, "session_uuid", sessionMeta.UUID, "dialogue_id", dialogue.ID)
+		http.Error(w, "Доступ запрещен к данной сессии чата", http.StatusForbidden)
+		return nil, nil, nil, false
+	}
+	if sessionMeta.DeletedAt != nil {
+		http.Error(w, "Сессия чата удалена", http.StatusNotFound)
+		return nil, nil, nil, false
+	}
+	return &req, dialogue, sessionMeta, true
+}
+
+// dialogueBrancher отвечает на сообщение заново в новой ветке, начинающейся на месте прежнего обмена
+type dialogueBrancher struct {
+	responder *dialogueResponder
+	appConfig *config.Config
+	fileStore storage.Storage
+}
+
+// fork ставит новую ветку на место обмена dialogue и отвечает на prompt. Файл, прикрепленный к прежнему
+// обмену, прикрепляется и к новому. Если ответ не сохранен, текущей снова становится прежняя ветка.
+// keepNote - prompt взят из прежнего обмена вместе с пометкой о файле.
+func (b *dialogueBrancher) fork(w http.ResponseWriter, r *http.Request, dialogue *db.Dialogue, sessionMeta *db.ChatSessionMeta, prompt string, keepNote bool) {
+	userID := sessionMeta.UserID
+	msg := userMessage{Prompt: prompt}
+
+	attachments, err := db.GetAttachmentsForDialogues([]int64{dialogue.ID})
+	if err != nil {
+		slog.Error("Ошибка получения вложений обмена", "dialogue_id", dialogue.ID, "user_id", userID, "error", err)
+		http.Error(w, "Ошибка сервера при получении сообщения", http.StatusInternalServerError)
+		return
+	}
+	if list := attachments[dialogue.ID]; len(list) > 0 {
+		previous := list[0]
+		content, _, errOpen := openAttachment(r.Context(), b.appConfig, b.fileStore, &previous)
+		if errOpen != nil {
+			// Отвечаем без файла; пометка о нем остается в тексте сообщения
+			slog.Warn("Файл вложения недоступен, ответ без него", "attachment_id", previous.ID, "dialogue_id", dialogue.ID, "error", errOpen)
+		} else {
+			upload, errSpool := storage.Spool(content)
+			content.Close()
+			if errSpool != nil {
+				slog.Error("Не удалось прочитать файл вложения", "attachment_id", previous.ID, "error", errSpool)
+				http.Error(w, "Ошибка сервера при чтении файла", http.StatusInternalServerError)
+				return
+			}
+			defer upload.Remove()
+			if keepNote {
+				msg.Prompt = strings.TrimSuffix(msg.Prompt, attachmentNote(previous.OriginalName))
+			}
+			msg.FilePath = upload.Path
+			// Новая запись о том же файле в хранилище: вложение принадлежит обмену
+			msg.Attachment = &db.MessageAttachment{
+				OriginalName: previous.OriginalName,
+				ServerPath:   previous.ServerPath,
+				SHA256:       previous.SHA256,
+				StorageKey:   previous.StorageKey,
+				MIMEType:     previous.MIMEType,
+				Size:         previous.Size,
+			}
+		}
+	}
+
+	previousLeaf, err := db.ForkDialogueBranch(dialogue)
+	if err != nil {
+		slog.Error("Не удалось создать ветку диалога", "dialogue_id", dialogue.ID, "user_id", userID, "error", err)
+		http.Error(w, "Ошибка сервера при создании ветки диалога", http.StatusInternalServerError)
+		return
+	}
+	if b.responder.respond(w, r, userID, sessionMeta, msg) == 0 {
+		if err := db.SelectDialogueBranch(sessionMeta.UUID, previousLeaf); err != nil {
+			slog.Error("Не удалось вернуть прежнюю ветку диалога", "chat_uuid", sessionMeta.UUID, "leaf_id", previousLeaf, "error", err)
+		}
+	}
+}
+
+func newDialogueBrancher(appConfig *config.Config, llmClient llm.Client, modeRouter moderouter.Router, personaRegistry *personas.Registry, historyBuilder *history.Builder, titleGenerator *titlegen.Generator, guard *guardrails.Guard, fileStore storage.Storage) *dialogueBrancher {
+	return &dialogueBrancher{
+		responder: &dialogueResponder{appConfig: appConfig, llmClient: llmClient, modeRouter: modeRouter, personaRegistry: personaRegistry, historyBuilder: historyBuilder, titleGenerator: titleGenerator, guard: guard},
+		appConfig: appConfig,
+		fileStore: fileStore,
+	}
+}
+
+// RegenerateDialogueHandler получает другой ответ на сообщение обмена dialogue_id. Прежний ответ
+// остается вариантом, к нему можно вернуться через /api/dialogue_branch.
+func RegenerateDialogueHandler(appConfig *config.Config, llmClient llm.Client, modeRouter moderouter.Router, personaRegistry *personas.Registry, historyBuilder *history.Builder, titleGenerator *titlegen.Generator, guard *guardrails.Guard, fileStore storage.Storage) http.HandlerFunc {
+	brancher := newDialogueBrancher(appConfig, llmClient, modeRouter, personaRegistry, historyBuilder, titleGenerator, guard, fileStore)
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, dialogue, sessionMeta, ok := ownedDialogueRequest(w, r)
+		if !ok {
+			return
+		}
+		slog.Info("Запрос повторного ответа", "user_id", sessionMeta.UserID, "chat_uuid", sessionMeta.UUID, "dialogue_id", dialogue.ID)
+		brancher.fork(w, r, dialogue, sessionMeta, dialogue.UserPrompt, true)
+	}
+}
+
+// EditDialogueHandler заменяет сообщение обмена dialogue_id новым текстом: диалог продолжается
+// в новой ветке с этого места, прежнее сообщение и все, что после него, остаются в своей ветке
+func EditDialogueHandler(appConfig *config.Config, llmClient llm.Client, modeRouter moderouter.Router, personaRegistry *personas.Registry, historyBuilder *history.Builder, titleGenerator *titlegen.Generator, guard *guardrails.Guard, fileStore storage.Storage) http.HandlerFunc {
+	brancher := newDialogueBrancher(appConfig, llmClient, modeRouter, personaRegistry, historyBuilder, titleGenerator, guard, fileStore)
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, dialogue, sessionMeta, ok := ownedDialogueRequest(w, r)
+		if !ok {
+			return
+		}
+		prompt := strings.TrimSpace(req.Prompt)
+		if prompt == "" {
+			http.Error(w, "Текст сообщения не может быть пустым", http.StatusBadRequest)
+			return
+		}
+		slog.Info("Запрос редактирования сообщения", "user_id", sessionMeta.UserID, "chat_uuid", sessionMeta.UUID, "dialogue_id", dialogue.ID, "prompt_length", len(prompt))
+		brancher.fork(w, r, dialogue, sessionMeta, prompt, false)
+	}
+}
+
+// SelectDialogueBranchHandler делает текущей ветку, проходящую через обмен dialogue_id,
+// и возвращает сообщения диалога в этой ветке
+func SelectDialogueBranchHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, dialogue, sessionMeta, ok := ownedDialogueRequest(w, r)
+		if !ok {
+			return
+		}
+		if err := db.SelectDialogueBranch(sessionMeta.UUID, dialogue.ID); err != nil {
+			slog.Error("Не удалось переключить ветку диалога", "chat_uuid", sessionMeta.UUID, "dialogue_id", dialogue.ID, "error", err)
+			http.Error(w, "Ошибка сервера при переключении ветки диалога", http.StatusInternalServerError)
+			return
+		}
+
+		messages, err := chatSessionMessages(sessionMeta.UUID)
+		if err != nil {
+			slog.Error("Ошибка получения сообщений сессии", "uuid", sessionMeta.UUID, "user_id", sessionMeta.UserID, "error", err)
+			http.Error(w, "Ошибка сервера при получении сообщений сессии", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+	}
+}
@@ -0,0 +1,151 @@
+// internal/handlers/export_handlers.go
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/export"
+	"shaman-ai.kz/internal/middleware"
+	"shaman-ai.kz/internal/personas"
+	"shaman-ai.kz/internal/storage"
+	"shaman-ai.kz/internal/timeutil"
+)
+
+// personaNameFunc возвращает название режима диалога для выгрузки
+func personaNameFunc(personaRegistry *personas.Registry) func(db.ChatSessionMeta) string {
+	return func(session db.ChatSessionMeta) string {
+		slug := export.PersonaSlug(session)
+		if slug == "" {
+			return ""
+		}
+		if persona, ok := personaRegistry.Get(slug); ok {
+			return persona.Name
+		}
+		return slug
+	}
+}
+
+// ExportChatSessionHandler выгружает диалог: /api/chat_sessions/{uuid}/export?format=md|json|pdf
+func ExportChatSessionHandler(personaRegistry *personas.Registry, pdfRenderer *export.PDFRenderer) http.HandlerFunc {
+	personaName := personaNameFunc(personaRegistry)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(middleware.UserIDContextKey).(int64)
+		if !ok || userID == 0 {
+			http.Error(w, "Не авторизован", http.StatusUnauthorized)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "md"
+		}
+		formatInfo, ok := export.Formats[format]
+		if !ok {
+			http.Error(w, "Параметр 'format' может быть md, json или pdf", http.StatusBadRequest)
+			return
+		}
+
+		sessionUUID := r.PathValue("uuid")
+		sessionMeta, err := db.GetChatSessionMeta(sessionUUID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Сессия чата не найдена", http.StatusNotFound)
+				return
+			}
+			slog.Error("Ошибка получения метаданных сессии", "uuid", sessionUUID, "user_id", userID, "error", err)
+			http.Error(w, "Ошибка сервера при получении данных сессии", http.StatusInternalServerError)
+			return
+		}
+		if sessionMeta.UserID != userID {
+			slog.Warn("Попытка выгрузить чужую сессию чата", "user_id", userID, "session_owner_id", sessionMeta.UserID, "session_uuid", sessionUUID)
+			http.Error(w, "Доступ запрещен к данной сессии чата", http.StatusForbidden)
+			return
+		}
+		if sessionMeta.DeletedAt != nil {
+			http.Error(w, "Сессия чата удалена", http.StatusNotFound)
+			return
+		}
+
+		conv, err := export.Load(sessionMeta, personaName(*sessionMeta))
+		if err != nil {
+			slog.Error("Ошибка чтения диалога для выгрузки", "uuid", sessionUUID, "user_id", userID, "error", err)
+			http.Error(w, "Ошибка сервера при выгрузке диалога", http.StatusInternalServerError)
+			return
+		}
+		// Выгрузка собирается целиком до отправки, чтобы при ошибке можно было ответить 500
+		var buf bytes.Buffer
+		if err := export.Write(&buf, conv, format, pdfRenderer); err != nil {
+			slog.Error("Ошибка формирования выгрузки диалога", "uuid", sessionUUID, "format", format, "error", err)
+			http.Error(w, "Ошибка сервера при выгрузке диалога", http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("Диалог выгружен", "user_id", userID, "session_uuid", sessionUUID, "format", format, "size", buf.Len())
+		w.Header().Set("Content-Type", formatInfo.ContentType)
+		setAttachmentDisposition(w, conv.Filename(formatInfo.Extension))
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(buf.Bytes())
+	}
+}
+
+// ExportUserDataHandler отдает zip-архив со всеми данными пользователя (переносимость данных):
+// профиль, все диалоги в Markdown и JSON и файлы вложений
+func ExportUserDataHandler(appConfig *config.Config, fileStore storage.Storage, personaRegistry *personas.Registry) http.HandlerFunc {
+	personaName := personaNameFunc(personaRegistry)
+	openForExport := func(ctx context.Context, a *db.MessageAttachment) (io.ReadCloser, error) {
+		content, _, err := openAttachment(ctx, appConfig, fileStore, a)
+		return content, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(middleware.UserIDContextKey).(int64)
+		if !ok || userID == 0 {
+			http.Error(w, "Не авторизован", http.StatusUnauthorized)
+			return
+		}
+		user, err := db.GetUserByID(userID)
+		if err != nil || user == nil {
+			slog.Error("Ошибка получения пользователя для выгрузки данных", "user_id", userID, "error", err)
+			http.Error(w, "Ошибка сервера при выгрузке данных", http.StatusInternalServerError)
+			return
+		}
+
+		// Архив может быть большим, поэтому передается по мере формирования;
+		// если запись прервется, клиент получит поврежденный архив, а ошибка останется в логе
+		w.Header().Set("Content-Type", "application/zip")
+		setAttachmentDisposition(w, "shaman-ai-export-"+time.Now().In(timeutil.Location).Format("2006-01-02")+".zip")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := export.WriteArchive(r.Context(), w, user, personaName, openForExport); err != nil {
+			slog.Error("Ошибка выгрузки данных пользователя", "user_id", userID, "error", err)
+			return
+		}
+		slog.Info("Данные пользователя выгружены", "user_id", userID)
+	}
+}
+
+func setAttachmentDisposition(w http.ResponseWriter, filename string) {
+	disposition := "attachment"
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); value != "" {
+		disposition = value
+	}
+	w.Header().Set("Content-Disposition", disposition)
+}
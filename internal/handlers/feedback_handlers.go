@@ -0,0 +1,64 @@
+// internal/handlers/feedback_handlers.go
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"shaman-ai.kz/internal/db"
+)
+
+// Наибольшая длина комментария к оценке ответа, в символах
+const maxFeedbackCommentLength = 2000
+
+// MessageFeedbackHandler сохраняет оценку ответа обмена dialogue_id: rating 1 или -1 с причиной
+// и комментарием, rating 0 снимает оценку. Повторная оценка заменяет прежнюю.
+func MessageFeedbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, dialogue, sessionMeta, ok := ownedDialogueRequest(w, r)
+		if !ok {
+			return
+		}
+
+		if req.Rating == 0 {
+			if err := db.DeleteMessageFeedback(dialogue.ID); err != nil {
+				http.Error(w, "Ошибка сервера при сохранении оценки", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if req.Rating != db.FeedbackRatingUp && req.Rating != db.FeedbackRatingDown {
+			http.Error(w, "Параметр 'rating' может быть 1, -1 или 0", http.StatusBadRequest)
+			return
+		}
+		if _, known := db.FeedbackReasons[req.Reason]; req.Reason != "" && !known {
+			http.Error(w, "Неизвестная причина оценки", http.StatusBadRequest)
+			return
+		}
+		comment := strings.TrimSpace(req.Comment)
+		if utf8.RuneCountInString(comment) > maxFeedbackCommentLength {
+			http.Error(w, "Комментарий слишком длинный", http.StatusBadRequest)
+			return
+		}
+
+		feedback := &db.MessageFeedback{
+			DialogueID: dialogue.ID,
+			UserID:     sessionMeta.UserID,
+			Rating:     req.Rating,
+			Reason:     req.Reason,
+			Comment:    comment,
+		}
+		if err := db.SaveMessageFeedback(feedback); err != nil {
+			http.Error(w, "Ошибка сервера при сохранении оценки", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Оценка ответа сохранена", "user_id", sessionMeta.UserID, "dialogue_id", dialogue.ID, "rating", req.Rating, "reason", req.Reason)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(feedback)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"shaman-ai.kz/internal/db"
 	"shaman-ai.kz/internal/middleware"
 	"shaman-ai.kz/internal/models"
+	"shaman-ai.kz/internal/utils"
 	"strings"
 	"time"
 
@@ -64,6 +65,22 @@ type PageData struct {
 	LaunchDate                 string 
 	TokenUsageWarning          string
 	ShowResendVerificationLink bool
+	Personas                   []models.Persona
+	EditingPersona             *models.Persona
+	PromptKey                  string
+	PromptKeys                 []string
+	PromptRevisions            []db.PromptRevision
+	PromptDiff                 []utils.DiffLine
+	SharedChat                 *SharedChatView
+	FeedbackItems              []db.FeedbackItem
+	FeedbackModels             []string
+	FeedbackReasons            map[string]string
+	GuardrailEvents            []db.GuardrailEvent
+	PaymentEvents              []db.PaymentEvent
+	Plans                      []models.Plan
+	EditingPlan                *models.Plan
+	CurrentPlan                *models.Plan // Тариф подписки пользователя
+	TrialAvailable             bool         // Пользователь может оформить пробный период
 }
 
 type AppHandlers struct {
@@ -383,14 +400,15 @@ func (h *AppHandlers) DashboardPageHandler(w http.ResponseWriter, r *http.Reques
 	data.PageDescription = "Управляйте сессиями и взаимодействуйте с Sham'an AI."
 	data.RobotsContent = "noindex, nofollow"
 
-	// Проверяем лимит токенов для текущего пользователя
+	// Проверяем лимит токенов тарифа текущего пользователя
 	if data.User != nil {
-		costInputUSD := (float64(data.User.TokensUsedInputThisPeriod) / 1000000.0) * h.Config.RemoteLLM.TokenCostInputPerMillion
-		costOutputUSD := (float64(data.User.TokensUsedOutputThisPeriod) / 1000000.0) * h.Config.RemoteLLM.TokenCostOutputPerMillion
-		totalCostUSD := costInputUSD + costOutputUSD
-		totalCostKZT := totalCostUSD * h.Config.Billing.USDToKZTRate
+		plan, err := db.GetUserPlan(data.User.ID)
+		if err != nil {
+			slog.Error("DashboardPageHandler: не удалось получить тариф пользователя", "userID", data.User.ID, "error", err)
+		}
+		data.CurrentPlan = plan
 
-		if totalCostKZT >= h.Config.TokenMonthlyLimitKZT {
+		if plan != nil && middleware.TokenCostKZT(h.Config, data.User) >= plan.TokenLimitKZT {
 			nextBillingDate := "следующего платежа"
 			if data.User.CurrentPeriodEnd != nil {
 				nextBillingDate = "даты " + data.User.CurrentPeriodEnd.Format("02.01.2006")
@@ -436,14 +454,35 @@ func (h *AppHandlers) SubscribePageHandler(w http.ResponseWriter, r *http.Reques
 		userEmail = data.User.Email
 	}
 
+	// Каталог тарифов: цены в тиынах, выбранный тариф и период отправляются в /billing/create-payment-link
+	plans, err := db.GetVisiblePlans()
+	if err != nil {
+		slog.Error("SubscribePageHandler: не удалось получить каталог тарифов", "error", err)
+		http.Error(w, "Ошибка сервера при загрузке тарифов", http.StatusInternalServerError)
+		return
+	}
+	data.Plans = plans
+	if data.User != nil {
+		if data.CurrentPlan, err = db.GetUserPlan(data.User.ID); err != nil {
+			slog.Error("SubscribePageHandler: не удалось получить тариф пользователя", "userID", data.User.ID, "error", err)
+		}
+		hadSubscription, err := db.HadSubscription(data.User.ID)
+		if err != nil {
+			slog.Error("SubscribePageHandler: не удалось проверить подписки пользователя", "userID", data.User.ID, "error", err)
+		}
+		data.TrialAvailable = err == nil && !hadSubscription
+	}
+
+	selectedPlan := r.URL.Query().Get("plan")
+	if selectedPlan == "" {
+		selectedPlan = models.DefaultPlanSlug
+	}
 	data.Form = map[string]string{
 		"PaymentGatewayPublishableKey": h.Config.Billing.PaymentGatewayPublishableKey,
-		"PriceID":                      h.Config.Billing.PriceID,
 		"UserEmail":                    userEmail,
 		"Currency":                     h.Config.Billing.Currency,
-		// "Amount" теперь берется из h.Config.Billing.MonthlyAmount в шаблоне
-		// Для передачи в JS, если нужно, можно сделать так:
-		// "AmountValue": fmt.Sprintf("%d", h.Config.Billing.MonthlyAmount / 100),
+		"Plan":                         selectedPlan,
+		"Period":                       r.URL.Query().Get("period"),
 	}
 
 	h.RenderPage(w, r, "subscribe.html", data)
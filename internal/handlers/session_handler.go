@@ -16,7 +16,7 @@ func ListUserChatSessionsHandler() http.HandlerFunc {
 		userID, ok := r.Context().Value(middleware.UserIDContextKey).(int64)
 		if !ok || userID == 0 { /* ... ошибка аутентификации ... */ return }
 
-		sessions, err := db.GetUserChatSessions(userID, 50) // Лимит на 50 сессий
+		sessions, _, err := db.GetUserChatSessions(userID, db.ChatSessionsActive, nil, 50) // Лимит на 50 сессий
 		if err != nil { /* ... ошибка сервера ... */ return }
 
 		w.Header().Set("Content-Type", "application/json")
@@ -34,7 +34,7 @@ func CreateUserChatSessionHandler() http.HandlerFunc {
 		// Заголовок можно взять из первого сообщения или оставить пустым
 		initialTitle := "Новый диалог" // Или пусто
 
-		err := db.CreateChatSession(userID, newUUID, initialTitle)
+		err := db.CreateChatSession(userID, newUUID, initialTitle, "")
 		if err != nil {
 			http.Error(w, "Не удалось создать новую сессию", http.StatusInternalServerError)
 			return
@@ -0,0 +1,340 @@
+// internal/handlers/share_handlers.go
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/export"
+	"shaman-ai.kz/internal/middleware"
+	"shaman-ai.kz/internal/personas"
+	"shaman-ai.kz/internal/storage"
+	"shaman-ai.kz/internal/timeutil"
+)
+
+// Длина токена ссылки в байтах (в адресе - вдвое больше hex-символов)
+const shareTokenBytes = 24
+
+// SharedChatView - диалог на публичной странице по ссылке. Время - по Алматы.
+type SharedChatView struct {
+	Title       string
+	PersonaName string
+	CreatedAt   time.Time
+	ExpiresAt   *time.Time
+	Turns       []SharedChatTurn
+	Disclaimer  string
+}
+
+type SharedChatTurn struct {
+	UserPrompt        string
+	AIResponse        string
+	CreatedAt         time.Time
+	Attachments       []SharedChatAttachment
+	HiddenAttachments int // Сколько вложений скрыто владельцем ссылки
+}
+
+type SharedChatAttachment struct {
+	Name    string
+	URL     string
+	IsImage bool
+}
+
+// CreateChatShareResponse - созданная ссылка. URL возвращается только здесь: токен не хранится.
+type CreateChatShareResponse struct {
+	Share *db.ChatShare `json:"share"`
+	URL   string        `json:"url"`
+}
+
+// ChatShareRevokeRequest - тело запроса на отзыв ссылки
+type ChatShareRevokeRequest struct {
+	ID int64 `json:"id"`
+}
+
+func chatShareURL(baseURL, token string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/share/" + token
+}
+
+// CreateChatShareHandler создает ссылку на диалог только для чтения:
+// {"uuid": "...", "expires_in_days": 7, "redact_attachments": true}
+func CreateChatShareHandler(appConfig *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, sessionMeta, ok := ownedChatSessionRequest(w, r)
+		if !ok {
+			return
+		}
+		if sessionMeta.DeletedAt != nil {
+			http.Error(w, "Сессия чата удалена", http.StatusNotFound)
+			return
+		}
+
+		maxDays := appConfig.Shares.MaxExpiryDays
+		if req.ExpiresInDays < 0 {
+			http.Error(w, "Срок действия ссылки не может быть отрицательным", http.StatusBadRequest)
+			return
+		}
+		if maxDays > 0 && req.ExpiresInDays > maxDays {
+			http.Error(w, fmt.Sprintf("Срок действия ссылки может быть от 1 до %d дней", maxDays), http.StatusBadRequest)
+			return
+		}
+		days := req.ExpiresInDays
+		if days == 0 && maxDays > 0 {
+			days = maxDays
+		}
+		var expiresAt *time.Time
+		if days > 0 {
+			t := time.Now().AddDate(0, 0, days)
+			expiresAt = &t
+		}
+
+		token, err := db.GenerateSecureToken(shareTokenBytes)
+		if err != nil {
+			slog.Error("Ошибка генерации токена ссылки на диалог", "error", err)
+			http.Error(w, "Не удалось создать ссылку", http.StatusInternalServerError)
+			return
+		}
+		shareID, err := db.CreateChatShare(sessionMeta.UserID, req.UUID, token, expiresAt, req.RedactAttachments)
+		if err != nil {
+			http.Error(w, "Не удалось создать ссылку", http.StatusInternalServerError)
+			return
+		}
+		share, err := db.GetChatShare(shareID)
+		if err != nil {
+			slog.Error("Ошибка получения созданной ссылки на диалог", "share_id", shareID, "error", err)
+			http.Error(w, "Ошибка сервера при получении ссылки", http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("Создана ссылка на диалог", "user_id", sessionMeta.UserID, "session_uuid", req.UUID, "share_id", shareID,
+			"expires_in_days", days, "redact_attachments", req.RedactAttachments)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(CreateChatShareResponse{Share: share, URL: chatShareURL(appConfig.BaseURL, token)})
+	}
+}
+
+// ListChatSharesHandler возвращает ссылки пользователя: GET /api/chat_shares[?uuid=...]
+func ListChatSharesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(middleware.UserIDContextKey).(int64)
+		if !ok || userID == 0 {
+			http.Error(w, "Не авторизован", http.StatusUnauthorized)
+			return
+		}
+
+		shares, err := db.GetUserChatShares(userID, r.URL.Query().Get("uuid"))
+		if err != nil {
+			slog.Error("Ошибка получения ссылок на диалоги", "user_id", userID, "error", err)
+			http.Error(w, "Не удалось получить ссылки", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"shares": shares})
+	}
+}
+
+// RevokeChatShareHandler отзывает ссылку: {"id": 1}
+func RevokeChatShareHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(middleware.UserIDContextKey).(int64)
+		if !ok || userID == 0 {
+			http.Error(w, "Не авторизован", http.StatusUnauthorized)
+			return
+		}
+
+		var req ChatShareRevokeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID <= 0 {
+			http.Error(w, "Параметр 'id' ссылки обязателен", http.StatusBadRequest)
+			return
+		}
+
+		share, err := db.GetChatShare(req.ID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Ссылка не найдена", http.StatusNotFound)
+				return
+			}
+			slog.Error("Ошибка получения ссылки на диалог", "share_id", req.ID, "user_id", userID, "error", err)
+			http.Error(w, "Ошибка сервера при получении ссылки", http.StatusInternalServerError)
+			return
+		}
+		if share.UserID != userID {
+			slog.Warn("Попытка отозвать чужую ссылку на диалог", "user_id", userID, "share_owner_id", share.UserID, "share_id", req.ID)
+			http.Error(w, "Доступ запрещен к данной ссылке", http.StatusForbidden)
+			return
+		}
+
+		if err := db.RevokeChatShare(req.ID); err != nil {
+			http.Error(w, "Не удалось отозвать ссылку", http.StatusInternalServerError)
+			return
+		}
+		share, err = db.GetChatShare(req.ID)
+		if err != nil {
+			slog.Error("Ошибка получения ссылки на диалог после отзыва", "share_id", req.ID, "error", err)
+			http.Error(w, "Ошибка сервера при получении ссылки", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Ссылка на диалог отозвана", "user_id", userID, "share_id", req.ID, "session_uuid", share.ChatSessionUUID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(share)
+	}
+}
+
+// activeChatShare находит действующую ссылку по токену из адреса. Отозванная, истекшая, несуществующая
+// ссылка и ссылка на удаленный диалог для посетителя неразличимы - все дают 404.
+// При ошибке ответ уже отправлен и возвращается false.
+func activeChatShare(w http.ResponseWriter, r *http.Request) (*db.ChatShare, *db.ChatSessionMeta, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return nil, nil, false
+	}
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	// Токен в адресе не должен уходить на внешние сайты через Referer
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+
+	share, err := db.GetChatShareByToken(r.PathValue("token"))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("Ошибка получения ссылки на диалог", "error", err)
+			http.Error(w, "Ошибка сервера при получении диалога", http.StatusInternalServerError)
+			return nil, nil, false
+		}
+		http.Error(w, "Ссылка недействительна или срок ее действия истек", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if !share.Active {
+		http.Error(w, "Ссылка недействительна или срок ее действия истек", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	sessionMeta, err := db.GetChatSessionMeta(share.ChatSessionUUID)
+	if err != nil {
+		slog.Error("Ошибка получения метаданных сессии по ссылке", "share_id", share.ID, "uuid", share.ChatSessionUUID, "error", err)
+		http.Error(w, "Ошибка сервера при получении диалога", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if sessionMeta.DeletedAt != nil {
+		http.Error(w, "Ссылка недействительна или срок ее действия истек", http.StatusNotFound)
+		return nil, nil, false
+	}
+	return share, sessionMeta, true
+}
+
+// SharedChatPageHandler показывает диалог по ссылке: GET /share/{token}.
+// Страница публичная и не индексируется; просмотры владельца не учитываются.
+func SharedChatPageHandler(app *AppHandlers, personaRegistry *personas.Registry) http.HandlerFunc {
+	personaName := personaNameFunc(personaRegistry)
+	return func(w http.ResponseWriter, r *http.Request) {
+		share, sessionMeta, ok := activeChatShare(w, r)
+		if !ok {
+			return
+		}
+
+		conv, err := export.LoadBranch(sessionMeta, personaName(*sessionMeta), share.LastDialogueID)
+		if err != nil {
+			slog.Error("Ошибка чтения диалога по ссылке", "share_id", share.ID, "uuid", share.ChatSessionUUID, "error", err)
+			http.Error(w, "Ошибка сервера при получении диалога", http.StatusInternalServerError)
+			return
+		}
+		view := sharedChatView(conv, share, r.PathValue("token"))
+
+		viewerID, _ := r.Context().Value(middleware.LoggedInUserIDContextKey).(int64)
+		if viewerID != share.UserID && r.Method == http.MethodGet {
+			if err := db.RecordChatShareView(share.ID); err != nil {
+				slog.Warn("Не удалось учесть просмотр ссылки на диалог", "share_id", share.ID, "error", err)
+			}
+		}
+
+		data := app.NewPageData(r)
+		data.PageTitle = view.Title
+		data.PageDescription = "Диалог с Sham'an AI, открытый владельцем по ссылке."
+		data.RobotsContent = "noindex, nofollow"
+		data.CanonicalURL = ""
+		data.SharedChat = view
+		app.RenderPage(w, r, "shared_chat.html", data)
+	}
+}
+
+// sharedChatView готовит диалог для страницы: без вложений, если владелец их скрыл
+func sharedChatView(conv *export.Conversation, share *db.ChatShare, token string) *SharedChatView {
+	view := &SharedChatView{
+		Title:       conv.Session.Title,
+		PersonaName: conv.PersonaName,
+		CreatedAt:   conv.Session.CreatedAt.In(timeutil.Location),
+		ExpiresAt:   share.ExpiresAt,
+		Disclaimer:  export.Disclaimer,
+	}
+	if view.ExpiresAt != nil {
+		expiresAt := view.ExpiresAt.In(timeutil.Location)
+		view.ExpiresAt = &expiresAt
+	}
+	for _, turn := range conv.Turns {
+		st := SharedChatTurn{UserPrompt: turn.UserPrompt, AIResponse: turn.AIResponse, CreatedAt: turn.CreatedAt.In(timeutil.Location)}
+		for _, a := range turn.Attachments {
+			if share.RedactAttachments {
+				// Имя файла сохраняется и в тексте сообщения - его тоже убираем
+				st.UserPrompt = strings.Replace(st.UserPrompt, attachmentNote(a.OriginalName), "", 1)
+				st.HiddenAttachments++
+				continue
+			}
+			st.Attachments = append(st.Attachments, SharedChatAttachment{
+				Name:    a.OriginalName,
+				URL:     fmt.Sprintf("/share/%s/attachments/%d", token, a.ID),
+				IsImage: isInlineImage(a.MIMEType),
+			})
+		}
+		view.Turns = append(view.Turns, st)
+	}
+	return view
+}
+
+// SharedChatAttachmentHandler отдает вложение диалога по ссылке: GET /share/{token}/attachments/{id}
+func SharedChatAttachmentHandler(appConfig *config.Config, fileStore storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		share, _, ok := activeChatShare(w, r)
+		if !ok {
+			return
+		}
+		if share.RedactAttachments {
+			http.NotFound(w, r)
+			return
+		}
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "Неверный ID вложения", http.StatusBadRequest)
+			return
+		}
+
+		attachment, err := db.GetChatShareAttachment(share, id)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				slog.Error("Ошибка получения вложения по ссылке", "attachment_id", id, "share_id", share.ID, "error", err)
+				http.Error(w, "Ошибка сервера при получении вложения", http.StatusInternalServerError)
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+		// no-store: после отзыва ссылки файл не должен оставаться доступным из кеша
+		serveAttachment(w, r, appConfig, fileStore, attachment, "no-store")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"shaman-ai.kz/internal/config"
 	"shaman-ai.kz/internal/db"
 	"shaman-ai.kz/internal/llm"
+	"shaman-ai.kz/internal/personas"
 	"time"
 )
 
@@ -17,8 +18,8 @@ type TrialDialogueRequest struct {
 	Prompt string `json:"prompt"`
 }
 
-// Использует только generalSystemPrompt
-func TrialDialogueHandler(appConfig *config.Config, generalSystemPrompt string) http.HandlerFunc {
+// Использует только общую персону
+func TrialDialogueHandler(appConfig *config.Config, llmClient llm.Client, personaRegistry *personas.Registry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.Header().Set("Content-Type", "application/json")
@@ -43,15 +44,16 @@ func TrialDialogueHandler(appConfig *config.Config, generalSystemPrompt string)
 
 		slog.Info("TrialDialogueHandler: Получен пробный запрос", "prompt_length", len(req.Prompt))
 
-		// Используем только generalSystemPrompt, без истории
+		// Используем только общую персону, без истории
 		history := []db.Message{} // Пустая история
 
 		// Уменьшенный таймаут для пробных запросов, если нужно
 		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(appConfig.RemoteLLM.RequestTimeoutSeconds/2)*time.Second)
 		defer cancel()
 
+		persona := personaRegistry.Default()
 		// ИСПРАВЛЕНИЕ: Используем _ для игнорирования данных о токенах
-		aiResponse, _, err := llm.GenerateRemoteResponse(ctx, appConfig.RemoteLLM, generalSystemPrompt, history, req.Prompt)
+		aiResponse, _, err := llmClient.GenerateRemoteResponse(ctx, personas.LLMConfig(persona, appConfig.RemoteLLM), personaRegistry.SystemPrompt(persona), history, req.Prompt)
 		if err != nil {
 			slog.Error("TrialDialogueHandler: Ошибка при генерации ответа LLM", "error", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,249 @@
+// internal/history/builder.go
+//
+// Package history собирает историю диалога для запроса к LLM: последние обмены в пределах
+// бюджета токенов модели и резюме более старой части, которое пересчитывается в фоне.
+package history
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/llm"
+)
+
+const summaryHeader = "[Краткое содержание предыдущей части диалога]"
+
+const summarySystemPrompt = `Ты ведешь краткое резюме длинного диалога пользователя с ассистентом.
+Тебе дают текущее резюме (может быть пустым) и следующие сообщения диалога.
+Верни обновленное резюме: факты о пользователе, его вопросы и цели, договоренности и важные ответы ассистента.
+Пиши кратко, в третьем лице, на языке диалога. Не добавляй ничего, чего не было в диалоге. Верни только текст резюме.`
+
+// Result - история, готовая к передаче в llm.Client
+type Result struct {
+	SystemPrompt    string // Системный промпт, дополненный резюме (если оно есть)
+	Messages        []db.Message
+	IncludedTurns   int
+	DroppedTurns    int // Обмены из прочитанного окна, не вошедшие в бюджет
+	SummaryUsed     bool
+	Budget          int
+	EstimatedTokens int
+	// Пересчет резюме, запущенный в фоне: получит usage запроса к LLM (nil - запроса не было)
+	// и закроется. Токены резюме учитывает вызывающий, как и токены ответа. nil - пересчет не нужен.
+	SummaryRefresh <-chan *llm.Usage
+}
+
+type Builder struct {
+	cfg       config.HistoryConfig
+	llmClient llm.Client
+	llmCfg    config.RemoteLLMConfig // Базовая конфигурация для запросов резюмирования
+
+	mu      sync.Mutex
+	running map[string]bool // Сессии, для которых резюме уже пересчитывается
+}
+
+func NewBuilder(cfg config.HistoryConfig, llmClient llm.Client, llmCfg config.RemoteLLMConfig) *Builder {
+	return &Builder{cfg: cfg, llmClient: llmClient, llmCfg: llmCfg, running: make(map[string]bool)}
+}
+
+// Budget возвращает бюджет входных токенов для модели запроса
+func (b *Builder) Budget(llmCfg config.RemoteLLMConfig) int {
+	keys := []string{llmCfg.ModelSpec}
+	if _, model, ok := strings.Cut(llmCfg.ModelSpec, ":"); ok {
+		keys = append(keys, model)
+	}
+	keys = append(keys, llmCfg.Provider+":"+llmCfg.ModelName, llmCfg.ModelName)
+	for _, key := range keys {
+		if budget, ok := b.cfg.ModelTokenBudgets[key]; ok && key != "" && budget > 0 {
+			return budget
+		}
+	}
+	return b.cfg.DefaultTokenBudget
+}
+
+func turnTokens(turn db.DialogueTurn) int {
+	return llm.EstimateTokens(turn.UserPrompt) + llm.EstimateTokens(turn.AIResponse)
+}
+
+func summaryBlock(summary string) string {
+	return "\n\n" + summaryHeader + "\n" + summary
+}
+
+// Build собирает историю сессии: самые свежие обмены, пока они помещаются в бюджет,
+// а все, что раньше, - через резюме. Если резюме устарело, запускает его пересчет в фоне.
+func (b *Builder) Build(sessionUUID string, llmCfg config.RemoteLLMConfig, systemPrompt, userPrompt string) (*Result, error) {
+	res := &Result{SystemPrompt: systemPrompt, Budget: b.Budget(llmCfg)}
+
+	turns, err := db.GetRecentDialogueTurns(sessionUUID, b.cfg.MaxTurns)
+	if err != nil {
+		return res, err
+	}
+
+	var summary *db.ChatSessionSummary
+	if !b.cfg.DisableSummary {
+		if summary, err = db.GetChatSessionSummary(sessionUUID); err != nil {
+			slog.Error("Не удалось получить резюме сессии", "chat_uuid", sessionUUID, "error", err)
+		}
+	}
+
+	used := llm.EstimateTokens(systemPrompt) + llm.EstimateTokens(userPrompt)
+	summaryCost := 0
+	if summary != nil {
+		summaryCost = llm.EstimateTokens(summaryBlock(summary.Summary))
+	}
+	first := len(turns) // Индекс самого старого обмена, вошедшего в историю
+	for i := len(turns) - 1; i >= 0; i-- {
+		cost := turnTokens(turns[i])
+		if used+summaryCost+cost > res.Budget {
+			break
+		}
+		used += cost
+		first = i
+	}
+	included := turns[first:]
+	res.IncludedTurns = len(included)
+	res.DroppedTurns = first
+	for _, turn := range included {
+		res.Messages = append(res.Messages, db.Message{Role: "user", Content: turn.UserPrompt})
+		if turn.AIResponse != "" {
+			res.Messages = append(res.Messages, db.Message{Role: "assistant", Content: turn.AIResponse})
+		}
+	}
+
+	// Окно прочитано целиком и все вошло - диалог короткий, резюме не нужно
+	if first == 0 && len(turns) < b.cfg.MaxTurns {
+		res.EstimatedTokens = used
+		return res, nil
+	}
+
+	if summary != nil {
+		res.SystemPrompt = systemPrompt + summaryBlock(summary.Summary)
+		res.SummaryUsed = true
+		used += summaryCost
+	}
+	res.EstimatedTokens = used
+	if !b.cfg.DisableSummary {
+		// Граница - самый старый обмен, ушедший в запрос; все, что раньше, должно быть в резюме
+		boundary := int64(math.MaxInt64)
+		if len(included) > 0 {
+			boundary = included[0].ID
+		}
+		res.SummaryRefresh = b.refreshIfStale(sessionUUID, summary, boundary)
+	}
+	return res, nil
+}
+
+// refreshIfStale запускает пересчет резюме, если за его пределами накопилось достаточно обменов.
+// Возвращает канал с usage пересчета; nil - пересчет не запущен.
+func (b *Builder) refreshIfStale(sessionUUID string, summary *db.ChatSessionSummary, boundary int64) <-chan *llm.Usage {
+	var coveredUntil int64
+	if summary != nil {
+		coveredUntil = summary.CoveredUntilID
+	}
+	pending, err := db.CountDialogueTurnsBetween(sessionUUID, coveredUntil, boundary)
+	if err != nil {
+		slog.Error("Не удалось проверить актуальность резюме сессии", "chat_uuid", sessionUUID, "error", err)
+		return nil
+	}
+	// Первое резюме строим сразу, как только что-то выпало из истории
+	if pending == 0 || (summary != nil && pending < b.cfg.SummaryTriggerTurns) {
+		return nil
+	}
+
+	b.mu.Lock()
+	if b.running[sessionUUID] {
+		b.mu.Unlock()
+		return nil
+	}
+	b.running[sessionUUID] = true
+	b.mu.Unlock()
+
+	refresh := make(chan *llm.Usage, 1)
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			delete(b.running, sessionUUID)
+			b.mu.Unlock()
+			close(refresh)
+		}()
+		usage, err := b.summarize(sessionUUID, summary, boundary)
+		if err != nil {
+			slog.Error("Не удалось обновить резюме сессии", "chat_uuid", sessionUUID, "error", err)
+		}
+		refresh <- usage
+	}()
+	return refresh
+}
+
+// summarize дописывает в резюме следующую порцию обменов, предшествующих boundary.
+// За один проход резюмируется не больше SummaryInputBudget токенов, остальное - в следующий раз.
+// Usage возвращается и при ошибке, если запрос к LLM уже выполнен.
+func (b *Builder) summarize(sessionUUID string, summary *db.ChatSessionSummary, boundary int64) (*llm.Usage, error) {
+	var coveredUntil int64
+	var previous string
+	if summary != nil {
+		coveredUntil = summary.CoveredUntilID
+		previous = summary.Summary
+	}
+	turns, err := db.GetDialogueTurnsBetween(sessionUUID, coveredUntil, boundary, b.cfg.MaxTurns)
+	if err != nil {
+		return nil, err
+	}
+	if len(turns) == 0 {
+		return nil, nil
+	}
+
+	var sb strings.Builder
+	if previous != "" {
+		sb.WriteString("Текущее резюме:\n" + previous + "\n\n")
+	}
+	sb.WriteString("Следующие сообщения диалога:\n")
+	used := 0
+	lastID := coveredUntil
+	for _, turn := range turns {
+		cost := turnTokens(turn)
+		if used > 0 && used+cost > b.cfg.SummaryInputBudget {
+			break
+		}
+		used += cost
+		lastID = turn.ID
+		fmt.Fprintf(&sb, "Пользователь: %s\n", turn.UserPrompt)
+		if turn.AIResponse != "" {
+			fmt.Fprintf(&sb, "Ассистент: %s\n", turn.AIResponse)
+		}
+	}
+
+	llmCfg := b.llmCfg
+	if b.cfg.SummaryModel != "" {
+		llmCfg.ModelSpec = b.cfg.SummaryModel
+	}
+	llmCfg.MaxTokens = b.cfg.SummaryMaxTokens
+	llmCfg.Temperature = 0.2
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(b.cfg.SummaryTimeoutSeconds)*time.Second)
+	defer cancel()
+	text, usage, err := b.llmClient.GenerateRemoteResponse(ctx, llmCfg, summarySystemPrompt, nil, sb.String())
+	if err != nil {
+		return usage, fmt.Errorf("ошибка запроса резюме к LLM: %w", err)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return usage, fmt.Errorf("LLM вернула пустое резюме")
+	}
+	if err := db.SaveChatSessionSummary(sessionUUID, text, lastID); err != nil {
+		return usage, err
+	}
+
+	attrs := []any{"chat_uuid", sessionUUID, "covered_until", lastID, "summary_length", len(text)}
+	if usage != nil {
+		attrs = append(attrs, "model", usage.Model, "input_tokens", usage.PromptTokens, "output_tokens", usage.CompletionTokens)
+	}
+	slog.Info("Резюме сессии обновлено", attrs...)
+	return usage, nil
+}
@@ -0,0 +1,203 @@
+// internal/history/builder_test.go
+package history
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/llm"
+	"shaman-ai.kz/internal/llm/fakellm"
+)
+
+const (
+	testSystemPrompt = "Ты - ассистент."
+	testUserPrompt   = "Новый вопрос"
+)
+
+// baseCost - tokens of the system prompt and the new message, spent before any history
+var baseCost = llm.EstimateTokens(testSystemPrompt) + llm.EstimateTokens(testUserPrompt)
+
+// newTestSession stores count exchanges of equal size in a new session and returns their IDs
+func newTestSession(t *testing.T, count int) (string, []int64) {
+	t.Helper()
+	db.OpenTestDB(t)
+	userID := db.CreateTestUser(t)
+	sessionUUID := uuid.NewString()
+	if err := db.CreateChatSession(userID, sessionUUID, "Тест", ""); err != nil {
+		t.Fatalf("CreateChatSession failed: %v", err)
+	}
+	ids := make([]int64, count)
+	for i := range ids {
+		id, err := db.SaveChatMessage(userID, sessionUUID, fmt.Sprintf("Вопрос номер %02d", i+1), fmt.Sprintf("Ответ ассистента номер %02d", i+1), db.DialogueOrigin{})
+		if err != nil {
+			t.Fatalf("SaveChatMessage failed: %v", err)
+		}
+		ids[i] = id
+	}
+	return sessionUUID, ids
+}
+
+// turnCost - estimated tokens of one stored exchange; all exchanges of newTestSession are the same size
+var turnCost = turnTokens(db.DialogueTurn{UserPrompt: "Вопрос номер 01", AIResponse: "Ответ ассистента номер 01"})
+
+func newTestBuilder(t *testing.T, cfg config.HistoryConfig) (*Builder, *fakellm.Server) {
+	t.Helper()
+	srv := fakellm.NewServer()
+	t.Cleanup(srv.Close)
+	registry, err := llm.NewRegistry(srv.LLMConfig())
+	if err != nil {
+		t.Fatalf("NewRegistry failed: %v", err)
+	}
+	if cfg.MaxTurns == 0 {
+		cfg.MaxTurns = 20
+	}
+	cfg.SummaryMaxTokens, cfg.SummaryInputBudget, cfg.SummaryTimeoutSeconds = 200, 10000, 5
+	return NewBuilder(cfg, registry, srv.LLMConfig()), srv
+}
+
+func saveSummary(t *testing.T, sessionUUID, text string, coveredUntil int64) {
+	t.Helper()
+	if err := db.SaveChatSessionSummary(sessionUUID, text, coveredUntil); err != nil {
+		t.Fatalf("SaveChatSessionSummary failed: %v", err)
+	}
+}
+
+func TestBuildTakesNewestTurnsFirst(t *testing.T) {
+	sessionUUID, _ := newTestSession(t, 5)
+	builder, _ := newTestBuilder(t, config.HistoryConfig{DefaultTokenBudget: baseCost + 2*turnCost + turnCost/2, DisableSummary: true})
+
+	res, err := builder.Build(sessionUUID, config.RemoteLLMConfig{}, testSystemPrompt, testUserPrompt)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if res.IncludedTurns != 2 || res.DroppedTurns != 3 {
+		t.Fatalf("Expected 2 included and 3 dropped turns, got %d and %d", res.IncludedTurns, res.DroppedTurns)
+	}
+	want := []string{"Вопрос номер 04", "Ответ ассистента номер 04", "Вопрос номер 05", "Ответ ассистента номер 05"}
+	if len(res.Messages) != len(want) {
+		t.Fatalf("Expected %d messages, got %+v", len(want), res.Messages)
+	}
+	for i, message := range res.Messages {
+		if message.Content != want[i] {
+			t.Fatalf("Message %d is %q, want %q", i, message.Content, want[i])
+		}
+	}
+	if res.EstimatedTokens != baseCost+2*turnCost || res.EstimatedTokens > res.Budget {
+		t.Fatalf("Unexpected estimate %d for budget %d", res.EstimatedTokens, res.Budget)
+	}
+}
+
+func TestBuildCountsSummaryAgainstBudget(t *testing.T) {
+	sessionUUID, ids := newTestSession(t, 5)
+	summary := strings.Repeat("Пользователь спрашивал о погоде. ", 3)
+	saveSummary(t, sessionUUID, summary, ids[1])
+	summaryCost := llm.EstimateTokens(summaryBlock(summary))
+
+	// Three turns fit on their own, but not together with the summary
+	builder, srv := newTestBuilder(t, config.HistoryConfig{DefaultTokenBudget: baseCost + 3*turnCost + summaryCost - 1, SummaryTriggerTurns: 100})
+	res, err := builder.Build(sessionUUID, config.RemoteLLMConfig{}, testSystemPrompt, testUserPrompt)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if res.IncludedTurns != 2 || !res.SummaryUsed {
+		t.Fatalf("Expected 2 turns with the summary, got %d turns, summary %v", res.IncludedTurns, res.SummaryUsed)
+	}
+	if res.SystemPrompt != testSystemPrompt+summaryBlock(summary) {
+		t.Fatalf("Summary not appended to the system prompt: %q", res.SystemPrompt)
+	}
+	if res.EstimatedTokens != baseCost+2*turnCost+summaryCost {
+		t.Fatalf("Expected the estimate to include the summary, got %d", res.EstimatedTokens)
+	}
+	// One turn fell out past the summary, below the trigger: no refresh
+	if res.SummaryRefresh != nil || len(srv.Requests()) != 0 {
+		t.Fatal("Summary refreshed before it went stale")
+	}
+}
+
+func TestBuildShortDialogueSkipsSummary(t *testing.T) {
+	sessionUUID, ids := newTestSession(t, 3)
+	saveSummary(t, sessionUUID, "Старое резюме", ids[0])
+	builder, srv := newTestBuilder(t, config.HistoryConfig{DefaultTokenBudget: 4000, MaxTurns: 10, SummaryTriggerTurns: 1})
+
+	res, err := builder.Build(sessionUUID, config.RemoteLLMConfig{}, testSystemPrompt, testUserPrompt)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	// The whole dialogue fits and is shorter than the window: the summary is neither sent nor refreshed
+	if res.IncludedTurns != 3 || res.SummaryUsed || res.SystemPrompt != testSystemPrompt {
+		t.Fatalf("Expected all 3 turns without the summary, got %d turns, summary %v", res.IncludedTurns, res.SummaryUsed)
+	}
+	if res.EstimatedTokens != baseCost+3*turnCost {
+		t.Fatalf("Unexpected estimate %d", res.EstimatedTokens)
+	}
+	if res.SummaryRefresh != nil || len(srv.Requests()) != 0 {
+		t.Fatal("Summary refreshed for a short dialogue")
+	}
+}
+
+func TestBuildRefreshesStaleSummary(t *testing.T) {
+	cases := []struct {
+		name        string
+		summarized  int // Turns covered by the existing summary; 0 - no summary yet
+		trigger     int
+		wantRefresh bool
+	}{
+		{"first summary once a turn falls out", 0, 10, true},
+		{"below the trigger", 1, 4, false},
+		{"trigger reached", 1, 3, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// 6 turns, the last 2 fit: turns 1-4 are outside the history
+			sessionUUID, ids := newTestSession(t, 6)
+			budget := baseCost + 2*turnCost + turnCost/2
+			if tc.summarized > 0 {
+				saveSummary(t, sessionUUID, "Старое резюме", ids[tc.summarized-1])
+				budget += llm.EstimateTokens(summaryBlock("Старое резюме"))
+			}
+			builder, srv := newTestBuilder(t, config.HistoryConfig{DefaultTokenBudget: budget, SummaryTriggerTurns: tc.trigger})
+			srv.Enqueue(fakellm.Response{Content: "Новое резюме", PromptTokens: 40, CompletionTokens: 8})
+
+			res, err := builder.Build(sessionUUID, config.RemoteLLMConfig{}, testSystemPrompt, testUserPrompt)
+			if err != nil {
+				t.Fatalf("Build failed: %v", err)
+			}
+			if res.IncludedTurns != 2 {
+				t.Fatalf("Expected 2 turns, got %d", res.IncludedTurns)
+			}
+			if !tc.wantRefresh {
+				if res.SummaryRefresh != nil || len(srv.Requests()) != 0 {
+					t.Fatal("Summary refreshed below the trigger")
+				}
+				return
+			}
+			if res.SummaryRefresh == nil {
+				t.Fatal("Stale summary not refreshed")
+			}
+
+			// The caller gets the usage of the summary request to charge the user
+			var usage *llm.Usage
+			select {
+			case usage = <-res.SummaryRefresh:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Summary refresh did not finish")
+			}
+			if usage == nil || usage.PromptTokens != 40 || usage.CompletionTokens != 8 || usage.Model != "fake-model" {
+				t.Fatalf("Unexpected summary usage %+v", usage)
+			}
+			saved, err := db.GetChatSessionSummary(sessionUUID)
+			if err != nil || saved == nil || saved.Summary != "Новое резюме" || saved.CoveredUntilID != ids[3] {
+				t.Fatalf("Expected the summary to cover turn 4, got %+v, %v", saved, err)
+			}
+			if prompt := srv.Requests()[0].LastUserMessage(); strings.Contains(prompt, "номер 05") || !strings.Contains(prompt, "Вопрос номер 04") {
+				t.Fatalf("Summary request must cover the turns before the history only: %q", prompt)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// internal/imageproc/imageproc.go
+//
+// Package imageproc обрабатывает загруженные изображения: перекодирует их при загрузке,
+// отбрасывая метаданные (EXIF, GPS), и готовит уменьшенные копии для vision-моделей.
+package imageproc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxPixels - изображения больше этого числа пикселей не декодируем, чтобы не исчерпать память
+// (маленький файл может разворачиваться в гигабайты пикселей)
+const MaxPixels = 50_000_000
+
+// CheckDimensions читает только заголовок изображения и проверяет его размеры
+func CheckDimensions(r io.Reader) (image.Config, string, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return cfg, "", fmt.Errorf("неподдерживаемый формат изображения: %w", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return cfg, format, fmt.Errorf("недопустимый размер изображения %dx%d", cfg.Width, cfg.Height)
+	}
+	return cfg, format, nil
+}
+
+// decodeFile декодирует изображение, предварительно проверив его размеры
+func decodeFile(path string) (image.Image, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка открытия изображения: %w", err)
+	}
+	defer f.Close()
+
+	if _, _, err := CheckDimensions(f); err != nil {
+		return nil, "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, "", fmt.Errorf("ошибка чтения изображения: %w", err)
+	}
+	img, format, err := image.Decode(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка декодирования изображения: %w", err)
+	}
+	return img, format, nil
+}
+
+// Downscale уменьшает изображение так, чтобы большая сторона не превышала maxDimension,
+// и кодирует результат в JPEG. Прозрачные области заливаются белым.
+func Downscale(path string, maxDimension, quality int) ([]byte, error) {
+	src, _, err := decodeFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	if maxDimension > 0 && (width > maxDimension || height > maxDimension) {
+		if width >= height {
+			height = max(1, height*maxDimension/width)
+			width = maxDimension
+		} else {
+			width = max(1, width*maxDimension/height)
+			height = maxDimension
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("ошибка кодирования изображения: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DataURL уменьшает изображение и возвращает его как data URL для запроса к LLM
+func DataURL(path string, maxDimension, quality int) (string, error) {
+	data, err := Downscale(path, maxDimension, quality)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,133 @@
+// internal/imageproc/orientation.go
+package imageproc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+)
+
+// readOrientation читает тег Orientation (0x0112) из EXIF в JPEG. Фотографии с телефонов
+// хранятся "как сняла матрица", а правильный поворот записан только в этом теге.
+// При любой ошибке возвращает 1 (без поворота).
+func readOrientation(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xFF, 0xD8} {
+		return 1
+	}
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return 1
+		}
+		// Начало данных изображения: дальше метаданных не бывает
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return 1
+		}
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			return 1
+		}
+		segmentLen := int(binary.BigEndian.Uint16(lenBuf[:])) - 2
+		if segmentLen < 0 {
+			return 1
+		}
+		if marker[1] != 0xE1 {
+			if _, err := r.Discard(segmentLen); err != nil {
+				return 1
+			}
+			continue
+		}
+		segment := make([]byte, segmentLen)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return 1
+		}
+		if len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return orientationFromTIFF(segment[6:])
+		}
+	}
+}
+
+func orientationFromTIFF(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8:]))
+			if value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation поворачивает и отражает изображение согласно значению EXIF Orientation
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w // Повороты на 90 градусов меняют стороны местами
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Отражение по горизонтали
+				sx, sy = w-1-x, y
+			case 3: // Поворот на 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // Отражение по вертикали
+				sx, sy = x, h-1-y
+			case 5: // Отражение относительно главной диагонали
+				sx, sy = y, x
+			case 6: // Поворот на 90 по часовой
+				sx, sy = y, h-1-x
+			case 7: // Отражение относительно побочной диагонали
+				sx, sy = w-1-y, h-1-x
+			case 8: // Поворот на 90 против часовой
+				sx, sy = w-1-y, x
+			}
+			i, j := dst.PixOffset(x, y), src.PixOffset(sx, sy)
+			copy(dst.Pix[i:i+4], src.Pix[j:j+4])
+		}
+	}
+	return dst
+}
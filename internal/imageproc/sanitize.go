@@ -0,0 +1,84 @@
+// internal/imageproc/sanitize.go
+package imageproc
+
+import (
+	"fmt"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Качество JPEG при перекодировании загруженных фотографий
+const sanitizeJPEGQuality = 92
+
+// Sanitize декодирует изображение и записывает его заново поверх исходного файла.
+// Заново закодированный файл содержит только пиксели: EXIF (с GPS-координатами),
+// комментарии и любые данные, дописанные после изображения, отбрасываются.
+// Поворот из EXIF применяется к пикселям, чтобы фото с телефона не легло на бок.
+// WebP перекодируется в PNG (кодировщика WebP нет); возвращается итоговый MIME-тип.
+func Sanitize(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("ошибка открытия изображения: %w", err)
+	}
+	_, format, err := CheckDimensions(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	var encode func(w io.Writer) error
+	var mimeType string
+	switch format {
+	case "gif":
+		// Анимацию сохраняем покадрово; расширения приложений и комментарии при этом пропадают
+		f, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("ошибка открытия изображения: %w", err)
+		}
+		anim, err := gif.DecodeAll(f)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("ошибка декодирования изображения: %w", err)
+		}
+		encode = func(w io.Writer) error { return gif.EncodeAll(w, anim) }
+		mimeType = "image/gif"
+	case "jpeg":
+		img, _, err := decodeFile(path)
+		if err != nil {
+			return "", err
+		}
+		img = applyOrientation(img, readOrientation(path))
+		encode = func(w io.Writer) error { return jpeg.Encode(w, img, &jpeg.Options{Quality: sanitizeJPEGQuality}) }
+		mimeType = "image/jpeg"
+	case "png", "webp":
+		img, _, err := decodeFile(path)
+		if err != nil {
+			return "", err
+		}
+		encode = func(w io.Writer) error { return png.Encode(w, img) }
+		mimeType = "image/png"
+	default:
+		return "", fmt.Errorf("неподдерживаемый формат изображения: %s", format)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".sanitize-*")
+	if err != nil {
+		return "", fmt.Errorf("ошибка создания файла изображения: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := encode(tmp); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("ошибка кодирования изображения: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("ошибка записи изображения: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("ошибка записи изображения: %w", err)
+	}
+	return mimeType, nil
+}
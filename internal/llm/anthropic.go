@@ -0,0 +1,324 @@
+// internal/llm/anthropic.go
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+)
+
+const (
+	anthropicDefaultURL = "https://api.anthropic.com/v1/messages"
+	anthropicAPIVersion = "2023-06-01"
+)
+
+// AnthropicClient - клиент для Anthropic Messages API
+type AnthropicClient struct{}
+
+type anthropicMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"` // Строка или []anthropicContentBlock
+}
+
+type anthropicContentBlock struct {
+	Type   string                `json:"type"` // text или image
+	Text   string                `json:"text,omitempty"`
+	Source *anthropicImageSource `json:"source,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"` // base64
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicRequestBody struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float64            `json:"temperature,omitempty"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type anthropicResponseBody struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string          `json:"stop_reason"`
+	Usage      anthropicUsage  `json:"usage"`
+	Error      *anthropicError `json:"error,omitempty"`
+}
+
+// Событие потока Messages API (поле data: у SSE-события)
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Message *struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message,omitempty"`
+	Delta *struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta,omitempty"`
+	Usage *anthropicUsage `json:"usage,omitempty"`
+	Error *anthropicError `json:"error,omitempty"`
+}
+
+// buildAnthropicMessages формирует сообщения для Messages API. Системный промпт передается
+// отдельным полем, а подряд идущие реплики одной роли склеиваются - API требует чередования ролей.
+// Изображения (data URL) прикладываются блоками к последней реплике пользователя.
+func buildAnthropicMessages(history []db.Message, userPrompt string, images []string) []anthropicMessage {
+	messages := []anthropicMessage{}
+	appendMessage := func(role, content string) {
+		if content == "" {
+			return
+		}
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			messages[n-1].Content = messages[n-1].Content.(string) + "\n\n" + content
+			return
+		}
+		messages = append(messages, anthropicMessage{Role: role, Content: content})
+	}
+	for _, msg := range history {
+		switch msg.Role {
+		case "system":
+			continue
+		case "assistant":
+			// Диалог должен начинаться с реплики пользователя
+			if len(messages) == 0 {
+				continue
+			}
+			appendMessage("assistant", msg.Content)
+		default:
+			appendMessage("user", msg.Content)
+		}
+	}
+	appendMessage("user", userPrompt)
+
+	n := len(messages)
+	if len(images) == 0 || n == 0 || messages[n-1].Role != "user" {
+		return messages
+	}
+	var blocks []anthropicContentBlock
+	for _, image := range images {
+		mediaType, data, ok := splitDataURL(image)
+		if !ok {
+			continue
+		}
+		blocks = append(blocks, anthropicContentBlock{
+			Type:   "image",
+			Source: &anthropicImageSource{Type: "base64", MediaType: mediaType, Data: data},
+		})
+	}
+	if len(blocks) > 0 {
+		// Anthropic рекомендует ставить изображения перед текстом вопроса
+		blocks = append(blocks, anthropicContentBlock{Type: "text", Text: messages[n-1].Content.(string)})
+		messages[n-1].Content = blocks
+	}
+	return messages
+}
+
+func (c *AnthropicClient) newRequest(ctx context.Context, llmCfg config.RemoteLLMConfig, body anthropicRequestBody) (*http.Request, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка кодирования запроса для Anthropic API: %w", err)
+	}
+	apiURL := llmCfg.APIUrl
+	if apiURL == "" {
+		apiURL = anthropicDefaultURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания запроса к Anthropic API: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", llmCfg.APIKey)
+	req.Header.Set("anthropic-version", anthropicAPIVersion)
+	return req, nil
+}
+
+func anthropicErrorFromBody(resp *http.Response, bodyBytes []byte) error {
+	var apiResp anthropicResponseBody
+	if json.Unmarshal(bodyBytes, &apiResp) == nil && apiResp.Error != nil {
+		return newStatusError(resp, "ошибка Anthropic API: %s (%s)", apiResp.Error.Message, apiResp.Error.Type)
+	}
+	return newStatusError(resp, "ошибка Anthropic API: статус %d", resp.StatusCode)
+}
+
+func (c *AnthropicClient) GenerateRemoteResponse(ctx context.Context, llmCfg config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string) (string, *Usage, error) {
+	req, err := c.newRequest(ctx, llmCfg, anthropicRequestBody{
+		Model:       llmCfg.ModelName,
+		System:      systemPrompt,
+		Messages:    buildAnthropicMessages(history, userPrompt, llmCfg.Images),
+		MaxTokens:   llmCfg.MaxTokens,
+		Temperature: llmCfg.Temperature,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{Timeout: time.Duration(llmCfg.RequestTimeoutSeconds) * time.Second}
+	slog.Info("Отправка запроса к Anthropic API", "url", req.URL.String(), "model", llmCfg.ModelName)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return "", nil, fmt.Errorf("Anthropic API не ответил вовремя или запрос был отменен (%w)", err)
+		}
+		return "", nil, fmt.Errorf("ошибка отправки запроса к Anthropic API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("ошибка чтения ответа от Anthropic API: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		slog.Error("Anthropic API вернул ошибку HTTP", "status_code", resp.StatusCode, "response_body", string(bodyBytes))
+		return "", nil, anthropicErrorFromBody(resp, bodyBytes)
+	}
+
+	var apiResp anthropicResponseBody
+	if err := json.Unmarshal(bodyBytes, &apiResp); err != nil {
+		return "", nil, fmt.Errorf("ошибка декодирования JSON ответа от Anthropic API: %w", err)
+	}
+	var text strings.Builder
+	for _, block := range apiResp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if text.Len() == 0 {
+		slog.Warn("Ответ от Anthropic API не содержит текста", "response_body", string(bodyBytes))
+		return "", nil, errors.New("получен пустой ответ от Anthropic API")
+	}
+
+	usage := &Usage{
+		PromptTokens:     apiResp.Usage.InputTokens,
+		CompletionTokens: apiResp.Usage.OutputTokens,
+		TotalTokens:      apiResp.Usage.InputTokens + apiResp.Usage.OutputTokens,
+	}
+	slog.Info("Сгенерирован ответ Anthropic", "response_length", text.Len(), "usage", usage)
+	return text.String(), usage, nil
+}
+
+func (c *AnthropicClient) GenerateRemoteResponseStream(ctx context.Context, llmCfg config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string, onDelta func(delta string) error) (string, *Usage, error) {
+	req, err := c.newRequest(ctx, llmCfg, anthropicRequestBody{
+		Model:       llmCfg.ModelName,
+		System:      systemPrompt,
+		Messages:    buildAnthropicMessages(history, userPrompt, llmCfg.Images),
+		MaxTokens:   llmCfg.MaxTokens,
+		Temperature: llmCfg.Temperature,
+		Stream:      true,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	slog.Info("Отправка потокового запроса к Anthropic API", "url", req.URL.String(), "model", llmCfg.ModelName)
+
+	// Как и для OpenAI-совместимого потока, длительность ограничивается контекстом
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return "", nil, fmt.Errorf("Anthropic API не ответил вовремя или запрос был отменен (%w)", err)
+		}
+		return "", nil, fmt.Errorf("ошибка отправки запроса к Anthropic API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		slog.Error("Anthropic API вернул ошибку HTTP на потоковый запрос", "status_code", resp.StatusCode, "response_body", string(bodyBytes))
+		return "", nil, anthropicErrorFromBody(resp, bodyBytes)
+	}
+
+	var fullText strings.Builder
+	var usage *Usage
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue // Тип события дублируется в поле type внутри data
+		}
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			slog.Warn("Не удалось декодировать событие потока Anthropic API, пропускаем", "line", line, "error", err)
+			continue
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				usage = &Usage{PromptTokens: event.Message.Usage.InputTokens}
+			}
+		case "content_block_delta":
+			if event.Delta == nil || event.Delta.Text == "" {
+				continue
+			}
+			fullText.WriteString(event.Delta.Text)
+			if onDelta != nil {
+				if err := onDelta(event.Delta.Text); err != nil {
+					return fullText.String(), usage, fmt.Errorf("передача фрагмента ответа прервана: %w", err)
+				}
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				if usage == nil {
+					usage = &Usage{}
+				}
+				usage.CompletionTokens = event.Usage.OutputTokens
+				usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+			}
+		case "error":
+			if event.Error != nil {
+				slog.Error("Anthropic API вернул ошибку в потоке", "type", event.Error.Type, "message", event.Error.Message)
+				return fullText.String(), usage, fmt.Errorf("ошибка Anthropic API: %s (%s)", event.Error.Message, event.Error.Type)
+			}
+		}
+		if event.Type == "message_stop" {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fullText.String(), usage, fmt.Errorf("потоковый запрос к Anthropic API был отменен (%w)", ctxErr)
+		}
+		return fullText.String(), usage, fmt.Errorf("ошибка чтения потока от Anthropic API: %w", err)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fullText.String(), usage, fmt.Errorf("потоковый запрос к Anthropic API был отменен (%w)", ctxErr)
+	}
+	if fullText.Len() == 0 {
+		return "", usage, errors.New("получен пустой ответ от Anthropic API")
+	}
+
+	slog.Info("Сгенерирован потоковый ответ Anthropic", "response_length", fullText.Len(), "usage", usage)
+	return fullText.String(), usage, nil
+}
@@ -2,6 +2,7 @@
 package llm
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -10,6 +11,7 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"shaman-ai.kz/internal/config"
@@ -18,8 +20,20 @@ import (
 
 // Структура для сообщений в запросе к API
 type APIRequestMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role string `json:"role"`
+	// Строка или []ContentPart, если к сообщению приложены изображения
+	Content interface{} `json:"content"`
+}
+
+// ContentPart - часть multimodal-сообщения (формат OpenAI): текст или изображение
+type ContentPart struct {
+	Type     string        `json:"type"` // text или image_url
+	Text     string        `json:"text,omitempty"`
+	ImageURL *ContentImage `json:"image_url,omitempty"`
+}
+
+type ContentImage struct {
+	URL string `json:"url"` // https:// или data:image/...;base64,...
 }
 
 // Структура тела запроса к API
@@ -29,6 +43,13 @@ type APIRequestBody struct {
 	MaxTokens   int                 `json:"max_tokens,omitempty"`
 	Temperature float64             `json:"temperature,omitempty"`
 	Stream      bool                `json:"stream"`
+	// Для потокового режима просим API прислать usage в последнем чанке
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// StreamOptions - параметры потоковой генерации (OpenAI-совместимые)
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // Usage содержит информацию о количестве использованных токенов
@@ -36,6 +57,8 @@ type Usage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+	// Модель, которая фактически ответила (с учетом переключения на резервный endpoint)
+	Model string `json:"model,omitempty"`
 }
 
 // Структура для разбора ответа API (упрощенная, совместимая с OpenAI-подобными)
@@ -51,6 +74,19 @@ type APIResponseBody struct {
 	Error *APIError `json:"error,omitempty"` // Поле для ошибок API
 }
 
+// Структура чанка потокового ответа (строка "data: {...}" в SSE-потоке API)
+type APIStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *Usage    `json:"usage,omitempty"` // Приходит в последнем чанке, если запрошено include_usage
+	Error *APIError `json:"error,omitempty"`
+}
+
 // Структура для ошибок API
 type APIError struct {
 	Message string `json:"message"`
@@ -58,10 +94,9 @@ type APIError struct {
 	Code    string `json:"code"`
 }
 
-// GenerateRemoteResponse отправляет запрос к удаленному LLM API и возвращает Usage
-func GenerateRemoteResponse(ctx context.Context, llmConfig config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string) (string, *Usage, error) {
-
-	// Формируем историю сообщений для API
+// buildAPIMessages формирует список сообщений для API из системного промпта, истории и текущего запроса.
+// Изображения (data URL) прикладываются к текущему сообщению пользователя.
+func buildAPIMessages(systemPrompt string, history []db.Message, userPrompt string, images []string) []APIRequestMessage {
 	messages := []APIRequestMessage{}
 	if systemPrompt != "" {
 		messages = append(messages, APIRequestMessage{Role: "system", Content: systemPrompt})
@@ -80,15 +115,48 @@ func GenerateRemoteResponse(ctx context.Context, llmConfig config.RemoteLLMConfi
 	}
 
 	// Добавляем текущий промпт пользователя
-	messages = append(messages, APIRequestMessage{Role: "user", Content: userPrompt})
+	if len(images) == 0 {
+		messages = append(messages, APIRequestMessage{Role: "user", Content: userPrompt})
+		return messages
+	}
+	parts := []ContentPart{{Type: "text", Text: userPrompt}}
+	for _, image := range images {
+		parts = append(parts, ContentPart{Type: "image_url", ImageURL: &ContentImage{URL: image}})
+	}
+	messages = append(messages, APIRequestMessage{Role: "user", Content: parts})
+	return messages
+}
+
+// splitDataURL разбирает data URL вида data:image/jpeg;base64,... на MIME-тип и base64-данные
+func splitDataURL(dataURL string) (mediaType, data string, ok bool) {
+	rest, found := strings.CutPrefix(dataURL, "data:")
+	if !found {
+		return "", "", false
+	}
+	meta, data, found := strings.Cut(rest, ",")
+	if !found {
+		return "", "", false
+	}
+	mediaType, found = strings.CutSuffix(meta, ";base64")
+	if !found || mediaType == "" {
+		return "", "", false
+	}
+	return mediaType, data, true
+}
+
+// GenerateRemoteResponse отправляет запрос к удаленному LLM API и возвращает Usage
+func GenerateRemoteResponse(ctx context.Context, llmConfig config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string) (string, *Usage, error) {
+
+	// Формируем историю сообщений для API
+	messages := buildAPIMessages(systemPrompt, history, userPrompt, llmConfig.Images)
 
 	// Формируем тело запроса
 	requestBody := APIRequestBody{
 		Model:       llmConfig.ModelName,
 		Messages:    messages,
 		Stream:      false,
-		MaxTokens:   2048,
-		Temperature: 0.7,
+		MaxTokens:   llmConfig.MaxTokens,
+		Temperature: llmConfig.Temperature,
 	}
 
 	jsonData, err := json.Marshal(requestBody)
@@ -139,7 +207,7 @@ func GenerateRemoteResponse(ctx context.Context, llmConfig config.RemoteLLMConfi
 		slog.Error("Не удалось декодировать JSON от LLM API", "status_code", resp.StatusCode, "response_body", string(bodyBytes), "error", err)
 		// Если статус не ОК, пытаемся вернуть текст ошибки
 		if resp.StatusCode >= 400 {
-			return "", nil, fmt.Errorf("ошибка от LLM API: статус %d, тело: %s", resp.StatusCode, string(bodyBytes))
+			return "", nil, newStatusError(resp, "ошибка от LLM API: статус %d, тело: %s", resp.StatusCode, string(bodyBytes))
 		}
 		return "", nil, fmt.Errorf("ошибка декодирования JSON ответа от LLM API: %w", err)
 	}
@@ -147,6 +215,9 @@ func GenerateRemoteResponse(ctx context.Context, llmConfig config.RemoteLLMConfi
 	// Проверяем на ошибки внутри JSON ответа API
 	if apiResp.Error != nil {
 		slog.Error("LLM API вернул ошибку в JSON", "type", apiResp.Error.Type, "message", apiResp.Error.Message, "code", apiResp.Error.Code)
+		if resp.StatusCode >= 400 {
+			return "", nil, newStatusError(resp, "ошибка LLM API: %s (%s)", apiResp.Error.Message, apiResp.Error.Type)
+		}
 		return "", nil, fmt.Errorf("ошибка LLM API: %s (%s)", apiResp.Error.Message, apiResp.Error.Type)
 	}
 
@@ -158,7 +229,7 @@ func GenerateRemoteResponse(ctx context.Context, llmConfig config.RemoteLLMConfi
 		if apiResp.Error != nil {
 			errMsg = fmt.Sprintf("ошибка LLM API: %s (%s)", apiResp.Error.Message, apiResp.Error.Type)
 		}
-		return "", nil, errors.New(errMsg)
+		return "", nil, newStatusError(resp, "%s", errMsg)
 	}
 
 	// Проверяем наличие ответа
@@ -172,4 +243,112 @@ func GenerateRemoteResponse(ctx context.Context, llmConfig config.RemoteLLMConfi
 	slog.Info("Сгенерирован ответ Remote LLM", "response_length", len(aiResponse), "usage", apiResp.Usage)
 
 	return aiResponse, &apiResp.Usage, nil
-}
\ No newline at end of file
+}
+
+// GenerateRemoteResponseStream отправляет потоковый запрос (stream: true) к удаленному LLM API.
+// Каждый фрагмент текста передается в onDelta по мере поступления. Возвращает весь накопленный текст
+// даже при ошибке или отмене контекста, чтобы вызывающий код мог сохранить частичный ответ.
+// Usage будет nil, если поток оборвался до последнего чанка.
+func GenerateRemoteResponseStream(ctx context.Context, llmConfig config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string, onDelta func(delta string) error) (string, *Usage, error) {
+	requestBody := APIRequestBody{
+		Model:         llmConfig.ModelName,
+		Messages:      buildAPIMessages(systemPrompt, history, userPrompt, llmConfig.Images),
+		Stream:        true,
+		StreamOptions: &StreamOptions{IncludeUsage: true},
+		MaxTokens:     llmConfig.MaxTokens,
+		Temperature:   llmConfig.Temperature,
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", nil, fmt.Errorf("ошибка кодирования запроса для LLM API: %w", err)
+	}
+
+	// Общий таймаут http.Client ограничивает и чтение тела, поэтому для потока полагаемся на контекст
+	client := &http.Client{}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", llmConfig.APIUrl, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", nil, fmt.Errorf("ошибка создания запроса к LLM API: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+llmConfig.APIKey)
+
+	slog.Info("Отправка потокового запроса к Remote LLM API", "url", llmConfig.APIUrl, "model", llmConfig.ModelName)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			slog.Error("Тайм-аут или отмена при потоковом запросе к LLM API", "url", llmConfig.APIUrl, "error", err)
+			return "", nil, fmt.Errorf("LLM API не ответил вовремя или запрос был отменен (%w)", err)
+		}
+		return "", nil, fmt.Errorf("ошибка отправки запроса к LLM API (%s): %w", llmConfig.APIUrl, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		slog.Error("LLM API вернул ошибку HTTP на потоковый запрос", "status_code", resp.StatusCode, "response_body", string(bodyBytes))
+		var apiResp APIResponseBody
+		if json.Unmarshal(bodyBytes, &apiResp) == nil && apiResp.Error != nil {
+			return "", nil, newStatusError(resp, "ошибка LLM API: %s (%s)", apiResp.Error.Message, apiResp.Error.Type)
+		}
+		return "", nil, newStatusError(resp, "ошибка LLM API: статус %d", resp.StatusCode)
+	}
+
+	var fullText strings.Builder
+	var usage *Usage
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue // Пустые строки-разделители, комментарии ":" и поля event:/id:
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if payload == "[DONE]" {
+			break
+		}
+
+		var chunk APIStreamChunk
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			slog.Warn("Не удалось декодировать чанк потока LLM API, пропускаем", "payload", payload, "error", err)
+			continue
+		}
+		if chunk.Error != nil {
+			slog.Error("LLM API вернул ошибку в потоке", "type", chunk.Error.Type, "message", chunk.Error.Message)
+			return fullText.String(), usage, fmt.Errorf("ошибка LLM API: %s (%s)", chunk.Error.Message, chunk.Error.Type)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			fullText.WriteString(choice.Delta.Content)
+			if onDelta != nil {
+				if err := onDelta(choice.Delta.Content); err != nil {
+					return fullText.String(), usage, fmt.Errorf("передача фрагмента ответа прервана: %w", err)
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fullText.String(), usage, fmt.Errorf("потоковый запрос к LLM API был отменен (%w)", ctxErr)
+		}
+		return fullText.String(), usage, fmt.Errorf("ошибка чтения потока от LLM API: %w", err)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fullText.String(), usage, fmt.Errorf("потоковый запрос к LLM API был отменен (%w)", ctxErr)
+	}
+	if fullText.Len() == 0 {
+		return "", usage, errors.New("получен пустой ответ от LLM API")
+	}
+
+	slog.Info("Сгенерирован потоковый ответ Remote LLM", "response_length", fullText.Len(), "usage", usage)
+	return fullText.String(), usage, nil
+}
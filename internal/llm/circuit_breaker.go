@@ -0,0 +1,85 @@
+// internal/llm/circuit_breaker.go
+package llm
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitBreaker отключает endpoint после серии подряд неудачных запросов.
+// По истечении cooldown пропускается один пробный запрос: успех возвращает endpoint
+// в работу, неудача снова отключает его на cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	endpoints map[string]*breakerState
+}
+
+type breakerState struct {
+	failures  int
+	openUntil time.Time
+	probing   bool // Пробный запрос после cooldown уже выполняется
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if cooldown <= 0 {
+		cooldown = time.Minute
+	}
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		endpoints: make(map[string]*breakerState),
+	}
+}
+
+// allow сообщает, можно ли сейчас обращаться к endpoint'у
+func (b *circuitBreaker) allow(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, ok := b.endpoints[key]
+	if !ok || state.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(state.openUntil) || state.probing {
+		return false
+	}
+	state.probing = true
+	return true
+}
+
+func (b *circuitBreaker) success(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.endpoints, key)
+}
+
+// failure учитывает неудачу и возвращает true, если endpoint только что был отключен
+func (b *circuitBreaker) failure(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, ok := b.endpoints[key]
+	if !ok {
+		state = &breakerState{}
+		b.endpoints[key] = state
+	}
+	state.failures++
+	state.probing = false
+	if state.failures >= b.threshold {
+		state.openUntil = time.Now().Add(b.cooldown)
+		return true
+	}
+	return false
+}
+
+// release снимает флаг пробного запроса, если он завершился без вердикта (например, отменен клиентом)
+func (b *circuitBreaker) release(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if state, ok := b.endpoints[key]; ok {
+		state.probing = false
+	}
+}
@@ -0,0 +1,69 @@
+// internal/llm/errors.go
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrAllProvidersFailed возвращается, когда ни один endpoint (основной и резервные) не ответил
+var ErrAllProvidersFailed = errors.New("все LLM-провайдеры недоступны")
+
+// StatusError - ответ провайдера с HTTP-статусом ошибки
+type StatusError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration // Из заголовка Retry-After, если провайдер его прислал
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// Retryable сообщает, имеет ли смысл повторить запрос: 429 и 5xx обычно временные
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func newStatusError(resp *http.Response, format string, args ...interface{}) *StatusError {
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Message:    fmt.Sprintf(format, args...),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter понимает оба формата заголовка: число секунд и HTTP-дату
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// isRetryable решает, стоит ли повторять запрос к тому же endpoint'у. Отмена контекста
+// вызывающей стороны не повторяется, а сетевые ошибки и таймауты клиента - повторяются.
+func isRetryable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Retryable()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
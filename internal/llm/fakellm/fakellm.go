@@ -0,0 +1,333 @@
+// internal/llm/fakellm/fakellm.go
+//
+// Package fakellm - детерминированный OpenAI-совместимый сервер для локальной разработки
+// и интеграционных проверок без обращения к платному API. Ответы задаются сценарием
+// (очередь Response), функцией-ответчиком или по умолчанию повторяют запрос пользователя.
+package fakellm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/llm"
+)
+
+// Response описывает один ответ фейкового сервера. Нулевые значения дают успешный ответ
+// без задержек с usage, посчитанным по llm.EstimateTokens.
+type Response struct {
+	Content string `json:"content"`
+	// Usage; если оба поля 0, считается оценка по тексту запроса и ответа
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	// Ошибка API: при StatusCode >= 400 вместо ответа возвращается {"error": {...}}
+	StatusCode        int    `json:"status_code"`
+	ErrorMessage      string `json:"error_message"`
+	RetryAfterSeconds int    `json:"retry_after_seconds"`
+	// Задержка перед ответом (или перед первым чанком потока)
+	Delay Duration `json:"delay"`
+	// Параметры потокового режима
+	ChunkRunes      int      `json:"chunk_runes"` // Размер чанка в символах, по умолчанию 8
+	ChunkDelay      Duration `json:"chunk_delay"`
+	OmitStreamUsage bool     `json:"omit_stream_usage"` // Не присылать usage в конце потока
+	FailAfterChunks int      `json:"fail_after_chunks"` // Оборвать поток ошибкой после N чанков
+}
+
+// Duration - time.Duration, который в JSON-сценарии записывается строкой вида "250ms"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("некорректная длительность %q: %w", v, err)
+		}
+		*d = Duration(parsed)
+	case float64:
+		*d = Duration(time.Duration(v) * time.Millisecond) // Число трактуется как миллисекунды
+	default:
+		return fmt.Errorf("некорректная длительность: %s", data)
+	}
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Message - сообщение из запроса клиента
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request - принятый сервером запрос, сохраняется для последующих проверок
+type Request struct {
+	Model         string    `json:"model"`
+	Messages      []Message `json:"messages"`
+	MaxTokens     int       `json:"max_tokens"`
+	Temperature   float64   `json:"temperature"`
+	Stream        bool      `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	Authorization string `json:"-"`
+}
+
+// LastUserMessage возвращает текст последнего сообщения пользователя
+func (r Request) LastUserMessage() string {
+	for i := len(r.Messages) - 1; i >= 0; i-- {
+		if r.Messages[i].Role == "user" {
+			return r.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+// Responder вычисляет ответ по запросу, когда очередь сценария пуста
+type Responder func(req Request) Response
+
+// EchoResponder - ответчик по умолчанию, повторяет последнее сообщение пользователя
+func EchoResponder(req Request) Response {
+	return Response{Content: "Ответ на: " + req.LastUserMessage()}
+}
+
+// Handler - http.Handler фейкового API. Принимает POST на любой путь.
+type Handler struct {
+	mu        sync.Mutex
+	script    []Response
+	responder Responder
+	requests  []Request
+}
+
+func NewHandler() *Handler {
+	return &Handler{responder: EchoResponder}
+}
+
+// Enqueue добавляет ответы в очередь сценария; они отдаются по одному на запрос
+func (h *Handler) Enqueue(responses ...Response) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.script = append(h.script, responses...)
+}
+
+// SetResponder задает ответчик для запросов сверх сценария
+func (h *Handler) SetResponder(responder Responder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if responder == nil {
+		responder = EchoResponder
+	}
+	h.responder = responder
+}
+
+// Requests возвращает копию всех принятых запросов
+func (h *Handler) Requests() []Request {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Request(nil), h.requests...)
+}
+
+// Reset очищает сценарий и журнал запросов
+func (h *Handler) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.script = nil
+	h.requests = nil
+	h.responder = EchoResponder
+}
+
+func (h *Handler) next(req Request) Response {
+	h.mu.Lock()
+	h.requests = append(h.requests, req)
+	if len(h.script) > 0 {
+		resp := h.script[0]
+		h.script = h.script[1:]
+		h.mu.Unlock()
+		return resp
+	}
+	responder := h.responder
+	h.mu.Unlock()
+	return responder(req)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "метод не поддерживается", 0)
+		return
+	}
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "некорректный JSON: "+err.Error(), 0)
+		return
+	}
+	req.Authorization = r.Header.Get("Authorization")
+
+	resp := h.next(req)
+	slog.Debug("fakellm: запрос", "model", req.Model, "stream", req.Stream, "messages", len(req.Messages), "status", resp.StatusCode)
+
+	if !sleepCtx(r, time.Duration(resp.Delay)) {
+		return
+	}
+	if resp.StatusCode >= 400 {
+		message := resp.ErrorMessage
+		if message == "" {
+			message = http.StatusText(resp.StatusCode)
+		}
+		writeError(w, resp.StatusCode, message, resp.RetryAfterSeconds)
+		return
+	}
+
+	usage := resp.usage(req)
+	if req.Stream {
+		h.stream(w, r, req, resp, usage)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      "fake-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		"object":  "chat.completion",
+		"model":   req.Model,
+		"choices": []map[string]interface{}{{"index": 0, "message": Message{Role: "assistant", Content: resp.Content}, "finish_reason": "stop"}},
+		"usage":   usage,
+	})
+}
+
+func (h *Handler) stream(w http.ResponseWriter, r *http.Request, req Request, resp Response, usage llm.Usage) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	send := func(payload interface{}) {
+		data, _ := json.Marshal(payload)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		_ = rc.Flush()
+	}
+	chunk := func(delta map[string]string, finishReason interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"object":  "chat.completion.chunk",
+			"model":   req.Model,
+			"choices": []map[string]interface{}{{"index": 0, "delta": delta, "finish_reason": finishReason}},
+		}
+	}
+
+	send(chunk(map[string]string{"role": "assistant"}, nil))
+	for i, part := range splitRunes(resp.Content, resp.ChunkRunes) {
+		if resp.FailAfterChunks > 0 && i == resp.FailAfterChunks {
+			message := resp.ErrorMessage
+			if message == "" {
+				message = "поток прерван"
+			}
+			send(map[string]interface{}{"error": map[string]string{"message": message, "type": "server_error"}})
+			return
+		}
+		if i > 0 && !sleepCtx(r, time.Duration(resp.ChunkDelay)) {
+			return
+		}
+		send(chunk(map[string]string{"content": part}, nil))
+	}
+	send(chunk(map[string]string{}, "stop"))
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage && !resp.OmitStreamUsage {
+		send(map[string]interface{}{"object": "chat.completion.chunk", "model": req.Model, "choices": []interface{}{}, "usage": usage})
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	_ = rc.Flush()
+}
+
+func (resp Response) usage(req Request) llm.Usage {
+	prompt, completion := resp.PromptTokens, resp.CompletionTokens
+	if prompt == 0 && completion == 0 {
+		for _, msg := range req.Messages {
+			prompt += llm.EstimateTokens(msg.Content)
+		}
+		completion = llm.EstimateTokens(resp.Content)
+	}
+	return llm.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+}
+
+func writeError(w http.ResponseWriter, status int, message string, retryAfterSeconds int) {
+	if retryAfterSeconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": llm.APIError{Message: message, Type: "fake_error", Code: strconv.Itoa(status)},
+	})
+}
+
+// sleepCtx ждет d и возвращает false, если клиент отключился раньше
+func sleepCtx(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-r.Context().Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func splitRunes(text string, size int) []string {
+	if size <= 0 {
+		size = 8
+	}
+	runes := []rune(text)
+	parts := make([]string, 0, len(runes)/size+1)
+	for start := 0; start < len(runes); start += size {
+		end := start + size
+		if end > len(runes) {
+			end = len(runes)
+		}
+		parts = append(parts, string(runes[start:end]))
+	}
+	return parts
+}
+
+// Server - Handler, запущенный на httptest.Server
+type Server struct {
+	*Handler
+	HTTP *httptest.Server
+	URL  string // Адрес endpoint'а chat completions
+}
+
+// NewServer запускает фейковый API на локальном порту. Остановить - Close.
+func NewServer() *Server {
+	h := NewHandler()
+	srv := httptest.NewServer(h)
+	return &Server{Handler: h, HTTP: srv, URL: srv.URL + "/v1/chat/completions"}
+}
+
+func (s *Server) Close() {
+	s.HTTP.Close()
+}
+
+// LLMConfig возвращает конфигурацию OpenAI-совместимого провайдера, направленную на этот сервер
+func (s *Server) LLMConfig() config.RemoteLLMConfig {
+	return config.RemoteLLMConfig{
+		Provider:              llm.ProviderOpenAI,
+		APIKey:                "fake-key",
+		APIUrl:                s.URL,
+		ModelName:             "fake-model",
+		MaxTokens:             2048,
+		Temperature:           0.7,
+		RequestTimeoutSeconds: 10,
+		Retry:                 config.LLMRetryConfig{MaxAttempts: 1},
+	}
+}
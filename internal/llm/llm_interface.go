@@ -7,19 +7,27 @@ import (
 	"shaman-ai.kz/internal/db"
 )
 
+// Client - общий интерфейс LLM-провайдера. Конкретный endpoint, ключ и модель передаются в llmCfg,
+// поэтому один экземпляр клиента может обслуживать разные модели одного провайдера.
 type Client interface {
 	GenerateRemoteResponse(ctx context.Context, llmCfg config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string) (string, *Usage, error)
+	// GenerateRemoteResponseStream передает фрагменты ответа в onDelta по мере генерации
+	GenerateRemoteResponseStream(ctx context.Context, llmCfg config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string, onDelta func(delta string) error) (string, *Usage, error)
 }
 
-// Ваша существующая структура
-type APIClient struct {
-	// поля если есть
+// VisionChecker реализуют клиенты, которые знают, принимает ли выбранная модель изображения
+// (список remote_llm.vision_models). Изображения передаются в llmCfg.Images.
+type VisionChecker interface {
+	SupportsVision(llmCfg config.RemoteLLMConfig) bool
 }
 
-// Реализация интерфейса вашим существующим клиентом
+// APIClient - клиент для OpenAI-совместимых API (OpenAI, Fireworks, OpenRouter и т.п.)
+type APIClient struct{}
+
 func (c *APIClient) GenerateRemoteResponse(ctx context.Context, llmCfg config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string) (string, *Usage, error) {
-	return GenerateRemoteResponse(ctx, llmCfg, systemPrompt, history, userPrompt) // Вызов вашей текущей функции
+	return GenerateRemoteResponse(ctx, llmCfg, systemPrompt, history, userPrompt)
 }
 
-// Ваша существующая функция, возможно, станет методом или будет вызываться из метода
-// func GenerateRemoteResponse(...) (string, error) { ... }
\ No newline at end of file
+func (c *APIClient) GenerateRemoteResponseStream(ctx context.Context, llmCfg config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string, onDelta func(delta string) error) (string, *Usage, error) {
+	return GenerateRemoteResponseStream(ctx, llmCfg, systemPrompt, history, userPrompt, onDelta)
+}
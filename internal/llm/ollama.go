@@ -0,0 +1,228 @@
+// internal/llm/ollama.go
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+)
+
+const ollamaDefaultURL = "http://localhost:11434/api/chat"
+
+// OllamaClient - клиент для локального Ollama-совместимого endpoint (/api/chat)
+type OllamaClient struct{}
+
+type ollamaOptions struct {
+	Temperature float64 `json:"temperature,omitempty"`
+	NumPredict  int     `json:"num_predict,omitempty"`
+}
+
+// ollamaMessage - сообщение /api/chat; изображения передаются отдельным полем в base64 без префикса data:
+type ollamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+type ollamaRequestBody struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  ollamaOptions   `json:"options"`
+}
+
+// Ответ /api/chat. В потоковом режиме приходит по одному такому объекту на строку (NDJSON),
+// счетчики токенов заполнены только в последнем объекте с done: true.
+type ollamaResponseBody struct {
+	Message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error,omitempty"`
+}
+
+func (r *ollamaResponseBody) usage() *Usage {
+	return &Usage{
+		PromptTokens:     r.PromptEvalCount,
+		CompletionTokens: r.EvalCount,
+		TotalTokens:      r.PromptEvalCount + r.EvalCount,
+	}
+}
+
+func buildOllamaMessages(systemPrompt string, history []db.Message, userPrompt string, images []string) []ollamaMessage {
+	var messages []ollamaMessage
+	for _, msg := range buildAPIMessages(systemPrompt, history, userPrompt, nil) {
+		content, _ := msg.Content.(string)
+		messages = append(messages, ollamaMessage{Role: msg.Role, Content: content})
+	}
+	last := &messages[len(messages)-1]
+	for _, image := range images {
+		if _, data, ok := splitDataURL(image); ok {
+			last.Images = append(last.Images, data)
+		}
+	}
+	return messages
+}
+
+func (c *OllamaClient) newRequest(ctx context.Context, llmCfg config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string, stream bool) (*http.Request, error) {
+	jsonData, err := json.Marshal(ollamaRequestBody{
+		Model:    llmCfg.ModelName,
+		Messages: buildOllamaMessages(systemPrompt, history, userPrompt, llmCfg.Images),
+		Stream:   stream,
+		Options:  ollamaOptions{Temperature: llmCfg.Temperature, NumPredict: llmCfg.MaxTokens},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка кодирования запроса для Ollama: %w", err)
+	}
+	apiURL := llmCfg.APIUrl
+	if apiURL == "" {
+		apiURL = ollamaDefaultURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания запроса к Ollama: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Локальному Ollama ключ не нужен, но прокси перед ним может его требовать
+	if llmCfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+llmCfg.APIKey)
+	}
+	return req, nil
+}
+
+func ollamaErrorFromBody(resp *http.Response, bodyBytes []byte) error {
+	var apiResp ollamaResponseBody
+	if json.Unmarshal(bodyBytes, &apiResp) == nil && apiResp.Error != "" {
+		return newStatusError(resp, "ошибка Ollama: %s", apiResp.Error)
+	}
+	return newStatusError(resp, "ошибка Ollama: статус %d", resp.StatusCode)
+}
+
+func (c *OllamaClient) GenerateRemoteResponse(ctx context.Context, llmCfg config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string) (string, *Usage, error) {
+	req, err := c.newRequest(ctx, llmCfg, systemPrompt, history, userPrompt, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	client := &http.Client{Timeout: time.Duration(llmCfg.RequestTimeoutSeconds) * time.Second}
+	slog.Info("Отправка запроса к Ollama", "url", req.URL.String(), "model", llmCfg.ModelName)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return "", nil, fmt.Errorf("Ollama не ответила вовремя или запрос был отменен (%w)", err)
+		}
+		return "", nil, fmt.Errorf("ошибка отправки запроса к Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("ошибка чтения ответа от Ollama: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		slog.Error("Ollama вернула ошибку HTTP", "status_code", resp.StatusCode, "response_body", string(bodyBytes))
+		return "", nil, ollamaErrorFromBody(resp, bodyBytes)
+	}
+
+	var apiResp ollamaResponseBody
+	if err := json.Unmarshal(bodyBytes, &apiResp); err != nil {
+		return "", nil, fmt.Errorf("ошибка декодирования JSON ответа от Ollama: %w", err)
+	}
+	if apiResp.Error != "" {
+		return "", nil, fmt.Errorf("ошибка Ollama: %s", apiResp.Error)
+	}
+	if apiResp.Message.Content == "" {
+		slog.Warn("Ответ от Ollama не содержит текста", "response_body", string(bodyBytes))
+		return "", nil, errors.New("получен пустой ответ от Ollama")
+	}
+
+	usage := apiResp.usage()
+	slog.Info("Сгенерирован ответ Ollama", "response_length", len(apiResp.Message.Content), "usage", usage)
+	return apiResp.Message.Content, usage, nil
+}
+
+func (c *OllamaClient) GenerateRemoteResponseStream(ctx context.Context, llmCfg config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string, onDelta func(delta string) error) (string, *Usage, error) {
+	req, err := c.newRequest(ctx, llmCfg, systemPrompt, history, userPrompt, true)
+	if err != nil {
+		return "", nil, err
+	}
+
+	slog.Info("Отправка потокового запроса к Ollama", "url", req.URL.String(), "model", llmCfg.ModelName)
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return "", nil, fmt.Errorf("Ollama не ответила вовремя или запрос был отменен (%w)", err)
+		}
+		return "", nil, fmt.Errorf("ошибка отправки запроса к Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		slog.Error("Ollama вернула ошибку HTTP на потоковый запрос", "status_code", resp.StatusCode, "response_body", string(bodyBytes))
+		return "", nil, ollamaErrorFromBody(resp, bodyBytes)
+	}
+
+	var fullText strings.Builder
+	var usage *Usage
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaResponseBody
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			slog.Warn("Не удалось декодировать строку потока Ollama, пропускаем", "line", string(line), "error", err)
+			continue
+		}
+		if chunk.Error != "" {
+			return fullText.String(), usage, fmt.Errorf("ошибка Ollama: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			fullText.WriteString(chunk.Message.Content)
+			if onDelta != nil {
+				if err := onDelta(chunk.Message.Content); err != nil {
+					return fullText.String(), usage, fmt.Errorf("передача фрагмента ответа прервана: %w", err)
+				}
+			}
+		}
+		if chunk.Done {
+			usage = chunk.usage()
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fullText.String(), usage, fmt.Errorf("потоковый запрос к Ollama был отменен (%w)", ctxErr)
+		}
+		return fullText.String(), usage, fmt.Errorf("ошибка чтения потока от Ollama: %w", err)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fullText.String(), usage, fmt.Errorf("потоковый запрос к Ollama был отменен (%w)", ctxErr)
+	}
+	if fullText.Len() == 0 {
+		return "", usage, errors.New("получен пустой ответ от Ollama")
+	}
+
+	slog.Info("Сгенерирован потоковый ответ Ollama", "response_length", fullText.Len(), "usage", usage)
+	return fullText.String(), usage, nil
+}
@@ -0,0 +1,364 @@
+// internal/llm/registry.go
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+)
+
+// Типы провайдеров. Имя провайдера в конфиге совпадает с его типом.
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderOllama    = "ollama"
+)
+
+// DefaultModelSettingKey - настройка приложения, переопределяющая модель (и, при наличии префикса, провайдера)
+const DefaultModelSettingKey = "default_llm_model"
+
+// Конструкторы клиентов по типу провайдера
+var providerFactories = map[string]func() Client{
+	ProviderOpenAI:    func() Client { return &APIClient{} },
+	ProviderAnthropic: func() Client { return &AnthropicClient{} },
+	ProviderOllama:    func() Client { return &OllamaClient{} },
+}
+
+type registryEntry struct {
+	client Client
+	cfg    config.LLMProviderConfig
+}
+
+// Registry выбирает провайдера для каждого запроса и сам реализует Client,
+// поэтому обработчики получают его как обычный клиент.
+//
+// Провайдер по умолчанию задается remote_llm.provider (endpoint и ключ - из remote_llm),
+// дополнительные - в remote_llm.providers. Настройка default_llm_model вида
+// "anthropic:claude-sonnet-4-20250514" переключает провайдера и модель, значение без
+// известного префикса меняет только модель провайдера по умолчанию.
+//
+// Если выбранный endpoint отвечает 429/5xx или недоступен, запрос повторяется с экспоненциальной
+// задержкой, а затем по очереди отправляется на резервные endpoint'ы из remote_llm.fallbacks.
+// Endpoint'ы, которые раз за разом не отвечают, отключаются на время cooldown.
+type Registry struct {
+	primary   string
+	providers map[string]registryEntry
+	fallbacks []llmTarget
+	retry     config.LLMRetryConfig
+	breaker   *circuitBreaker
+	// Модели, принимающие изображения: "<модель>" или "<провайдер>:<модель>"
+	visionModels map[string]bool
+	// Источник значения default_llm_model
+	modelSetting func() string
+}
+
+// llmTarget - конкретный endpoint с моделью, к которому отправляется запрос
+type llmTarget struct {
+	provider string
+	client   Client
+	cfg      config.RemoteLLMConfig
+}
+
+func (t llmTarget) key() string {
+	return t.provider + "|" + t.cfg.APIUrl + "|" + t.cfg.ModelName
+}
+
+// NewRegistry создает реестр провайдеров из конфигурации
+func NewRegistry(llmCfg config.RemoteLLMConfig) (*Registry, error) {
+	primary := strings.ToLower(strings.TrimSpace(llmCfg.Provider))
+	if primary == "" {
+		primary = ProviderOpenAI
+	}
+
+	r := &Registry{
+		primary:      primary,
+		providers:    make(map[string]registryEntry),
+		retry:        llmCfg.Retry,
+		breaker:      newCircuitBreaker(llmCfg.CircuitBreaker.FailureThreshold, time.Duration(llmCfg.CircuitBreaker.CooldownSeconds)*time.Second),
+		visionModels: make(map[string]bool),
+		modelSetting: modelFromSettings,
+	}
+	for _, model := range llmCfg.VisionModels {
+		if model = strings.TrimSpace(model); model != "" {
+			r.visionModels[model] = true
+		}
+	}
+	if r.retry.MaxAttempts <= 0 {
+		r.retry.MaxAttempts = 1
+	}
+	if err := r.register(primary, config.LLMProviderConfig{
+		APIKey:    llmCfg.APIKey,
+		APIUrl:    llmCfg.APIUrl,
+		ModelName: llmCfg.ModelName,
+	}); err != nil {
+		return nil, err
+	}
+	for name, providerCfg := range llmCfg.Providers {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == primary {
+			slog.Warn("Провайдер LLM из remote_llm.providers совпадает с основным и будет проигнорирован", "provider", name)
+			continue
+		}
+		if err := r.register(name, providerCfg); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, fallback := range llmCfg.Fallbacks {
+		name := strings.ToLower(strings.TrimSpace(fallback.Provider))
+		if name == "" {
+			name = primary
+		}
+		factory, ok := providerFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("неизвестный провайдер LLM в remote_llm.fallbacks[%d]: %s", i, name)
+		}
+		// Пустые ключ и адрес наследуются от одноименного провайдера
+		target := llmTarget{provider: name, client: factory(), cfg: llmCfg}
+		target.cfg.Provider = name
+		target.cfg.APIKey, target.cfg.APIUrl, target.cfg.ModelName = fallback.APIKey, fallback.APIUrl, fallback.ModelName
+		if entry, registered := r.providers[name]; registered {
+			if target.cfg.APIKey == "" {
+				target.cfg.APIKey = entry.cfg.APIKey
+			}
+			if target.cfg.APIUrl == "" {
+				target.cfg.APIUrl = entry.cfg.APIUrl
+			}
+		}
+		r.fallbacks = append(r.fallbacks, target)
+	}
+
+	slog.Info("Реестр LLM-провайдеров инициализирован", "primary", primary, "providers", len(r.providers), "fallbacks", len(r.fallbacks))
+	return r, nil
+}
+
+func (r *Registry) register(name string, providerCfg config.LLMProviderConfig) error {
+	factory, ok := providerFactories[name]
+	if !ok {
+		return fmt.Errorf("неизвестный провайдер LLM: %s", name)
+	}
+	r.providers[name] = registryEntry{client: factory(), cfg: providerCfg}
+	return nil
+}
+
+// modelFromSettings читает default_llm_model из app_settings
+func modelFromSettings() string {
+	setting, err := db.GetSetting(DefaultModelSettingKey)
+	if err != nil {
+		slog.Warn("Не удалось прочитать настройку модели LLM, используется конфиг", "error", err)
+		return ""
+	}
+	if setting == nil {
+		return ""
+	}
+	return strings.TrimSpace(setting.Value)
+}
+
+// Resolve возвращает клиента и конфигурацию запроса для текущих настроек: модель берется
+// из llmCfg.ModelSpec, а если она не задана - из default_llm_model. Параметры генерации (таймаут, max_tokens, temperature) берутся из llmCfg.
+func (r *Registry) Resolve(llmCfg config.RemoteLLMConfig) (Client, config.RemoteLLMConfig, string) {
+	name, model := r.primary, ""
+	if llmCfg.ModelSpec != "" {
+		name, model = r.parseModel(llmCfg.ModelSpec)
+	} else if value := r.modelSetting(); value != "" {
+		name, model = r.parseModel(value)
+	}
+	client, resolved := r.resolve(llmCfg, name, model)
+	return client, resolved, name
+}
+
+// ResolveModel возвращает клиента и конфигурацию для явно заданной модели "<провайдер>:<модель>"
+// без учета default_llm_model - например, для вспомогательных запросов к дешевой модели
+func (r *Registry) ResolveModel(llmCfg config.RemoteLLMConfig, spec string) (Client, config.RemoteLLMConfig) {
+	name, model := r.parseModel(strings.TrimSpace(spec))
+	return r.resolve(llmCfg, name, model)
+}
+
+func (r *Registry) resolve(llmCfg config.RemoteLLMConfig, name, model string) (Client, config.RemoteLLMConfig) {
+	entry := r.providers[name]
+	resolved := llmCfg
+	resolved.ModelSpec = ""
+	resolved.Provider = name
+	resolved.APIKey = entry.cfg.APIKey
+	resolved.APIUrl = entry.cfg.APIUrl
+	resolved.ModelName = entry.cfg.ModelName
+	if model != "" {
+		resolved.ModelName = model
+	}
+	return entry.client, resolved
+}
+
+// SupportsVision сообщает, принимает ли изображения модель, выбранная для llmCfg
+func (r *Registry) SupportsVision(llmCfg config.RemoteLLMConfig) bool {
+	_, resolved, name := r.Resolve(llmCfg)
+	return r.supportsVision(name, resolved.ModelName)
+}
+
+func (r *Registry) supportsVision(provider, model string) bool {
+	return r.visionModels[model] || r.visionModels[provider+":"+model]
+}
+
+// parseModel разбирает "<провайдер>:<модель>". Двоеточие встречается и в именах моделей
+// (например, "llama3:8b" у Ollama), поэтому префикс учитывается, только если это известный провайдер.
+func (r *Registry) parseModel(value string) (string, string) {
+	if prefix, model, ok := strings.Cut(value, ":"); ok {
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		if _, known := r.providers[prefix]; known {
+			return prefix, strings.TrimSpace(model)
+		}
+		if _, known := providerFactories[prefix]; known {
+			slog.Warn("Провайдер из default_llm_model не настроен, используется основной", "provider", prefix)
+			return r.primary, ""
+		}
+	}
+	return r.primary, value
+}
+
+// targets возвращает основной endpoint и резервные в порядке приоритета.
+// Параметры генерации (таймаут, max_tokens, temperature) и изображения у всех берутся из текущего llmCfg.
+func (r *Registry) targets(llmCfg config.RemoteLLMConfig) []llmTarget {
+	client, resolved, name := r.Resolve(llmCfg)
+	targets := []llmTarget{{provider: name, client: client, cfg: resolved}}
+	for _, fallback := range r.fallbacks {
+		target := fallback
+		target.cfg.RequestTimeoutSeconds = llmCfg.RequestTimeoutSeconds
+		target.cfg.MaxTokens = llmCfg.MaxTokens
+		target.cfg.Temperature = llmCfg.Temperature
+		target.cfg.Images = llmCfg.Images
+		// Резервный endpoint, совпадающий с основным, повторно не опрашиваем
+		if target.key() == targets[0].key() {
+			continue
+		}
+		targets = append(targets, target)
+	}
+	// Модель без поддержки изображений получит только текст, иначе API отклонит запрос
+	for i := range targets {
+		if len(targets[i].cfg.Images) > 0 && !r.supportsVision(targets[i].provider, targets[i].cfg.ModelName) {
+			slog.Warn("Модель не поддерживает изображения, они не будут переданы", "provider", targets[i].provider, "model", targets[i].cfg.ModelName)
+			targets[i].cfg.Images = nil
+		}
+	}
+	return targets
+}
+
+// backoff возвращает задержку перед повтором: экспонента со случайным разбросом,
+// но не меньше Retry-After от провайдера и не больше max_backoff_ms
+func (r *Registry) backoff(attempt int, err error) time.Duration {
+	maxBackoff := time.Duration(r.retry.MaxBackoffMs) * time.Millisecond
+	delay := time.Duration(r.retry.InitialBackoffMs) * time.Millisecond << (attempt - 1)
+	if delay <= 0 || (maxBackoff > 0 && delay > maxBackoff) {
+		delay = maxBackoff
+	}
+	if delay > 0 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+	// Ошибка провайдера приходит обернутой (%w), поэтому ищем StatusError по цепочке
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+		delay = statusErr.RetryAfter
+		if maxBackoff > 0 && delay > maxBackoff {
+			delay = maxBackoff
+		}
+	}
+	return delay
+}
+
+type generateFunc func(ctx context.Context, client Client, llmCfg config.RemoteLLMConfig) (string, *Usage, error)
+
+// generate выполняет запрос с повторами и переключением на резервные endpoint'ы.
+// canRetry позволяет запретить повтор, когда часть ответа уже отдана клиенту.
+func (r *Registry) generate(ctx context.Context, llmCfg config.RemoteLLMConfig, call generateFunc, canRetry func() bool) (string, *Usage, error) {
+	var lastErr error
+	for index, target := range r.targets(llmCfg) {
+		key := target.key()
+		if !r.breaker.allow(key) {
+			slog.Warn("LLM endpoint временно отключен, пропускаем", "provider", target.provider, "url", target.cfg.APIUrl, "model", target.cfg.ModelName)
+			continue
+		}
+
+		for attempt := 1; attempt <= r.retry.MaxAttempts; attempt++ {
+			slog.Info("Запрос к LLM", "provider", target.provider, "url", target.cfg.APIUrl, "model", target.cfg.ModelName, "attempt", attempt, "fallback_index", index)
+
+			text, usage, err := call(ctx, target.client, target.cfg)
+			if usage != nil {
+				usage.Model = target.cfg.ModelName
+			}
+			if err == nil {
+				r.breaker.success(key)
+				if index > 0 {
+					slog.Warn("Ответ получен от резервного LLM endpoint", "provider", target.provider, "url", target.cfg.APIUrl, "model", target.cfg.ModelName)
+				}
+				return text, usage, nil
+			}
+			lastErr = err
+
+			if ctx.Err() != nil {
+				r.breaker.release(key)
+				return text, usage, err
+			}
+			if !canRetry() {
+				// Часть ответа уже передана клиенту, повтор или смена модели исказили бы его
+				if r.breaker.failure(key) {
+					slog.Error("LLM endpoint отключен после серии ошибок", "provider", target.provider, "url", target.cfg.APIUrl, "model", target.cfg.ModelName)
+				}
+				return text, usage, err
+			}
+
+			retryable := isRetryable(ctx, err)
+			slog.Warn("Попытка запроса к LLM не удалась", "provider", target.provider, "url", target.cfg.APIUrl, "model", target.cfg.ModelName, "attempt", attempt, "retryable", retryable, "error", err)
+			if !retryable || attempt == r.retry.MaxAttempts {
+				break
+			}
+
+			timer := time.NewTimer(r.backoff(attempt, err))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				r.breaker.release(key)
+				return "", nil, err
+			case <-timer.C:
+			}
+		}
+
+		// В цепь засчитываем только признаки недоступности; ошибки вида 400 говорят о самом запросе
+		if isRetryable(ctx, lastErr) {
+			if r.breaker.failure(key) {
+				slog.Error("LLM endpoint отключен после серии ошибок", "provider", target.provider, "url", target.cfg.APIUrl, "model", target.cfg.ModelName)
+			}
+		} else {
+			r.breaker.release(key)
+		}
+	}
+
+	if lastErr == nil {
+		return "", nil, fmt.Errorf("%w: все endpoint'ы временно отключены", ErrAllProvidersFailed)
+	}
+	return "", nil, fmt.Errorf("%w: %w", ErrAllProvidersFailed, lastErr)
+}
+
+func (r *Registry) GenerateRemoteResponse(ctx context.Context, llmCfg config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string) (string, *Usage, error) {
+	return r.generate(ctx, llmCfg, func(ctx context.Context, client Client, cfg config.RemoteLLMConfig) (string, *Usage, error) {
+		return client.GenerateRemoteResponse(ctx, cfg, systemPrompt, history, userPrompt)
+	}, func() bool { return true })
+}
+
+// GenerateRemoteResponseStream переключается на резервный endpoint только до первого фрагмента ответа
+func (r *Registry) GenerateRemoteResponseStream(ctx context.Context, llmCfg config.RemoteLLMConfig, systemPrompt string, history []db.Message, userPrompt string, onDelta func(delta string) error) (string, *Usage, error) {
+	started := false
+	return r.generate(ctx, llmCfg, func(ctx context.Context, client Client, cfg config.RemoteLLMConfig) (string, *Usage, error) {
+		return client.GenerateRemoteResponseStream(ctx, cfg, systemPrompt, history, userPrompt, func(delta string) error {
+			started = true
+			if onDelta == nil {
+				return nil
+			}
+			return onDelta(delta)
+		})
+	}, func() bool { return !started })
+}
@@ -0,0 +1,180 @@
+// internal/llm/registry_failover_test.go
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/llm"
+	"shaman-ai.kz/internal/llm/fakellm"
+)
+
+// newFailoverRegistry wires a primary fake endpoint with a fallback fake endpoint serving another model
+func newFailoverRegistry(t *testing.T, configure func(cfg *config.RemoteLLMConfig)) (*llm.Registry, config.RemoteLLMConfig, *fakellm.Server, *fakellm.Server) {
+	t.Helper()
+	primary, fallback := fakellm.NewServer(), fakellm.NewServer()
+	t.Cleanup(primary.Close)
+	t.Cleanup(fallback.Close)
+
+	cfg := primary.LLMConfig()
+	cfg.Retry = config.LLMRetryConfig{MaxAttempts: 2, InitialBackoffMs: 1, MaxBackoffMs: 5}
+	cfg.Fallbacks = []config.LLMFallbackConfig{{Provider: llm.ProviderOpenAI, APIUrl: fallback.URL, ModelName: "fallback-model"}}
+	if configure != nil {
+		configure(&cfg)
+	}
+	registry, err := llm.NewRegistry(cfg)
+	if err != nil {
+		t.Fatalf("NewRegistry failed: %v", err)
+	}
+	return registry, cfg, primary, fallback
+}
+
+func unavailable() fakellm.Response {
+	return fakellm.Response{StatusCode: http.StatusServiceUnavailable, ErrorMessage: "overloaded"}
+}
+
+func TestRegistryFailover(t *testing.T) {
+	answer := func(content string) fakellm.Response {
+		return fakellm.Response{Content: content, PromptTokens: 50, CompletionTokens: 7}
+	}
+	cases := []struct {
+		name              string
+		primary, fallback []fakellm.Response
+		stream            bool
+		wantText          string
+		wantModel         string // Model the usage is attributed to
+		wantPrimaryCalls  int
+		wantFallbackCalls int
+		wantErr           bool
+	}{
+		{
+			name: "primary answers", primary: []fakellm.Response{answer("primary")},
+			wantText: "primary", wantModel: "fake-model", wantPrimaryCalls: 1,
+		},
+		{
+			name: "retried on primary", primary: []fakellm.Response{unavailable(), answer("primary")},
+			wantText: "primary", wantModel: "fake-model", wantPrimaryCalls: 2,
+		},
+		{
+			name: "5xx falls back", primary: []fakellm.Response{unavailable(), unavailable()}, fallback: []fakellm.Response{answer("fallback")},
+			wantText: "fallback", wantModel: "fallback-model", wantPrimaryCalls: 2, wantFallbackCalls: 1,
+		},
+		{
+			name:     "429 falls back",
+			primary:  []fakellm.Response{{StatusCode: http.StatusTooManyRequests, ErrorMessage: "rate limited"}, {StatusCode: http.StatusTooManyRequests, ErrorMessage: "rate limited"}},
+			fallback: []fakellm.Response{answer("fallback")},
+			wantText: "fallback", wantModel: "fallback-model", wantPrimaryCalls: 2, wantFallbackCalls: 1,
+		},
+		{
+			name: "client error is not retried", primary: []fakellm.Response{{StatusCode: http.StatusBadRequest, ErrorMessage: "bad request"}},
+			fallback: []fakellm.Response{answer("fallback")},
+			wantText: "fallback", wantModel: "fallback-model", wantPrimaryCalls: 1, wantFallbackCalls: 1,
+		},
+		{
+			name: "stream falls back before the first chunk", primary: []fakellm.Response{unavailable(), unavailable()}, fallback: []fakellm.Response{answer("fallback")},
+			stream: true, wantText: "fallback", wantModel: "fallback-model", wantPrimaryCalls: 2, wantFallbackCalls: 1,
+		},
+		{
+			name: "stream broken midway is not switched", primary: []fakellm.Response{{Content: "primary answer", ChunkRunes: 4, FailAfterChunks: 1}},
+			stream: true, wantPrimaryCalls: 1, wantErr: true,
+		},
+		{
+			name: "all endpoints fail", primary: []fakellm.Response{unavailable(), unavailable()}, fallback: []fakellm.Response{unavailable(), unavailable()},
+			wantPrimaryCalls: 2, wantFallbackCalls: 2, wantErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			registry, cfg, primary, fallback := newFailoverRegistry(t, nil)
+			primary.Enqueue(tc.primary...)
+			fallback.Enqueue(tc.fallback...)
+
+			var text string
+			var usage *llm.Usage
+			var err error
+			if tc.stream {
+				text, usage, err = registry.GenerateRemoteResponseStream(context.Background(), cfg, "system", nil, "вопрос", nil)
+			} else {
+				text, usage, err = registry.GenerateRemoteResponse(context.Background(), cfg, "system", nil, "вопрос")
+			}
+
+			if got := len(primary.Requests()); got != tc.wantPrimaryCalls {
+				t.Errorf("Primary received %d requests, want %d", got, tc.wantPrimaryCalls)
+			}
+			if got := len(fallback.Requests()); got != tc.wantFallbackCalls {
+				t.Errorf("Fallback received %d requests, want %d", got, tc.wantFallbackCalls)
+			}
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got %q", text)
+				}
+				if len(tc.fallback) > 0 && !errors.Is(err, llm.ErrAllProvidersFailed) {
+					t.Fatalf("Expected ErrAllProvidersFailed, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if text != tc.wantText {
+				t.Fatalf("Got %q, want %q", text, tc.wantText)
+			}
+			if usage == nil || usage.Model != tc.wantModel || usage.PromptTokens != 50 || usage.CompletionTokens != 7 {
+				t.Fatalf("Usage must be attributed to %s with its tokens, got %+v", tc.wantModel, usage)
+			}
+		})
+	}
+}
+
+func TestRegistryCircuitBreaker(t *testing.T) {
+	registry, cfg, primary, fallback := newFailoverRegistry(t, func(cfg *config.RemoteLLMConfig) {
+		cfg.Retry.MaxAttempts = 1
+		cfg.CircuitBreaker = config.LLMCircuitBreakerConfig{FailureThreshold: 2, CooldownSeconds: 1}
+	})
+	fallback.SetResponder(func(req fakellm.Request) fakellm.Response {
+		return fakellm.Response{Content: "fallback"}
+	})
+	const cooldown = time.Second + 100*time.Millisecond
+
+	steps := []struct {
+		name             string
+		wait             time.Duration
+		primary          []fakellm.Response
+		wantText         string
+		wantPrimaryCalls int // Total requests the primary has received after the step
+	}{
+		{"first failure keeps the circuit closed", 0, []fakellm.Response{unavailable()}, "fallback", 1},
+		{"threshold reached opens the circuit", 0, []fakellm.Response{unavailable()}, "fallback", 2},
+		{"open circuit skips the primary", 0, nil, "fallback", 2},
+		{"half-open probe fails and reopens", cooldown, []fakellm.Response{unavailable()}, "fallback", 3},
+		{"reopened circuit skips the primary", 0, nil, "fallback", 3},
+		{"half-open probe succeeds and closes", cooldown, []fakellm.Response{{Content: "primary"}}, "primary", 4},
+		{"closed circuit counts failures from zero", 0, []fakellm.Response{unavailable()}, "fallback", 5},
+		{"one failure after closing does not open", 0, []fakellm.Response{{Content: "primary"}}, "primary", 6},
+	}
+	for _, step := range steps {
+		time.Sleep(step.wait)
+		primary.Enqueue(step.primary...)
+		text, usage, err := registry.GenerateRemoteResponse(context.Background(), cfg, "system", nil, "вопрос")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if text != step.wantText {
+			t.Fatalf("%s: got %q, want %q", step.name, text, step.wantText)
+		}
+		wantModel := "fake-model"
+		if step.wantText == "fallback" {
+			wantModel = "fallback-model"
+		}
+		if usage == nil || usage.Model != wantModel {
+			t.Fatalf("%s: usage must be attributed to %s, got %+v", step.name, wantModel, usage)
+		}
+		if got := len(primary.Requests()); got != step.wantPrimaryCalls {
+			t.Fatalf("%s: primary received %d requests, want %d", step.name, got, step.wantPrimaryCalls)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package llm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+)
+
+func TestBackoffRespectsWrappedRetryAfter(t *testing.T) {
+	r := &Registry{retry: config.LLMRetryConfig{InitialBackoffMs: 10, MaxBackoffMs: 5000}}
+	statusErr := &StatusError{StatusCode: 429, Message: "rate limited", RetryAfter: 2 * time.Second}
+	err := fmt.Errorf("request failed: %w", statusErr)
+
+	if delay := r.backoff(1, err); delay != 2*time.Second {
+		t.Fatalf("backoff() = %v, want Retry-After 2s", delay)
+	}
+
+	// Retry-After is capped by max_backoff_ms
+	statusErr.RetryAfter = time.Minute
+	if delay := r.backoff(1, err); delay != 5*time.Second {
+		t.Fatalf("backoff() = %v, want max backoff 5s", delay)
+	}
+}
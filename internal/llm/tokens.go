@@ -0,0 +1,40 @@
+// internal/llm/tokens.go
+package llm
+
+import (
+	"unicode/utf8"
+
+	"shaman-ai.kz/internal/db"
+)
+
+// runesPerToken - грубая оценка для смешанного русского/казахского/английского текста.
+// Используется только там, где API не вернул точный usage (например, оборванный поток).
+const runesPerToken = 3
+
+// EstimateTokens приблизительно оценивает количество токенов в тексте.
+func EstimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	n := utf8.RuneCountInString(text)
+	return (n + runesPerToken - 1) / runesPerToken
+}
+
+// RunesForTokens - сколько символов текста примерно соответствует tokens токенам
+func RunesForTokens(tokens int) int {
+	return tokens * runesPerToken
+}
+
+// EstimateUsage оценивает usage запроса, если API его не прислал.
+func EstimateUsage(systemPrompt string, history []db.Message, userPrompt, completion string) *Usage {
+	promptTokens := EstimateTokens(systemPrompt) + EstimateTokens(userPrompt)
+	for _, msg := range history {
+		promptTokens += EstimateTokens(msg.Content)
+	}
+	completionTokens := EstimateTokens(completion)
+	return &Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+}
@@ -0,0 +1,132 @@
+// internal/middleware/client_ip.go
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const clientIPContextKey contextKey = "clientIP"
+
+// TrustedProxies - обратные прокси, которым разрешено передавать адрес клиента в X-Forwarded-For
+// и X-Real-IP. Заголовки от остальных игнорируются: их может подставить любой клиент.
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+}
+
+// ParseTrustedProxies разбирает список IP-адресов и подсетей CIDR ("10.0.0.0/8", "127.0.0.1")
+func ParseTrustedProxies(list []string) (*TrustedProxies, error) {
+	p := &TrustedProxies{}
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("некорректный адрес доверенного прокси %q: %w", item, err)
+			}
+			addr = addr.Unmap()
+			p.prefixes = append(p.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("некорректная подсеть доверенных прокси %q: %w", item, err)
+		}
+		p.prefixes = append(p.prefixes, prefix.Masked())
+	}
+	return p, nil
+}
+
+func (p *TrustedProxies) trusts(addr netip.Addr) bool {
+	if p == nil {
+		return false
+	}
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr - адрес соединения без порта
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// resolve определяет адрес клиента. X-Forwarded-For читается справа налево: каждый прокси дописывает
+// в конец адрес, от которого получил запрос, поэтому клиент - первый адрес не из доверенных прокси.
+// Все, что левее, мог прислать сам клиент.
+func (p *TrustedProxies) resolve(r *http.Request) string {
+	addr, ok := remoteAddr(r)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !p.trusts(addr) {
+		return addr.String()
+	}
+
+	var forwarded []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+	if len(forwarded) == 0 {
+		if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return realIP.Unmap().String()
+		}
+		return addr.String()
+	}
+	client := addr
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break // Дальше левее мусор - останавливаемся на последнем разобранном адресе
+		}
+		client = hop.Unmap()
+		if !p.trusts(client) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// ClientIPMiddleware определяет адрес клиента с учетом доверенных прокси и сохраняет его в контексте
+// для ClientIP. Подключается первой, до всех обработчиков, которым нужен IP.
+func ClientIPMiddleware(proxies *TrustedProxies) func(http.Handler) http.Handler {
+	if proxies == nil || len(proxies.prefixes) == 0 {
+		slog.Info("Доверенные прокси не заданы, X-Forwarded-For и X-Real-IP игнорируются")
+	} else {
+		slog.Info("Адрес клиента берется из X-Forwarded-For доверенных прокси", "trusted_proxies", len(proxies.prefixes))
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientIPContextKey, proxies.resolve(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientIP возвращает IP-адрес клиента, определенный ClientIPMiddleware. Без нее (например, в тестах)
+// используется адрес соединения: заголовкам прокси без списка доверенных верить нельзя.
+func ClientIP(r *http.Request) string {
+	if clientIP, ok := r.Context().Value(clientIPContextKey).(string); ok && clientIP != "" {
+		return clientIP
+	}
+	return (*TrustedProxies)(nil).resolve(r)
+}
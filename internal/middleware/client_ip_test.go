@@ -0,0 +1,72 @@
+// internal/middleware/client_ip_test.go
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "::1", " 192.168.1.5 "})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		realIP     string
+		want       string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.7:5555", want: "203.0.113.7"},
+		{name: "spoofed XFF from untrusted client", remoteAddr: "203.0.113.7:5555", xff: []string{"1.2.3.4"}, want: "203.0.113.7"},
+		{name: "spoofed X-Real-IP from untrusted client", remoteAddr: "203.0.113.7:5555", realIP: "1.2.3.4", want: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.2:80", xff: []string{"198.51.100.9"}, want: "198.51.100.9"},
+		{name: "client prepends a fake hop", remoteAddr: "10.0.0.2:80", xff: []string{"1.2.3.4, 198.51.100.9"}, want: "198.51.100.9"},
+		{name: "chain of trusted proxies", remoteAddr: "10.0.0.2:80", xff: []string{"198.51.100.9, 192.168.1.5", "10.1.1.1"}, want: "198.51.100.9"},
+		{name: "only trusted hops", remoteAddr: "10.0.0.2:80", xff: []string{"10.0.0.3"}, want: "10.0.0.3"},
+		{name: "garbage before the proxy hop", remoteAddr: "10.0.0.2:80", xff: []string{"not-an-ip, 10.0.0.3"}, want: "10.0.0.3"},
+		{name: "X-Real-IP from trusted proxy", remoteAddr: "[::1]:80", realIP: "198.51.100.9", want: "198.51.100.9"},
+		{name: "IPv6 client", remoteAddr: "[2001:db8::1]:443", want: "2001:db8::1"},
+		{name: "IPv4-mapped address", remoteAddr: "[::ffff:10.0.0.2]:80", xff: []string{"198.51.100.9"}, want: "198.51.100.9"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, value := range tc.xff {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if tc.realIP != "" {
+				req.Header.Set("X-Real-IP", tc.realIP)
+			}
+
+			var got string
+			ClientIPMiddleware(proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), req)
+			if got != tc.want {
+				t.Fatalf("Expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutMiddlewareIgnoresHeaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:5555"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	if got := ClientIP(req); got != "203.0.113.7" {
+		t.Fatalf("Expected the connection address, got %s", got)
+	}
+}
+
+func TestParseTrustedProxiesRejectsInvalid(t *testing.T) {
+	for _, value := range []string{"10.0.0.0/33", "proxy.local"} {
+		if _, err := ParseTrustedProxies([]string{value}); err == nil {
+			t.Fatalf("Expected an error for %q", value)
+		}
+	}
+}
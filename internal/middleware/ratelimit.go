@@ -4,7 +4,6 @@ package middleware
 import (
 	"log/slog"
 	"net/http"
-	"strings" 
 	"sync"
 	"time"
 
@@ -17,71 +16,70 @@ type ClientLimiter struct {
 	lastSeen time.Time
 }
 
-var (
-	clients = make(map[string]*ClientLimiter)
+// ipRateLimiter - лимитеры одного RateLimitMiddleware по IP клиентов. У каждого маршрута свой набор,
+// чтобы просмотры одного маршрута не расходовали лимит другого.
+type ipRateLimiter struct {
+	rps   rate.Limit
+	burst int
+
 	mu      sync.Mutex
-)
+	clients map[string]*ClientLimiter
+}
 
-// init запускает горутину для периодической очистки старых записей клиентов.
-func init() {
-	go cleanupClients()
+func newIPRateLimiter(rps float64, burst int) *ipRateLimiter {
+	l := &ipRateLimiter{rps: rate.Limit(rps), burst: burst, clients: make(map[string]*ClientLimiter)}
+	go l.cleanupClients()
+	return l
 }
 
-func cleanupClients() {
+func (l *ipRateLimiter) cleanupClients() {
 	for {
 		// Пауза перед следующей очисткой
 		time.Sleep(10 * time.Minute) // Например, каждые 10 минут
 
-		mu.Lock()
-		for ip, client := range clients {
+		l.mu.Lock()
+		for ip, client := range l.clients {
 			// Удаляем запись, если клиент не появлялся более 15 минут (или другого интервала)
 			if time.Since(client.lastSeen) > 15*time.Minute {
-				delete(clients, ip)
+				delete(l.clients, ip)
 				slog.Debug("Удален лимитер для неактивного IP", "ip", ip)
 			}
 		}
-		mu.Unlock()
+		l.mu.Unlock()
+	}
+}
+
+func (l *ipRateLimiter) allow(clientIP string) bool {
+	l.mu.Lock()
+	// Проверяем, есть ли уже лимитер для этого IP.
+	clientData, found := l.clients[clientIP]
+	if !found {
+		// Создаем новый лимитер для нового IP.
+		clientData = &ClientLimiter{
+			limiter: rate.NewLimiter(l.rps, l.burst),
+		}
+		l.clients[clientIP] = clientData
+		slog.Debug("Создан новый лимитер", "ip", clientIP, "rps", l.rps, "burst", l.burst)
 	}
+	// Обновляем время последнего обращения.
+	clientData.lastSeen = time.Now()
+	limiterInstance := clientData.limiter
+	l.mu.Unlock()
+
+	return limiterInstance.Allow()
 }
 
 // RateLimitMiddleware ограничивает количество запросов с одного IP.
 // rps - это количество разрешенных запросов в секунду.
 // burst - это максимальное количество запросов, которые могут быть обработаны в "пачке" (burst).
+// Каждый вызов создает отдельный набор лимитеров. IP клиента берется из ClientIP.
 func RateLimitMiddleware(next http.Handler, rps float64, burst int) http.Handler {
+	limiter := newIPRateLimiter(rps, burst)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Получаем IP-адрес клиента.
-		// r.RemoteAddr может содержать порт, поэтому мы его отсекаем.
-		// В реальных условиях за прокси IP может быть в заголовках X-Forwarded-For или X-Real-IP.
-		var clientIP string
-		xff := r.Header.Get("X-Forwarded-For")
-		if xff != "" {
-			clientIP = strings.Split(xff, ",")[0] // Берем первый IP, если их несколько
-		} else {
-			clientIP = r.Header.Get("X-Real-IP")
-		}
-
-		if clientIP == "" {
-			clientIP = strings.Split(r.RemoteAddr, ":")[0]
-		}
-
-		mu.Lock()
-		// Проверяем, есть ли уже лимитер для этого IP.
-		clientData, found := clients[clientIP]
-		if !found {
-			// Создаем новый лимитер для нового IP.
-			clientData = &ClientLimiter{
-				limiter: rate.NewLimiter(rate.Limit(rps), burst),
-			}
-			clients[clientIP] = clientData
-			slog.Debug("Создан новый лимитер", "ip", clientIP, "rps", rps, "burst", burst)
-		}
-		// Обновляем время последнего обращения.
-		clientData.lastSeen = time.Now()
-		limiterInstance := clientData.limiter
-		mu.Unlock()
+		clientIP := ClientIP(r)
 
 		// Проверяем, разрешен ли запрос текущим лимитером.
-		if !limiterInstance.Allow() {
+		if !limiter.allow(clientIP) {
 			slog.Warn("Превышен лимит запросов (Rate Limit)", "ip", clientIP, "path", r.URL.Path)
 			http.Error(w, "Слишком много запросов. Пожалуйста, попробуйте позже.", http.StatusTooManyRequests)
 			return
@@ -90,4 +88,4 @@ func RateLimitMiddleware(next http.Handler, rps float64, burst int) http.Handler
 		// Если запрос разрешен, передаем его следующему обработчику.
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,38 @@
+// internal/middleware/ratelimit_test.go
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitMiddlewarePerInstance(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	pages := RateLimitMiddleware(ok, 0.001, 2)
+	attachments := RateLimitMiddleware(ok, 0.001, 2)
+
+	request := func(h http.Handler, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := request(pages, "203.0.113.7:1000"); code != http.StatusOK {
+			t.Fatalf("Request %d within burst got %d", i+1, code)
+		}
+	}
+	if code := request(pages, "203.0.113.7:1001"); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 after the burst, got %d", code)
+	}
+	// Another route and another client have their own buckets
+	if code := request(attachments, "203.0.113.7:1002"); code != http.StatusOK {
+		t.Fatalf("Another middleware instance must not share the bucket, got %d", code)
+	}
+	if code := request(pages, "198.51.100.9:1000"); code != http.StatusOK {
+		t.Fatalf("Another client must not share the bucket, got %d", code)
+	}
+}
@@ -32,12 +32,19 @@ func RequireActiveSubscription(sessionManager *scs.SessionManager) func(http.Han
 			}
 
 			isActive := false
-			if status == models.SubscriptionStatusActive {
+			switch status {
+			case models.SubscriptionStatusActive:
 				if currentPeriodEnd == nil || currentPeriodEnd.After(time.Now()) {
 					isActive = true
 				} else {
 					slog.Info("Подписка пользователя истекла (currentPeriodEnd в прошлом)", "userID", userID, "status", status, "periodEnd", currentPeriodEnd)
 				}
+			case models.SubscriptionStatusTrial:
+				// Пробный период тарифа: без оплаты заканчивается вместе с периодом
+				isActive = currentPeriodEnd != nil && currentPeriodEnd.After(time.Now())
+			case models.SubscriptionStatusPastDue:
+				// Льготный период после неудачного продления: доступ сохраняется, пока планировщик не отменит подписку
+				isActive = true
 			}
 
 			if !isActive {
@@ -10,13 +10,28 @@ import (
 	"shaman-ai.kz/internal/db"
 	"shaman-ai.kz/internal/models"
 	"strings"
-	"time"
 )
 
 // TokenLimitExceededContextKey - ключ для передачи в контекст информации о превышении лимита
 const TokenLimitExceededContextKey contextKey = "isTokenLimitExceeded"
 
-// CheckTokenLimit - это middleware, проверяющий, не превысил ли пользователь месячный лимит токенов.
+// PlanContextKey - ключ тарифа пользователя в контексте, его используют проверки возможностей тарифа
+const PlanContextKey contextKey = "plan"
+
+// PlanFromContext возвращает тариф, загруженный CheckTokenLimit; nil - тариф не загружен
+func PlanFromContext(ctx context.Context) *models.Plan {
+	plan, _ := ctx.Value(PlanContextKey).(*models.Plan)
+	return plan
+}
+
+// TokenCostKZT - стоимость токенов, израсходованных пользователем за текущий расчетный период
+func TokenCostKZT(appConfig *config.Config, user *models.User) float64 {
+	costInputUSD := (float64(user.TokensUsedInputThisPeriod) / 1000000.0) * appConfig.RemoteLLM.TokenCostInputPerMillion
+	costOutputUSD := (float64(user.TokensUsedOutputThisPeriod) / 1000000.0) * appConfig.RemoteLLM.TokenCostOutputPerMillion
+	return (costInputUSD + costOutputUSD) * appConfig.Billing.USDToKZTRate
+}
+
+// CheckTokenLimit - это middleware, проверяющий, не превысил ли пользователь месячный лимит токенов своего тарифа.
 func CheckTokenLimit(appConfig *config.Config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -29,32 +44,26 @@ func CheckTokenLimit(appConfig *config.Config) func(http.Handler) http.Handler {
 				return
 			}
 
+			plan, err := db.GetUserPlan(user.ID)
+			if err != nil {
+				slog.Error("CheckTokenLimit: не удалось получить тариф пользователя. Пропускаем проверку.", "userID", user.ID, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), PlanContextKey, plan))
+
 			// Проверка не нужна для администраторов
 			if user.RoleName != nil && *user.RoleName == models.RoleAdmin {
 				next.ServeHTTP(w, r)
 				return
 			}
 			
-			// Проверка на истечение расчетного периода
-			// Если с даты последней оплаты прошел месяц, а подписка все еще активна,
-			// это может означать проблему с вебхуком об оплате. В этом случае мы сбрасываем счетчик,
-			// чтобы не блокировать пользователя. Основная логика сброса будет в обработчике вебхука.
-			if user.BillingCycleAnchorDate != nil && time.Since(*user.BillingCycleAnchorDate) > (31*24*time.Hour) {
-				slog.Info("Прошел месяц с последней точки оплаты, счетчик токенов сброшен для пользователя", "userID", user.ID)
-				db.IncrementTokenUsage(user.ID, -user.TokensUsedInputThisPeriod, -user.TokensUsedOutputThisPeriod) // Сброс в 0
-				user.TokensUsedInputThisPeriod = 0
-				user.TokensUsedOutputThisPeriod = 0
-			}
-
-			// Рассчитываем стоимость использованных токенов
-			costInputUSD := (float64(user.TokensUsedInputThisPeriod) / 1000000.0) * appConfig.RemoteLLM.TokenCostInputPerMillion
-			costOutputUSD := (float64(user.TokensUsedOutputThisPeriod) / 1000000.0) * appConfig.RemoteLLM.TokenCostOutputPerMillion
-			totalCostUSD := costInputUSD + costOutputUSD
-			totalCostKZT := totalCostUSD * appConfig.Billing.USDToKZTRate
-
-			// Сравниваем с лимитом
-			if totalCostKZT >= appConfig.TokenMonthlyLimitKZT {
-				slog.Warn("Пользователь превысил месячный лимит токенов", "userID", user.ID, "spent_kzt", totalCostKZT, "limit_kzt", appConfig.TokenMonthlyLimitKZT)
+			// Счетчики сбрасываются при оплате периода (db.CompletePayment) и в начале каждого месяца
+			// годовой подписки (планировщик продлений), здесь только проверка
+			// Сравниваем стоимость использованных токенов с лимитом тарифа
+			totalCostKZT := TokenCostKZT(appConfig, user)
+			if totalCostKZT >= plan.TokenLimitKZT {
+				slog.Warn("Пользователь превысил месячный лимит токенов", "userID", user.ID, "plan", plan.Slug, "spent_kzt", totalCostKZT, "limit_kzt", plan.TokenLimitKZT)
 
 				// Для API-запросов возвращаем ошибку
 				if strings.HasPrefix(r.URL.Path, "/api/") {
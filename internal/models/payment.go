@@ -2,36 +2,30 @@ package models
 
 import "time"
 
-type Payment struct {
-	ID             int64     `json:"id"`
-	UserID         int64     `json:"user_id"`
-	SubscriptionID int64     `json:"subscription_id"`
-	Amount         float64   `json:"amount"`
-	Currency       string    `json:"currency"`
-	Status         string    `json:"status"` // e.g., pending, success, failed
-	GatewayOrderID string    `json:"-"` // ID заказа в шлюзе
-	GatewayName    string    `json:"-"` // Название шлюза (bcc)
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-}
-
-// type PaymentStatus string
+type PaymentStatus string
 
-// const (
-// 	PaymentStatusPending PaymentStatus = "pending"
-// 	PaymentStatusSuccess PaymentStatus = "success"
-// 	PaymentStatusFailed  PaymentStatus = "failed"
-// )
+const (
+	PaymentStatusPending    PaymentStatus = "pending"    // Создан, заказ в шлюзе еще не создан
+	PaymentStatusProcessing PaymentStatus = "processing" // Заказ создан, пользователь на странице оплаты
+	PaymentStatusSuccess    PaymentStatus = "success"    // Оплачен, подписка продлена
+	PaymentStatusFailed     PaymentStatus = "failed"
+	PaymentStatusRefunded   PaymentStatus = "refunded"
+)
 
-// type Payment struct {
-// 	ID                          string
-// 	UserID                      int64
-// 	SubscriptionID              string
-// 	PaymentGatewayTransactionID string
-// 	Amount                      int64
-// 	Currency                    string
-// 	Status                      PaymentStatus
-// 	PaymentDate                 time.Time
-// 	CreatedAt                   time.Time
-// 	UpdatedAt                   time.Time
-// }
+type Payment struct {
+	ID                          string        `json:"id"` // "pay_..."; он же merchant_order_id в шлюзе
+	UserID                      int64         `json:"user_id"`
+	SubscriptionID              string        `json:"subscription_id"` // Заполняется при успешной оплате
+	PaymentGatewayTransactionID string        `json:"-"`               // ID списания в шлюзе
+	Amount                      int64         `json:"amount"`          // В тиынах
+	Currency                    string        `json:"currency"`
+	Status                      PaymentStatus `json:"status"`
+	GatewayOrderID              string        `json:"-"`             // ID заказа в шлюзе
+	GatewayName                 string        `json:"-"`             // Название шлюза (bcc)
+	Renewal                     bool          `json:"renewal"`       // Списание автопродления по сохраненной карте
+	PlanID                      string        `json:"plan_id"`       // Тариф (plans.slug), пусто - тариф подписки не меняется
+	PeriodMonths                int           `json:"period_months"` // На сколько месяцев продлевает подписку
+	PaymentDate                 time.Time     `json:"payment_date"`  // Дата списания, пусто - не оплачен
+	CreatedAt                   time.Time     `json:"created_at"`
+	UpdatedAt                   time.Time     `json:"updated_at"`
+}
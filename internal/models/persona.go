@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Persona - режим ассистента со своим системным промптом и параметрами модели
+type Persona struct {
+	ID              int64     `json:"id"`
+	Slug            string    `json:"slug"`
+	Name            string    `json:"name"`
+	Description     string    `json:"description"`
+	SystemPrompt    string    `json:"-"`
+	Model           string    `json:"-"` // "<провайдер>:<модель>", пусто - модель по умолчанию
+	Temperature     *float64  `json:"-"`
+	MaxTokens       *int      `json:"-"`
+	RoutingKeywords []string  `json:"-"`
+	AutoRoute       bool      `json:"-"`
+	IsActive        bool      `json:"is_active"`
+	IsBuiltin       bool      `json:"-"`
+	SortOrder       int       `json:"-"`
+	CreatedAt       time.Time `json:"-"`
+	UpdatedAt       time.Time `json:"-"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// DefaultPlanSlug - тариф пользователей, у подписки которых тариф не указан или не найден в каталоге
+const DefaultPlanSlug = "standard"
+
+// Периоды оплаты тарифа
+const (
+	PlanPeriodMonthly = "monthly"
+	PlanPeriodAnnual  = "annual"
+)
+
+// Plan - тариф подписки из каталога plans
+type Plan struct {
+	ID               int64     `json:"id"`
+	Slug             string    `json:"slug"`
+	Name             string    `json:"name"`
+	Description      string    `json:"description"`
+	MonthlyPrice     int64     `json:"monthly_price"` // В тиынах
+	AnnualPrice      int64     `json:"annual_price"`  // В тиынах, 0 - годовой оплаты нет
+	TokenLimitKZT    float64   `json:"-"`             // Месячный лимит расходов на LLM
+	AllowFileUploads bool      `json:"allow_file_uploads"`
+	AllowShamanMode  bool      `json:"allow_shaman_mode"`
+	AllowVision      bool      `json:"allow_vision"`
+	TrialDays        int       `json:"trial_days"` // 0 - без пробного периода
+	IsVisible        bool      `json:"-"`          // Показывается в каталоге на странице подписки
+	SortOrder        int       `json:"-"`
+	CreatedAt        time.Time `json:"-"`
+	UpdatedAt        time.Time `json:"-"`
+}
+
+// PeriodMonths - на сколько месяцев оплачивается период; неизвестный период считается помесячным
+func PeriodMonths(period string) int {
+	if period == PlanPeriodAnnual {
+		return 12
+	}
+	return 1
+}
+
+// Price - цена тарифа за months месяцев в тиынах; 0 - такой период оплаты недоступен
+func (p *Plan) Price(months int) int64 {
+	switch months {
+	case 1:
+		return p.MonthlyPrice
+	case 12:
+		return p.AnnualPrice
+	}
+	return 0
+}
@@ -80,4 +80,7 @@ type Subscription struct {
 	CancelAtPeriodEnd            bool               `json:"cancel_at_period_end"`
 	CreatedAt                    time.Time          `json:"created_at"`
 	UpdatedAt                    time.Time          `json:"updated_at"`
+	RecurringToken               string             `json:"-"` // Токен сохраненной карты; пусто - автопродление невозможно
+	RecurringGateway             string             `json:"-"` // Шлюз, в котором сохранена карта
+	BillingPeriodMonths          int                `json:"billing_period_months"` // 1 - помесячно, 12 - за год
 }
@@ -0,0 +1,153 @@
+// internal/moderouter/keyword.go
+package moderouter
+
+import (
+	"context"
+	"strings"
+	"unicode"
+)
+
+// Ключевые слова режима "Шаман" на русском, казахском и английском. Совпадение ищется по целым
+// словам; "*" в конце означает любое окончание ("болезн*" - болезнь, болезни, болезнями).
+var shamanKeywords = []string{
+	// Русский
+	"здоровье", "здоровья", "симптом*", "болит", "болят", "больно", "болезн*", "недомогани*", "недуг*",
+	"лечени*", "лечить", "вылечить", "диагноз*", "диагностик*", "анализы", "обследовани*",
+	"врач*", "доктор*", "медик*", "медицин*", "клиник*", "больниц*", "рецепт*", "таблетк*", "лекарств*",
+	"психосоматик*", "гнм", "германская новая медицина", "хамер*", "dhs", "сбп",
+	"биологический конфликт", "эмоциональная причина", "психолог*", "психотерапевт*", "психиатр*",
+	"аллерги*", "астм*", "давлени*", "мигрен*", "бессонниц*", "депресси*", "апати*",
+	"тревог*", "стресс*", "паническ*", "фоби*",
+	"сыпь", "зуд", "экзем*", "псориаз*", "сустав*", "поясниц*",
+	"онемени*", "покалывани*", "головокружени*", "тошнот*", "рвот*",
+	"слабость", "усталость", "температур*", "озноб*", "кашел*", "кашля*", "насморк*",
+	"отек*", "отёк*", "опухол*", "воспалени*", "инфекци*", "вирус*", "бактери*",
+	"что со мной", "почему я так себя чувствую", "плохо себя чувствую",
+	// Казахский
+	"ауру*", "ауырады", "ауырып*", "денсаулы*", "дәрігер*", "дәрі", "дәрілер*", "емдеу*", "белгілер*",
+	"қысым*", "жөтел*", "тұмау*", "бас ауруы", "ұйқысыздық*", "күйзеліс*", "мазасыздық*",
+	// Английский
+	"health", "symptom*", "pain", "pains", "painful", "hurt*", "ache*", "sick*", "ill", "illness*", "disease*",
+	"doctor*", "treatment*", "diagnos*", "headache*", "migraine*", "anxiety", "depress*",
+	"insomnia", "allerg*", "fever", "cough*", "nausea", "rash", "psychosomatic*",
+}
+
+// Признаки того, что пользователь говорит о себе. Вместе с темой самочувствия указывают на режим "Шаман".
+var personalIndicators = []string{
+	"у меня", "меня беспокоит", "я чувствую", "мои", "мой", "моя", "мое", "моё", "мне", "со мной",
+	"я страдаю", "я болею", "менің", "маған", "i feel", "my",
+}
+
+var personalTopics = []string{"самочувстви*", "здоровь*", "состояни*", "жағдай*", "feeling*"}
+
+// KeywordRouter определяет режим по ключевым словам с учетом границ слов. Для "shaman" к правилам
+// персоны добавляется встроенный список, для остальных кандидатов используются только их правила.
+type KeywordRouter struct {
+	shamanKeywords []phrase
+	indicators     []phrase
+	topics         []phrase
+}
+
+// phrase - последовательность слов; prefix - последнее слово может иметь любое окончание
+type phrase struct {
+	words  []string
+	prefix bool
+	text   string
+}
+
+func compilePhrases(list []string) []phrase {
+	phrases := make([]phrase, 0, len(list))
+	for _, item := range list {
+		p := phrase{text: item}
+		item = strings.ToLower(item)
+		if strings.HasSuffix(item, "*") {
+			p.prefix = true
+			item = strings.TrimSuffix(item, "*")
+		}
+		p.words = tokenize(item)
+		if len(p.words) > 0 {
+			phrases = append(phrases, p)
+		}
+	}
+	return phrases
+}
+
+func NewKeywordRouter() *KeywordRouter {
+	return &KeywordRouter{
+		shamanKeywords: compilePhrases(shamanKeywords),
+		indicators:     compilePhrases(personalIndicators),
+		topics:         compilePhrases(personalTopics),
+	}
+}
+
+// tokenize разбивает текст на слова в нижнем регистре
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (p phrase) matchAt(tokens []string, i int) bool {
+	if i+len(p.words) > len(tokens) {
+		return false
+	}
+	last := len(p.words) - 1
+	for j, word := range p.words {
+		token := tokens[i+j]
+		if j == last && p.prefix {
+			if !strings.HasPrefix(token, word) {
+				return false
+			}
+		} else if token != word {
+			return false
+		}
+	}
+	return true
+}
+
+// findMatches возвращает найденные фразы (каждую не более одного раза)
+func findMatches(tokens []string, phrases []phrase) []string {
+	var found []string
+	for _, p := range phrases {
+		for i := range tokens {
+			if p.matchAt(tokens, i) {
+				found = append(found, p.text)
+				break
+			}
+		}
+	}
+	return found
+}
+
+func (k *KeywordRouter) Route(ctx context.Context, req Request) (Decision, error) {
+	tokens := tokenize(req.Prompt)
+
+	var best Candidate
+	var bestMatches []string
+	for _, c := range req.candidates() {
+		phrases := compilePhrases(c.Keywords)
+		if c.Mode == ModeShaman {
+			phrases = append(phrases, k.shamanKeywords...)
+		}
+		if matches := findMatches(tokens, phrases); len(matches) > len(bestMatches) {
+			best, bestMatches = c, matches
+		}
+	}
+	if len(bestMatches) > 0 {
+		confidence := 0.75
+		if len(bestMatches) > 1 {
+			confidence = 0.9
+		}
+		return Decision{Mode: best.Mode, Confidence: confidence, Source: SourceKeyword, Reason: "ключевые слова: " + strings.Join(bestMatches, ", ")}, nil
+	}
+
+	if req.known(ModeShaman) {
+		if indicators := findMatches(tokens, k.indicators); len(indicators) > 0 {
+			if topics := findMatches(tokens, k.topics); len(topics) > 0 {
+				return Decision{Mode: ModeShaman, Confidence: 0.7, Source: SourceKeyword, Reason: "о себе и самочувствии: " + strings.Join(append(indicators, topics...), ", ")}, nil
+			}
+		}
+	}
+
+	return Decision{Mode: ModeGeneral, Confidence: 0.6, Source: SourceKeyword, Reason: "ключевые слова не найдены"}, nil
+}
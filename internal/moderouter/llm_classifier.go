@@ -0,0 +1,141 @@
+// internal/moderouter/llm_classifier.go
+package moderouter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/llm"
+)
+
+// Описание режима "shaman" для классификатора, если у персоны не задано свое
+const shamanDescription = "пользователь спрашивает о своем физическом или эмоциональном здоровье, симптомах, болезнях, самочувствии, лечении, психосоматике"
+
+// classifierSystemPrompt перечисляет доступные режимы; язык сообщения значения не имеет
+func classifierSystemPrompt(candidates []Candidate) string {
+	var b strings.Builder
+	b.WriteString("Ты классификатор сообщений для сервиса с несколькими режимами ответа. Режимы:\n")
+	for _, c := range candidates {
+		description := c.Description
+		if description == "" && c.Mode == ModeShaman {
+			description = shamanDescription
+		}
+		fmt.Fprintf(&b, "%q - %s.\n", string(c.Mode), description)
+	}
+	fmt.Fprintf(&b, "%q - все остальные вопросы.\n", string(ModeGeneral))
+	b.WriteString("Сообщение может быть на любом языке, в том числе на казахском и английском. Учитывай контекст последних сообщений диалога.\n")
+	b.WriteString(`Ответь только JSON без пояснений: {"label": "<режим>", "confidence": число от 0 до 1}`)
+	return b.String()
+}
+
+// Сколько последних сообщений истории и символов каждого передавать классификатору
+const (
+	classifierHistoryMessages = 4
+	classifierMaxRunes        = 600
+)
+
+// LLMClassifier определяет режим дешевым запросом к LLM. При ошибке или низкой уверенности
+// решение принимает запасной маршрутизатор (обычно KeywordRouter).
+type LLMClassifier struct {
+	client        llm.Client
+	llmCfg        config.RemoteLLMConfig
+	fallback      Router
+	minConfidence float64
+	timeout       time.Duration
+}
+
+func NewLLMClassifier(client llm.Client, llmCfg config.RemoteLLMConfig, fallback Router, routerCfg config.ModeRouterConfig) *LLMClassifier {
+	// Ответ классификатора короткий, а разброс не нужен
+	llmCfg.MaxTokens = 50
+	llmCfg.Temperature = 0.1
+	return &LLMClassifier{
+		client:        client,
+		llmCfg:        llmCfg,
+		fallback:      fallback,
+		minConfidence: routerCfg.MinConfidence,
+		timeout:       time.Duration(routerCfg.TimeoutSeconds) * time.Second,
+	}
+}
+
+type classifierResult struct {
+	Label      string  `json:"label"`
+	Confidence float64 `json:"confidence"`
+}
+
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "…"
+}
+
+func (c *LLMClassifier) Route(ctx context.Context, req Request) (Decision, error) {
+	history := req.History
+	if len(history) > classifierHistoryMessages {
+		history = history[len(history)-classifierHistoryMessages:]
+	}
+	shortHistory := make([]db.Message, 0, len(history))
+	for _, msg := range history {
+		shortHistory = append(shortHistory, db.Message{Role: msg.Role, Content: truncateRunes(msg.Content, classifierMaxRunes)})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	answer, usage, err := c.client.GenerateRemoteResponse(ctx, c.llmCfg, classifierSystemPrompt(req.candidates()), shortHistory, truncateRunes(req.Prompt, classifierMaxRunes*2))
+	if err != nil {
+		return c.fallbackRoute(ctx, req, fmt.Sprintf("классификатор недоступен: %v", err))
+	}
+	if usage != nil {
+		slog.Info("Классификация режима", "chat_uuid", req.SessionUUID, "model", usage.Model, "input_tokens", usage.PromptTokens, "output_tokens", usage.CompletionTokens)
+	}
+	// Токены потрачены, даже если ответ классификатора не пригодился
+	decision, err := c.decide(ctx, req, answer)
+	decision.Usage = usage
+	return decision, err
+}
+
+func (c *LLMClassifier) decide(ctx context.Context, req Request, answer string) (Decision, error) {
+	result, err := parseClassifierAnswer(answer)
+	if err != nil {
+		return c.fallbackRoute(ctx, req, err.Error())
+	}
+
+	mode := Mode(result.Label)
+	if !req.known(mode) {
+		return c.fallbackRoute(ctx, req, fmt.Sprintf("неизвестная метка классификатора: %q", result.Label))
+	}
+	if result.Confidence < c.minConfidence {
+		return c.fallbackRoute(ctx, req, fmt.Sprintf("низкая уверенность классификатора: %s %.2f", mode, result.Confidence))
+	}
+	return Decision{Mode: mode, Confidence: result.Confidence, Source: SourceLLM, Reason: "классификатор LLM"}, nil
+}
+
+func (c *LLMClassifier) fallbackRoute(ctx context.Context, req Request, reason string) (Decision, error) {
+	slog.Warn("LLM-классификатор режима не дал решения, используется запасной вариант", "chat_uuid", req.SessionUUID, "reason", reason)
+	decision, err := c.fallback.Route(ctx, req)
+	decision.Reason = reason + "; " + decision.Reason
+	return decision, err
+}
+
+// parseClassifierAnswer извлекает JSON из ответа модели, даже если она обернула его в текст или ```
+func parseClassifierAnswer(answer string) (classifierResult, error) {
+	var result classifierResult
+	start := strings.Index(answer, "{")
+	end := strings.LastIndex(answer, "}")
+	if start < 0 || end <= start {
+		return result, fmt.Errorf("ответ классификатора не содержит JSON: %q", truncateRunes(answer, 100))
+	}
+	if err := json.Unmarshal([]byte(answer[start:end+1]), &result); err != nil {
+		return result, fmt.Errorf("некорректный JSON классификатора: %w", err)
+	}
+	result.Label = strings.ToLower(strings.TrimSpace(result.Label))
+	return result, nil
+}
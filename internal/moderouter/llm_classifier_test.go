@@ -0,0 +1,67 @@
+// internal/moderouter/llm_classifier_test.go
+package moderouter
+
+import (
+	"context"
+	"testing"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/llm"
+	"shaman-ai.kz/internal/llm/fakellm"
+)
+
+func newTestClassifier(t *testing.T) (*LLMClassifier, *fakellm.Server) {
+	t.Helper()
+	srv := fakellm.NewServer()
+	t.Cleanup(srv.Close)
+	registry, err := llm.NewRegistry(srv.LLMConfig())
+	if err != nil {
+		t.Fatalf("Failed to create LLM registry: %v", err)
+	}
+	routerCfg := config.ModeRouterConfig{MinConfidence: 0.6, TimeoutSeconds: 5}
+	return NewLLMClassifier(registry, srv.LLMConfig(), NewKeywordRouter(), routerCfg), srv
+}
+
+func TestLLMClassifierReportsUsage(t *testing.T) {
+	classifier, srv := newTestClassifier(t)
+	srv.Enqueue(fakellm.Response{Content: `{"label": "shaman", "confidence": 0.9}`, PromptTokens: 150, CompletionTokens: 9})
+
+	decision, err := classifier.Route(context.Background(), Request{SessionUUID: "s1", Prompt: "болит голова"})
+	if err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+	if decision.Mode != ModeShaman || decision.Source != SourceLLM {
+		t.Fatalf("Unexpected decision: %+v", decision)
+	}
+	if decision.Usage == nil || decision.Usage.PromptTokens != 150 || decision.Usage.CompletionTokens != 9 {
+		t.Fatalf("Expected classifier usage 150/9, got %+v", decision.Usage)
+	}
+}
+
+func TestLLMClassifierReportsUsageOnFallback(t *testing.T) {
+	classifier, srv := newTestClassifier(t)
+	srv.Enqueue(
+		fakellm.Response{Content: "не знаю", PromptTokens: 100, CompletionTokens: 3},
+		fakellm.Response{Content: `{"label": "shaman", "confidence": 0.2}`, PromptTokens: 110, CompletionTokens: 8},
+		fakellm.Response{StatusCode: 503},
+	)
+
+	// Unusable answers still cost tokens
+	for _, want := range []int{100, 110} {
+		decision, err := classifier.Route(context.Background(), Request{Prompt: "привет"})
+		if err != nil {
+			t.Fatalf("Route failed: %v", err)
+		}
+		if decision.Source != SourceKeyword || decision.Usage == nil || decision.Usage.PromptTokens != want {
+			t.Fatalf("Expected keyword fallback with usage %d, got %+v", want, decision)
+		}
+	}
+
+	decision, err := classifier.Route(context.Background(), Request{Prompt: "привет"})
+	if err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+	if decision.Source != SourceKeyword || decision.Usage != nil {
+		t.Fatalf("Expected keyword fallback without usage after LLM error, got %+v", decision)
+	}
+}
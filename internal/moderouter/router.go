@@ -0,0 +1,135 @@
+// internal/moderouter/router.go
+//
+// Package moderouter выбирает режим диалога (персону) для каждого сообщения пользователя.
+package moderouter
+
+import (
+	"context"
+	"log/slog"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/llm"
+)
+
+// Mode - slug персоны
+type Mode string
+
+const (
+	ModeGeneral Mode = "general" // Выбирается, если ни один кандидат не подошел
+	ModeShaman  Mode = "shaman"
+)
+
+// Candidate - режим, участвующий в автоматическом выборе, с правилами маршрутизации
+type Candidate struct {
+	Mode        Mode
+	Description string   // Для LLM-классификатора
+	Keywords    []string // Ключевые слова, "*" в конце - любое окончание
+}
+
+// Источники решения
+const (
+	SourceKeyword = "keyword"
+	SourceLLM     = "llm"
+	SourceSticky  = "sticky"
+)
+
+// Request - данные, по которым принимается решение
+type Request struct {
+	SessionUUID string
+	SessionMode string // Закрепленный за сессией режим, пусто - первый запрос
+	Prompt      string
+	History     []db.Message
+	// Режимы для выбора помимо общего; nil - только встроенный "shaman"
+	Candidates []Candidate
+}
+
+func (req Request) candidates() []Candidate {
+	if req.Candidates == nil {
+		return []Candidate{{Mode: ModeShaman}}
+	}
+	return req.Candidates
+}
+
+// known сообщает, можно ли выбрать режим в рамках этого запроса
+func (req Request) known(mode Mode) bool {
+	if mode == ModeGeneral {
+		return true
+	}
+	for _, c := range req.candidates() {
+		if c.Mode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// Decision - выбранный режим с уверенностью от 0 до 1
+type Decision struct {
+	Mode         Mode
+	Confidence   float64
+	Source       string
+	Reason       string
+	PreviousMode Mode
+	// Расход LLM-классификатора, nil - решение принято без запроса к LLM. Учитывается в лимите
+	// пользователя вместе с ответом: классификатор вызывается на каждое его сообщение.
+	Usage *llm.Usage
+}
+
+type Router interface {
+	Route(ctx context.Context, req Request) (Decision, error)
+}
+
+// New собирает маршрутизатор по конфигурации: поиск по ключевым словам или LLM-классификатор
+// (с ключевыми словами как запасным вариантом), обернутые в закрепление режима за сессией.
+func New(routerCfg config.ModeRouterConfig, registry *llm.Registry, llmCfg config.RemoteLLMConfig) Router {
+	var router Router = NewKeywordRouter()
+	if routerCfg.Strategy == "llm" {
+		var client llm.Client = registry
+		classifierCfg := llmCfg
+		if routerCfg.Model != "" {
+			client, classifierCfg = registry.ResolveModel(llmCfg, routerCfg.Model)
+		}
+		router = NewLLMClassifier(client, classifierCfg, router, routerCfg)
+	}
+	slog.Info("Маршрутизатор режимов диалога инициализирован", "strategy", routerCfg.Strategy, "model", routerCfg.Model)
+	return NewStickyRouter(router, routerCfg.SwitchConfidence)
+}
+
+// StickyRouter закрепляет режим за сессией, чтобы беседа не переключалась между персонами
+// на каждом сообщении. Сменить режим может только решение с уверенностью не ниже switchConfidence.
+type StickyRouter struct {
+	inner            Router
+	switchConfidence float64
+}
+
+func NewStickyRouter(inner Router, switchConfidence float64) *StickyRouter {
+	return &StickyRouter{inner: inner, switchConfidence: switchConfidence}
+}
+
+func (s *StickyRouter) Route(ctx context.Context, req Request) (Decision, error) {
+	decision, err := s.inner.Route(ctx, req)
+	if err != nil {
+		return decision, err
+	}
+
+	current := Mode(req.SessionMode)
+	hasMode := current != "" && req.known(current) // Режим отключенной персоны не удерживаем
+	decision.PreviousMode = current
+	switch {
+	case hasMode && decision.Mode == current:
+		return decision, nil
+	case hasMode && decision.Confidence < s.switchConfidence:
+		decision.Reason = "режим закреплен за сессией; " + decision.Reason
+		decision.Mode = current
+		decision.Source = SourceSticky
+		return decision, nil
+	}
+
+	if req.SessionUUID != "" {
+		if errSet := db.SetChatSessionMode(req.SessionUUID, string(decision.Mode)); errSet != nil {
+			slog.Warn("Не удалось закрепить режим за сессией", "chat_uuid", req.SessionUUID, "mode", decision.Mode, "error", errSet)
+		}
+	}
+	return decision, nil
+}
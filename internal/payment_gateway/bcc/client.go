@@ -117,4 +117,35 @@ func (c *Client) GetOrderStatus(ctx context.Context, gatewayOrderID string) (*Or
 	}
 
 	return &orderResp, nil
-}
\ No newline at end of file
+}
+
+// Refund возвращает сумму по оплаченному заказу (в тенге, как в заказе)
+func (c *Client) Refund(ctx context.Context, gatewayOrderID string, amount float64) error {
+	endpoint := fmt.Sprintf("/orders/%s/refund", gatewayOrderID)
+
+	bodyBytes, err := json.Marshal(RefundRequest{Amount: fmt.Sprintf("%.2f", amount)})
+	if err != nil {
+		return fmt.Errorf("bcc: failed to marshal refund request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+endpoint, bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return fmt.Errorf("bcc: failed to create refund request: %w", err)
+	}
+
+	req.SetBasicAuth(c.login, c.password)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("bcc: failed to perform refund request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("bcc: unexpected status code on refund: %d, body: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package bcc
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"shaman-ai.kz/internal/models"
+	paymentgateway "shaman-ai.kz/internal/payment_gateway"
+)
+
+// Name - имя шлюза в payments.gateway_name
+const Name = "bcc"
+
+// SignatureHeader - заголовок с подписью уведомления, если ее нет в теле
+const SignatureHeader = "X-Signature"
+
+// Notification - уведомление о смене статуса заказа, в XML или JSON. Подпись (Sign) считается
+// по остальным полям; уведомление может прийти и в формате Request с атрибутами и подписью.
+type Notification struct {
+	XMLName   xml.Name `xml:"notification" json:"-"`
+	EventID   string   `xml:"event_id" json:"event_id"`
+	OrderID   string   `xml:"order_id" json:"order_id"`
+	PaymentID string   `xml:"payment_id" json:"payment_id"`
+	Status    string   `xml:"status" json:"status"`
+	Amount    int64    `xml:"amount" json:"amount"`
+	Signature string   `xml:"signature" json:"signature"`
+}
+
+// attributes - подписываемые поля уведомления
+func (n Notification) attributes() []Attribute {
+	return []Attribute{
+		{Name: "amount", Value: strconv.FormatInt(n.Amount, 10)},
+		{Name: "event_id", Value: n.EventID},
+		{Name: "order_id", Value: n.OrderID},
+		{Name: "payment_id", Value: n.PaymentID},
+		{Name: "status", Value: n.Status},
+	}
+}
+
+// Gateway - адаптер Client к paymentgateway.Gateway
+type Gateway struct {
+	client        *Client
+	webhookSecret string // Ключ подписи уведомлений (billing.webhook_secret)
+}
+
+func NewGateway(client *Client, webhookSecret string) *Gateway {
+	return &Gateway{client: client, webhookSecret: webhookSecret}
+}
+
+func (g *Gateway) Name() string {
+	return Name
+}
+
+func (g *Gateway) CreateOrder(ctx context.Context, req paymentgateway.OrderRequest) (*paymentgateway.Order, error) {
+	result, err := g.client.CreateOrder(ctx, CreateOrderRequest{
+		Amount:          float64(req.Amount) / 100, // В банк сумма передается в тенге
+		MerchantOrderID: req.PaymentID,
+		Currency:        req.Currency,
+		Description:     req.Description,
+		Client:          ClientInfo{Email: req.Customer.Email, Name: req.Customer.Name, Phone: req.Customer.Phone},
+		Options:         Options{ReturnURL: req.ReturnURL},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &paymentgateway.Order{GatewayOrderID: result.GatewayOrderID, PaymentURL: result.PaymentURL}, nil
+}
+
+// paymentStatus переводит статус заказа в статус платежа
+func paymentStatus(orderStatus string) models.PaymentStatus {
+	switch orderStatus {
+	case OrderStatusCharged, OrderStatusAuthorized:
+		return models.PaymentStatusSuccess
+	case OrderStatusRefunded:
+		return models.PaymentStatusRefunded
+	case OrderStatusDeclined, OrderStatusRejected, OrderStatusFraud, OrderStatusReversed, OrderStatusExpired, OrderStatusError:
+		return models.PaymentStatusFailed
+	default:
+		return models.PaymentStatusProcessing
+	}
+}
+
+func (g *Gateway) OrderStatus(ctx context.Context, gatewayOrderID string) (*paymentgateway.OrderStatus, error) {
+	resp, err := g.client.GetOrderStatus(ctx, gatewayOrderID)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Orders) == 0 {
+		return nil, fmt.Errorf("bcc: order %s not found in status response", gatewayOrderID)
+	}
+	order := resp.Orders[0]
+	amount, err := strconv.ParseFloat(order.Amount, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bcc: invalid order amount %q: %w", order.Amount, err)
+	}
+	return &paymentgateway.OrderStatus{
+		GatewayOrderID:  order.ID,
+		MerchantOrderID: order.MerchantOrderID,
+		Status:          paymentStatus(order.Status),
+		RawStatus:       order.Status,
+		Amount:          int64(math.Round(amount * 100)),
+		Currency:        order.Currency,
+		TransactionID:   order.ID,
+	}, nil
+}
+
+func (g *Gateway) Refund(ctx context.Context, gatewayOrderID string, amount int64) error {
+	return g.client.Refund(ctx, gatewayOrderID, float64(amount)/100)
+}
+
+// parseNotification разбирает уведомление в атрибуты и подпись
+func parseNotification(r *http.Request, body []byte) ([]Attribute, string, error) {
+	if !strings.Contains(r.Header.Get("Content-Type"), "xml") {
+		var n Notification
+		if err := json.Unmarshal(body, &n); err != nil {
+			return nil, "", err
+		}
+		return n.attributes(), n.Signature, nil
+	}
+	var req Request
+	if err := xml.Unmarshal(body, &req); err == nil {
+		return req.Attributes, req.Signature.Value, nil
+	}
+	var n Notification
+	if err := xml.Unmarshal(body, &n); err != nil {
+		return nil, "", err
+	}
+	return n.attributes(), n.Signature, nil
+}
+
+// VerifyWebhook проверяет подпись уведомления ключом billing.webhook_secret. Без ключа уведомления
+// не принимаются. Если банк не передает event_id, ID события - заказ и статус: повтор того же
+// уведомления отбрасывается, а смена статуса заказа обрабатывается.
+func (g *Gateway) VerifyWebhook(r *http.Request, body []byte) (*paymentgateway.WebhookEvent, error) {
+	params, signature, err := parseNotification(r, body)
+	if err != nil {
+		return nil, fmt.Errorf("bcc: invalid notification: %w", err)
+	}
+	if signature == "" {
+		signature = r.Header.Get(SignatureHeader)
+	}
+	if !VerifySignature(params, g.webhookSecret, signature) {
+		return nil, fmt.Errorf("bcc: %w", paymentgateway.ErrInvalidSignature)
+	}
+
+	values := make(map[string]string, len(params))
+	for _, attr := range params {
+		values[attr.Name] = attr.Value
+	}
+	event := &paymentgateway.WebhookEvent{EventID: values["event_id"], GatewayOrderID: values["order_id"], Status: values["status"]}
+	if event.GatewayOrderID == "" {
+		return nil, fmt.Errorf("bcc: notification without order_id")
+	}
+	if event.EventID == "" {
+		event.EventID = event.GatewayOrderID + ":" + event.Status
+	}
+	return event, nil
+}
+
+// SupportsRecurring: списание по сохраненной карте через API банка пока не подключено, SaveCard
+// при создании заказа не передается в банк. Подписки, оплаченные через BCC, продлеваются оплатой вручную.
+func (g *Gateway) SupportsRecurring() bool {
+	return false
+}
+
+// ChargeRecurring: см. SupportsRecurring
+func (g *Gateway) ChargeRecurring(ctx context.Context, recurringToken string, req paymentgateway.OrderRequest) (*paymentgateway.OrderStatus, error) {
+	return nil, paymentgateway.ErrNotSupported
+}
+
+// CancelRecurring: повторные списания по сохраненной карте через API банка пока не подключены
+func (g *Gateway) CancelRecurring(ctx context.Context, recurringToken string) error {
+	return paymentgateway.ErrNotSupported
+}
@@ -24,19 +24,42 @@ type Options struct {
 	ReturnURL string `json:"return_url"`
 }
 
+// Статусы заказа
+const (
+	OrderStatusNew        = "new"
+	OrderStatusPrepared   = "prepared"
+	OrderStatusAuthorized = "authorized" // Средства захолдированы (двухстадийная схема)
+	OrderStatusCharged    = "charged"    // Средства списаны
+	OrderStatusDeclined   = "declined"
+	OrderStatusRejected   = "rejected"
+	OrderStatusFraud      = "fraud"
+	OrderStatusReversed   = "reversed"
+	OrderStatusRefunded   = "refunded"
+	OrderStatusExpired    = "expired"
+	OrderStatusError      = "error"
+)
+
+// Order - заказ в ответе API
+type Order struct {
+	ID              string `json:"id"`
+	Status          string `json:"status"`
+	Amount          string `json:"amount"`
+	AmountCharged   string `json:"amount_charged"`
+	AmountRefunded  string `json:"amount_refunded"`
+	MerchantOrderID string `json:"merchant_order_id"`
+	Currency        string `json:"currency"`
+	Description     string `json:"description"`
+}
+
 // OrderResponse описывает структуру успешного ответа от API
 // (включая ответ на запрос статуса)
 type OrderResponse struct {
-	Orders []struct {
-		ID              string  `json:"id"`
-		Status          string  `json:"status"`
-		Amount          string  `json:"amount"`
-		AmountCharged   string  `json:"amount_charged"`
-		AmountRefunded  string  `json:"amount_refunded"`
-		MerchantOrderID string  `json:"merchant_order_id"`
-		Currency        string  `json:"currency"`
-		Description     string  `json:"description"`
-	} `json:"orders"`
+	Orders []Order `json:"orders"`
+}
+
+// RefundRequest описывает тело запроса на возврат
+type RefundRequest struct {
+	Amount string `json:"amount"`
 }
 
 // ErrorResponse описывает структуру ответа с ошибкой
@@ -0,0 +1,80 @@
+package bcc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/xml"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// XML-формат запросов шлюза с подписью
+type Request struct {
+	XMLName    xml.Name    `xml:"request"`
+	Point      string      `xml:"point,attr"`
+	Action     string      `xml:"action,attr"`
+	Timestamp  string      `xml:"datetime,attr"`
+	Attributes []Attribute `xml:"attribute"`
+	Signature  Signature   `xml:"signature"`
+}
+
+type Attribute struct {
+	XMLName xml.Name `xml:"attribute"`
+	Name    string   `xml:"name,attr"`
+	Value   string   `xml:"value,attr"`
+}
+
+type Signature struct {
+	XMLName xml.Name `xml:"signature"`
+	Type    string   `xml:"type,attr"`
+	Value   string   `xml:",chardata"`
+}
+
+type Response struct {
+	XMLName     xml.Name    `xml:"response"`
+	Code        int         `xml:"code"`
+	Message     string      `xml:"message"`
+	PayURL      string      `xml:"pay-url"`
+	Transaction Transaction `xml:"transaction"`
+}
+
+type Transaction struct {
+	ID        string `xml:"id,attr"`
+	PaymentID string `xml:"payment_id,attr"`
+}
+
+// Sign считает подпись: SHA-256 от строки атрибутов, отсортированных по имени, и секретного ключа.
+// Атрибуты записываются парами name=value через "&", имя и значение экранируются как в URL:
+// без разделителей разные наборы атрибутов ("1" и "23", "12" и "3") давали бы одну строку и одну подпись.
+func Sign(params []Attribute, secretKey string) string {
+	sorted := make([]Attribute, len(params))
+	copy(sorted, params)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	var signatureString strings.Builder
+	for i, attr := range sorted {
+		if i > 0 {
+			signatureString.WriteByte('&')
+		}
+		signatureString.WriteString(url.QueryEscape(attr.Name))
+		signatureString.WriteByte('=')
+		signatureString.WriteString(url.QueryEscape(attr.Value))
+	}
+	signatureString.WriteString(secretKey)
+
+	h := sha256.Sum256([]byte(signatureString.String()))
+	return hex.EncodeToString(h[:])
+}
+
+// VerifySignature сравнивает подпись с ожидаемой за постоянное время
+func VerifySignature(params []Attribute, secretKey, signature string) bool {
+	if secretKey == "" || signature == "" {
+		return false
+	}
+	expected := Sign(params, secretKey)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(strings.TrimSpace(signature)))) == 1
+}
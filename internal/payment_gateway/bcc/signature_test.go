@@ -0,0 +1,73 @@
+// internal/payment_gateway/bcc/signature_test.go
+package bcc
+
+import (
+	"strings"
+	"testing"
+)
+
+var signedNotification = []Attribute{
+	{Name: "status", Value: "charged"},
+	{Name: "order_id", Value: "bcc-1"},
+	{Name: "amount", Value: "449900"},
+}
+
+func TestSign(t *testing.T) {
+	// sha256("amount=449900&order_id=bcc-1&status=charged" + "secret")
+	const want = "934318accb93d8bd253be9e6196b1c3c2bdf047294ffbf165bf3ed08c474b6ad"
+	if got := Sign(signedNotification, "secret"); got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+	if signedNotification[0].Name != "status" {
+		t.Fatal("Sign must not reorder the caller's attributes")
+	}
+}
+
+func TestSignSeparatesAttributes(t *testing.T) {
+	pairs := [][2][]Attribute{
+		{ // Values shifted between attributes
+			{{Name: "a", Value: "1"}, {Name: "b", Value: "23"}},
+			{{Name: "a", Value: "12"}, {Name: "b", Value: "3"}},
+		},
+		{ // A value that looks like more attributes
+			{{Name: "a", Value: "1&b=2"}},
+			{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}},
+		},
+		{ // Name and value boundary
+			{{Name: "ab", Value: "c"}},
+			{{Name: "a", Value: "bc"}},
+		},
+	}
+	for _, pair := range pairs {
+		if Sign(pair[0], "secret") == Sign(pair[1], "secret") {
+			t.Fatalf("Different attributes %v and %v have the same signature", pair[0], pair[1])
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	signature := Sign(signedNotification, "secret")
+	tampered := append([]Attribute{}, signedNotification...)
+	tampered[2].Value = "100"
+
+	cases := []struct {
+		name      string
+		params    []Attribute
+		secret    string
+		signature string
+		want      bool
+	}{
+		{"valid", signedNotification, "secret", signature, true},
+		{"upper case with spaces", signedNotification, "secret", " " + strings.ToUpper(signature) + "\n", true},
+		{"wrong secret", signedNotification, "other", signature, false},
+		{"tampered amount", tampered, "secret", signature, false},
+		{"no secret configured", signedNotification, "", Sign(signedNotification, ""), false},
+		{"no signature", signedNotification, "secret", "", false},
+		{"truncated signature", signedNotification, "secret", signature[:32], false},
+	}
+	for _, tc := range cases {
+		if got := VerifySignature(tc.params, tc.secret, tc.signature); got != tc.want {
+			t.Errorf("%s: VerifySignature = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// internal/payment_gateway/gateway.go
+//
+// Package paymentgateway описывает платежный шлюз, через который оплачивается подписка.
+// Шлюз выбирается для каждого платежа и сохраняется в payments.gateway_name: возврат пользователя,
+// уведомления и проверка статуса идут через тот шлюз, в котором создан заказ.
+package paymentgateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+
+	"shaman-ai.kz/internal/models"
+)
+
+// ErrNotSupported - шлюз не поддерживает операцию
+var ErrNotSupported = errors.New("операция не поддерживается платежным шлюзом")
+
+// ErrInvalidSignature - уведомление без подписи или с неверной подписью
+var ErrInvalidSignature = errors.New("неверная подпись уведомления")
+
+// Customer - плательщик
+type Customer struct {
+	Email string
+	Name  string
+	Phone string
+}
+
+// OrderRequest - заказ на оплату
+type OrderRequest struct {
+	PaymentID   string // Наш ID платежа, в шлюзе - merchant_order_id
+	Amount      int64  // В тиынах
+	Currency    string
+	Description string
+	Customer    Customer
+	ReturnURL   string // Куда шлюз возвращает пользователя после оплаты
+	SaveCard    bool   // Сохранить карту для автопродления; передается только шлюзу с SupportsRecurring
+}
+
+// Order - созданный в шлюзе заказ
+type Order struct {
+	GatewayOrderID string
+	PaymentURL     string // Страница оплаты, на нее перенаправляется пользователь
+}
+
+// OrderStatus - состояние заказа в шлюзе
+type OrderStatus struct {
+	GatewayOrderID  string
+	MerchantOrderID string               // Пусто - шлюз не вернул
+	Status          models.PaymentStatus // processing - оплата еще не завершена
+	RawStatus       string               // Статус в терминах шлюза, для журнала
+	Amount          int64                // В тиынах
+	Currency        string               // Пусто - шлюз не вернул
+	TransactionID   string
+	RecurringToken  string // Токен сохраненной карты, если заказ оплачен с SaveCard
+}
+
+// WebhookEvent - проверенное уведомление шлюза. Статус в уведомлении только для журнала:
+// платеж проводится по статусу, запрошенному у шлюза.
+type WebhookEvent struct {
+	EventID        string // Уникален в пределах шлюза: повторное уведомление с тем же ID не обрабатывается
+	GatewayOrderID string
+	Status         string
+}
+
+// Gateway - платежный шлюз
+type Gateway interface {
+	// Name - имя шлюза в payments.gateway_name и в адресе уведомлений /api/billing/webhook/{name}
+	Name() string
+	CreateOrder(ctx context.Context, req OrderRequest) (*Order, error)
+	OrderStatus(ctx context.Context, gatewayOrderID string) (*OrderStatus, error)
+	// Refund возвращает amount тиын по оплаченному заказу
+	Refund(ctx context.Context, gatewayOrderID string, amount int64) error
+	// VerifyWebhook проверяет подпись уведомления и разбирает его; неверная подпись - ErrInvalidSignature
+	VerifyWebhook(r *http.Request, body []byte) (*WebhookEvent, error)
+	// SupportsRecurring - шлюз сохраняет карту при оплате и списывает с нее продление (ChargeRecurring).
+	// false - подписка, оплаченная через шлюз, продлевается только оплатой вручную.
+	SupportsRecurring() bool
+	// ChargeRecurring списывает оплату заказа с сохраненной карты без участия пользователя.
+	// Результат - состояние созданного заказа; processing - итог придет уведомлением.
+	ChargeRecurring(ctx context.Context, recurringToken string, req OrderRequest) (*OrderStatus, error)
+	// CancelRecurring отключает повторные списания по сохраненной карте
+	CancelRecurring(ctx context.Context, recurringToken string) error
+}
+
+// Registry - подключенные шлюзы и шлюз по умолчанию
+type Registry struct {
+	gateways    map[string]Gateway
+	defaultName string
+}
+
+func NewRegistry(defaultName string) *Registry {
+	return &Registry{gateways: make(map[string]Gateway), defaultName: defaultName}
+}
+
+func (r *Registry) Register(g Gateway) {
+	r.gateways[g.Name()] = g
+}
+
+// Get возвращает шлюз по имени; пустое имя - шлюз по умолчанию
+func (r *Registry) Get(name string) (Gateway, bool) {
+	if name == "" {
+		name = r.defaultName
+	}
+	g, ok := r.gateways[name]
+	return g, ok
+}
+
+// Names - имена подключенных шлюзов по алфавиту
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.gateways))
+	for name := range r.gateways {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
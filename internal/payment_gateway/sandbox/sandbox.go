@@ -0,0 +1,426 @@
+// internal/payment_gateway/sandbox/sandbox.go
+//
+// Package sandbox - платежный шлюз для разработки и проверки оплаты без банка. Заказы хранятся в памяти,
+// страница оплаты обслуживается самим приложением и позволяет выбрать сценарий: успешная оплата, отказ,
+// подтверждение 3-D Secure или оплата, о которой шлюз сообщает уведомлением с задержкой.
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/models"
+	paymentgateway "shaman-ai.kz/internal/payment_gateway"
+
+	"github.com/google/uuid"
+)
+
+// Name - имя шлюза в payments.gateway_name
+const Name = "sandbox"
+
+// PayPath - страница оплаты песочницы
+const PayPath = "/billing/sandbox/pay"
+
+// SignatureHeader - заголовок уведомления с HMAC-SHA256 тела в hex
+const SignatureHeader = "X-Sandbox-Signature"
+
+// Сценарии оплаты
+const (
+	ScenarioSuccess        = "success"
+	ScenarioDecline        = "decline"
+	Scenario3DS            = "3ds"
+	ScenarioDelayedWebhook = "delayed_webhook"
+)
+
+var scenarioTitles = []struct{ Scenario, Title string }{
+	{ScenarioSuccess, "Успешная оплата"},
+	{ScenarioDecline, "Отказ банка"},
+	{Scenario3DS, "Подтверждение 3-D Secure"},
+	{ScenarioDelayedWebhook, "Оплата с задержкой уведомления"},
+}
+
+// ValidScenario проверяет название сценария из конфигурации
+func ValidScenario(scenario string) bool {
+	for _, s := range scenarioTitles {
+		if s.Scenario == scenario {
+			return true
+		}
+	}
+	return false
+}
+
+// Статусы заказа
+const (
+	statusNew        = "new"
+	status3DS        = "3ds_pending"
+	statusProcessing = "processing"
+	statusCharged    = "charged"
+	statusDeclined   = "declined"
+	statusRefunded   = "refunded"
+)
+
+// Код подтверждения 3-D Secure; любой другой код - отказ
+const code3DS = "1234"
+
+// Сколько раз отправляется уведомление, если приложение ответило ошибкой
+const notifyAttempts = 3
+
+type order struct {
+	ID              string
+	MerchantOrderID string
+	Amount          int64
+	Currency        string
+	Description     string
+	ReturnURL       string
+	Status          string
+	SaveCard        bool
+	RecurringToken  string // Сохраненная карта, появляется после оплаты с SaveCard
+}
+
+// notification - уведомление о смене статуса заказа
+type notification struct {
+	EventID string `json:"event_id"`
+	OrderID string `json:"order_id"`
+	Status  string `json:"status"`
+}
+
+// Gateway - платежный шлюз в памяти процесса
+type Gateway struct {
+	cfg        config.PaymentSandboxConfig
+	webhookURL string
+	secret     []byte // Ключ подписи уведомлений: billing.webhook_secret или новый при каждом запуске
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	orders map[string]*order
+	cards  map[string]bool // Сохраненные карты для автопродления
+}
+
+// New создает песочницу; уведомления подписываются webhookSecret и отправляются на webhookURL
+func New(cfg config.PaymentSandboxConfig, webhookSecret, webhookURL string) *Gateway {
+	secret := []byte(webhookSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(fmt.Sprintf("sandbox: не удалось сгенерировать ключ подписи: %v", err))
+		}
+	}
+	return &Gateway{
+		cfg:        cfg,
+		webhookURL: webhookURL,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		orders:     make(map[string]*order),
+		cards:      make(map[string]bool),
+	}
+}
+
+func (g *Gateway) Name() string {
+	return Name
+}
+
+func (g *Gateway) CreateOrder(ctx context.Context, req paymentgateway.OrderRequest) (*paymentgateway.Order, error) {
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("sandbox: сумма заказа должна быть больше нуля")
+	}
+	o := &order{
+		ID:              "sbx_" + uuid.NewString()[:12],
+		MerchantOrderID: req.PaymentID,
+		Amount:          req.Amount,
+		Currency:        req.Currency,
+		Description:     req.Description,
+		ReturnURL:       req.ReturnURL,
+		Status:          statusNew,
+		SaveCard:        req.SaveCard,
+	}
+	g.mu.Lock()
+	g.orders[o.ID] = o
+	g.mu.Unlock()
+	return &paymentgateway.Order{GatewayOrderID: o.ID, PaymentURL: PayPath + "?order=" + url.QueryEscape(o.ID)}, nil
+}
+
+func paymentStatus(status string) models.PaymentStatus {
+	switch status {
+	case statusCharged:
+		return models.PaymentStatusSuccess
+	case statusDeclined:
+		return models.PaymentStatusFailed
+	case statusRefunded:
+		return models.PaymentStatusRefunded
+	default:
+		return models.PaymentStatusProcessing
+	}
+}
+
+// snapshot возвращает копию заказа, чтобы читать его без блокировки
+func (g *Gateway) snapshot(gatewayOrderID string) (order, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	o, ok := g.orders[gatewayOrderID]
+	if !ok {
+		return order{}, false
+	}
+	return *o, true
+}
+
+func (g *Gateway) OrderStatus(ctx context.Context, gatewayOrderID string) (*paymentgateway.OrderStatus, error) {
+	o, ok := g.snapshot(gatewayOrderID)
+	if !ok {
+		// Заказы живут до перезапуска приложения
+		return nil, fmt.Errorf("sandbox: заказ %s не найден", gatewayOrderID)
+	}
+	return &paymentgateway.OrderStatus{
+		GatewayOrderID:  o.ID,
+		MerchantOrderID: o.MerchantOrderID,
+		Status:          paymentStatus(o.Status),
+		RawStatus:       o.Status,
+		Amount:          o.Amount,
+		Currency:        o.Currency,
+		TransactionID:   o.ID,
+		RecurringToken:  o.RecurringToken,
+	}, nil
+}
+
+// SupportsRecurring: песочница сохраняет карту при оплате с SaveCard
+func (g *Gateway) SupportsRecurring() bool {
+	return true
+}
+
+// ChargeRecurring списывает оплату с сохраненной карты сразу; сценарий decline в renewal_scenario - отказ
+func (g *Gateway) ChargeRecurring(ctx context.Context, recurringToken string, req paymentgateway.OrderRequest) (*paymentgateway.OrderStatus, error) {
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("sandbox: сумма заказа должна быть больше нуля")
+	}
+	status := statusCharged
+	if g.cfg.RenewalScenario == ScenarioDecline {
+		status = statusDeclined
+	}
+	o := &order{
+		ID:              "sbx_" + uuid.NewString()[:12],
+		MerchantOrderID: req.PaymentID,
+		Amount:          req.Amount,
+		Currency:        req.Currency,
+		Description:     req.Description,
+		Status:          status,
+	}
+	g.mu.Lock()
+	if !g.cards[recurringToken] {
+		g.mu.Unlock()
+		return nil, fmt.Errorf("sandbox: карта %s не сохранена", recurringToken)
+	}
+	g.orders[o.ID] = o
+	g.mu.Unlock()
+
+	slog.Info("Песочница: списание с сохраненной карты", "order", o.ID, "payment", o.MerchantOrderID, "status", status)
+	g.notify(o.ID, status)
+	return g.OrderStatus(ctx, o.ID)
+}
+
+func (g *Gateway) Refund(ctx context.Context, gatewayOrderID string, amount int64) error {
+	g.mu.Lock()
+	o, ok := g.orders[gatewayOrderID]
+	if !ok {
+		g.mu.Unlock()
+		return fmt.Errorf("sandbox: заказ %s не найден", gatewayOrderID)
+	}
+	if o.Status != statusCharged {
+		g.mu.Unlock()
+		return fmt.Errorf("sandbox: заказ %s не оплачен (статус %s)", gatewayOrderID, o.Status)
+	}
+	if amount <= 0 || amount > o.Amount {
+		g.mu.Unlock()
+		return fmt.Errorf("sandbox: сумма возврата %d вне пределов суммы заказа %d", amount, o.Amount)
+	}
+	o.Status = statusRefunded
+	g.mu.Unlock()
+
+	g.notify(gatewayOrderID, statusRefunded)
+	return nil
+}
+
+func (g *Gateway) sign(body []byte) string {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (g *Gateway) VerifyWebhook(r *http.Request, body []byte) (*paymentgateway.WebhookEvent, error) {
+	signature, err := hex.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return nil, fmt.Errorf("sandbox: %w", paymentgateway.ErrInvalidSignature)
+	}
+	expected, _ := hex.DecodeString(g.sign(body))
+	if !hmac.Equal(signature, expected) {
+		return nil, fmt.Errorf("sandbox: %w", paymentgateway.ErrInvalidSignature)
+	}
+	var n notification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, fmt.Errorf("sandbox: некорректное уведомление: %w", err)
+	}
+	return &paymentgateway.WebhookEvent{EventID: n.EventID, GatewayOrderID: n.OrderID, Status: n.Status}, nil
+}
+
+func (g *Gateway) CancelRecurring(ctx context.Context, recurringToken string) error {
+	g.mu.Lock()
+	delete(g.cards, recurringToken)
+	g.mu.Unlock()
+	slog.Info("Песочница: повторные списания отключены", "token", recurringToken)
+	return nil
+}
+
+// notify отправляет уведомление в фоне, как настоящий шлюз: ответ пользователю его не ждет
+func (g *Gateway) notify(gatewayOrderID, status string) {
+	n := notification{EventID: "evt_" + uuid.NewString(), OrderID: gatewayOrderID, Status: status}
+	body, err := json.Marshal(n)
+	if err != nil {
+		slog.Error("Песочница: не удалось сформировать уведомление", "order", gatewayOrderID, "error", err)
+		return
+	}
+	go func() {
+		for attempt := 1; attempt <= notifyAttempts; attempt++ {
+			req, err := http.NewRequest(http.MethodPost, g.webhookURL, bytes.NewReader(body))
+			if err != nil {
+				slog.Error("Песочница: некорректный адрес уведомлений", "url", g.webhookURL, "error", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(SignatureHeader, g.sign(body))
+			resp, err := g.httpClient.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode < 300 {
+					slog.Info("Песочница: уведомление доставлено", "order", gatewayOrderID, "status", status, "event", n.EventID)
+					return
+				}
+				err = fmt.Errorf("код ответа %d", resp.StatusCode)
+			}
+			slog.Warn("Песочница: уведомление не доставлено", "order", gatewayOrderID, "attempt", attempt, "error", err)
+			time.Sleep(time.Duration(attempt) * 2 * time.Second)
+		}
+	}()
+}
+
+// setStatus меняет статус заказа, ожидающего оплаты; false - заказ уже оплачен или отклонен
+func (g *Gateway) setStatus(gatewayOrderID string, from []string, to string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	o, ok := g.orders[gatewayOrderID]
+	if !ok {
+		return false
+	}
+	for _, status := range from {
+		if o.Status == status {
+			o.Status = to
+			if to == statusCharged && o.SaveCard {
+				o.RecurringToken = "sbx_card_" + uuid.NewString()[:12]
+				g.cards[o.RecurringToken] = true
+			}
+			return true
+		}
+	}
+	return false
+}
+
+var payPage = template.Must(template.New("pay").Parse(`<!DOCTYPE html>
+<html lang="ru"><head><meta charset="utf-8"><title>Песочница оплаты</title>
+<style>body{font-family:sans-serif;max-width:480px;margin:40px auto}button{display:block;width:100%;margin:8px 0;padding:10px}</style>
+</head><body>
+<h1>Песочница оплаты</h1>
+<p>{{.Order.Description}}<br>Сумма: {{.Amount}} {{.Order.Currency}}<br>Заказ: {{.Order.ID}}</p>
+{{if .Confirm3DS}}
+<form method="post"><input type="hidden" name="order" value="{{.Order.ID}}"><input type="hidden" name="action" value="3ds">
+<p>Введите код из SMS (в песочнице - {{.Code}}):</p><input name="code" autocomplete="off" autofocus>
+<button type="submit">Подтвердить</button></form>
+{{else}}
+<form method="post"><input type="hidden" name="order" value="{{.Order.ID}}">
+{{range .Scenarios}}<button type="submit" name="scenario" value="{{.Scenario}}">{{.Title}}</button>{{end}}
+</form>
+{{end}}
+</body></html>`))
+
+func (g *Gateway) renderPayPage(w http.ResponseWriter, o order, confirm3DS bool) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := payPage.Execute(w, map[string]interface{}{
+		"Order":      o,
+		"Amount":     fmt.Sprintf("%.2f", float64(o.Amount)/100),
+		"Confirm3DS": confirm3DS,
+		"Code":       code3DS,
+		"Scenarios":  scenarioTitles,
+	})
+	if err != nil {
+		slog.Error("Песочница: ошибка отрисовки страницы оплаты", "error", err)
+	}
+}
+
+// PayPageHandler - страница оплаты песочницы. Сценарий из конфигурации применяется сразу,
+// иначе его выбирают на странице.
+func (g *Gateway) PayPageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		o, ok := g.snapshot(r.FormValue("order"))
+		if !ok {
+			http.Error(w, "Заказ не найден", http.StatusNotFound)
+			return
+		}
+		if o.Status != statusNew && o.Status != status3DS {
+			http.Redirect(w, r, o.ReturnURL, http.StatusSeeOther) // Оплата уже завершена
+			return
+		}
+
+		scenario := g.cfg.Scenario
+		if r.Method == http.MethodPost {
+			if r.FormValue("action") == "3ds" {
+				status := statusDeclined
+				if r.FormValue("code") == code3DS {
+					status = statusCharged
+				}
+				g.finish(w, r, o, []string{status3DS}, status)
+				return
+			}
+			scenario = r.FormValue("scenario")
+		}
+
+		switch scenario {
+		case ScenarioSuccess:
+			g.finish(w, r, o, []string{statusNew}, statusCharged)
+		case ScenarioDecline:
+			g.finish(w, r, o, []string{statusNew}, statusDeclined)
+		case Scenario3DS:
+			g.setStatus(o.ID, []string{statusNew}, status3DS)
+			g.renderPayPage(w, o, true)
+		case ScenarioDelayedWebhook:
+			// Пользователь возвращается раньше, чем шлюз узнает результат; оплата приходит уведомлением
+			if g.setStatus(o.ID, []string{statusNew}, statusProcessing) {
+				time.AfterFunc(time.Duration(g.cfg.WebhookDelaySeconds)*time.Second, func() {
+					if g.setStatus(o.ID, []string{statusProcessing}, statusCharged) {
+						g.notify(o.ID, statusCharged)
+					}
+				})
+			}
+			http.Redirect(w, r, o.ReturnURL, http.StatusSeeOther)
+		default:
+			g.renderPayPage(w, o, o.Status == status3DS)
+		}
+	}
+}
+
+// finish завершает оплату, отправляет уведомление и возвращает пользователя в приложение
+func (g *Gateway) finish(w http.ResponseWriter, r *http.Request, o order, from []string, status string) {
+	if g.setStatus(o.ID, from, status) {
+		slog.Info("Песочница: оплата завершена", "order", o.ID, "payment", o.MerchantOrderID, "status", status)
+		g.notify(o.ID, status)
+	}
+	http.Redirect(w, r, o.ReturnURL, http.StatusSeeOther)
+}
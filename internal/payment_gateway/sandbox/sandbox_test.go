@@ -0,0 +1,215 @@
+// internal/payment_gateway/sandbox/sandbox_test.go
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/models"
+	paymentgateway "shaman-ai.kz/internal/payment_gateway"
+)
+
+const testSecret = "webhook-secret"
+
+// newTestGateway starts a sandbox whose notifications are verified and collected by a test webhook endpoint
+func newTestGateway(t *testing.T, cfg config.PaymentSandboxConfig) (*Gateway, <-chan *paymentgateway.WebhookEvent) {
+	t.Helper()
+	events := make(chan *paymentgateway.WebhookEvent, 10)
+	var g *Gateway
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		event, err := g.VerifyWebhook(r, body)
+		if err != nil {
+			t.Errorf("Notification rejected: %v", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		events <- event
+	}))
+	t.Cleanup(srv.Close)
+	g = New(cfg, testSecret, srv.URL)
+	return g, events
+}
+
+func createOrder(t *testing.T, g *Gateway, saveCard bool) *paymentgateway.Order {
+	t.Helper()
+	order, err := g.CreateOrder(context.Background(), paymentgateway.OrderRequest{
+		PaymentID: "pay_1", Amount: 449900, Currency: "KZT", Description: "Подписка",
+		ReturnURL: "https://shaman.test/billing/success?payment_id=pay_1", SaveCard: saveCard,
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	if !strings.HasPrefix(order.PaymentURL, PayPath+"?order=") {
+		t.Fatalf("Unexpected payment URL %q", order.PaymentURL)
+	}
+	return order
+}
+
+// pay submits the pay page form the way the customer's browser does
+func pay(g *Gateway, orderID string, form url.Values) *httptest.ResponseRecorder {
+	form.Set("order", orderID)
+	req := httptest.NewRequest(http.MethodPost, PayPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	g.PayPageHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func waitEvent(t *testing.T, events <-chan *paymentgateway.WebhookEvent) *paymentgateway.WebhookEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notification not delivered")
+		return nil
+	}
+}
+
+func orderStatus(t *testing.T, g *Gateway, orderID string) *paymentgateway.OrderStatus {
+	t.Helper()
+	status, err := g.OrderStatus(context.Background(), orderID)
+	if err != nil {
+		t.Fatalf("OrderStatus failed: %v", err)
+	}
+	return status
+}
+
+func TestCheckoutReturnWebhook(t *testing.T) {
+	cases := []struct {
+		name       string
+		steps      []url.Values // Forms submitted on the pay page; the last one returns the customer
+		want       models.PaymentStatus
+		wantStatus string
+	}{
+		{"success", []url.Values{{"scenario": {ScenarioSuccess}}}, models.PaymentStatusSuccess, statusCharged},
+		{"decline", []url.Values{{"scenario": {ScenarioDecline}}}, models.PaymentStatusFailed, statusDeclined},
+		{"3ds confirmed", []url.Values{{"scenario": {Scenario3DS}}, {"action": {"3ds"}, "code": {code3DS}}}, models.PaymentStatusSuccess, statusCharged},
+		{"3ds wrong code", []url.Values{{"scenario": {Scenario3DS}}, {"action": {"3ds"}, "code": {"0000"}}}, models.PaymentStatusFailed, statusDeclined},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g, events := newTestGateway(t, config.PaymentSandboxConfig{})
+			order := createOrder(t, g, false)
+
+			var rec *httptest.ResponseRecorder
+			for i, form := range tc.steps {
+				rec = pay(g, order.GatewayOrderID, form)
+				if i < len(tc.steps)-1 && (rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `name="code"`)) {
+					t.Fatalf("Expected the 3-D Secure form, got %d", rec.Code)
+				}
+			}
+			// The customer is returned to the application
+			if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "https://shaman.test/billing/success?payment_id=pay_1" {
+				t.Fatalf("Expected a redirect to the return URL, got %d %q", rec.Code, rec.Header().Get("Location"))
+			}
+			status := orderStatus(t, g, order.GatewayOrderID)
+			if status.Status != tc.want || status.MerchantOrderID != "pay_1" || status.Amount != 449900 || status.Currency != "KZT" {
+				t.Fatalf("Unexpected order status: %+v", status)
+			}
+
+			event := waitEvent(t, events)
+			if event.GatewayOrderID != order.GatewayOrderID || event.Status != tc.wantStatus || event.EventID == "" {
+				t.Fatalf("Unexpected notification: %+v", event)
+			}
+
+			// A finished order only returns the customer
+			if rec := pay(g, order.GatewayOrderID, url.Values{"scenario": {ScenarioSuccess}}); rec.Code != http.StatusSeeOther {
+				t.Fatalf("Expected a redirect for a finished order, got %d", rec.Code)
+			}
+			if got := orderStatus(t, g, order.GatewayOrderID).Status; got != tc.want {
+				t.Fatalf("A finished order changed to %s", got)
+			}
+		})
+	}
+}
+
+func TestDelayedWebhook(t *testing.T) {
+	g, events := newTestGateway(t, config.PaymentSandboxConfig{Scenario: ScenarioDelayedWebhook, WebhookDelaySeconds: 1})
+	order := createOrder(t, g, true)
+
+	// The configured scenario applies on opening the page; the customer returns before the result is known
+	rec := httptest.NewRecorder()
+	g.PayPageHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, order.PaymentURL, nil))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect to the return URL, got %d", rec.Code)
+	}
+	if status := orderStatus(t, g, order.GatewayOrderID); status.Status != models.PaymentStatusProcessing {
+		t.Fatalf("Expected the order processing on return, got %s", status.Status)
+	}
+
+	event := waitEvent(t, events)
+	if event.GatewayOrderID != order.GatewayOrderID || event.Status != statusCharged {
+		t.Fatalf("Unexpected notification: %+v", event)
+	}
+	status := orderStatus(t, g, order.GatewayOrderID)
+	if status.Status != models.PaymentStatusSuccess || status.RecurringToken == "" {
+		t.Fatalf("Expected a charged order with a saved card, got %+v", status)
+	}
+
+	// The saved card renews the subscription
+	renewal, err := g.ChargeRecurring(context.Background(), status.RecurringToken, paymentgateway.OrderRequest{PaymentID: "pay_2", Amount: 449900, Currency: "KZT"})
+	if err != nil || renewal.Status != models.PaymentStatusSuccess || renewal.MerchantOrderID != "pay_2" {
+		t.Fatalf("ChargeRecurring = %+v, %v", renewal, err)
+	}
+	if event := waitEvent(t, events); event.GatewayOrderID != renewal.GatewayOrderID {
+		t.Fatalf("Unexpected renewal notification: %+v", event)
+	}
+	if err := g.CancelRecurring(context.Background(), status.RecurringToken); err != nil {
+		t.Fatalf("CancelRecurring failed: %v", err)
+	}
+	if _, err := g.ChargeRecurring(context.Background(), status.RecurringToken, paymentgateway.OrderRequest{PaymentID: "pay_3", Amount: 449900}); err == nil {
+		t.Fatal("A canceled card must not be charged")
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	g := New(config.PaymentSandboxConfig{}, testSecret, "")
+	other := New(config.PaymentSandboxConfig{}, "other-secret", "")
+	body := []byte(`{"event_id":"evt_1","order_id":"sbx_1","status":"charged"}`)
+
+	cases := []struct {
+		name      string
+		body      []byte
+		signature string
+		wantErr   error
+	}{
+		{"valid", body, g.sign(body), nil},
+		{"upper case hex", body, strings.ToUpper(g.sign(body)), nil},
+		{"other secret", body, other.sign(body), paymentgateway.ErrInvalidSignature},
+		{"tampered body", []byte(strings.Replace(string(body), "charged", "refunded", 1)), g.sign(body), paymentgateway.ErrInvalidSignature},
+		{"no signature", body, "", paymentgateway.ErrInvalidSignature},
+		{"not hex", body, "signature", paymentgateway.ErrInvalidSignature},
+		{"truncated", body, g.sign(body)[:32], paymentgateway.ErrInvalidSignature},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/billing/webhook/"+Name, nil)
+		if tc.signature != "" {
+			req.Header.Set(SignatureHeader, tc.signature)
+		}
+		event, err := g.VerifyWebhook(req, tc.body)
+		if tc.wantErr != nil {
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("%s: expected %v, got %v", tc.name, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil || event.EventID != "evt_1" || event.GatewayOrderID != "sbx_1" || event.Status != statusCharged {
+			t.Errorf("%s: VerifyWebhook = %+v, %v", tc.name, event, err)
+		}
+	}
+
+	// Without a configured secret each start signs with a new random key
+	if a, b := New(config.PaymentSandboxConfig{}, "", ""), New(config.PaymentSandboxConfig{}, "", ""); a.sign(body) == b.sign(body) {
+		t.Fatal("Sandboxes without a secret must not share a signing key")
+	}
+}
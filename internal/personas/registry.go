@@ -0,0 +1,133 @@
+// internal/personas/registry.go
+//
+// Package personas хранит реестр персон ассистента (режимов со своим промптом и параметрами модели).
+// Персоны лежат в таблице personas и редактируются в админке; "general" и "shaman" встроенные.
+package personas
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/models"
+)
+
+const (
+	SlugGeneral = "general"
+	SlugShaman  = "shaman"
+)
+
+// Как долго список активных персон берется из памяти, прежде чем перечитать его из БД
+const cacheTTL = time.Minute
+
+// Встроенные персоны на случай, если таблица недоступна
+var builtinPersonas = []models.Persona{
+	{Slug: SlugGeneral, Name: "Универсальный помощник", IsActive: true, IsBuiltin: true},
+	{Slug: SlugShaman, Name: "Шаман", AutoRoute: true, IsActive: true, IsBuiltin: true, SortOrder: 10},
+}
+
+// PromptSource отдает промпты встроенных персон (см. prompts.Provider)
+type PromptSource interface {
+	Get(slug string) string
+}
+
+type Registry struct {
+	mu       sync.RWMutex
+	active   []models.Persona
+	loadedAt time.Time
+	// Промпты встроенных персон, у которых system_prompt в БД пуст
+	prompts PromptSource
+}
+
+func NewRegistry(prompts PromptSource) *Registry {
+	return &Registry{prompts: prompts}
+}
+
+// Invalidate сбрасывает кеш, следующий запрос перечитает персоны из БД
+func (r *Registry) Invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadedAt = time.Time{}
+}
+
+// Active возвращает активные персоны в порядке сортировки
+func (r *Registry) Active() []models.Persona {
+	r.mu.RLock()
+	if !r.loadedAt.IsZero() && time.Since(r.loadedAt) < cacheTTL {
+		active := r.active
+		r.mu.RUnlock()
+		return active
+	}
+	r.mu.RUnlock()
+
+	loaded, err := db.GetActivePersonas()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		slog.Error("Не удалось загрузить персоны из БД", "error", err)
+		if r.active == nil {
+			return builtinPersonas
+		}
+		return r.active // Отдаем последний успешно загруженный список
+	}
+	r.active = loaded
+	r.loadedAt = time.Now()
+	return r.active
+}
+
+// Get возвращает активную персону по slug
+func (r *Registry) Get(slug string) (models.Persona, bool) {
+	for _, p := range r.Active() {
+		if p.Slug == slug {
+			return p, true
+		}
+	}
+	return models.Persona{}, false
+}
+
+// Default возвращает персону общего режима
+func (r *Registry) Default() models.Persona {
+	if p, ok := r.Get(SlugGeneral); ok {
+		return p
+	}
+	return builtinPersonas[0]
+}
+
+// Resolve возвращает персону по slug, а если она не найдена или отключена - общую
+func (r *Registry) Resolve(slug string) models.Persona {
+	if slug != "" {
+		if p, ok := r.Get(slug); ok {
+			return p
+		}
+		slog.Warn("Персона не найдена или отключена, используется общая", "slug", slug)
+	}
+	return r.Default()
+}
+
+// SystemPrompt возвращает системный промпт персоны
+func (r *Registry) SystemPrompt(p models.Persona) string {
+	if p.SystemPrompt != "" {
+		return p.SystemPrompt
+	}
+	if prompt := r.prompts.Get(p.Slug); prompt != "" {
+		return prompt
+	}
+	return r.prompts.Get(SlugGeneral)
+}
+
+// LLMConfig применяет модель и параметры генерации персоны к базовой конфигурации
+func LLMConfig(p models.Persona, base config.RemoteLLMConfig) config.RemoteLLMConfig {
+	cfg := base
+	if p.Model != "" {
+		cfg.ModelSpec = p.Model
+	}
+	if p.Temperature != nil {
+		cfg.Temperature = *p.Temperature
+	}
+	if p.MaxTokens != nil && *p.MaxTokens > 0 {
+		cfg.MaxTokens = *p.MaxTokens
+	}
+	return cfg
+}
@@ -0,0 +1,136 @@
+// internal/prompts/provider.go
+//
+// Package prompts отдает системные промпты встроенных персон с учетом правок в админке.
+// Порядок разрешения: содержимое из app_settings (<slug>_system_prompt_content),
+// затем файл (<slug>_system_prompt_path_db или путь из config.yaml), затем встроенный текст.
+package prompts
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/utils"
+)
+
+// Кеш перечитывается не реже этого интервала, даже если уведомление об изменении не пришло
+// (например, настройку поменял другой экземпляр сервера)
+const cacheTTL = 5 * time.Minute
+
+const (
+	SourceDB      = "db"
+	SourceFile    = "file"
+	SourceBuiltin = "builtin"
+)
+
+// Встроенные промпты на случай, если ни БД, ни файл не дали текста
+var builtinPrompts = map[string]string{
+	"general": "Ты — полезный AI-ассистент.",
+	"shaman":  "Ты — Шаман, мудрый и внимательный собеседник. Отвечай бережно, по существу и на языке пользователя.",
+}
+
+// ContentKey - ключ настройки с текстом промпта
+func ContentKey(slug string) string {
+	return slug + "_system_prompt_content"
+}
+
+// PathKey - ключ настройки с путем к файлу промпта
+func PathKey(slug string) string {
+	return slug + "_system_prompt_path_db"
+}
+
+// SlugForKey возвращает slug, к промпту которого относится ключ настройки
+func SlugForKey(key string) (string, bool) {
+	for _, suffix := range []string{"_system_prompt_content", "_system_prompt_path_db"} {
+		if slug, ok := strings.CutSuffix(key, suffix); ok && slug != "" {
+			return slug, true
+		}
+	}
+	return "", false
+}
+
+type cachedPrompt struct {
+	text     string
+	source   string
+	loadedAt time.Time
+}
+
+type Provider struct {
+	mu          sync.RWMutex
+	cache       map[string]cachedPrompt
+	configPaths map[string]string
+}
+
+func NewProvider(llmCfg config.RemoteLLMConfig) *Provider {
+	return &Provider{
+		cache: make(map[string]cachedPrompt),
+		configPaths: map[string]string{
+			"shaman":  llmCfg.ShamanSystemPromptPath,
+			"general": llmCfg.GeneralSystemPromptPath,
+		},
+	}
+}
+
+// Get возвращает актуальный системный промпт для slug (пустая строка, если его нет нигде)
+func (p *Provider) Get(slug string) string {
+	text, _ := p.Resolve(slug)
+	return text
+}
+
+// Resolve возвращает промпт и источник, из которого он получен
+func (p *Provider) Resolve(slug string) (string, string) {
+	p.mu.RLock()
+	cached, ok := p.cache[slug]
+	p.mu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < cacheTTL {
+		return cached.text, cached.source
+	}
+
+	text, source := p.load(slug)
+	p.mu.Lock()
+	p.cache[slug] = cachedPrompt{text: text, source: source, loadedAt: time.Now()}
+	p.mu.Unlock()
+	return text, source
+}
+
+// Invalidate сбрасывает кеш промпта, если key - одна из его настроек.
+// Подходит как обработчик для db.OnSettingChanged.
+func (p *Provider) Invalidate(key string) {
+	slug, ok := SlugForKey(key)
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	delete(p.cache, slug)
+	p.mu.Unlock()
+	slog.Info("Кеш системного промпта сброшен", "slug", slug, "key", key)
+}
+
+func (p *Provider) load(slug string) (string, string) {
+	if setting, err := db.GetSetting(ContentKey(slug)); err != nil {
+		slog.Warn("Не удалось прочитать промпт из БД", "slug", slug, "error", err)
+	} else if setting != nil && strings.TrimSpace(setting.Value) != "" {
+		return setting.Value, SourceDB
+	}
+
+	var paths []string
+	if setting, err := db.GetSetting(PathKey(slug)); err == nil && setting != nil && strings.TrimSpace(setting.Value) != "" {
+		paths = append(paths, strings.TrimSpace(setting.Value))
+	}
+	if path := p.configPaths[slug]; path != "" {
+		paths = append(paths, path)
+	}
+	for _, path := range paths {
+		text, err := utils.LoadSystemPrompt(path)
+		if err != nil {
+			slog.Warn("Не удалось загрузить промпт из файла", "slug", slug, "path", path, "error", err)
+			continue
+		}
+		return text, SourceFile
+	}
+
+	return builtinPrompts[slug], SourceBuiltin
+}
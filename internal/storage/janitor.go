@@ -0,0 +1,67 @@
+// internal/storage/janitor.go
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"shaman-ai.kz/internal/db"
+)
+
+// CleanupOrphans удаляет объекты, на которые не ссылается ни одна запись message_attachments.
+// Объекты моложе grace не трогаются: файл кладется в хранилище до того, как обмен
+// с вложением сохранен в БД (между ними идет запрос к LLM).
+func CleanupOrphans(ctx context.Context, s Storage, grace time.Duration) (removed int, freed int64, err error) {
+	// Ключи читаем до обхода хранилища: объект, появившийся позже, защищен grace
+	referenced, err := db.GetReferencedStorageKeys()
+	if err != nil {
+		return 0, 0, err
+	}
+	cutoff := time.Now().Add(-grace)
+	var orphans []ObjectInfo
+	err = s.List(ctx, ContentPrefix, func(obj ObjectInfo) error {
+		if !referenced[obj.Key] && obj.ModTime.Before(cutoff) {
+			orphans = append(orphans, obj)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, obj := range orphans {
+		// Пока шел обход, на объект могли сослаться повторной загрузкой того же файла
+		if stillReferenced, errRef := db.IsStorageKeyReferenced(obj.Key); errRef != nil || stillReferenced {
+			continue
+		}
+		if err := s.Delete(ctx, obj.Key); err != nil {
+			slog.Error("Не удалось удалить неиспользуемый объект хранилища", "key", obj.Key, "error", err)
+			continue
+		}
+		removed++
+		freed += obj.Size
+	}
+	return removed, freed, nil
+}
+
+// StartJanitor запускает периодическое удаление неиспользуемых объектов хранилища
+func StartJanitor(s Storage, interval, grace time.Duration) {
+	slog.Info("Очистка хранилища загрузок запущена", "interval", interval.String(), "grace", grace.String())
+	ticker := time.NewTicker(interval)
+	go func() {
+		for {
+			<-ticker.C
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			removed, freed, err := CleanupOrphans(ctx, s, grace)
+			cancel()
+			if err != nil {
+				slog.Error("Ошибка очистки хранилища загрузок", "error", err)
+				continue
+			}
+			if removed > 0 {
+				slog.Info("Удалены неиспользуемые объекты хранилища", "count", removed, "bytes", freed)
+			}
+		}
+	}()
+}
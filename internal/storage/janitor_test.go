@@ -0,0 +1,82 @@
+// internal/storage/janitor_test.go
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shaman-ai.kz/internal/db"
+)
+
+func TestCleanupOrphansGracePeriod(t *testing.T) {
+	db.OpenTestDB(t)
+	root := t.TempDir()
+	s, err := NewLocal(root)
+	if err != nil {
+		t.Fatalf("NewLocal failed: %v", err)
+	}
+
+	// Contents are unique per run: the referenced keys come from the shared test DB
+	unique := time.Now().UnixNano()
+	put := func(name string, age time.Duration) (string, int64) {
+		data := []byte(fmt.Sprintf("%s-%d", name, unique))
+		key := contentKey(data)
+		if err := s.Put(context.Background(), key, bytes.NewReader(data), int64(len(data))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		modTime := time.Now().Add(-age)
+		if err := os.Chtimes(filepath.Join(root, filepath.FromSlash(key)), modTime, modTime); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+		return key, int64(len(data))
+	}
+	referencedKey, _ := put("referenced", 3*time.Hour)
+	orphanKey, orphanSize := put("orphan", 3*time.Hour)
+	freshKey, _ := put("fresh", 10*time.Minute) // Stored, but the exchange is not saved yet
+
+	userID := db.CreateTestUser(t)
+	sessionUUID := uuid.NewString()
+	if err := db.CreateChatSession(userID, sessionUUID, "Janitor", ""); err != nil {
+		t.Fatalf("CreateChatSession failed: %v", err)
+	}
+	dialogueID, err := db.SaveChatMessage(userID, sessionUUID, "prompt", "answer", db.DialogueOrigin{})
+	if err != nil {
+		t.Fatalf("SaveChatMessage failed: %v", err)
+	}
+	_, err = db.CreateMessageAttachment(&db.MessageAttachment{DialogueID: dialogueID, OriginalName: "a.txt",
+		SHA256: filepath.Base(referencedKey), StorageKey: referencedKey, MIMEType: "text/plain", Size: 1})
+	if err != nil {
+		t.Fatalf("CreateMessageAttachment failed: %v", err)
+	}
+
+	removed, freed, err := CleanupOrphans(context.Background(), s, time.Hour)
+	if err != nil {
+		t.Fatalf("CleanupOrphans failed: %v", err)
+	}
+	if removed != 1 || freed != orphanSize {
+		t.Fatalf("Expected 1 object of %d bytes removed, got %d of %d", orphanSize, removed, freed)
+	}
+	if _, _, err := s.Open(context.Background(), orphanKey); err == nil {
+		t.Fatal("Old orphan must be removed")
+	}
+	for _, key := range []string{referencedKey, freshKey} {
+		if _, _, err := s.Open(context.Background(), key); err != nil {
+			t.Fatalf("Object %s must be kept: %v", key, err)
+		}
+	}
+
+	// Once the grace period passes, the unsaved upload is removed too
+	if removed, _, err := CleanupOrphans(context.Background(), s, time.Minute); err != nil || removed != 1 {
+		t.Fatalf("Expected the expired upload removed, got %d, %v", removed, err)
+	}
+	if _, _, err := s.Open(context.Background(), referencedKey); err != nil {
+		t.Fatalf("Referenced object must be kept: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+// internal/storage/local_test.go
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	root := t.TempDir()
+	s, err := NewLocal(root)
+	if err != nil {
+		t.Fatalf("NewLocal failed: %v", err)
+	}
+	testBackend(t, s)
+
+	// Unfinished writes and files outside the content prefix are not objects
+	data := []byte("x")
+	dir := filepath.Join(root, filepath.FromSlash(ContentPrefix), "ab")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".tmp-123"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "legacy.txt"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if keys := listKeys(t, s, ContentPrefix); len(keys) != 1 {
+		t.Fatalf("Expected only the stored object, got %v", keys)
+	}
+	if keys := listKeys(t, s, ContentPrefix+"zz/"); len(keys) != 0 {
+		t.Fatalf("Expected empty list for a missing prefix, got %v", keys)
+	}
+}
+
+func TestNewLocalRequiresRoot(t *testing.T) {
+	if _, err := NewLocal(""); err == nil {
+		t.Fatal("Expected an error for an empty root")
+	}
+}
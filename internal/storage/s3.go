@@ -0,0 +1,112 @@
+// internal/storage/s3.go
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"shaman-ai.kz/internal/config"
+)
+
+// S3 хранит объекты в бакете S3-совместимого хранилища (AWS S3, MinIO и т.п.)
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 подключается к хранилищу и создает бакет, если его еще нет
+func NewS3(ctx context.Context, cfg config.S3StorageConfig) (*S3, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка настройки клиента S3: %w", err)
+	}
+	s := &S3{client: client, bucket: cfg.Bucket, prefix: strings.Trim(cfg.Prefix, "/")}
+	if s.prefix != "" {
+		s.prefix += "/"
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось проверить бакет %s: %w", cfg.Bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, fmt.Errorf("не удалось создать бакет %s: %w", cfg.Bucket, err)
+		}
+		slog.Info("Создан бакет S3 для загрузок", "bucket", cfg.Bucket)
+	}
+	return s, nil
+}
+
+func isNotFound(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	return resp.StatusCode == http.StatusNotFound || resp.Code == "NoSuchKey"
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if !validKey(key) {
+		return fmt.Errorf("недопустимый ключ объекта: %q", key)
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, s.prefix+key, r, size, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		return fmt.Errorf("не удалось записать объект %s в S3: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadSeekCloser, ObjectInfo, error) {
+	if !validKey(key) {
+		return nil, ObjectInfo{}, fmt.Errorf("недопустимый ключ объекта: %q", key)
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, s.prefix+key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("не удалось открыть объект %s в S3: %w", key, err)
+	}
+	// GetObject ленивый: ошибка "нет такого ключа" приходит только при первом обращении
+	stat, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		if isNotFound(err) {
+			return nil, ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, ObjectInfo{}, fmt.Errorf("не удалось прочитать объект %s в S3: %w", key, err)
+	}
+	return obj, ObjectInfo{Key: key, Size: stat.Size, ModTime: stat.LastModified}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return fmt.Errorf("недопустимый ключ объекта: %q", key)
+	}
+	if err := s.client.RemoveObject(ctx, s.bucket, s.prefix+key, minio.RemoveObjectOptions{}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("не удалось удалить объект %s из S3: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Останавливает фоновый листинг, если fn вернула ошибку
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix + prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return fmt.Errorf("ошибка получения списка объектов S3: %w", obj.Err)
+		}
+		if err := fn(ObjectInfo{Key: strings.TrimPrefix(obj.Key, s.prefix), Size: obj.Size, ModTime: obj.LastModified}); err != nil {
+			return err
+		}
+	}
+	// Канал закрывается и при отмене контекста - это не полный список
+	return ctx.Err()
+}
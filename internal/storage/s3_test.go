@@ -0,0 +1,68 @@
+// internal/storage/s3_test.go
+package storage
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+
+	"shaman-ai.kz/internal/config"
+)
+
+// newFakeS3 starts an in-memory S3-compatible server and returns its config
+func newFakeS3(t *testing.T) config.S3StorageConfig {
+	t.Helper()
+	srv := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	t.Cleanup(srv.Close)
+	return config.S3StorageConfig{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    "uploads",
+		Prefix:    "/shaman/",
+		AccessKey: "test",
+		SecretKey: "test-secret",
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	cfg := newFakeS3(t)
+	s, err := NewS3(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewS3 failed: %v", err)
+	}
+	testBackend(t, s)
+
+	// Objects are stored under the configured prefix, keys are returned without it
+	other := *s
+	other.prefix = ""
+	for _, key := range listKeys(t, &other, "") {
+		if !strings.HasPrefix(key, "shaman/"+ContentPrefix) {
+			t.Fatalf("Object stored outside the prefix: %s", key)
+		}
+	}
+}
+
+func TestS3StorageReusesBucket(t *testing.T) {
+	cfg := newFakeS3(t)
+	data := []byte("kept across restarts")
+	first, err := NewS3(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewS3 failed: %v", err)
+	}
+	if err := first.Put(context.Background(), contentKey(data), bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	second, err := NewS3(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewS3 with an existing bucket failed: %v", err)
+	}
+	if stored, _ := readObject(t, second, contentKey(data)); !bytes.Equal(stored, data) {
+		t.Fatalf("Stored %q, want %q", stored, data)
+	}
+}
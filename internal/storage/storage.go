@@ -0,0 +1,130 @@
+// internal/storage/storage.go
+//
+// Package storage хранит загруженные пользователями файлы. Объекты адресуются SHA-256
+// содержимого (ключ sha256/ab/cd/<хеш>), поэтому один и тот же файл, загруженный много раз,
+// занимает место один раз. Есть две реализации: локальный каталог и S3-совместимое хранилище.
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+)
+
+// ContentPrefix - общий префикс ключей объектов; все, что вне его, хранилище не трогает
+const ContentPrefix = "sha256/"
+
+var ErrNotFound = errors.New("объект не найден в хранилище")
+
+// ObjectInfo - сведения об объекте хранилища
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage - бэкенд хранения объектов
+type Storage interface {
+	// Put записывает объект целиком; существующий объект с тем же ключом перезаписывается
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Open открывает объект для чтения; если его нет - ErrNotFound
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	// List вызывает fn для каждого объекта с ключом, начинающимся с prefix
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+}
+
+// New создает хранилище по конфигурации. Локальное хранилище использует каталог uploadPath.
+func New(ctx context.Context, cfg config.StorageConfig, uploadPath string) (Storage, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocal(uploadPath)
+	case "s3":
+		return NewS3(ctx, cfg.S3)
+	default:
+		return nil, fmt.Errorf("неизвестный тип хранилища: %s", cfg.Backend)
+	}
+}
+
+// ContentKey возвращает ключ объекта по SHA-256 содержимого (hex). Два уровня подкаталогов
+// не дают одному каталогу разрастись до сотен тысяч файлов.
+func ContentKey(sum string) string {
+	return ContentPrefix + sum[:2] + "/" + sum[2:4] + "/" + sum
+}
+
+func validKey(key string) bool {
+	return strings.HasPrefix(key, ContentPrefix) && !strings.Contains(key, "..") && !strings.Contains(key, "\\")
+}
+
+// Upload - загруженный файл во временном каталоге; хеш считается во время записи
+type Upload struct {
+	Path   string
+	SHA256 string
+	Size   int64
+}
+
+// Key - ключ, под которым файл будет лежать в хранилище
+func (u *Upload) Key() string {
+	return ContentKey(u.SHA256)
+}
+
+// Remove удаляет временный файл
+func (u *Upload) Remove() {
+	_ = os.Remove(u.Path)
+}
+
+// Spool сохраняет поток во временный файл и считает его SHA-256. Временный файл нужен,
+// чтобы определить тип и извлечь текст до того, как файл попадет в хранилище.
+func Spool(r io.Reader) (*Upload, error) {
+	f, err := os.CreateTemp("", "shaman-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("не удалось создать временный файл: %w", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return nil, fmt.Errorf("не удалось сохранить загруженный файл: %w", err)
+	}
+	return &Upload{Path: f.Name(), SHA256: hex.EncodeToString(hash.Sum(nil)), Size: written}, nil
+}
+
+// Rehash пересчитывает хеш и размер после того, как временный файл был изменен
+// (например, изображение перекодировано без метаданных)
+func (u *Upload) Rehash() error {
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть загруженный файл: %w", err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return fmt.Errorf("не удалось прочитать загруженный файл: %w", err)
+	}
+	u.SHA256, u.Size = hex.EncodeToString(hash.Sum(nil)), size
+	return nil
+}
+
+// PutUpload кладет временный файл в хранилище под ключом по его содержимому
+func PutUpload(ctx context.Context, s Storage, u *Upload) error {
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть загруженный файл: %w", err)
+	}
+	defer f.Close()
+	return s.Put(ctx, u.Key(), f, u.Size)
+}
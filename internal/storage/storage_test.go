@@ -0,0 +1,138 @@
+// internal/storage/storage_test.go
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func contentKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return ContentKey(hex.EncodeToString(sum[:]))
+}
+
+func readObject(t *testing.T, s Storage, key string) ([]byte, ObjectInfo) {
+	t.Helper()
+	rc, info, err := s.Open(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Open(%s) failed: %v", key, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("Read(%s) failed: %v", key, err)
+	}
+	return data, info
+}
+
+func listKeys(t *testing.T, s Storage, prefix string) []string {
+	t.Helper()
+	var keys []string
+	err := s.List(context.Background(), prefix, func(obj ObjectInfo) error {
+		keys = append(keys, obj.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List(%s) failed: %v", prefix, err)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// testBackend checks the Storage contract shared by all backends
+func testBackend(t *testing.T, s Storage) {
+	ctx := context.Background()
+	first, second := []byte("первый файл"), []byte("second file")
+	firstKey, secondKey := contentKey(first), contentKey(second)
+
+	for _, data := range [][]byte{first, second} {
+		if err := s.Put(ctx, contentKey(data), bytes.NewReader(data), int64(len(data))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	data, info := readObject(t, s, firstKey)
+	if !bytes.Equal(data, first) || info.Key != firstKey || info.Size != int64(len(first)) {
+		t.Fatalf("Unexpected object %q %+v", data, info)
+	}
+	if time.Since(info.ModTime) > time.Minute {
+		t.Fatalf("Unexpected ModTime %v", info.ModTime)
+	}
+
+	// Overwriting with the same key keeps a single object
+	if err := s.Put(ctx, firstKey, bytes.NewReader(first), int64(len(first))); err != nil {
+		t.Fatalf("Second Put failed: %v", err)
+	}
+	want := []string{firstKey, secondKey}
+	sort.Strings(want)
+	if got := listKeys(t, s, ContentPrefix); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("Expected keys %v, got %v", want, got)
+	}
+
+	// List stops on the callback error
+	stop := errors.New("stop")
+	calls := 0
+	err := s.List(ctx, ContentPrefix, func(ObjectInfo) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("Expected List to stop after the first error, got %v after %d calls", err, calls)
+	}
+
+	if err := s.Delete(ctx, firstKey); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, _, err := s.Open(ctx, firstKey); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound after Delete, got %v", err)
+	}
+	if err := s.Delete(ctx, firstKey); err != nil {
+		t.Fatalf("Deleting a missing object must succeed, got %v", err)
+	}
+	if got := listKeys(t, s, ContentPrefix); len(got) != 1 || got[0] != secondKey {
+		t.Fatalf("Expected only %s, got %v", secondKey, got)
+	}
+
+	for _, key := range []string{"other/file", ContentPrefix + "../escape", ContentPrefix + `ab\cd`} {
+		if err := s.Put(ctx, key, strings.NewReader("x"), 1); err == nil {
+			t.Fatalf("Put must reject key %q", key)
+		}
+		if _, _, err := s.Open(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+			t.Fatalf("Open must reject key %q, got %v", key, err)
+		}
+		if err := s.Delete(ctx, key); err == nil {
+			t.Fatalf("Delete must reject key %q", key)
+		}
+	}
+}
+
+func TestSpoolAndPutUpload(t *testing.T) {
+	s, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal failed: %v", err)
+	}
+	data := []byte("содержимое загрузки")
+	upload, err := Spool(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Spool failed: %v", err)
+	}
+	defer upload.Remove()
+
+	if upload.Size != int64(len(data)) || upload.Key() != contentKey(data) {
+		t.Fatalf("Unexpected upload %+v", upload)
+	}
+	if err := PutUpload(context.Background(), s, upload); err != nil {
+		t.Fatalf("PutUpload failed: %v", err)
+	}
+	if stored, _ := readObject(t, s, upload.Key()); !bytes.Equal(stored, data) {
+		t.Fatalf("Stored %q, want %q", stored, data)
+	}
+}
@@ -0,0 +1,22 @@
+// internal/timeutil/timeutil.go
+//
+// Package timeutil хранит часовой пояс сервиса: даты в выгрузках, письмах и фильтрах
+// админки показываются и разбираются по Алматы.
+package timeutil
+
+import "time"
+
+// TimezoneName - имя часового пояса, как оно показывается в выгрузках
+const TimezoneName = "Asia/Almaty"
+
+// Location - часовой пояс сервиса. Если в системе нет базы часовых поясов, используется
+// смещение UTC+5 (Алматы с марта 2024 года).
+var Location = loadLocation()
+
+func loadLocation() *time.Location {
+	loc, err := time.LoadLocation(TimezoneName)
+	if err != nil {
+		return time.FixedZone(TimezoneName, 5*60*60)
+	}
+	return loc
+}
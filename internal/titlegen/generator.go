@@ -0,0 +1,192 @@
+// internal/titlegen/generator.go
+//
+// Package titlegen придумывает заголовки диалогов. После первого обмена в диалоге LLM получает
+// вопрос и ответ и возвращает короткий заголовок на языке пользователя. Генерация идет в фоне
+// и не задерживает ответ; неудачные попытки повторяются периодически, пока не кончится лимит.
+package titlegen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/db"
+	"shaman-ai.kz/internal/llm"
+)
+
+const titleSystemPrompt = `Придумай короткий заголовок для диалога пользователя с ассистентом по его началу.
+Заголовок - 2-6 слов о сути вопроса, на том же языке, на котором написал пользователь.
+Верни только заголовок: без кавычек, без точки в конце, без пояснений.`
+
+// Сколько символов вопроса и ответа передается модели: для заголовка хватает начала
+const maxExcerptRunes = 1500
+
+// Наибольшая длина заголовка; все, что длиннее, обрезается по границе слова
+const maxTitleRunes = 80
+
+// Сколько диалогов берется на повтор за один проход
+const retryBatchSize = 20
+
+type Generator struct {
+	cfg       config.TitleConfig
+	llmClient llm.Client
+	llmCfg    config.RemoteLLMConfig // Базовая конфигурация для запросов заголовков
+
+	mu      sync.Mutex
+	running map[string]bool // Диалоги, для которых заголовок уже генерируется
+}
+
+func NewGenerator(cfg config.TitleConfig, llmClient llm.Client, llmCfg config.RemoteLLMConfig) *Generator {
+	return &Generator{cfg: cfg, llmClient: llmClient, llmCfg: llmCfg, running: make(map[string]bool)}
+}
+
+// Enqueue запускает генерацию заголовка в фоне. Вызывается после сохранения обмена в диалоге,
+// у которого заголовок еще по дате создания; повторный вызов, пока генерация идет, ничего не делает.
+func (g *Generator) Enqueue(sessionUUID string) {
+	if g.cfg.Disabled {
+		return
+	}
+	g.mu.Lock()
+	if g.running[sessionUUID] {
+		g.mu.Unlock()
+		return
+	}
+	g.running[sessionUUID] = true
+	g.mu.Unlock()
+
+	go func() {
+		defer func() {
+			g.mu.Lock()
+			delete(g.running, sessionUUID)
+			g.mu.Unlock()
+		}()
+		if err := g.generate(sessionUUID); err != nil {
+			slog.Warn("Не удалось сгенерировать заголовок диалога", "chat_uuid", sessionUUID, "error", err)
+		}
+	}()
+}
+
+// StartRetries периодически повторяет генерацию для диалогов, где прошлая попытка не удалась
+func (g *Generator) StartRetries() {
+	if g.cfg.Disabled {
+		return
+	}
+	interval := time.Duration(g.cfg.RetryIntervalMinutes) * time.Minute
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			uuids, err := db.GetChatSessionsForTitleRetry(g.cfg.MaxAttempts, time.Now().Add(-interval), retryBatchSize)
+			if err != nil {
+				slog.Error("Не удалось получить диалоги для повторной генерации заголовка", "error", err)
+				continue
+			}
+			for _, sessionUUID := range uuids {
+				g.Enqueue(sessionUUID)
+			}
+		}
+	}()
+}
+
+func (g *Generator) generate(sessionUUID string) error {
+	session, err := db.GetChatSessionMeta(sessionUUID)
+	if err != nil {
+		return err
+	}
+	if session.TitleSource != db.TitleSourceDefault || session.DeletedAt != nil || session.TitleAttempts >= g.cfg.MaxAttempts {
+		return nil
+	}
+	turns, err := db.GetDialogueTurnsBetween(sessionUUID, 0, math.MaxInt64, 1)
+	if err != nil {
+		return err
+	}
+	if len(turns) == 0 {
+		return nil
+	}
+	if err := db.MarkChatSessionTitleAttempt(sessionUUID); err != nil {
+		return err
+	}
+
+	prompt := "Пользователь: " + excerpt(turns[0].UserPrompt)
+	if turns[0].AIResponse != "" {
+		prompt += "\n\nАссистент: " + excerpt(turns[0].AIResponse)
+	}
+
+	llmCfg := g.llmCfg
+	if g.cfg.Model != "" {
+		llmCfg.ModelSpec = g.cfg.Model
+	}
+	llmCfg.MaxTokens = g.cfg.MaxTokens
+	llmCfg.Temperature = 0.3
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(g.cfg.TimeoutSeconds)*time.Second)
+	defer cancel()
+	text, usage, err := g.llmClient.GenerateRemoteResponse(ctx, llmCfg, titleSystemPrompt, nil, prompt)
+	if err != nil {
+		return fmt.Errorf("ошибка запроса заголовка к LLM: %w", err)
+	}
+	title := cleanTitle(text)
+	if title == "" {
+		return errors.New("LLM вернула пустой заголовок")
+	}
+
+	saved, err := db.SetGeneratedChatSessionTitle(sessionUUID, title)
+	if err != nil {
+		return err
+	}
+	if !saved {
+		slog.Info("Сгенерированный заголовок отброшен: пользователь уже задал свой", "chat_uuid", sessionUUID)
+		return nil
+	}
+	attrs := []any{"chat_uuid", sessionUUID, "title_length", utf8.RuneCountInString(title)}
+	if usage != nil {
+		attrs = append(attrs, "model", usage.Model, "input_tokens", usage.PromptTokens, "output_tokens", usage.CompletionTokens)
+	}
+	slog.Info("Заголовок диалога сгенерирован", attrs...)
+	return nil
+}
+
+func excerpt(text string) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= maxExcerptRunes {
+		return string(runes)
+	}
+	return string(runes[:maxExcerptRunes]) + "…"
+}
+
+// cleanTitle приводит ответ модели к заголовку: первая непустая строка без подписи "Заголовок:",
+// кавычек, разметки и точки в конце
+func cleanTitle(text string) string {
+	var line string
+	for _, l := range strings.Split(text, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			line = l
+			break
+		}
+	}
+	for _, prefix := range []string{"Заголовок:", "Название:", "Title:"} {
+		if len(line) >= len(prefix) && strings.EqualFold(line[:len(prefix)], prefix) {
+			line = line[len(prefix):]
+		}
+	}
+	line = strings.Trim(line, " \t\"'`«»“”„*#.!;:,")
+	line = strings.Join(strings.FieldsFunc(line, unicode.IsSpace), " ")
+
+	runes := []rune(line)
+	if len(runes) <= maxTitleRunes {
+		return line
+	}
+	cut := string(runes[:maxTitleRunes])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, ".,;:- ") + "…"
+}
@@ -0,0 +1,271 @@
+// internal/uploadcheck/check.go
+//
+// Package uploadcheck проверяет загруженный файл до того, как он попадет в хранилище:
+// тип определяется по содержимому и должен соответствовать расширению из списка разрешенных,
+// для архивов действуют ограничения на распаковку, файл проверяется антивирусом,
+// а изображения перекодируются без метаданных.
+package uploadcheck
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"shaman-ai.kz/internal/config"
+	"shaman-ai.kz/internal/docextract"
+	"shaman-ai.kz/internal/imageproc"
+)
+
+// Причины отказа (поле reason в журнале и в ответе API)
+const (
+	ReasonExtensionNotAllowed = "extension_not_allowed"
+	ReasonTypeMismatch        = "type_mismatch"
+	ReasonCorrupted           = "corrupted_file"
+	ReasonArchiveLimits       = "archive_limits"
+	ReasonMalware             = "malware_detected"
+	ReasonScanFailed          = "scan_failed"
+	ReasonQuotaExceeded       = "quota_exceeded" // Проверяется при сохранении, а не здесь
+)
+
+// RejectError - файл отклонен проверкой. Message можно показать пользователю, Detail - только в журнал.
+type RejectError struct {
+	Reason  string
+	Message string
+	Detail  string
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("файл отклонен (%s): %s", e.Reason, e.Detail)
+}
+
+func reject(reason, message, detail string) *RejectError {
+	return &RejectError{Reason: reason, Message: message, Detail: detail}
+}
+
+// Типы, которые можно разрешить: расширение и MIME-типы содержимого, допустимые для него.
+// Форматов, которые браузер может исполнить (HTML, SVG), здесь нет намеренно.
+var knownTypes = map[string][]string{
+	".jpg":      {"image/jpeg"},
+	".jpeg":     {"image/jpeg"},
+	".png":      {"image/png"},
+	".gif":      {"image/gif"},
+	".webp":     {"image/webp"},
+	".pdf":      {docextract.MIMEPDF},
+	".docx":     {docextract.MIMEDOCX},
+	".xlsx":     {docextract.MIMEXLSX},
+	".odt":      {docextract.MIMEODT},
+	".ods":      {docextract.MIMEODS},
+	".txt":      {docextract.MIMEText, docextract.MIMECSV},
+	".md":       {docextract.MIMEMarkdown},
+	".markdown": {docextract.MIMEMarkdown},
+	".csv":      {docextract.MIMECSV},
+}
+
+// Расширение, которое получает файл, если при перекодировании сменился его тип
+var canonicalExt = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+var defaultExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".pdf", ".docx", ".xlsx", ".odt", ".ods", ".txt", ".md", ".csv"}
+
+// Result - итог успешной проверки
+type Result struct {
+	MIMEType  string
+	Filename  string // Имя для отображения: без пути и управляющих символов, расширение соответствует типу
+	Sanitized bool   // Файл был перезаписан (изображение перекодировано), хеш нужно пересчитать
+}
+
+type Checker struct {
+	cfg     config.UploadSafetyConfig
+	allowed map[string]bool
+	scanner Scanner
+}
+
+// New создает проверку по конфигурации. Если задан clamd.address, файлы проверяются антивирусом.
+func New(cfg config.UploadSafetyConfig) *Checker {
+	c := &Checker{cfg: cfg, allowed: make(map[string]bool)}
+	extensions := cfg.AllowedExtensions
+	if len(extensions) == 0 {
+		extensions = defaultExtensions
+	}
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if _, known := knownTypes[ext]; !known {
+			slog.Warn("Расширение из upload_safety.allowed_extensions не поддерживается и будет проигнорировано", "extension", ext)
+			continue
+		}
+		c.allowed[ext] = true
+	}
+	if cfg.Clamd.Address != "" {
+		c.scanner = NewClamd(cfg.Clamd.Address, time.Duration(cfg.Clamd.TimeoutSeconds)*time.Second)
+	}
+	return c
+}
+
+// WithScanner заменяет антивирусный сканер (например, заглушкой)
+func (c *Checker) WithScanner(scanner Scanner) *Checker {
+	c.scanner = scanner
+	return c
+}
+
+// AllowedExtensions возвращает разрешенные расширения в алфавитном порядке
+func (c *Checker) AllowedExtensions() []string {
+	extensions := make([]string, 0, len(c.allowed))
+	for ext := range c.allowed {
+		extensions = append(extensions, ext)
+	}
+	slices.Sort(extensions)
+	return extensions
+}
+
+// Check проверяет файл path, загруженный под именем filename. Отказ возвращается как *RejectError,
+// остальные ошибки - внутренние (файл не удалось прочитать и т.п.).
+// Изображения перекодируются на месте, поэтому проверять нужно временную копию.
+func (c *Checker) Check(ctx context.Context, path, filename string) (*Result, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if !c.allowed[ext] {
+		return nil, reject(ReasonExtensionNotAllowed,
+			fmt.Sprintf("Файлы этого типа загружать нельзя. Разрешены: %s", strings.Join(c.AllowedExtensions(), ", ")),
+			fmt.Sprintf("расширение %q", ext))
+	}
+
+	mimeType, err := docextract.DetectFile(path, filename)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(knownTypes[ext], mimeType) {
+		return nil, reject(ReasonTypeMismatch, "Содержимое файла не соответствует его расширению",
+			fmt.Sprintf("расширение %s, содержимое %s", ext, mimeType))
+	}
+
+	isImage := strings.HasPrefix(mimeType, "image/")
+	switch {
+	case isImage:
+		if rejectErr := checkImage(path); rejectErr != nil {
+			return nil, rejectErr
+		}
+	case mimeType == docextract.MIMEDOCX || mimeType == docextract.MIMEXLSX || mimeType == docextract.MIMEODT || mimeType == docextract.MIMEODS:
+		if rejectErr := c.checkArchive(path); rejectErr != nil {
+			return nil, rejectErr
+		}
+	}
+
+	// Антивирус проверяет исходный файл, до перекодирования
+	if c.scanner != nil {
+		if rejectErr := c.scan(ctx, path); rejectErr != nil {
+			return nil, rejectErr
+		}
+	}
+
+	res := &Result{MIMEType: mimeType, Filename: safeFilename(filename)}
+	if isImage && !c.cfg.KeepImageMetadata {
+		sanitizedType, err := imageproc.Sanitize(path)
+		if err != nil {
+			return nil, reject(ReasonCorrupted, "Не удалось обработать изображение", err.Error())
+		}
+		res.Sanitized = true
+		if sanitizedType != mimeType {
+			res.MIMEType = sanitizedType
+			res.Filename = strings.TrimSuffix(res.Filename, filepath.Ext(res.Filename)) + canonicalExt[sanitizedType]
+		}
+	}
+	return res, nil
+}
+
+func checkImage(path string) *RejectError {
+	f, err := os.Open(path)
+	if err != nil {
+		return reject(ReasonCorrupted, "Не удалось прочитать изображение", err.Error())
+	}
+	defer f.Close()
+	if _, _, err := imageproc.CheckDimensions(f); err != nil {
+		return reject(ReasonCorrupted, "Изображение повреждено или слишком велико", err.Error())
+	}
+	return nil
+}
+
+// Маленькие файлы архива (XML-разметка) сжимаются очень сильно и без всякого умысла,
+// поэтому коэффициент сжатия проверяем только у крупных
+const ratioCheckMinSize = 1 << 20
+
+// checkArchive отсекает zip-бомбы по заголовкам архива. Заголовкам можно солгать, поэтому
+// при извлечении текста чтение каждого файла архива дополнительно ограничено (docextract).
+func (c *Checker) checkArchive(path string) *RejectError {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return reject(ReasonCorrupted, "Документ поврежден", err.Error())
+	}
+	defer zr.Close()
+
+	if len(zr.File) > c.cfg.MaxArchiveEntries {
+		return reject(ReasonArchiveLimits, "Документ слишком сложный для обработки",
+			fmt.Sprintf("%d файлов в архиве при лимите %d", len(zr.File), c.cfg.MaxArchiveEntries))
+	}
+	maxTotal := uint64(c.cfg.MaxUncompressedMB) << 20
+	var total uint64
+	for _, f := range zr.File {
+		total += f.UncompressedSize64
+		if total > maxTotal {
+			return reject(ReasonArchiveLimits, "Документ слишком большой после распаковки",
+				fmt.Sprintf("больше %d МБ после распаковки", c.cfg.MaxUncompressedMB))
+		}
+		if f.UncompressedSize64 >= ratioCheckMinSize && f.UncompressedSize64 > f.CompressedSize64*uint64(c.cfg.MaxCompressionRatio) {
+			return reject(ReasonArchiveLimits, "Документ слишком большой после распаковки",
+				fmt.Sprintf("%s: сжатие %d -> %d байт", f.Name, f.CompressedSize64, f.UncompressedSize64))
+		}
+	}
+	return nil
+}
+
+func (c *Checker) scan(ctx context.Context, path string) *RejectError {
+	f, err := os.Open(path)
+	if err != nil {
+		return reject(ReasonScanFailed, "Не удалось проверить файл", err.Error())
+	}
+	defer f.Close()
+
+	verdict, err := c.scanner.Scan(ctx, f)
+	if err != nil {
+		if c.cfg.Clamd.FailOpen {
+			slog.Warn("Антивирусная проверка недоступна, файл принят без проверки", "error", err)
+			return nil
+		}
+		return reject(ReasonScanFailed, "Не удалось проверить файл на вирусы. Попробуйте позже.", err.Error())
+	}
+	if verdict.Infected {
+		return reject(ReasonMalware, "Файл отклонен антивирусной проверкой", verdict.Signature)
+	}
+	return nil
+}
+
+// safeFilename оставляет от имени, присланного браузером, только базовое имя без управляющих
+// символов и ограничивает его длину (имя попадает в Content-Disposition и в интерфейс)
+func safeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > 200 {
+		ext := filepath.Ext(name)
+		name = string(runes[:200-len([]rune(ext))]) + ext
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	return name
+}
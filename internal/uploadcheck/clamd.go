@@ -0,0 +1,156 @@
+// internal/uploadcheck/clamd.go
+package uploadcheck
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Verdict - результат антивирусной проверки
+type Verdict struct {
+	Infected  bool
+	Signature string // Имя сигнатуры, если файл заражен
+}
+
+// Scanner проверяет содержимое файла на вредоносный код
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Verdict, error)
+}
+
+// Размер порции данных в команде INSTREAM; clamd по умолчанию принимает до StreamMaxLength (25 МБ) целиком
+const clamdChunkSize = 64 << 10
+
+// Clamd проверяет файлы через демон ClamAV по его сетевому протоколу (команда INSTREAM)
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd создает сканер. address - "host:port" для TCP или путь к unix-сокету (начинается с "/").
+func NewClamd(address string, timeout time.Duration) *Clamd {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	c := &Clamd{network: "tcp", address: address, timeout: timeout}
+	if strings.HasPrefix(address, "/") {
+		c.network = "unix"
+	}
+	return c
+}
+
+// dial открывает соединение; весь обмен с clamd должен уложиться в timeout
+func (c *Clamd) dial(ctx context.Context) (net.Conn, error) {
+	deadline := time.Now().Add(c.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	var d net.Dialer
+	dialCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	conn, err := d.DialContext(dialCtx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("clamd недоступен (%s): %w", c.address, err)
+	}
+	_ = conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+// Ping проверяет, что clamd отвечает
+func (c *Clamd) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("ошибка отправки команды clamd: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("неожиданный ответ clamd: %q", reply)
+	}
+	return nil
+}
+
+// Scan передает содержимое в clamd порциями: 4 байта длины (big-endian) и данные,
+// нулевая длина завершает поток. Ответ: "stream: OK" или "stream: <сигнатура> FOUND".
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Verdict, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Verdict{}, fmt.Errorf("ошибка отправки команды clamd: %w", err)
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, readErr := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// clamd закрывает соединение, если поток превысил StreamMaxLength, и пишет причину
+				if reply, errReply := readReply(conn); errReply == nil && reply != "" {
+					return Verdict{}, fmt.Errorf("clamd прервал проверку: %s", reply)
+				}
+				return Verdict{}, fmt.Errorf("ошибка передачи данных в clamd: %w", err)
+			}
+		}
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if readErr != nil {
+			return Verdict{}, fmt.Errorf("ошибка чтения файла для проверки: %w", readErr)
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Verdict{}, fmt.Errorf("ошибка передачи данных в clamd: %w", err)
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return Verdict{}, err
+	}
+	return parseScanReply(reply)
+}
+
+// readReply читает ответ clamd до завершающего нулевого байта (команды с префиксом z)
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(io.LimitReader(conn, 4096)).ReadString(0)
+	if err != nil && (!errors.Is(err, io.EOF) || reply == "") {
+		return "", fmt.Errorf("ошибка чтения ответа clamd: %w", err)
+	}
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
+
+func parseScanReply(reply string) (Verdict, error) {
+	// "stream: OK", "stream: <сигнатура> FOUND", "stream: <причина> ERROR" или "<причина> ERROR"
+	// (например, "INSTREAM size limit exceeded. ERROR", если поток больше StreamMaxLength)
+	_, result, found := strings.Cut(reply, ": ")
+	if !found {
+		if strings.HasSuffix(reply, " ERROR") {
+			return Verdict{}, fmt.Errorf("clamd не смог проверить файл: %s", reply)
+		}
+		return Verdict{}, fmt.Errorf("неожиданный ответ clamd: %q", reply)
+	}
+	switch {
+	case result == "OK":
+		return Verdict{}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return Verdict{Infected: true, Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	default:
+		return Verdict{}, fmt.Errorf("clamd не смог проверить файл: %s", result)
+	}
+}
@@ -0,0 +1,229 @@
+// internal/uploadcheck/clamd_test.go
+package uploadcheck
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shaman-ai.kz/internal/config"
+)
+
+// eicar is the standard antivirus test string
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd speaks the clamd protocol (zPING, zINSTREAM) on a local TCP port
+type fakeClamd struct {
+	listener      net.Listener
+	maxStream     int    // Like StreamMaxLength: a longer stream is rejected and the connection closed
+	errorOnMarker string // Content that makes the scan fail with an ERROR reply
+
+	mu       sync.Mutex
+	received [][]byte
+}
+
+func newFakeClamd(t *testing.T) *fakeClamd {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	f := &fakeClamd{listener: listener, maxStream: 25 << 20}
+	t.Cleanup(func() { listener.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeClamd) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeClamd) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	reply := func(text string) { _, _ = conn.Write([]byte(text + "\x00")) }
+
+	switch command {
+	case "zPING\x00":
+		reply("PONG")
+	case "zINSTREAM\x00":
+		var data []byte
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if len(data)+int(size) > f.maxStream {
+				reply("INSTREAM size limit exceeded. ERROR")
+				return // clamd closes the connection without reading the rest
+			}
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			data = append(data, chunk...)
+		}
+		f.mu.Lock()
+		f.received = append(f.received, data)
+		f.mu.Unlock()
+
+		switch {
+		case bytes.Contains(data, []byte(eicar)):
+			reply("stream: Eicar-Signature FOUND")
+		case f.errorOnMarker != "" && bytes.Contains(data, []byte(f.errorOnMarker)):
+			reply("stream: Can't allocate memory ERROR")
+		default:
+			reply("stream: OK")
+		}
+	default:
+		reply("UNKNOWN COMMAND")
+	}
+}
+
+func (f *fakeClamd) lastReceived() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.received) == 0 {
+		return nil
+	}
+	return f.received[len(f.received)-1]
+}
+
+func TestClamdPing(t *testing.T) {
+	fake := newFakeClamd(t)
+	if err := NewClamd(fake.addr(), time.Second).Ping(context.Background()); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+}
+
+func TestClamdScanClean(t *testing.T) {
+	fake := newFakeClamd(t)
+	// Several INSTREAM chunks, the last one partial
+	data := bytes.Repeat([]byte("clean data "), 3*clamdChunkSize/10)
+
+	verdict, err := NewClamd(fake.addr(), time.Second).Scan(context.Background(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if verdict.Infected {
+		t.Fatalf("Expected clean verdict, got %+v", verdict)
+	}
+	if !bytes.Equal(fake.lastReceived(), data) {
+		t.Fatalf("clamd received %d bytes, want %d", len(fake.lastReceived()), len(data))
+	}
+}
+
+func TestClamdScanFound(t *testing.T) {
+	fake := newFakeClamd(t)
+	verdict, err := NewClamd(fake.addr(), time.Second).Scan(context.Background(), strings.NewReader(eicar))
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if !verdict.Infected || verdict.Signature != "Eicar-Signature" {
+		t.Fatalf("Expected Eicar-Signature, got %+v", verdict)
+	}
+}
+
+func TestClamdScanErrorReply(t *testing.T) {
+	fake := newFakeClamd(t)
+	fake.errorOnMarker = "boom"
+	_, err := NewClamd(fake.addr(), time.Second).Scan(context.Background(), strings.NewReader("boom"))
+	if err == nil || !strings.Contains(err.Error(), "Can't allocate memory") {
+		t.Fatalf("Expected clamd error, got %v", err)
+	}
+}
+
+func TestClamdScanSizeLimit(t *testing.T) {
+	fake := newFakeClamd(t)
+	fake.maxStream = 1024
+
+	for _, size := range []int{2048, 4 << 20} { // The reply comes at the end of the stream or while it is still sent
+		_, err := NewClamd(fake.addr(), time.Second).Scan(context.Background(), bytes.NewReader(make([]byte, size)))
+		if err == nil {
+			t.Fatalf("Expected an error for a %d byte stream over the clamd limit", size)
+		}
+		if !strings.Contains(err.Error(), "size limit exceeded") {
+			t.Fatalf("Unexpected error for %d bytes: %v", size, err)
+		}
+	}
+	if err := NewClamd(fake.addr(), time.Second).Ping(context.Background()); err != nil {
+		t.Fatalf("clamd must keep serving after a rejected stream: %v", err)
+	}
+}
+
+func TestClamdUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	if _, err := NewClamd(addr, time.Second).Scan(context.Background(), strings.NewReader("x")); err == nil {
+		t.Fatal("Expected an error when clamd is down")
+	}
+}
+
+func TestCheckerScansWithClamd(t *testing.T) {
+	fake := newFakeClamd(t)
+	fake.errorOnMarker = "boom"
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	checker := New(config.UploadSafetyConfig{Clamd: config.ClamdConfig{Address: fake.addr(), TimeoutSeconds: 1}})
+	if _, err := checker.Check(context.Background(), write("clean.txt", "обычный текст"), "clean.txt"); err != nil {
+		t.Fatalf("Clean file rejected: %v", err)
+	}
+
+	var rejectErr *RejectError
+	_, err := checker.Check(context.Background(), write("virus.txt", eicar), "virus.txt")
+	if !errors.As(err, &rejectErr) || rejectErr.Reason != ReasonMalware || rejectErr.Detail != "Eicar-Signature" {
+		t.Fatalf("Expected malware rejection, got %v", err)
+	}
+	_, err = checker.Check(context.Background(), write("broken.txt", "boom"), "broken.txt")
+	if !errors.As(err, &rejectErr) || rejectErr.Reason != ReasonScanFailed {
+		t.Fatalf("Expected scan_failed rejection, got %v", err)
+	}
+
+	// fail_open accepts files when clamd cannot scan them, but never infected ones
+	failOpen := New(config.UploadSafetyConfig{Clamd: config.ClamdConfig{Address: fake.addr(), TimeoutSeconds: 1, FailOpen: true}})
+	if _, err := failOpen.Check(context.Background(), write("broken2.txt", "boom"), "broken2.txt"); err != nil {
+		t.Fatalf("fail_open must accept the file on scan errors, got %v", err)
+	}
+	if _, err := failOpen.Check(context.Background(), write("virus2.txt", eicar), "virus2.txt"); !errors.As(err, &rejectErr) || rejectErr.Reason != ReasonMalware {
+		t.Fatalf("fail_open must still reject malware, got %v", err)
+	}
+}
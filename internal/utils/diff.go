@@ -0,0 +1,62 @@
+// internal/utils/diff.go
+package utils
+
+import "strings"
+
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// DiffLine - строка построчного сравнения двух текстов
+type DiffLine struct {
+	Op   string // DiffEqual, DiffInsert или DiffDelete
+	Text string
+}
+
+// LineDiff строит построчный diff от a к b по наибольшей общей подпоследовательности.
+// Рассчитан на тексты размером с системный промпт (сотни строк).
+func LineDiff(a, b string) []DiffLine {
+	left := strings.Split(strings.ReplaceAll(a, "\r\n", "\n"), "\n")
+	right := strings.Split(strings.ReplaceAll(b, "\r\n", "\n"), "\n")
+
+	// lcs[i][j] - длина НОП для left[i:] и right[j:]
+	lcs := make([][]int, len(left)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(right)+1)
+	}
+	for i := len(left) - 1; i >= 0; i-- {
+		for j := len(right) - 1; j >= 0; j-- {
+			if left[i] == right[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	diff := make([]DiffLine, 0, max(len(left), len(right)))
+	i, j := 0, 0
+	for i < len(left) && j < len(right) {
+		switch {
+		case left[i] == right[j]:
+			diff = append(diff, DiffLine{Op: DiffEqual, Text: left[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Op: DiffDelete, Text: left[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: DiffInsert, Text: right[j]})
+			j++
+		}
+	}
+	for ; i < len(left); i++ {
+		diff = append(diff, DiffLine{Op: DiffDelete, Text: left[i]})
+	}
+	for ; j < len(right); j++ {
+		diff = append(diff, DiffLine{Op: DiffInsert, Text: right[j]})
+	}
+	return diff
+}
@@ -0,0 +1,3 @@
+-- migrations/000016_add_mode_to_chat_sessions.down.sql
+ALTER TABLE chat_sessions
+DROP COLUMN mode;
@@ -0,0 +1,4 @@
+-- migrations/000016_add_mode_to_chat_sessions.up.sql
+-- Закрепленный режим диалога (general/shaman), чтобы беседа не переключалась между персонами
+ALTER TABLE chat_sessions
+ADD COLUMN mode VARCHAR(32) NULL DEFAULT NULL AFTER title;
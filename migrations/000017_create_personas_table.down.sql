@@ -0,0 +1,5 @@
+-- migrations/000017_create_personas_table.down.sql
+ALTER TABLE chat_sessions
+DROP COLUMN persona_slug;
+
+DROP TABLE IF EXISTS personas;
@@ -0,0 +1,26 @@
+-- migrations/000017_create_personas_table.up.sql
+CREATE TABLE IF NOT EXISTS personas (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    slug VARCHAR(64) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NULL,
+    system_prompt MEDIUMTEXT NULL, -- Пусто у встроенных персон: промпт берется из файла конфигурации
+    model VARCHAR(255) NULL, -- "<провайдер>:<модель>", пусто - модель по умолчанию
+    temperature DECIMAL(3,2) NULL,
+    max_tokens INT NULL,
+    routing_keywords TEXT NULL, -- Ключевые слова через запятую для автоматического выбора, "*" - любое окончание
+    auto_route BOOLEAN NOT NULL DEFAULT FALSE, -- Участвует в автоматическом выборе режима
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    is_builtin BOOLEAN NOT NULL DEFAULT FALSE,
+    sort_order INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+INSERT IGNORE INTO personas (slug, name, description, auto_route, is_active, is_builtin, sort_order) VALUES
+('general', 'Универсальный помощник', 'Общие вопросы: учеба, работа, быт, творчество.', FALSE, TRUE, TRUE, 0),
+('shaman', 'Шаман', 'Вопросы о физическом и эмоциональном самочувствии, симптомах и их возможных причинах.', TRUE, TRUE, TRUE, 10);
+
+-- Персона, выбранная пользователем при создании диалога. NULL - режим выбирается автоматически
+ALTER TABLE chat_sessions
+ADD COLUMN persona_slug VARCHAR(64) NULL DEFAULT NULL AFTER mode;
@@ -0,0 +1,2 @@
+-- migrations/000018_create_prompt_revisions_table.down.sql
+DROP TABLE IF EXISTS prompt_revisions;
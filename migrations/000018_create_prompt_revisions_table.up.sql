@@ -0,0 +1,13 @@
+-- migrations/000018_create_prompt_revisions_table.up.sql
+-- История изменений системных промптов: ключ настройки (например, shaman_system_prompt_content)
+-- или "persona:<slug>" для промптов персон
+CREATE TABLE IF NOT EXISTS prompt_revisions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    prompt_key VARCHAR(128) NOT NULL,
+    content MEDIUMTEXT NOT NULL,
+    comment VARCHAR(255) NULL,
+    created_by_user_id INT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (created_by_user_id) REFERENCES users(id) ON DELETE SET NULL,
+    INDEX idx_prompt_revisions_key_created_at (prompt_key, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
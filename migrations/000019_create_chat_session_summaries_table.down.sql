@@ -0,0 +1,2 @@
+-- migrations/000019_create_chat_session_summaries_table.down.sql
+DROP TABLE IF EXISTS chat_session_summaries;
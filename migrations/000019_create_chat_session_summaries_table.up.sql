@@ -0,0 +1,10 @@
+-- migrations/000019_create_chat_session_summaries_table.up.sql
+-- Скользящее резюме старой части диалога, не помещающейся в бюджет токенов запроса.
+-- covered_until_dialogue_id - последний обмен из dialogues, учтенный в резюме.
+CREATE TABLE IF NOT EXISTS chat_session_summaries (
+    chat_session_uuid VARCHAR(36) PRIMARY KEY,
+    summary TEXT NOT NULL,
+    covered_until_dialogue_id INT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (chat_session_uuid) REFERENCES chat_sessions(uuid) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
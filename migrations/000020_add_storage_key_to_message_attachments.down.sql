@@ -0,0 +1,5 @@
+-- migrations/000020_add_storage_key_to_message_attachments.down.sql
+ALTER TABLE message_attachments
+    DROP INDEX idx_message_attachments_storage_key,
+    DROP COLUMN storage_key,
+    DROP COLUMN content_sha256;
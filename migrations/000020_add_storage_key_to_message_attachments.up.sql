@@ -0,0 +1,7 @@
+-- migrations/000020_add_storage_key_to_message_attachments.up.sql
+-- Вложения хранятся в хранилище по SHA-256 содержимого (storage_key = sha256/ab/cd/<хеш>).
+-- У старых записей storage_key пустой, файл лежит по server_path.
+ALTER TABLE message_attachments
+    ADD COLUMN content_sha256 CHAR(64) NULL AFTER server_path,
+    ADD COLUMN storage_key VARCHAR(255) NULL AFTER content_sha256,
+    ADD INDEX idx_message_attachments_storage_key (storage_key);
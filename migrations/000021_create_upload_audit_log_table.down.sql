@@ -0,0 +1,2 @@
+-- migrations/000021_create_upload_audit_log_table.down.sql
+DROP TABLE IF EXISTS upload_audit_log;
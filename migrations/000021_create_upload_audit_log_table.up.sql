@@ -0,0 +1,16 @@
+-- migrations/000021_create_upload_audit_log_table.up.sql
+-- Журнал отклоненных загрузок: кто, что и почему пытался загрузить.
+CREATE TABLE IF NOT EXISTS upload_audit_log (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NULL,
+    original_name VARCHAR(255) NOT NULL,
+    detected_type VARCHAR(100) NULL,
+    size BIGINT NOT NULL DEFAULT 0,
+    content_sha256 CHAR(64) NULL,
+    reason VARCHAR(50) NOT NULL,
+    detail TEXT NULL,
+    ip_address VARCHAR(45) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_upload_audit_log_user (user_id, created_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
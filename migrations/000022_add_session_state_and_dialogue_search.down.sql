@@ -0,0 +1,8 @@
+-- migrations/000022_add_session_state_and_dialogue_search.down.sql
+ALTER TABLE dialogues
+    DROP INDEX idx_dialogues_fulltext;
+
+ALTER TABLE chat_sessions
+    DROP COLUMN deleted_at,
+    DROP COLUMN archived_at,
+    DROP COLUMN pinned_at;
@@ -0,0 +1,10 @@
+-- migrations/000022_add_session_state_and_dialogue_search.up.sql
+-- Состояние диалога: закреплен, в архиве, удален (мягкое удаление, диалог можно восстановить)
+ALTER TABLE chat_sessions
+    ADD COLUMN pinned_at TIMESTAMP NULL DEFAULT NULL AFTER persona_slug,
+    ADD COLUMN archived_at TIMESTAMP NULL DEFAULT NULL AFTER pinned_at,
+    ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL AFTER archived_at;
+
+-- Полнотекстовый поиск по своим диалогам
+ALTER TABLE dialogues
+    ADD FULLTEXT INDEX idx_dialogues_fulltext (user_prompt, ai_response);
@@ -0,0 +1,5 @@
+-- migrations/000023_add_title_generation_to_chat_sessions.down.sql
+ALTER TABLE chat_sessions
+    DROP COLUMN title_attempted_at,
+    DROP COLUMN title_attempts,
+    DROP COLUMN title_source;
@@ -0,0 +1,7 @@
+-- migrations/000023_add_title_generation_to_chat_sessions.up.sql
+-- Откуда взят заголовок диалога: default - по дате создания, generated - придуман LLM по первому обмену,
+-- user - задан пользователем (такой заголовок автоматически не меняется)
+ALTER TABLE chat_sessions
+    ADD COLUMN title_source VARCHAR(16) NOT NULL DEFAULT 'default' AFTER title,
+    ADD COLUMN title_attempts TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER title_source,
+    ADD COLUMN title_attempted_at TIMESTAMP NULL DEFAULT NULL AFTER title_attempts;
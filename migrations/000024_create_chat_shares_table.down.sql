@@ -0,0 +1,2 @@
+-- migrations/000024_create_chat_shares_table.down.sql
+DROP TABLE IF EXISTS chat_shares;
@@ -0,0 +1,20 @@
+-- migrations/000024_create_chat_shares_table.up.sql
+-- Публичные ссылки на диалоги только для чтения. Хранится только хеш токена, как у токенов
+-- сброса пароля. Ссылка показывает диалог по состоянию на момент создания (last_dialogue_id).
+CREATE TABLE IF NOT EXISTS chat_shares (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    token_hash CHAR(64) NOT NULL,
+    chat_session_uuid VARCHAR(36) NOT NULL,
+    user_id INT NOT NULL,
+    last_dialogue_id INT NOT NULL DEFAULT 0,
+    redact_attachments BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    view_count INT UNSIGNED NOT NULL DEFAULT 0,
+    last_viewed_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_chat_shares_token_hash (token_hash),
+    INDEX idx_chat_shares_user (user_id, created_at),
+    FOREIGN KEY (chat_session_uuid) REFERENCES chat_sessions(uuid) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
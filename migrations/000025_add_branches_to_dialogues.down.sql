@@ -0,0 +1,9 @@
+-- migrations/000025_add_branches_to_dialogues.down.sql
+-- Обмены неактивных веток удаляются, иначе они смешаются с основной веткой
+DELETE FROM dialogues WHERE active_branch = FALSE;
+
+ALTER TABLE dialogues
+    DROP INDEX idx_dialogues_parent_id,
+    DROP INDEX idx_dialogues_session_branch,
+    DROP COLUMN active_branch,
+    DROP COLUMN parent_id;
@@ -0,0 +1,15 @@
+-- migrations/000025_add_branches_to_dialogues.up.sql
+-- Ветвление диалога: у обмена есть предыдущий обмен (parent_id), повторный ответ и
+-- отредактированное сообщение создают соседний обмен с тем же родителем. Обмены текущей
+-- ветки (путь от первого обмена до последнего) отмечены active_branch.
+-- Внешний ключ на себя не добавляем: каскадное удаление в InnoDB ограничено глубиной 15,
+-- а цепочка обменов длиннее; обмены удаляются вместе с сессией по chat_session_uuid.
+ALTER TABLE dialogues
+    ADD COLUMN parent_id INT NULL DEFAULT NULL AFTER chat_session_uuid,
+    ADD COLUMN active_branch BOOLEAN NOT NULL DEFAULT TRUE AFTER parent_id,
+    ADD INDEX idx_dialogues_session_branch (chat_session_uuid, active_branch, id),
+    ADD INDEX idx_dialogues_parent_id (parent_id);
+
+-- До ветвления каждый диалог был цепочкой: родитель - предыдущий обмен сессии
+UPDATE dialogues d
+SET d.parent_id = (SELECT MAX(p.id) FROM dialogues p WHERE p.chat_session_uuid = d.chat_session_uuid AND p.id < d.id);
@@ -0,0 +1,6 @@
+-- migrations/000026_create_message_feedback_table.down.sql
+DROP TABLE IF EXISTS message_feedback;
+
+ALTER TABLE dialogues
+    DROP COLUMN model,
+    DROP COLUMN persona_slug;
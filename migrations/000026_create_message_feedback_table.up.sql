@@ -0,0 +1,25 @@
+-- migrations/000026_create_message_feedback_table.up.sql
+-- Какой режим и какая модель дали ответ: для разбора отзывов. У старых обменов не заполнены.
+ALTER TABLE dialogues
+    ADD COLUMN persona_slug VARCHAR(64) NULL DEFAULT NULL AFTER ai_response,
+    ADD COLUMN model VARCHAR(128) NULL DEFAULT NULL AFTER persona_slug;
+
+-- Оценка ответа пользователем: одна на обмен, повторная оценка заменяет прежнюю.
+-- rating: 1 - хороший ответ, -1 - плохой. reviewed_at - отзыв разобран администратором.
+CREATE TABLE IF NOT EXISTS message_feedback (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    dialogue_id INT NOT NULL,
+    user_id INT NOT NULL,
+    rating TINYINT NOT NULL,
+    reason VARCHAR(32) NULL,
+    comment TEXT NULL,
+    reviewed_at TIMESTAMP NULL,
+    reviewed_by_user_id INT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_message_feedback_dialogue (dialogue_id),
+    INDEX idx_message_feedback_queue (rating, reviewed_at, created_at),
+    FOREIGN KEY (dialogue_id) REFERENCES dialogues(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (reviewed_by_user_id) REFERENCES users(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,2 @@
+-- migrations/000027_create_guardrail_events_table.down.sql
+DROP TABLE IF EXISTS guardrail_events;
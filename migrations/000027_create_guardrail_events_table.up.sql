@@ -0,0 +1,22 @@
+-- migrations/000027_create_guardrail_events_table.up.sql
+-- Журнал срабатываний проверок безопасности для аудита в админке.
+-- excerpt - текст, на котором сработала проверка; для скрытого ответа - сам скрытый ответ.
+CREATE TABLE IF NOT EXISTS guardrail_events (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    chat_session_uuid VARCHAR(36) NOT NULL,
+    dialogue_id INT NULL,
+    persona_slug VARCHAR(64) NULL,
+    stage VARCHAR(16) NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    categories VARCHAR(255) NOT NULL,
+    rules VARCHAR(255) NOT NULL,
+    sources VARCHAR(32) NOT NULL,
+    excerpt TEXT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_guardrail_events_created (created_at),
+    INDEX idx_guardrail_events_action (action, created_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (chat_session_uuid) REFERENCES chat_sessions(uuid) ON DELETE CASCADE,
+    FOREIGN KEY (dialogue_id) REFERENCES dialogues(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,11 @@
+-- migrations/000028_prepare_payments_for_checkout.down.sql
+ALTER TABLE payments
+    DROP INDEX idx_payments_status_created,
+    DROP INDEX idx_payments_gateway_order;
+
+UPDATE payments SET payment_gateway_transaction_id = id WHERE payment_gateway_transaction_id IS NULL;
+UPDATE payments SET payment_date = created_at WHERE payment_date IS NULL;
+
+ALTER TABLE payments
+    MODIFY COLUMN payment_date TIMESTAMP NOT NULL,
+    MODIFY COLUMN payment_gateway_transaction_id VARCHAR(255) NOT NULL;
@@ -0,0 +1,8 @@
+-- migrations/000028_prepare_payments_for_checkout.up.sql
+-- Платеж создается до обращения к шлюзу: ID транзакции и дата оплаты появляются только после списания.
+-- ID заказа уникален в пределах шлюза, по нему платеж находится при возврате пользователя и в уведомлениях.
+ALTER TABLE payments
+    MODIFY COLUMN payment_gateway_transaction_id VARCHAR(255) NULL,
+    MODIFY COLUMN payment_date TIMESTAMP NULL DEFAULT NULL,
+    ADD UNIQUE INDEX idx_payments_gateway_order (gateway_name, gateway_order_id),
+    ADD INDEX idx_payments_status_created (status, created_at);
@@ -0,0 +1,2 @@
+-- migrations/000029_create_payment_events_table.down.sql
+DROP TABLE IF EXISTS payment_events;
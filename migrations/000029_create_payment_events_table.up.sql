@@ -0,0 +1,22 @@
+-- migrations/000029_create_payment_events_table.up.sql
+-- Входящие уведомления платежных шлюзов. Уведомление сохраняется до обработки, повтор с тем же
+-- event_id отбрасывается уникальным ключом. status: pending - ждет обработки, processing - обрабатывается,
+-- failed - ошибка, будет повтор в next_attempt_at, processed - обработано, dead - попытки исчерпаны.
+CREATE TABLE IF NOT EXISTS payment_events (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    gateway_name VARCHAR(50) NOT NULL,
+    event_id VARCHAR(255) NOT NULL,
+    gateway_order_id VARCHAR(255) NOT NULL,
+    event_status VARCHAR(50) NULL,
+    payload MEDIUMTEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NULL,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_payment_events_gateway_event (gateway_name, event_id),
+    INDEX idx_payment_events_due (status, next_attempt_at),
+    INDEX idx_payment_events_order (gateway_name, gateway_order_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,13 @@
+-- migrations/000030_add_recurring_billing.down.sql
+ALTER TABLE payments
+    DROP INDEX idx_payments_subscription_status,
+    DROP COLUMN is_renewal;
+
+ALTER TABLE subscriptions
+    DROP INDEX idx_subscriptions_billing,
+    DROP COLUMN dunning_stage,
+    DROP COLUMN past_due_since,
+    DROP COLUMN next_renewal_attempt_at,
+    DROP COLUMN renewal_attempts,
+    DROP COLUMN recurring_gateway,
+    DROP COLUMN recurring_token;
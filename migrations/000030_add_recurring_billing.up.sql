@@ -0,0 +1,15 @@
+-- migrations/000030_add_recurring_billing.up.sql
+-- Автопродление подписки: сохраненная в шлюзе карта, повторы неудачного списания и напоминания
+-- о неоплате (dunning). past_due_since - начало льготного периода, dunning_stage - сколько напоминаний отправлено.
+ALTER TABLE subscriptions
+    ADD COLUMN IF NOT EXISTS recurring_token VARCHAR(255) NULL COMMENT 'Токен сохраненной карты в шлюзе',
+    ADD COLUMN IF NOT EXISTS recurring_gateway VARCHAR(50) NULL COMMENT 'Шлюз, в котором сохранена карта',
+    ADD COLUMN IF NOT EXISTS renewal_attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS next_renewal_attempt_at DATETIME NULL,
+    ADD COLUMN IF NOT EXISTS past_due_since DATETIME NULL,
+    ADD COLUMN IF NOT EXISTS dunning_stage INT NOT NULL DEFAULT 0,
+    ADD INDEX idx_subscriptions_billing (status, current_period_end);
+
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS is_renewal BOOLEAN NOT NULL DEFAULT FALSE COMMENT 'Списание автопродления по сохраненной карте',
+    ADD INDEX idx_payments_subscription_status (subscription_id, status);
@@ -0,0 +1,9 @@
+-- migrations/000031_create_plans_table.down.sql
+ALTER TABLE payments
+    DROP COLUMN period_months,
+    DROP COLUMN plan_id;
+
+ALTER TABLE subscriptions
+    DROP COLUMN billing_period_months;
+
+DROP TABLE IF EXISTS plans;
@@ -0,0 +1,35 @@
+-- migrations/000031_create_plans_table.up.sql
+-- Каталог тарифов. Цены в тиынах (KZT), annual_price = 0 - годовой оплаты нет.
+-- token_limit_kzt - месячный лимит расходов на LLM по тарифу, trial_days = 0 - без пробного периода.
+-- Тарифы не удаляются: на них ссылаются подписки, ненужный тариф скрывается из каталога (is_visible).
+CREATE TABLE IF NOT EXISTS plans (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    slug VARCHAR(64) NOT NULL UNIQUE, -- Записывается в subscriptions.plan_id
+    name VARCHAR(255) NOT NULL,
+    description TEXT NULL,
+    monthly_price BIGINT NOT NULL,
+    annual_price BIGINT NOT NULL DEFAULT 0,
+    token_limit_kzt DECIMAL(12,2) NOT NULL,
+    allow_file_uploads BOOLEAN NOT NULL DEFAULT TRUE,
+    allow_shaman_mode BOOLEAN NOT NULL DEFAULT TRUE,
+    allow_vision BOOLEAN NOT NULL DEFAULT TRUE,
+    trial_days INT NOT NULL DEFAULT 0,
+    is_visible BOOLEAN NOT NULL DEFAULT TRUE,
+    sort_order INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Единственный тариф до появления каталога: billing.monthly_amount = 449900, лимит токенов = monthly_amount / 100
+INSERT IGNORE INTO plans (slug, name, description, monthly_price, annual_price, token_limit_kzt, sort_order) VALUES
+('standard', 'Стандарт', 'Полный доступ к Sham''an AI: все режимы, файлы и изображения.', 449900, 0, 4499.00, 0);
+
+-- Все действующие подписки оформлены на прежний единственный тариф
+UPDATE subscriptions SET plan_id = 'standard' WHERE plan_id IS NULL OR plan_id NOT IN (SELECT slug FROM plans);
+
+ALTER TABLE subscriptions
+    ADD COLUMN IF NOT EXISTS billing_period_months INT NOT NULL DEFAULT 1 COMMENT 'Период оплаты: 1 - помесячно, 12 - за год';
+
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS plan_id VARCHAR(64) NULL COMMENT 'Оплачиваемый тариф (plans.slug)',
+    ADD COLUMN IF NOT EXISTS period_months INT NOT NULL DEFAULT 1 COMMENT 'На сколько месяцев продлевает подписку';
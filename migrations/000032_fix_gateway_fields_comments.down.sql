@@ -0,0 +1,4 @@
+-- migrations/000032_fix_gateway_fields_comments.down.sql
+ALTER TABLE payments
+    MODIFY COLUMN gateway_order_id VARCHAR(255),
+    MODIFY COLUMN gateway_name VARCHAR(50) DEFAULT 'none';
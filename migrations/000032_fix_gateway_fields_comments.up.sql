@@ -0,0 +1,5 @@
+-- migrations/000032_fix_gateway_fields_comments.up.sql
+-- Комментарии к столбцам из 000015: COMMENT ON COLUMN в MariaDB не работает, комментарий задается в определении столбца.
+ALTER TABLE payments
+    MODIFY COLUMN gateway_order_id VARCHAR(255) COMMENT 'ID заказа во внешней платежной системе',
+    MODIFY COLUMN gateway_name VARCHAR(50) DEFAULT 'none' COMMENT 'Название платежного шлюза, например, bcc';
@@ -11,6 +11,7 @@ const speechError = document.getElementById('speech-error');
 const sessionHistoryList = document.getElementById('session-history');
 const newChatButton = document.getElementById('new-chat-btn');
 const currentChatTitleElement = document.getElementById('current-chat-title');
+const personaSelect = document.getElementById('persona-select'); // Выбор режима для нового диалога (необязательный)
 
 const attachDocButton = document.getElementById('attach-doc-btn');
 const attachImgButton = document.getElementById('attach-img-btn');
@@ -288,6 +289,47 @@ function addMessage(sender, text, isHistorical = false, attachmentInfo = null) {
     if (!isHistorical || (chatBox.scrollHeight - chatBox.scrollTop - chatBox.clientHeight < 150) ) {
         chatBox.scrollTop = chatBox.scrollHeight;
     }
+    return textSpan;
+}
+
+// --- Чтение потокового ответа (Server-Sent Events) ---
+// Вызывает onDelta(text) для каждого фрагмента и возвращает итоговый ответ из события "done".
+async function readDialogueStream(response, onDelta) {
+    const reader = response.body.getReader();
+    const decoder = new TextDecoder();
+    let buffer = '';
+    let finalText = '';
+
+    while (true) {
+        const { value, done } = await reader.read();
+        if (done) break;
+        buffer += decoder.decode(value, { stream: true });
+
+        let separatorIndex;
+        while ((separatorIndex = buffer.indexOf('\n\n')) !== -1) {
+            const rawEvent = buffer.slice(0, separatorIndex);
+            buffer = buffer.slice(separatorIndex + 2);
+
+            let eventName = 'message';
+            let data = '';
+            rawEvent.split('\n').forEach(line => {
+                if (line.startsWith('event:')) eventName = line.slice(6).trim();
+                else if (line.startsWith('data:')) data += line.slice(5).trim();
+            });
+            if (!data) continue;
+
+            const payload = JSON.parse(data);
+            if (eventName === 'delta') {
+                finalText += payload.content;
+                onDelta(finalText);
+            } else if (eventName === 'done') {
+                finalText = payload.response;
+            } else if (eventName === 'error') {
+                throw new Error(payload.error || 'Не удалось получить ответ от ИИ.');
+            }
+        }
+    }
+    return finalText;
 }
 
 function setLoading(isLoading) {
@@ -420,6 +462,7 @@ if (chatForm) {
         const formData = new FormData();
         formData.append('prompt', userText);
         formData.append('chat_session_uuid', currentChatSessionUUID);
+        formData.append('stream', 'true');
         if (attachedFile) {
             formData.append('file', attachedFile, attachedFile.name);
         }
@@ -446,7 +489,7 @@ if (chatForm) {
             const response = await fetch("/api/dialogue_with_file", {
                 method: "POST",
                 headers: {
-                    "Accept": "application/json",
+                    "Accept": "text/event-stream, application/json",
                     "X-CSRF-Token": csrfToken || ''
                 },
                 body: formData,
@@ -461,16 +504,26 @@ if (chatForm) {
                 throw new Error(errorText);
             }
 
-            const data = await response.json();
+            if ((response.headers.get('Content-Type') || '').startsWith('text/event-stream')) {
+                const streamingSpan = addMessage('Assistant', '');
+                const finalText = await readDialogueStream(response, (textSoFar) => {
+                    streamingSpan.innerHTML = textSoFar.replace(/\n/g, '<br>');
+                    chatBox.scrollTop = chatBox.scrollHeight;
+                });
+                streamingSpan.innerHTML = finalText.replace(/\n/g, '<br>');
+                if (finalText) speakText(finalText);
+            } else {
+                const data = await response.json();
 
-            if (!data || typeof data.response === 'undefined') {
-                throw new Error("Некорректный формат ответа ИИ (поле 'response' отсутствует или пусто)");
-            }
+                if (!data || typeof data.response === 'undefined') {
+                    throw new Error("Некорректный формат ответа ИИ (поле 'response' отсутствует или пусто)");
+                }
 
-            let assistantAttachmentInfo = data.attachment_processed_url ? { url: data.attachment_processed_url, name: "Обработанный файл" } : null;
+                let assistantAttachmentInfo = data.attachment_processed_url ? { url: data.attachment_processed_url, name: "Обработанный файл" } : null;
 
-            addMessage('Assistant', data.response, false, assistantAttachmentInfo);
-            if(data.response) speakText(data.response);
+                addMessage('Assistant', data.response, false, assistantAttachmentInfo);
+                if(data.response) speakText(data.response);
+            }
 
             const updatedSession = activeSessionsCache.find(s => s.uuid === currentChatSessionUUID);
             if (updatedSession) {
@@ -478,6 +531,7 @@ if (chatForm) {
             }
             await fetchAndPopulateSessionHistory();
             setActiveSessionLink(currentChatSessionUUID);
+            refreshGeneratedTitleLater(currentChatSessionUUID);
 
         } catch (error) {
             console.error("Ошибка при отправке/получении ответа от ИИ (с файлом):", error);
@@ -488,6 +542,136 @@ if (chatForm) {
     });
 }
 
+// --- Сообщения диалога с кнопками повторного ответа, редактирования и переключения вариантов ---
+function renderSessionMessages(messages, sessionTitle) {
+    chatBox.innerHTML = '';
+    if (messages && messages.length > 0) {
+        messages.forEach(msg => {
+            // К сообщению пользователя прикрепляется не больше одного файла
+            const attachment = (msg.Attachments && msg.Attachments[0]) || null;
+            const textSpan = addMessage(msg.Role === 'user' ? 'User' : 'Assistant', msg.Content, true, attachment);
+            if (msg.DialogueID) addBranchControls(textSpan.parentElement, msg);
+        });
+    } else {
+        // Если сессия новая и пустая, можно добавить приветственное сообщение или ничего не делать
+         addMessage('Assistant', `Это начало вашего диалога "${sessionTitle}". Чем могу помочь?`, true);
+    }
+    chatBox.scrollTop = chatBox.scrollHeight;
+}
+
+function addBranchControls(messageDiv, msg) {
+    const controls = document.createElement('div');
+    controls.classList.add('mt-2', 'small', 'branch-controls');
+    const addButton = (label, title, onClick) => {
+        const button = document.createElement('button');
+        button.type = 'button';
+        button.classList.add('btn', 'btn-link', 'btn-sm', 'p-0', 'me-2');
+        button.textContent = label;
+        button.title = title;
+        button.addEventListener('click', onClick);
+        controls.appendChild(button);
+    };
+
+    if (msg.Role === 'user') {
+        // Варианты сообщения на этом месте: другие ответы и отредактированные версии
+        const alternatives = msg.Alternatives || [];
+        const index = alternatives.indexOf(msg.DialogueID);
+        if (alternatives.length > 1 && index !== -1) {
+            if (index > 0) addButton('‹', 'Предыдущий вариант', () => selectDialogueBranch(alternatives[index - 1]));
+            const counter = document.createElement('span');
+            counter.classList.add('me-2', 'text-muted');
+            counter.textContent = `${index + 1}/${alternatives.length}`;
+            controls.appendChild(counter);
+            if (index < alternatives.length - 1) addButton('›', 'Следующий вариант', () => selectDialogueBranch(alternatives[index + 1]));
+        }
+        addButton('Изменить', 'Изменить сообщение и продолжить диалог с этого места', () => {
+            const prompt = window.prompt('Новый текст сообщения:', msg.Content.replace(/ \(Прикреплен файл: .*\)$/, ''));
+            if (prompt && prompt.trim()) sendBranchRequest('/api/dialogue_edit', { dialogue_id: msg.DialogueID, prompt: prompt.trim() });
+        });
+    } else {
+        addButton('Повторить', 'Получить другой ответ, прежний сохранится', () => sendBranchRequest('/api/dialogue_regenerate', { dialogue_id: msg.DialogueID }));
+        addFeedbackButtons(controls, msg);
+    }
+    messageDiv.appendChild(controls);
+}
+
+// Оценка ответа: повторное нажатие на выбранную оценку снимает ее
+function addFeedbackButtons(controls, msg) {
+    let rating = msg.Rating || 0;
+    const buttons = {};
+    const render = () => {
+        buttons[1].classList.toggle('text-success', rating === 1);
+        buttons[-1].classList.toggle('text-danger', rating === -1);
+    };
+    [[1, '👍', 'Хороший ответ'], [-1, '👎', 'Плохой ответ']].forEach(([value, label, title]) => {
+        const button = document.createElement('button');
+        button.type = 'button';
+        button.classList.add('btn', 'btn-link', 'btn-sm', 'p-0', 'me-2', 'text-decoration-none');
+        button.textContent = label;
+        button.title = title;
+        button.addEventListener('click', async () => {
+            const body = { dialogue_id: msg.DialogueID, rating: rating === value ? 0 : value };
+            if (body.rating === -1) {
+                const comment = window.prompt('Что не так с ответом? (необязательно)', '');
+                if (comment === null) return;
+                body.comment = comment.trim();
+            }
+            try {
+                await postDialogueAction('/api/dialogue_feedback', body);
+                rating = body.rating;
+                render();
+            } catch (error) {
+                console.error('Ошибка сохранения оценки ответа:', error);
+            }
+        });
+        buttons[value] = button;
+        controls.appendChild(button);
+    });
+    render();
+}
+
+async function postDialogueAction(url, body) {
+    const csrfToken = document.querySelector('meta[name="csrf-token"]')?.getAttribute('content');
+    const response = await fetch(url, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', 'Accept': 'application/json', 'X-CSRF-Token': csrfToken || '' },
+        body: JSON.stringify(body),
+    });
+    if (!response.ok) throw new Error((await response.text()) || `Статус ${response.status}`);
+    return response;
+}
+
+// Повторный ответ или редактирование: новая ветка диалога, после ответа перечитываем сообщения
+async function sendBranchRequest(url, body) {
+    if (!currentChatSessionUUID || isChatLoading) return;
+    setLoading(true);
+    stopSpeech();
+    try {
+        await postDialogueAction(url, body);
+        setLoading(false);
+        await loadMessagesForSession(currentChatSessionUUID, currentChatTitleElement ? currentChatTitleElement.textContent : 'Диалог');
+    } catch (error) {
+        console.error('Ошибка при создании новой ветки диалога:', error);
+        addMessage('Assistant', `[Ошибка: ${error.message || 'Не удалось получить ответ от ИИ.'}]`);
+    } finally {
+        setLoading(false);
+    }
+}
+
+async function selectDialogueBranch(dialogueID) {
+    if (!currentChatSessionUUID || isChatLoading) return;
+    setLoading(true);
+    try {
+        const response = await postDialogueAction('/api/dialogue_branch', { dialogue_id: dialogueID });
+        renderSessionMessages(await response.json(), currentChatTitleElement ? currentChatTitleElement.textContent : 'Диалог');
+    } catch (error) {
+        console.error('Ошибка переключения ветки диалога:', error);
+        addMessage('Assistant', `[Ошибка: Не удалось переключить вариант сообщения.]`);
+    } finally {
+        setLoading(false);
+    }
+}
+
 // --- Функции для работы с API сессий ---
 async function fetchAndPopulateSessionHistory() {
     if (!sessionHistoryList) return null;
@@ -495,20 +679,11 @@ async function fetchAndPopulateSessionHistory() {
     try {
         const response = await fetch('/api/chat_sessions');
         if (!response.ok) throw new Error(`Ошибка ${response.status}`);
-        activeSessionsCache = await response.json();
+        const page = await response.json();
+        activeSessionsCache = page.sessions || [];
         sessionHistoryList.innerHTML = '';
-        if (activeSessionsCache && activeSessionsCache.length > 0) {
-            activeSessionsCache.forEach(session => {
-                const li = document.createElement('li'); li.classList.add('nav-item');
-                const a = document.createElement('a');
-                a.href = '#'; a.classList.add('nav-link', 'text-truncate', 'py-1', 'px-2', 'mb-1');
-                a.style.fontSize = '0.875rem';
-                a.textContent = session.title || `Диалог от ${new Date(session.updated_at).toLocaleString('ru-RU', { day: '2-digit', month: '2-digit', year: 'numeric' })}`;
-                a.setAttribute('data-session-uuid', session.uuid);
-                a.setAttribute('title', a.textContent);
-                li.appendChild(a);
-                sessionHistoryList.appendChild(li);
-            });
+        if (activeSessionsCache.length > 0) {
+            appendSessionLinks(activeSessionsCache, page.next_cursor);
             return activeSessionsCache[0];
         } else {
             sessionHistoryList.innerHTML = '<li class="nav-item"><span class="nav-link text-muted px-2 py-1" style="font-size: 0.875rem;">Нет прошлых диалогов</span></li>';
@@ -521,6 +696,49 @@ async function fetchAndPopulateSessionHistory() {
     }
 }
 
+// Добавляет диалоги в конец списка; если есть следующая страница - ссылку "Показать еще"
+function appendSessionLinks(sessions, nextCursor) {
+    sessions.forEach(session => {
+        const li = document.createElement('li'); li.classList.add('nav-item');
+        const a = document.createElement('a');
+        a.href = '#'; a.classList.add('nav-link', 'text-truncate', 'py-1', 'px-2', 'mb-1');
+        a.style.fontSize = '0.875rem';
+        a.textContent = (session.pinned_at ? '📌 ' : '') + (session.title || `Диалог от ${new Date(session.updated_at).toLocaleString('ru-RU', { day: '2-digit', month: '2-digit', year: 'numeric' })}`);
+        a.setAttribute('data-session-uuid', session.uuid);
+        a.setAttribute('title', a.textContent);
+        li.appendChild(a);
+        sessionHistoryList.appendChild(li);
+    });
+    if (nextCursor) {
+        const li = document.createElement('li'); li.classList.add('nav-item');
+        const a = document.createElement('a');
+        a.href = '#'; a.classList.add('nav-link', 'text-secondary', 'py-1', 'px-2', 'mb-1');
+        a.style.fontSize = '0.875rem';
+        a.textContent = 'Показать еще';
+        a.setAttribute('data-next-cursor', nextCursor);
+        li.appendChild(a);
+        sessionHistoryList.appendChild(li);
+    }
+}
+
+async function loadMoreSessions(link) {
+    const cursor = link.getAttribute('data-next-cursor');
+    link.classList.add('disabled');
+    try {
+        const response = await fetch(`/api/chat_sessions?cursor=${encodeURIComponent(cursor)}`);
+        if (!response.ok) throw new Error(`Ошибка ${response.status}`);
+        const page = await response.json();
+        link.closest('li').remove();
+        const sessions = page.sessions || [];
+        activeSessionsCache = activeSessionsCache.concat(sessions);
+        appendSessionLinks(sessions, page.next_cursor);
+        setActiveSessionLink(currentChatSessionUUID);
+    } catch (error) {
+        console.error("Не удалось загрузить следующую страницу сессий:", error);
+        link.classList.remove('disabled');
+    }
+}
+
 async function loadMessagesForSession(sessionUUID, sessionTitle = "Диалог") {
     if (!chatBox || !sessionUUID || isChatLoading) return;
     setLoading(true);
@@ -534,13 +752,7 @@ async function loadMessagesForSession(sessionUUID, sessionTitle = "Диалог"
         const response = await fetch(`/api/chat_session_messages?uuid=${encodeURIComponent(sessionUUID)}`);
         if (!response.ok) throw new Error(`Ошибка ${response.status}`);
         const messages = await response.json();
-        if (messages && messages.length > 0) {
-            messages.forEach(msg => addMessage(msg.Role === 'user' ? 'User' : 'Assistant', msg.Content, true));
-        } else {
-            // Если сессия новая и пустая, можно добавить приветственное сообщение или ничего не делать
-             addMessage('Assistant', `Это начало вашего диалога "${sessionTitle}". Чем могу помочь?`, true);
-        }
-        chatBox.scrollTop = chatBox.scrollHeight;
+        renderSessionMessages(messages, sessionTitle);
     } catch (error) {
         console.error("Ошибка загрузки сообщений сессии:", error);
         addMessage('Assistant', `[Ошибка: Не удалось загрузить сообщения сессии.]`, true);
@@ -557,7 +769,7 @@ async function handleCreateNewChat() {
         const response = await fetch('/api/chat_session_create', {
             method: 'POST',
             headers: { "Content-Type": "application/json", "Accept": "application/json", "X-CSRF-Token": csrfToken || '' },
-            body: JSON.stringify({ title: "" })
+            body: JSON.stringify({ title: "", persona_slug: personaSelect ? personaSelect.value : "" })
         });
         if (!response.ok) throw new Error(`Ошибка ${response.status}`);
         const newSession = await response.json();
@@ -577,6 +789,30 @@ async function handleCreateNewChat() {
     finally { setLoading(false); if (userInput) userInput.focus(); }
 }
 
+// Заполняет список режимов для нового диалога. Пустое значение - выбор режима автоматически по сообщению
+async function loadPersonaOptions() {
+    if (!personaSelect) return;
+    try {
+        const response = await fetch('/api/personas', { headers: { "Accept": "application/json" } });
+        if (!response.ok) throw new Error(`Ошибка ${response.status}`);
+        const personas = await response.json();
+        personaSelect.innerHTML = '';
+        const autoOption = document.createElement('option');
+        autoOption.value = '';
+        autoOption.textContent = 'Автоматически';
+        personaSelect.appendChild(autoOption);
+        (personas || []).forEach(persona => {
+            const option = document.createElement('option');
+            option.value = persona.slug;
+            option.textContent = persona.name;
+            if (persona.description) option.title = persona.description;
+            personaSelect.appendChild(option);
+        });
+    } catch (error) {
+        console.error("Ошибка загрузки списка режимов:", error);
+    }
+}
+
 function setActiveSessionLink(sessionUUID) {
     if (!sessionHistoryList) return;
     sessionHistoryList.querySelectorAll('a.nav-link').forEach(link => {
@@ -590,6 +826,18 @@ function setActiveSessionLink(sessionUUID) {
     });
 }
 
+// Заголовок диалога придумывается на сервере в фоне после первого ответа - перечитываем список чуть позже
+function refreshGeneratedTitleLater(sessionUUID) {
+    const session = activeSessionsCache.find(s => s.uuid === sessionUUID);
+    if (!session || session.title_source !== 'default') return;
+    setTimeout(async () => {
+        await fetchAndPopulateSessionHistory();
+        setActiveSessionLink(currentChatSessionUUID);
+        const updated = activeSessionsCache.find(s => s.uuid === sessionUUID);
+        if (updated && sessionUUID === currentChatSessionUUID) updateChatTitle(updated.title);
+    }, 5000);
+}
+
 function updateChatTitle(title) {
     if (currentChatTitleElement) {
         currentChatTitleElement.textContent = title || "Диалог";
@@ -604,6 +852,10 @@ if (sessionHistoryList) {
         const targetLink = event.target.closest('a.nav-link');
         if (!targetLink || targetLink.classList.contains('disabled')) return;
         event.preventDefault();
+        if (targetLink.hasAttribute('data-next-cursor')) {
+            loadMoreSessions(targetLink);
+            return;
+        }
         const sessionUUID = targetLink.getAttribute('data-session-uuid');
 
         if (!sessionUUID || (sessionUUID === currentChatSessionUUID && chatBox.children.length > 1 && !isChatLoading) ) {
@@ -685,6 +937,7 @@ if (tx) {
 document.addEventListener('DOMContentLoaded', async () => {
     if (window.location.pathname === '/dashboard') {
         document.body.classList.add('dashboard-page-active');
+        await loadPersonaOptions();
         const latestSession = await fetchAndPopulateSessionHistory();
         if (latestSession && latestSession.uuid) {
             await loadMessagesForSession(latestSession.uuid, latestSession.title);